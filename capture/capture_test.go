@@ -0,0 +1,219 @@
+package capture
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var testPackets = []Packet{
+	{
+		Data:     []byte{0x01, 0x02, 0x03},
+		Metadata: Metadata{Timestamp: time.Unix(1700000000, 0), CaptureLength: 3, Length: 3},
+	},
+	{
+		Data:     []byte{0x04, 0x05},
+		Metadata: Metadata{Timestamp: time.Unix(1700000001, 0), CaptureLength: 2, Length: 60},
+	},
+}
+
+// recordingWriter stores the frames written to it, failing if err is set
+type recordingWriter struct {
+	written []Packet
+	err     error
+}
+
+func (w *recordingWriter) WritePacket(md Metadata, data []byte) error {
+	if w.err != nil {
+		return w.err
+	}
+	w.written = append(w.written, Packet{Data: data, Metadata: md})
+	return nil
+}
+
+func TestReplaySource(t *testing.T) {
+	src := NewReplaySource(testPackets...)
+
+	for i, expected := range testPackets {
+		data, md, err := src.ReadPacket()
+		if err != nil {
+			t.Fatalf("expected no error reading frame %d - got %v", i, err)
+		}
+		if !bytes.Equal(data, expected.Data) {
+			t.Errorf("expected frame %d to be %v - got %v", i, expected.Data, data)
+		}
+		if md != expected.Metadata {
+			t.Errorf("expected metadata %d to be %+v - got %+v", i, expected.Metadata, md)
+		}
+		// the source must not share its frames with the caller
+		data[0] = 0xff
+		if testPackets[i].Data[0] == 0xff {
+			t.Errorf("expected frame %d to be a copy", i)
+		}
+	}
+
+	if _, _, err := src.ReadPacket(); err != io.EOF {
+		t.Errorf("expected error: %v - got %v", io.EOF, err)
+	}
+
+	stats, err := src.Stats()
+	if err != nil {
+		t.Fatalf("expected no error - got %v", err)
+	}
+	if stats.Received != uint64(len(testPackets)) {
+		t.Errorf("expected %d frames to be received - got %d", len(testPackets), stats.Received)
+	}
+
+	if err := src.Close(); err != nil {
+		t.Fatalf("expected no error - got %v", err)
+	}
+	if _, _, err := src.ReadPacket(); err != ErrSourceClosed {
+		t.Errorf("expected error: %v - got %v", ErrSourceClosed, err)
+	}
+}
+
+func TestTeeSource(t *testing.T) {
+	w := &recordingWriter{}
+	src := NewTeeSource(NewReplaySource(testPackets...), w)
+
+	for {
+		if _, _, err := src.ReadPacket(); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("expected no error - got %v", err)
+		}
+	}
+	if !reflect.DeepEqual(w.written, testPackets) {
+		t.Errorf("expected written packets to be %v - got %v", testPackets, w.written)
+	}
+
+	w.err = errors.New("disk full")
+	src = NewTeeSource(NewReplaySource(testPackets...), w)
+
+	data, _, err := src.ReadPacket()
+	if err == nil {
+		t.Errorf("expected the write error to be returned")
+	}
+	if !bytes.Equal(data, testPackets[0].Data) {
+		t.Errorf("expected the frame to be returned even if not written - got %v", data)
+	}
+}
+
+func TestFilteredSource(t *testing.T) {
+	src := NewFilteredSource(NewReplaySource(testPackets...), func(data []byte, md Metadata) bool {
+		return len(data) == 2
+	})
+
+	data, _, err := src.ReadPacket()
+	if err != nil {
+		t.Fatalf("expected no error - got %v", err)
+	}
+	if !bytes.Equal(data, testPackets[1].Data) {
+		t.Errorf("expected frame to be %v - got %v", testPackets[1].Data, data)
+	}
+	if _, _, err := src.ReadPacket(); err != io.EOF {
+		t.Errorf("expected error: %v - got %v", io.EOF, err)
+	}
+	if src.Filtered() != 1 {
+		t.Errorf("expected 1 frame to be filtered out - got %d", src.Filtered())
+	}
+
+	// the wrapped source's counters are still available
+	stats, _ := src.Stats()
+	if stats.Received != 2 || stats.Filtered != 1 {
+		t.Errorf("expected 2 frames to be received and 1 filtered out - got %+v", stats)
+	}
+}
+
+// idleSource is a source without frames, whose reads block until it is closed
+type idleSource struct {
+	closed chan struct{}
+}
+
+func (s *idleSource) ReadPacket() ([]byte, Metadata, error) {
+	<-s.closed
+	return nil, Metadata{}, ErrSourceClosed
+}
+
+func (s *idleSource) Close() error {
+	close(s.closed)
+	return nil
+}
+
+func (s *idleSource) Stats() (Stats, error) { return Stats{}, nil }
+
+// interfacePackets returns packets seen on the named interface at the given seconds
+func interfacePackets(iface string, seconds ...int64) []Packet {
+	packets := make([]Packet, len(seconds))
+	for i, sec := range seconds {
+		packets[i] = Packet{
+			Data:     []byte{byte(sec)},
+			Metadata: Metadata{Timestamp: time.Unix(1700000000+sec, 0), CaptureLength: 1, Length: 1, Interface: iface},
+		}
+	}
+	return packets
+}
+
+func TestMergedSource(t *testing.T) {
+	src := NewMergedSource(time.Second,
+		NewReplaySource(interfacePackets("eth0", 0, 3, 4)...),
+		NewReplaySource(interfacePackets("eth1", 1, 2, 5)...),
+	)
+	defer src.Close()
+
+	expected := []string{"eth0", "eth1", "eth1", "eth0", "eth0", "eth1"}
+	for i, iface := range expected {
+		data, md, err := src.ReadPacket()
+		if err != nil {
+			t.Fatalf("expected no error reading frame %d - got %v", i, err)
+		}
+		if data[0] != byte(i) || md.Interface != iface {
+			t.Errorf("expected frame %d to be seen on %s - got frame %d on %s", i, iface, data[0], md.Interface)
+		}
+	}
+	if _, _, err := src.ReadPacket(); err != io.EOF {
+		t.Errorf("expected error: %v - got %v", io.EOF, err)
+	}
+
+	stats, _ := src.Stats()
+	if stats.Received != 6 {
+		t.Errorf("expected 6 frames to be received - got %d", stats.Received)
+	}
+}
+
+func TestMergedSourceIdle(t *testing.T) {
+	window := 50 * time.Millisecond
+	idle := &idleSource{closed: make(chan struct{})}
+	src := NewMergedSource(window, idle, NewReplaySource(testPackets...))
+
+	// frames are not held back forever by a source without traffic
+	start := time.Now()
+	for i := range testPackets {
+		if _, _, err := src.ReadPacket(); err != nil {
+			t.Fatalf("expected no error reading frame %d - got %v", i, err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < window {
+		t.Errorf("expected the frames to wait for the reorder window - got %v", elapsed)
+	}
+
+	done := make(chan error)
+	go func() {
+		_, _, err := src.ReadPacket()
+		done <- err
+	}()
+	if err := src.Close(); err != nil {
+		t.Fatalf("expected no error - got %v", err)
+	}
+	select {
+	case err := <-done:
+		if err != ErrSourceClosed {
+			t.Errorf("expected error: %v - got %v", ErrSourceClosed, err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the read to return after closing the source")
+	}
+}
@@ -0,0 +1,34 @@
+package capture
+
+// Group is a set of sources read in parallel, like the raw sockets sharing the frames of an interface
+// through PACKET_FANOUT. Its counters are the sum of the sources' ones, and its sources are closed together
+type Group []PacketSource
+
+// Close closes all the sources, returning the first error
+func (g Group) Close() error {
+	var err error
+	for _, src := range g {
+		if closeErr := src.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// Stats returns the sum of the counters of all the sources, and the first error reported getting them
+func (g Group) Stats() (Stats, error) {
+	var total Stats
+	var err error
+	for _, src := range g {
+		stats, statsErr := src.Stats()
+		if statsErr != nil && err == nil {
+			err = statsErr
+		}
+		total.Received += stats.Received
+		total.Captured += stats.Captured
+		total.Dropped += stats.Dropped
+		total.FreezeQueue += stats.FreezeQueue
+		total.Filtered += stats.Filtered
+	}
+	return total, err
+}
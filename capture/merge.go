@@ -0,0 +1,183 @@
+package capture
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// mergeBuffer is the number of frames read in advance from every merged source
+const mergeBuffer = 64
+
+// mergedFrame is a frame, or an error, read from one of the merged sources
+type mergedFrame struct {
+	data    []byte
+	md      Metadata
+	err     error
+	arrived time.Time // when the frame has been read from its source
+}
+
+// MergedSource is a PacketSource returning the frames of several sources, like the raw sockets bound
+// to different interfaces, as a single stream ordered by timestamp.
+// Every source is read in its own goroutine. The earliest frame is returned once every source still open
+// has a frame ready, or after it has waited for the reorder window: an idle source delays the others by
+// at most the window, but its frames may then be returned out of order
+type MergedSource struct {
+	sources Group
+	window  time.Duration
+	inputs  []chan mergedFrame
+	notify  chan struct{}
+	done    chan struct{}
+	wg      sync.WaitGroup
+	once    sync.Once
+
+	mu    sync.Mutex
+	heads []*mergedFrame // next frame of every source, nil if not read yet
+	ended []bool         // sources which have no more frames
+}
+
+// NewMergedSource returns a source merging the frames of the passed sources, waiting at most
+// the reorder window for a frame of every source before returning the earliest one
+func NewMergedSource(window time.Duration, sources ...PacketSource) *MergedSource {
+	s := &MergedSource{
+		sources: sources,
+		window:  window,
+		inputs:  make([]chan mergedFrame, len(sources)),
+		notify:  make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		heads:   make([]*mergedFrame, len(sources)),
+		ended:   make([]bool, len(sources)),
+	}
+
+	s.wg.Add(len(sources))
+	for i := range sources {
+		s.inputs[i] = make(chan mergedFrame, mergeBuffer)
+		go s.read(i)
+	}
+	return s
+}
+
+// read sends the frames of the i-th source to its input channel, until the source is exhausted or closed
+func (s *MergedSource) read(i int) {
+	defer s.wg.Done()
+
+	for {
+		data, md, err := s.sources[i].ReadPacket()
+		select {
+		case s.inputs[i] <- mergedFrame{data: data, md: md, err: err, arrived: time.Now()}:
+		case <-s.done:
+			return
+		}
+		// wake up a pending ReadPacket, unless it has already been notified
+		select {
+		case s.notify <- struct{}{}:
+		default:
+		}
+
+		if err == io.EOF || errors.Is(err, ErrSourceClosed) {
+			return
+		}
+	}
+}
+
+// ReadPacket returns the earliest frame among the ones read from the sources. Errors of a source are
+// returned as soon as they are read. io.EOF is returned once every source is exhausted
+func (s *MergedSource) ReadPacket() ([]byte, Metadata, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for {
+		select {
+		case <-s.done:
+			return nil, Metadata{}, ErrSourceClosed
+		default:
+		}
+
+		s.fillHeads()
+		i, wait := s.nextHead()
+		if i >= 0 {
+			f := s.heads[i]
+			s.heads[i] = nil
+			return f.data, f.md, f.err
+		}
+		if wait == 0 {
+			return nil, Metadata{}, io.EOF
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-s.notify:
+		case <-timer.C:
+		case <-s.done:
+		}
+		timer.Stop()
+	}
+}
+
+// fillHeads takes the next frame of every source which has none ready, without waiting for it
+func (s *MergedSource) fillHeads() {
+	for i, in := range s.inputs {
+		if s.heads[i] != nil || s.ended[i] {
+			continue
+		}
+		select {
+		case f := <-in:
+			if f.err == io.EOF || errors.Is(f.err, ErrSourceClosed) {
+				s.ended[i] = true
+				continue
+			}
+			s.heads[i] = &f
+		default:
+		}
+	}
+}
+
+// nextHead returns the index of the frame to return next, or -1 and how long to wait for the other sources.
+// A zero wait means every source has ended
+func (s *MergedSource) nextHead() (int, time.Duration) {
+	next := -1
+	complete := true
+	for i, f := range s.heads {
+		if f == nil {
+			complete = complete && s.ended[i]
+			continue
+		}
+		if f.err != nil {
+			return i, 0
+		}
+		if next < 0 || f.md.Timestamp.Before(s.heads[next].md.Timestamp) {
+			next = i
+		}
+	}
+
+	if next < 0 {
+		if complete {
+			return -1, 0
+		}
+		return -1, s.window + time.Millisecond
+	}
+	if complete {
+		return next, 0
+	}
+	if waited := time.Since(s.heads[next].arrived); waited < s.window {
+		return -1, s.window - waited
+	}
+	return next, 0
+}
+
+// Close closes all the sources, returning the first error, and waits for them to stop being read
+func (s *MergedSource) Close() error {
+	var err error
+	s.once.Do(func() {
+		close(s.done)
+		err = s.sources.Close()
+		s.wg.Wait()
+	})
+	return err
+}
+
+// Stats returns the sum of the counters of all the sources, and the first error reported getting them
+func (s *MergedSource) Stats() (Stats, error) {
+	return s.sources.Stats()
+}
@@ -0,0 +1,62 @@
+package capture
+
+import (
+	"io"
+	"sync"
+)
+
+// Packet is a frame held in memory with its metadata
+type Packet struct {
+	Data     []byte
+	Metadata Metadata
+}
+
+// ReplaySource is a PacketSource returning, in order, a list of frames held in memory.
+// It allows to exercise the decoding pipeline without capturing live traffic
+type ReplaySource struct {
+	mu      sync.Mutex
+	packets []Packet
+	next    int
+	closed  bool
+}
+
+// NewReplaySource returns a source for the passed packets
+func NewReplaySource(packets ...Packet) *ReplaySource {
+	return &ReplaySource{packets: packets}
+}
+
+// ReadPacket returns a copy of the next frame, or io.EOF once all of them have been read
+func (s *ReplaySource) ReadPacket() ([]byte, Metadata, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil, Metadata{}, ErrSourceClosed
+	}
+	if s.next >= len(s.packets) {
+		return nil, Metadata{}, io.EOF
+	}
+	p := s.packets[s.next]
+	s.next++
+
+	data := make([]byte, len(p.Data))
+	copy(data, p.Data)
+	return data, p.Metadata, nil
+}
+
+// Close makes every subsequent read fail
+func (s *ReplaySource) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	return nil
+}
+
+// Stats returns the number of frames read so far
+func (s *ReplaySource) Stats() (Stats, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return Stats{Received: uint64(s.next)}, nil
+}
@@ -0,0 +1,97 @@
+// Package capture defines the sources raw frames are read from, like a live raw socket,
+// a capture file or an in-memory list of frames, and the metadata describing each frame.
+package capture
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrSourceClosed is returned when reading from a closed source
+var ErrSourceClosed = errors.New("packet source closed")
+
+// Metadata describes how and when a frame has been captured
+type Metadata struct {
+	Timestamp     time.Time
+	CaptureLength int    // number of bytes captured
+	Length        int    // number of bytes of the frame on the wire
+	Interface     string // name of the network interface the frame has been captured on, if known
+	PacketType    PacketType
+	VLAN          VLAN // 802.1Q tag removed from the frame by the kernel, if any
+	// ChecksumNotReady reports that the transport checksum of a frame sent by the host has been left
+	// for the network interface to compute, so the captured bytes do not carry it yet
+	ChecksumNotReady bool
+}
+
+// VLAN is an 802.1Q tag that the kernel, or the network card, removed from a captured frame
+// and reported apart from its bytes
+type VLAN struct {
+	Present bool
+	TPID    uint16 // tag protocol identifier, like 0x8100 or 0x88A8
+	TCI     uint16 // tag control information: priority, drop eligible indicator and VLAN ID
+}
+
+// PacketType tells who a frame was addressed to, or whether it was sent by the host,
+// as reported by the kernel in the link layer address of captured frames
+type PacketType uint8
+
+const (
+	PacketTypeUnknown   PacketType = iota // frames read from a file carry no packet type
+	PacketTypeHost                        // addressed to the host
+	PacketTypeBroadcast                   // addressed to the link layer broadcast address
+	PacketTypeMulticast                   // addressed to a link layer multicast group
+	PacketTypeOtherHost                   // addressed to another host, seen in promiscuous mode
+	PacketTypeOutgoing                    // sent by the host
+)
+
+func (t PacketType) String() string {
+	switch t {
+	case PacketTypeHost:
+		return "host"
+	case PacketTypeBroadcast:
+		return "broadcast"
+	case PacketTypeMulticast:
+		return "multicast"
+	case PacketTypeOtherHost:
+		return "otherhost"
+	case PacketTypeOutgoing:
+		return "outgoing"
+	default:
+		return "unknown"
+	}
+}
+
+// Outgoing reports whether the frame was sent by the host
+func (t PacketType) Outgoing() bool {
+	return t == PacketTypeOutgoing
+}
+
+// Incoming reports whether the frame was received by the host
+func (t PacketType) Incoming() bool {
+	return t != PacketTypeUnknown && t != PacketTypeOutgoing
+}
+
+// Stats contains the counters of a source
+type Stats struct {
+	Received    uint64 // frames read from the source
+	Captured    uint64 // frames captured by the kernel for the source, including the dropped ones
+	Dropped     uint64 // frames dropped by the source before they could be read
+	FreezeQueue uint64 // times the kernel stopped filling a memory-mapped ring because it was full
+	Filtered    uint64 // frames read but skipped by a userspace filter
+}
+
+// PacketSource is implemented by everything raw Ethernet frames can be read from
+type PacketSource interface {
+	// ReadPacket returns the next frame with its metadata. The returned slice is not reused by the source.
+	// io.EOF is returned when the source has no more frames
+	ReadPacket() ([]byte, Metadata, error)
+	// Close releases the resources held by the source
+	Close() error
+	// Stats returns the source's counters
+	Stats() (Stats, error)
+}
+
+// Writer persists the frames read from a source, like the pcap package's writers do
+type Writer interface {
+	WritePacket(md Metadata, data []byte) error
+}
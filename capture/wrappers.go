@@ -0,0 +1,75 @@
+package capture
+
+import (
+	"fmt"
+	"sync"
+)
+
+// TeeSource is a PacketSource writing every frame read from the wrapped source
+// to a Writer, before returning it
+type TeeSource struct {
+	PacketSource
+	w Writer
+}
+
+// NewTeeSource returns a source writing the frames read from src to w
+func NewTeeSource(src PacketSource, w Writer) *TeeSource {
+	return &TeeSource{PacketSource: src, w: w}
+}
+
+// ReadPacket reads the next frame from the wrapped source and writes it.
+// A failed write is reported as an error, but the frame is still returned
+func (s *TeeSource) ReadPacket() ([]byte, Metadata, error) {
+	data, md, err := s.PacketSource.ReadPacket()
+	if err != nil {
+		return data, md, err
+	}
+	if err := s.w.WritePacket(md, data); err != nil {
+		return data, md, fmt.Errorf("error writing frame: %v", err)
+	}
+	return data, md, nil
+}
+
+// FilteredSource is a PacketSource skipping the frames of the wrapped source not accepted by a match function,
+// like a BPF program executed in userspace. The function is passed the frame and its metadata
+type FilteredSource struct {
+	PacketSource
+	match func(data []byte, md Metadata) bool
+
+	mu       sync.Mutex
+	filtered uint64
+}
+
+// NewFilteredSource returns a source returning only the frames of src accepted by match
+func NewFilteredSource(src PacketSource, match func(data []byte, md Metadata) bool) *FilteredSource {
+	return &FilteredSource{PacketSource: src, match: match}
+}
+
+// ReadPacket returns the next frame of the wrapped source accepted by the match function
+func (s *FilteredSource) ReadPacket() ([]byte, Metadata, error) {
+	for {
+		data, md, err := s.PacketSource.ReadPacket()
+		if err != nil || s.match(data, md) {
+			return data, md, err
+		}
+
+		s.mu.Lock()
+		s.filtered++
+		s.mu.Unlock()
+	}
+}
+
+// Filtered returns the number of frames skipped so far
+func (s *FilteredSource) Filtered() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.filtered
+}
+
+// Stats returns the counters of the wrapped source, adding the number of frames skipped so far
+func (s *FilteredSource) Stats() (Stats, error) {
+	stats, err := s.PacketSource.Stats()
+	stats.Filtered += s.Filtered()
+	return stats, err
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/NamelessOne91/bisturi/pcap"
+	"github.com/NamelessOne91/bisturi/replay"
+	"github.com/NamelessOne91/bisturi/sockets"
+)
+
+// parseIPMapping parses an "old=new" pair of addresses into the map
+func parseIPMapping(ips map[netip.Addr]netip.Addr) func(string) error {
+	return func(s string) error {
+		from, to, ok := strings.Cut(s, "=")
+		if !ok {
+			return errors.New("expected old=new")
+		}
+		fromAddr, err := netip.ParseAddr(from)
+		if err != nil {
+			return err
+		}
+		toAddr, err := netip.ParseAddr(to)
+		if err != nil {
+			return err
+		}
+		ips[fromAddr] = toAddr
+		return nil
+	}
+}
+
+// parseMAC parses the MAC address, if any
+func parseMAC(s string) (net.HardwareAddr, error) {
+	if s == "" {
+		return nil, nil
+	}
+	return net.ParseMAC(s)
+}
+
+func main() {
+	readFile := flag.String("r", "", "pcap file whose frames are sent")
+	ifaceName := flag.String("i", "", "network interface the frames are sent out of")
+	speed := flag.Float64("speed", 1, "multiplier of the original timing: 2 sends the frames twice as fast")
+	topSpeed := flag.Bool("topspeed", false, "send the frames as fast as possible, ignoring their timing")
+	srcMAC := flag.String("src-mac", "", "replace the source MAC address of every frame")
+	dstMAC := flag.String("dst-mac", "", "replace the destination MAC address of every frame")
+	ips := map[netip.Addr]netip.Addr{}
+	flag.Func("ip-map", "replace an IP address, as either source or destination: old=new (can be repeated)", parseIPMapping(ips))
+	flag.Parse()
+
+	if *readFile == "" || *ifaceName == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *speed <= 0 {
+		log.Fatal("the speed must be positive: use -topspeed to send the frames as fast as possible")
+	}
+
+	opts := replay.Options{Speed: *speed, IPs: ips}
+	if *topSpeed {
+		opts.Speed = 0
+	}
+	var err error
+	if opts.SrcMAC, err = parseMAC(*srcMAC); err != nil {
+		log.Fatal("Invalid source MAC address: ", err)
+	}
+	if opts.DstMAC, err = parseMAC(*dstMAC); err != nil {
+		log.Fatal("Invalid destination MAC address: ", err)
+	}
+
+	if err := run(*readFile, *ifaceName, opts); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run sends the frames of the pcap file out of the network interface, until they are over or bisturi-replay is terminated
+func run(readFile, ifaceName string, opts replay.Options) error {
+	r, err := pcap.OpenFile(readFile)
+	if err != nil {
+		return fmt.Errorf("failed to open the capture file: %v", err)
+	}
+	defer r.Close()
+	if r.LinkType() != pcap.LinkTypeEthernet {
+		return fmt.Errorf("unsupported link type %d: only Ethernet frames can be sent", r.LinkType())
+	}
+
+	iface, err := net.InterfaceByName(ifaceName)
+	if err != nil {
+		return fmt.Errorf("failed to find the network interface: %v", err)
+	}
+	// a socket for the 0 Ethernet type receives no frames
+	rs, err := sockets.NewRawSocket(0)
+	if err != nil {
+		return fmt.Errorf("failed to open a raw socket: %v", err)
+	}
+	defer rs.Close()
+	if err := rs.Bind(*iface); err != nil {
+		return fmt.Errorf("failed to bind the raw socket: %v", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	stats, err := replay.Replay(ctx, r, rs, opts)
+	fmt.Printf("%d frames (%d bytes) sent in %s, %d rewritten\n", stats.Packets, stats.Bytes, stats.Duration, stats.Rewritten)
+	if err != nil && !errors.Is(err, context.Canceled) {
+		return fmt.Errorf("replay failed: %v", err)
+	}
+	return nil
+}
@@ -1,10 +1,17 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"io"
 	"log"
 	"os"
 	"os/exec"
+	"os/signal"
+	"syscall"
 
+	"github.com/NamelessOne91/bisturi/sockets"
 	models "github.com/NamelessOne91/bisturi/tui/models"
 	tea "github.com/charmbracelet/bubbletea"
 )
@@ -16,6 +23,18 @@ func clearScreen() error {
 }
 
 func main() {
+	readFile := flag.String("r", "", "read the packets from a pcap file instead of capturing them live")
+	writeFile := flag.String("w", "", "write the captured frames to a pcap (.pcap extension) or pcapng file")
+	ring := flag.Bool("ring", false, "capture through a memory-mapped TPACKET_V3 ring instead of a syscall per frame")
+	fanout := flag.Int("fanout", 0, "number of sockets sharing the frames of every interface through PACKET_FANOUT, each decoded in parallel")
+	fanoutMode := flag.String("fanout-mode", "hash", "how the frames are distributed among the fanout sockets: hash (by flow), lb (round robin) or cpu")
+	flag.Parse()
+
+	mode, err := sockets.ParseFanoutMode(*fanoutMode)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	if len(os.Getenv("BISTURI_DEBUG")) > 0 {
 		f, err := tea.LogToFile("bisturi_debug.log", "debug")
 		if err != nil {
@@ -28,8 +47,27 @@ func main() {
 		log.Fatal("Failed to clear the screen: ", err)
 	}
 
-	p := tea.NewProgram(models.NewBisturiModel(), tea.WithAltScreen())
-	if _, err := p.Run(); err != nil {
+	// the capture is stopped, and the socket released, when bisturi is terminated
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	m := models.NewBisturiModel(models.Config{
+		Context:    ctx,
+		ReadFile:   *readFile,
+		WriteFile:  *writeFile,
+		Ring:       *ring,
+		Fanout:     *fanout,
+		FanoutMode: mode,
+	})
+	p := tea.NewProgram(m, tea.WithAltScreen(), tea.WithContext(ctx))
+	final, err := p.Run()
+	// release the socket and flush the recorded frames even if the program failed
+	if c, ok := final.(io.Closer); ok {
+		if closeErr := c.Close(); closeErr != nil {
+			log.Println("Error closing the capture:", closeErr)
+		}
+	}
+	if err != nil && !errors.Is(err, tea.ErrProgramKilled) {
 		log.Fatal("Error running program:", err)
 	}
 }
@@ -0,0 +1,140 @@
+// Package filter compiles tcpdump-style filter expressions, like "tcp port 443 and not host 10.0.0.1",
+// to classic BPF programs which can be attached to a raw socket or executed in userspace.
+package filter
+
+import (
+	"errors"
+	"strings"
+	"syscall"
+)
+
+// SnapLen is the value returned by the compiled programs for the accepted frames.
+// It is larger than any frame we could read, so accepted frames are never truncated
+const SnapLen = 0x40000
+
+var errProgramTooLarge = errors.New("filter expression is too complex to be compiled")
+
+// Compile parses the provided expression and returns the corresponding classic BPF program.
+// A nil program, accepting every frame, is returned for an empty expression.
+// The supported syntax is a subset of pcap-filter(7):
+//
+//	[ether|ip|ip6|arp|tcp|udp] [src|dst] host <address>
+//	[ip|ip6|arp] [src|dst] net <address>/<bits>
+//	[tcp|udp] [src|dst] port <port>
+//	[tcp|udp] [src|dst] portrange <from>-<to>
+//	[ether|ip|ip6] proto <protocol>
+//	ip | ip6 | arp | tcp | udp | icmp | icmp6
+//	less <length> | greater <length>
+//	inbound | outbound
+//	vlan [<id>]
+//
+// combined with "and", "or", "not" (or "&&", "||", "!") and parentheses.
+func Compile(expression string) ([]syscall.SockFilter, error) {
+	if strings.TrimSpace(expression) == "" {
+		return nil, nil
+	}
+
+	tokens, err := tokenize(expression)
+	if err != nil {
+		return nil, err
+	}
+	e, err := parse(tokens)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &compiler{}
+	accept, reject := c.newLabel(), c.newLabel()
+	c.gen(e, accept, reject)
+
+	c.place(accept)
+	c.emit(syscall.SockFilter{Code: syscall.BPF_RET | syscall.BPF_K, K: SnapLen})
+	c.place(reject)
+	c.emit(syscall.SockFilter{Code: syscall.BPF_RET | syscall.BPF_K, K: 0})
+
+	return c.assemble()
+}
+
+// label identifies a yet to be known position in the program, target of a jump
+type label int
+
+// instruction is a BPF instruction whose jump targets are still labels
+type instruction struct {
+	syscall.SockFilter
+	conditional bool
+	jt, jf      label
+}
+
+type compiler struct {
+	program []instruction
+	// position of every label in the program, -1 until placed
+	labels []int
+}
+
+func (c *compiler) newLabel() label {
+	c.labels = append(c.labels, -1)
+	return label(len(c.labels) - 1)
+}
+
+// place sets the label's position to the one of the next emitted instruction
+func (c *compiler) place(l label) {
+	c.labels[l] = len(c.program)
+}
+
+func (c *compiler) emit(ins syscall.SockFilter) {
+	c.program = append(c.program, instruction{SockFilter: ins})
+}
+
+// gen emits the code evaluating the expression, which jumps to the t label if the expression is true
+// and to the f label otherwise. Boolean operators are short-circuited, so every jump is forward
+func (c *compiler) gen(e expr, t, f label) {
+	switch e := e.(type) {
+	case andExpr:
+		right := c.newLabel()
+		c.gen(e.left, right, f)
+		c.place(right)
+		c.gen(e.right, t, f)
+	case orExpr:
+		right := c.newLabel()
+		c.gen(e.left, t, right)
+		c.place(right)
+		c.gen(e.right, t, f)
+	case notExpr:
+		c.gen(e.e, f, t)
+	case test:
+		for _, ins := range e.load {
+			c.emit(ins)
+		}
+		c.program = append(c.program, instruction{
+			SockFilter:  syscall.SockFilter{Code: syscall.BPF_JMP | e.jump | syscall.BPF_K, K: e.k},
+			conditional: true,
+			jt:          t,
+			jf:          f,
+		})
+	}
+}
+
+// assemble resolves the labels to relative jump offsets, returning the final program
+func (c *compiler) assemble() ([]syscall.SockFilter, error) {
+	if len(c.program) > syscall.BPF_MAXINSNS {
+		return nil, errProgramTooLarge
+	}
+
+	prog := make([]syscall.SockFilter, len(c.program))
+	for i, ins := range c.program {
+		prog[i] = ins.SockFilter
+		if !ins.conditional {
+			continue
+		}
+
+		jt := c.labels[ins.jt] - (i + 1)
+		jf := c.labels[ins.jf] - (i + 1)
+		// conditional jumps only have 8 bits offsets
+		if jt < 0 || jt > 0xFF || jf < 0 || jf > 0xFF {
+			return nil, errProgramTooLarge
+		}
+		prog[i].Jt = uint8(jt)
+		prog[i].Jf = uint8(jf)
+	}
+	return prog, nil
+}
@@ -0,0 +1,315 @@
+package filter
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/NamelessOne91/bisturi/capture"
+	"github.com/NamelessOne91/bisturi/protocols"
+)
+
+var (
+	// 10.0.0.1:40000 -> 192.168.1.20:443 TCP SYN
+	tcp4Frame = []byte{
+		// Ethernet Frame
+		0x00, 0x1A, 0xA0, 0xBB, 0xCC, 0xDD, 0x00, 0x1A, 0xB0, 0xCC, 0xDD, 0xEE, 0x08, 0x00,
+		// IPv4 Header
+		0x45, 0x00, 0x00, 0x28, 0x1c, 0x46, 0x40, 0x00,
+		0x40, 0x06, 0x00, 0x00, 0x0a, 0x00, 0x00, 0x01,
+		0xc0, 0xa8, 0x01, 0x14,
+		// TCP Header
+		0x9c, 0x40, 0x01, 0xbb, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00,
+		0x50, 0x02, 0x20, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+	// 192.168.1.20:5353 -> 10.0.0.53:53 UDP, with IPv4 options
+	udp4Frame = []byte{
+		// Ethernet Frame
+		0x00, 0x1A, 0xB0, 0xCC, 0xDD, 0xEE, 0x00, 0x1A, 0xA0, 0xBB, 0xCC, 0xDD, 0x08, 0x00,
+		// IPv4 Header (IHL = 6)
+		0x46, 0x00, 0x00, 0x20, 0x1c, 0x47, 0x00, 0x00,
+		0x40, 0x11, 0x00, 0x00, 0xc0, 0xa8, 0x01, 0x14,
+		0x0a, 0x00, 0x00, 0x35,
+		0x01, 0x01, 0x01, 0x00,
+		// UDP Header
+		0x14, 0xe9, 0x00, 0x35, 0x00, 0x08, 0x00, 0x00,
+	}
+	// 2001:db8::1:1234 -> 2001:db8::2:80 TCP
+	tcp6Frame = []byte{
+		// Ethernet Frame
+		0x00, 0x1A, 0xA0, 0xBB, 0xCC, 0xDD, 0x00, 0x1A, 0xB0, 0xCC, 0xDD, 0xEE, 0x86, 0xDD,
+		// IPv6 Header
+		0x60, 0x00, 0x00, 0x00, 0x00, 0x14, 0x06, 0x40,
+		0x20, 0x01, 0x0d, 0xb8, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01,
+		0x20, 0x01, 0x0d, 0xb8, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02,
+		// TCP Header
+		0x04, 0xd2, 0x00, 0x50, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00,
+		0x50, 0x02, 0x20, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+	// who has 192.168.1.2? tell 192.168.1.1
+	arpFrame = []byte{
+		// Ethernet Frame
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x00, 0x1a, 0x2b, 0x3c, 0x4d, 0x5e, 0x08, 0x06,
+		// ARP Packet
+		0x00, 0x01, 0x08, 0x00, 0x06, 0x04, 0x00, 0x01,
+		0x00, 0x1a, 0x2b, 0x3c, 0x4d, 0x5e, 0xc0, 0xa8, 0x01, 0x01,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xc0, 0xa8, 0x01, 0x02,
+	}
+	// non initial fragment of a UDP datagram from 10.0.0.1 to 10.0.0.2
+	fragmentFrame = []byte{
+		// Ethernet Frame
+		0x00, 0x1A, 0xA0, 0xBB, 0xCC, 0xDD, 0x00, 0x1A, 0xB0, 0xCC, 0xDD, 0xEE, 0x08, 0x00,
+		// IPv4 Header, fragment offset 185
+		0x45, 0x00, 0x00, 0x1c, 0x1c, 0x48, 0x00, 0xb9,
+		0x40, 0x11, 0x00, 0x00, 0x0a, 0x00, 0x00, 0x01,
+		0x0a, 0x00, 0x00, 0x02,
+		// data which looks like ports 53 -> 53
+		0x00, 0x35, 0x00, 0x35, 0x00, 0x00, 0x00, 0x00,
+	}
+)
+
+// serialize returns the wire bytes of the layers, failing the test on errors
+func serialize(t *testing.T, layers ...protocols.SerializableLayer) []byte {
+	t.Helper()
+	raw, err := protocols.Serialize(layers...)
+	if err != nil {
+		t.Fatalf("expected no error serializing the layers - got %v", err)
+	}
+	return raw
+}
+
+// mldFrame returns the fe80::1 -> ff02::16 MLDv2 report, following a Hop-by-Hop header with the router alert
+func mldFrame(t *testing.T) []byte {
+	t.Helper()
+	return serialize(t,
+		&protocols.EthernetFrame{
+			DestinationMAC: net.HardwareAddr{0x33, 0x33, 0x00, 0x00, 0x00, 0x16},
+			SourceMAC:      net.HardwareAddr{0x00, 0x1A, 0xC0, 0x00, 0x00, 0x01},
+			EtherType:      0x86DD,
+		},
+		protocols.IPv6Header{
+			Version:       6,
+			NextHeader:    protocols.IPv6ExtHopByHop,
+			HopLimit:      1,
+			SourceIP:      netip.MustParseAddr("fe80::1"),
+			DestinationIP: netip.MustParseAddr("ff02::16"),
+			Extensions: []protocols.IPv6ExtensionHeader{
+				&protocols.IPv6HopByHopHeader{NextHeader: 58, Options: []protocols.IPv6Option{{Type: protocols.IPv6OptionRouterAlert, Data: []byte{0, 0}}}},
+			},
+		},
+		&protocols.ICMPv6Header{Type: protocols.ICMPv6TypeMLDv2Report},
+		&protocols.MLDMessage{
+			Type:    protocols.ICMPv6TypeMLDv2Report,
+			Records: []protocols.MLDAddressRecord{{Type: 4, MulticastAddress: netip.MustParseAddr("ff02::fb")}},
+		},
+	)
+}
+
+// udp6Frame returns the 2001:db8::1:5353 -> 2001:db8::3:53 UDP datagram, following an empty Hop-by-Hop header
+func udp6Frame(t *testing.T) []byte {
+	t.Helper()
+	return serialize(t,
+		&protocols.EthernetFrame{
+			DestinationMAC: net.HardwareAddr{0x00, 0x1A, 0xA0, 0xBB, 0xCC, 0xDD},
+			SourceMAC:      net.HardwareAddr{0x00, 0x1A, 0xC0, 0x00, 0x00, 0x01},
+			EtherType:      0x86DD,
+		},
+		protocols.IPv6Header{
+			Version:       6,
+			NextHeader:    protocols.IPv6ExtHopByHop,
+			HopLimit:      64,
+			SourceIP:      netip.MustParseAddr("2001:db8::1"),
+			DestinationIP: netip.MustParseAddr("2001:db8::3"),
+			Extensions:    []protocols.IPv6ExtensionHeader{&protocols.IPv6HopByHopHeader{NextHeader: 17}},
+		},
+		&protocols.UDPHeader{SourcePort: 5353, DestinationPort: 53},
+	)
+}
+
+func TestCompile(t *testing.T) {
+	frames := map[string][]byte{
+		"tcp4":     tcp4Frame,
+		"udp4":     udp4Frame,
+		"tcp6":     tcp6Frame,
+		"arp":      arpFrame,
+		"fragment": fragmentFrame,
+		"mld":      mldFrame(t),
+		"udp6":     udp6Frame(t),
+	}
+
+	tests := []struct {
+		expression string
+		matching   []string
+	}{
+		{expression: "", matching: []string{"tcp4", "udp4", "tcp6", "arp", "fragment", "mld", "udp6"}},
+		{expression: "ip", matching: []string{"tcp4", "udp4", "fragment"}},
+		{expression: "ip6", matching: []string{"tcp6", "mld", "udp6"}},
+		{expression: "arp", matching: []string{"arp"}},
+		{expression: "not arp", matching: []string{"tcp4", "udp4", "tcp6", "fragment", "mld", "udp6"}},
+		{expression: "tcp", matching: []string{"tcp4", "tcp6"}},
+		{expression: "udp", matching: []string{"udp4", "fragment", "udp6"}},
+		{expression: "ip and tcp", matching: []string{"tcp4"}},
+		{expression: "host 10.0.0.1", matching: []string{"tcp4", "fragment"}},
+		{expression: "src host 192.168.1.20", matching: []string{"udp4"}},
+		{expression: "dst host 192.168.1.20", matching: []string{"tcp4"}},
+		{expression: "host 192.168.1.1", matching: []string{"arp"}},
+		{expression: "ip host 192.168.1.1", matching: nil},
+		{expression: "arp dst host 192.168.1.2", matching: []string{"arp"}},
+		{expression: "host 2001:db8::2", matching: []string{"tcp6"}},
+		{expression: "net 192.168.0.0/16", matching: []string{"tcp4", "udp4", "arp"}},
+		{expression: "src net 10.0.0.0/8", matching: []string{"tcp4", "fragment"}},
+		{expression: "net 2001:db8::/32", matching: []string{"tcp6", "udp6"}},
+		{expression: "ip6 net 2001:db9::/32", matching: nil},
+		{expression: "port 443", matching: []string{"tcp4"}},
+		{expression: "udp and dst port 53", matching: []string{"udp4", "udp6"}},
+		{expression: "udp port 53", matching: []string{"udp4", "udp6"}},
+		{expression: "src port 5353", matching: []string{"udp4", "udp6"}},
+		{expression: "tcp port 53", matching: nil},
+		{expression: "src port 1234", matching: []string{"tcp6"}},
+		{expression: "portrange 50-100", matching: []string{"udp4", "tcp6", "udp6"}},
+		{expression: "tcp portrange 400-500", matching: []string{"tcp4"}},
+		{expression: "host 10.0.0.1 and tcp port 443", matching: []string{"tcp4"}},
+		{expression: "host 10.0.0.1 or 10.0.0.53", matching: []string{"tcp4", "udp4", "fragment"}},
+		{expression: "port 443 or 80", matching: []string{"tcp4", "tcp6"}},
+		{expression: "tcp && !(port 80)", matching: []string{"tcp4"}},
+		{expression: "not (tcp or udp)", matching: []string{"arp", "mld"}},
+		{expression: "ether host 00:1a:2b:3c:4d:5e", matching: []string{"arp"}},
+		{expression: "ether dst ff:ff:ff:ff:ff:ff", matching: []string{"arp"}},
+		{expression: "ether src 00:1a:b0:cc:dd:ee", matching: []string{"tcp4", "tcp6", "fragment"}},
+		{expression: "ether proto 0x0806", matching: []string{"arp"}},
+		{expression: "ip proto 17", matching: []string{"udp4", "fragment"}},
+		{expression: "proto tcp", matching: []string{"tcp4", "tcp6"}},
+		{expression: "less 60", matching: []string{"tcp4", "udp4", "arp", "fragment"}},
+		{expression: "greater 60", matching: []string{"tcp6", "mld", "udp6"}},
+		{expression: "icmp6", matching: []string{"mld"}},
+		{expression: "icmp or icmp6", matching: []string{"mld"}},
+		{expression: "ip6 and not udp", matching: []string{"tcp6", "mld"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			prog, err := Compile(tt.expression)
+			if err != nil {
+				t.Fatalf("expected no error - got %v", err)
+			}
+
+			expected := map[string]bool{}
+			for _, name := range tt.matching {
+				expected[name] = true
+			}
+			for name, frame := range frames {
+				if got := Match(prog, frame); got != expected[name] {
+					t.Errorf("expected %s frame match to be %v - got %v", name, expected[name], got)
+				}
+			}
+		})
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []string{
+		"host",
+		"host 10.0.0.256",
+		"tcp host 10.0.0.1",
+		"port 70000",
+		"portrange 10",
+		"ip6 net 10.0.0.0/8",
+		"(tcp",
+		"tcp)",
+		"tcp and",
+		"udp & port 53",
+		"ether",
+		"ether host 10.0.0.1",
+		"less",
+		"ip proto foo",
+		"tcp port 80 udp",
+		"host 10.0.0.1 $",
+		"vlan 4096",
+	}
+
+	for _, expression := range tests {
+		t.Run(expression, func(t *testing.T) {
+			if _, err := Compile(expression); err == nil {
+				t.Errorf("expected an error compiling %q", expression)
+			}
+		})
+	}
+}
+
+func TestCompileTooLarge(t *testing.T) {
+	expression := "host 10.0.0.1"
+	for i := 2; i < 100; i++ {
+		expression += " or 10.0.0.1"
+	}
+
+	if _, err := Compile(expression); err != errProgramTooLarge {
+		t.Errorf("expected error: %v - got %v", errProgramTooLarge, err)
+	}
+}
+
+func TestCompileDirection(t *testing.T) {
+	tests := []struct {
+		expression string
+		packetType capture.PacketType
+		expected   bool
+	}{
+		{expression: "outbound", packetType: capture.PacketTypeOutgoing, expected: true},
+		{expression: "outbound", packetType: capture.PacketTypeHost, expected: false},
+		{expression: "inbound", packetType: capture.PacketTypeBroadcast, expected: true},
+		{expression: "inbound", packetType: capture.PacketTypeOutgoing, expected: false},
+		{expression: "outbound and tcp port 443", packetType: capture.PacketTypeOutgoing, expected: true},
+		{expression: "inbound or udp", packetType: capture.PacketTypeOutgoing, expected: false},
+		// frames read from a file have no direction
+		{expression: "outbound", packetType: capture.PacketTypeUnknown, expected: false},
+		{expression: "inbound", packetType: capture.PacketTypeUnknown, expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s %s", tt.expression, tt.packetType), func(t *testing.T) {
+			prog, err := Compile(tt.expression)
+			if err != nil {
+				t.Fatalf("expected no error - got %v", err)
+			}
+			if got := MatchWithMetadata(prog, tcp4Frame, capture.Metadata{PacketType: tt.packetType}); got != tt.expected {
+				t.Errorf("expected match to be %v - got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestCompileVLAN(t *testing.T) {
+	// the tcp4 frame with an 802.1Q tag for VLAN 10, priority 5, still in the frame
+	tagged := append(append(append([]byte{}, tcp4Frame[:12]...), 0x81, 0x00, 0xa0, 0x0a), tcp4Frame[12:]...)
+	stripped := capture.Metadata{VLAN: capture.VLAN{Present: true, TPID: 0x8100, TCI: 0xa00a}}
+
+	tests := []struct {
+		name       string
+		expression string
+		frame      []byte
+		md         capture.Metadata
+		expected   bool
+	}{
+		{name: "stripped", expression: "vlan", frame: tcp4Frame, md: stripped, expected: true},
+		{name: "stripped", expression: "vlan 10", frame: tcp4Frame, md: stripped, expected: true},
+		{name: "stripped", expression: "vlan 20", frame: tcp4Frame, md: stripped, expected: false},
+		{name: "stripped", expression: "vlan 10 and not vlan 20", frame: tcp4Frame, md: stripped, expected: true},
+		{name: "in frame", expression: "vlan", frame: tagged, expected: true},
+		{name: "in frame", expression: "vlan 10", frame: tagged, expected: true},
+		{name: "in frame", expression: "vlan 20", frame: tagged, expected: false},
+		{name: "untagged", expression: "vlan", frame: tcp4Frame, expected: false},
+		{name: "untagged", expression: "vlan 10 or tcp", frame: tcp4Frame, expected: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s %s", tt.expression, tt.name), func(t *testing.T) {
+			prog, err := Compile(tt.expression)
+			if err != nil {
+				t.Fatalf("expected no error - got %v", err)
+			}
+			if got := MatchWithMetadata(prog, tt.frame, tt.md); got != tt.expected {
+				t.Errorf("expected match to be %v - got %v", tt.expected, got)
+			}
+		})
+	}
+}
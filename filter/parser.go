@@ -0,0 +1,246 @@
+package filter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// qualifiers precede the value of a primitive, as in "tcp dst port 443",
+// and define how the value has to be matched
+type qualifiers struct {
+	proto string // ether, ip, ip6, arp, tcp, udp, icmp, icmp6
+	dir   string // src, dst
+	kind  string // host, net, port, portrange, proto
+}
+
+var protoQualifiers = map[string]bool{
+	"ether": true,
+	"ip":    true,
+	"ip6":   true,
+	"arp":   true,
+	"tcp":   true,
+	"udp":   true,
+	"icmp":  true,
+	"icmp6": true,
+}
+
+var dirQualifiers = map[string]bool{
+	"src": true,
+	"dst": true,
+}
+
+var kindQualifiers = map[string]bool{
+	"host":      true,
+	"net":       true,
+	"port":      true,
+	"portrange": true,
+	"proto":     true,
+}
+
+// parser is a recursive descent parser turning a list of tokens into an expression tree.
+// The grammar, a subset of pcap-filter(7), is:
+//
+//	or      = and { ("or" | "||") and }
+//	and     = unary { ("and" | "&&") unary }
+//	unary   = ("not" | "!") unary | primary
+//	primary = "(" or ")" | primitive
+type parser struct {
+	tokens []token
+	pos    int
+	// qualifiers of the last parsed primitive, inherited by bare values as in "host a or b"
+	last *qualifiers
+}
+
+// parse returns the expression tree for the provided tokens
+func parse(tokens []token) (expr, error) {
+	p := &parser{tokens: tokens}
+
+	e, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, fmt.Errorf("unexpected %s at position %d", t, t.pos)
+	}
+	return e, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+// peekWord returns the lowercase text of the next token if it is a word, or an empty string
+func (p *parser) peekWord() string {
+	if t := p.peek(); t.kind == tokenWord {
+		return strings.ToLower(t.text)
+	}
+	return ""
+}
+
+func (p *parser) parseOr() (expr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peek().kind == tokenOr {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orExpr{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (expr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peek().kind == tokenAnd {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = andExpr{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (expr, error) {
+	if p.peek().kind == tokenNot {
+		p.next()
+		e, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notExpr{e: e}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (expr, error) {
+	t := p.peek()
+
+	switch t.kind {
+	case tokenLParen:
+		p.next()
+		e, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.next(); closing.kind != tokenRParen {
+			return nil, fmt.Errorf("expected \")\" at position %d - got %s", closing.pos, closing)
+		}
+		return e, nil
+	case tokenWord:
+		return p.parsePrimitive()
+	default:
+		return nil, fmt.Errorf("unexpected %s at position %d", t, t.pos)
+	}
+}
+
+// parsePrimitive parses a single test, like "arp", "less 128" or "src net 10.0.0.0/8"
+func (p *parser) parsePrimitive() (expr, error) {
+	switch word := p.peekWord(); word {
+	case "inbound", "outbound":
+		p.next()
+		if word == "inbound" {
+			return inbound(), nil
+		}
+		return outbound(), nil
+	case "vlan":
+		p.next()
+		if _, err := strconv.ParseUint(p.peekWord(), 0, 32); err != nil {
+			return vlan(0, true), nil
+		}
+		id, err := p.parseNumber()
+		if err != nil {
+			return nil, err
+		}
+		if id > vlanIDMask {
+			return nil, fmt.Errorf("invalid VLAN ID %d: must be at most %d", id, vlanIDMask)
+		}
+		return vlan(id, false), nil
+	case "less", "greater":
+		p.next()
+		n, err := p.parseNumber()
+		if err != nil {
+			return nil, err
+		}
+		if word == "less" {
+			return lengthAtMost(n), nil
+		}
+		return lengthAtLeast(n), nil
+	}
+
+	var q qualifiers
+	qualified := false
+	if w := p.peekWord(); protoQualifiers[w] {
+		q.proto = w
+		qualified = true
+		p.next()
+	}
+	if w := p.peekWord(); dirQualifiers[w] {
+		q.dir = w
+		qualified = true
+		p.next()
+	}
+	if w := p.peekWord(); kindQualifiers[w] {
+		q.kind = w
+		qualified = true
+		p.next()
+	}
+
+	// a protocol on its own, like "tcp" in "tcp and not port 22"
+	if q.proto != "" && q.dir == "" && q.kind == "" && p.peek().kind != tokenWord {
+		p.last = nil
+		return protocolExpr(q.proto)
+	}
+
+	t := p.next()
+	if t.kind != tokenWord {
+		return nil, fmt.Errorf("expected a value at position %d - got %s", t.pos, t)
+	}
+
+	if !qualified && p.last != nil {
+		q = *p.last
+	}
+	if q.kind == "" {
+		q.kind = "host"
+	}
+	p.last = &q
+
+	e, err := q.build(t.text)
+	if err != nil {
+		return nil, fmt.Errorf("%v at position %d", err, t.pos)
+	}
+	return e, nil
+}
+
+// parseNumber consumes the next token, expecting a non negative integer
+func (p *parser) parseNumber() (uint32, error) {
+	t := p.next()
+	if t.kind != tokenWord {
+		return 0, fmt.Errorf("expected a number at position %d - got %s", t.pos, t)
+	}
+
+	n, err := strconv.ParseUint(t.text, 0, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number %q at position %d", t.text, t.pos)
+	}
+	return uint32(n), nil
+}
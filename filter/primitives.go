@@ -0,0 +1,529 @@
+package filter
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// expr is a node of the boolean expression tree a filter is compiled from
+type expr interface {
+	exprNode()
+}
+
+type andExpr struct {
+	left, right expr
+}
+
+type orExpr struct {
+	left, right expr
+}
+
+type notExpr struct {
+	e expr
+}
+
+// test is the leaf of an expression tree: a few instructions loading the accumulator
+// followed by a single conditional jump comparing it against k
+type test struct {
+	load []syscall.SockFilter
+	jump uint16
+	k    uint32
+}
+
+func (andExpr) exprNode() {}
+func (orExpr) exprNode()  {}
+func (notExpr) exprNode() {}
+func (test) exprNode()    {}
+
+// and returns the conjunction of the passed expressions
+func and(exprs ...expr) expr {
+	e := exprs[0]
+	for _, r := range exprs[1:] {
+		e = andExpr{left: e, right: r}
+	}
+	return e
+}
+
+// or returns the disjunction of the passed expressions
+func or(exprs ...expr) expr {
+	e := exprs[0]
+	for _, r := range exprs[1:] {
+		e = orExpr{left: e, right: r}
+	}
+	return e
+}
+
+// offsets in an untagged Ethernet frame
+const (
+	ethDstOffset  = 0
+	ethSrcOffset  = 6
+	ethTypeOffset = 12
+	l3Offset      = 14
+
+	ipv4FragOffset  = l3Offset + 6
+	ipv4ProtoOffset = l3Offset + 9
+	ipv4SrcOffset   = l3Offset + 12
+	ipv4DstOffset   = l3Offset + 16
+
+	ipv6NextHeaderOffset = l3Offset + 6
+	ipv6SrcOffset        = l3Offset + 8
+	ipv6DstOffset        = l3Offset + 24
+	ipv6PayloadOffset    = l3Offset + 40
+	// the Next Header and length fields of a Hop-by-Hop header following the IPv6 header
+	ipv6HopByHopNextOffset = ipv6PayloadOffset
+	ipv6HopByHopLenOffset  = ipv6PayloadOffset + 1
+
+	arpSenderProtoOffset = l3Offset + 14
+	arpTargetProtoOffset = l3Offset + 24
+)
+
+const (
+	etherTypeIPv4 = 0x0800
+	etherTypeARP  = 0x0806
+	etherTypeIPv6 = 0x86DD
+	etherTypeVLAN = 0x8100
+	etherTypeQinQ = 0x88A8
+	vlanTCIOffset = l3Offset
+	vlanIDMask    = 0x0FFF
+)
+
+// ipv6HopByHop is the Next Header value of the Hop-by-Hop extension header,
+// like the one carrying the router alert of MLD messages
+const ipv6HopByHop = 0
+
+// maps the protocol names to their IANA protocol number
+var ipProtocolNumbers = map[string]uint32{
+	"icmp":  1,
+	"igmp":  2,
+	"tcp":   6,
+	"udp":   17,
+	"icmp6": 58,
+}
+
+// maps the protocol names to their EtherType
+var etherTypeNumbers = map[string]uint32{
+	"ip":  etherTypeIPv4,
+	"arp": etherTypeARP,
+	"ip6": etherTypeIPv6,
+}
+
+func loadAbs(size uint16, offset uint32) syscall.SockFilter {
+	return syscall.SockFilter{Code: syscall.BPF_LD | size | syscall.BPF_ABS, K: offset}
+}
+
+// equals returns a test comparing the value of the given size at the given offset with k
+func equals(size uint16, offset, k uint32) test {
+	return test{
+		load: []syscall.SockFilter{loadAbs(size, offset)},
+		jump: syscall.BPF_JEQ,
+		k:    k,
+	}
+}
+
+// maskedEquals returns a test comparing the 32 bit word at the given offset, masked, with k
+func maskedEquals(offset, mask, k uint32) test {
+	if mask == 0xFFFFFFFF {
+		return equals(syscall.BPF_W, offset, k)
+	}
+	return test{
+		load: []syscall.SockFilter{
+			loadAbs(syscall.BPF_W, offset),
+			{Code: syscall.BPF_ALU | syscall.BPF_AND | syscall.BPF_K, K: mask},
+		},
+		jump: syscall.BPF_JEQ,
+		k:    k & mask,
+	}
+}
+
+func etherType(t uint32) test {
+	return equals(syscall.BPF_H, ethTypeOffset, t)
+}
+
+func ipv4Protocol(proto uint32) expr {
+	return and(etherType(etherTypeIPv4), equals(syscall.BPF_B, ipv4ProtoOffset, proto))
+}
+
+// ipv6Protocol matches the IPv6 packets carrying the protocol, directly or after a Hop-by-Hop header.
+// Longer chains of extension headers are not followed
+func ipv6Protocol(proto uint32) expr {
+	return and(etherType(etherTypeIPv6), or(ipv6Direct(proto), ipv6AfterHopByHop(proto)))
+}
+
+// ipv6Direct matches the IPv6 packets whose Next Header is the protocol
+func ipv6Direct(proto uint32) expr {
+	return equals(syscall.BPF_B, ipv6NextHeaderOffset, proto)
+}
+
+// ipv6AfterHopByHop matches the IPv6 packets whose Hop-by-Hop header is followed by the protocol
+func ipv6AfterHopByHop(proto uint32) expr {
+	return and(
+		equals(syscall.BPF_B, ipv6NextHeaderOffset, ipv6HopByHop),
+		equals(syscall.BPF_B, ipv6HopByHopNextOffset, proto),
+	)
+}
+
+// lengthAtMost matches frames whose length is less than or equal to n
+func lengthAtMost(n uint32) expr {
+	return notExpr{e: lengthGreater(n)}
+}
+
+// lengthAtLeast matches frames whose length is greater than or equal to n
+func lengthAtLeast(n uint32) expr {
+	return test{
+		load: []syscall.SockFilter{{Code: syscall.BPF_LD | syscall.BPF_W | syscall.BPF_LEN}},
+		jump: syscall.BPF_JGE,
+		k:    n,
+	}
+}
+
+// outbound matches the frames sent by the host, according to the packet type the kernel assigned to them
+func outbound() expr {
+	return equals(syscall.BPF_B, skfAdOff+skfAdPktType, syscall.PACKET_OUTGOING)
+}
+
+// inbound matches the frames received by the host
+func inbound() expr {
+	return notExpr{e: outbound()}
+}
+
+// vlan matches the frames tagged with the given VLAN ID, or with any if anyID is set.
+// The tag is either the one stripped by the kernel, reported by the ancillary loads, or the outermost one in the frame
+func vlan(id uint32, anyID bool) expr {
+	stripped := equals(syscall.BPF_W, skfAdOff+skfAdVLANTagPresent, 1)
+	inFrame := or(etherType(etherTypeVLAN), etherType(etherTypeQinQ))
+	if anyID {
+		return or(stripped, inFrame)
+	}
+
+	return or(
+		and(stripped, test{
+			load: []syscall.SockFilter{
+				loadAbs(syscall.BPF_W, skfAdOff+skfAdVLANTag),
+				{Code: syscall.BPF_ALU | syscall.BPF_AND | syscall.BPF_K, K: vlanIDMask},
+			},
+			jump: syscall.BPF_JEQ,
+			k:    id,
+		}),
+		and(inFrame, test{
+			load: []syscall.SockFilter{
+				loadAbs(syscall.BPF_H, vlanTCIOffset),
+				{Code: syscall.BPF_ALU | syscall.BPF_AND | syscall.BPF_K, K: vlanIDMask},
+			},
+			jump: syscall.BPF_JEQ,
+			k:    id,
+		}),
+	)
+}
+
+func lengthGreater(n uint32) expr {
+	return test{
+		load: []syscall.SockFilter{{Code: syscall.BPF_LD | syscall.BPF_W | syscall.BPF_LEN}},
+		jump: syscall.BPF_JGT,
+		k:    n,
+	}
+}
+
+// protocolExpr returns the expression matching any packet of the given protocol
+func protocolExpr(proto string) (expr, error) {
+	switch proto {
+	case "ip", "ip6", "arp":
+		return etherType(etherTypeNumbers[proto]), nil
+	case "tcp", "udp":
+		n := ipProtocolNumbers[proto]
+		return or(ipv4Protocol(n), ipv6Protocol(n)), nil
+	case "icmp":
+		return ipv4Protocol(ipProtocolNumbers[proto]), nil
+	case "icmp6":
+		return ipv6Protocol(ipProtocolNumbers[proto]), nil
+	default:
+		return nil, fmt.Errorf("%q must be followed by a qualifier", proto)
+	}
+}
+
+// srcOrDst combines the tests built for the source and destination offsets according to the direction
+func srcOrDst(dir string, build func(src bool) expr) expr {
+	switch dir {
+	case "src":
+		return build(true)
+	case "dst":
+		return build(false)
+	default:
+		return or(build(true), build(false))
+	}
+}
+
+// build returns the expression matching the value according to the qualifiers
+func (q qualifiers) build(value string) (expr, error) {
+	switch q.kind {
+	case "host":
+		return q.hostExpr(value)
+	case "net":
+		return q.netExpr(value)
+	case "port":
+		port, err := parsePort(value)
+		if err != nil {
+			return nil, err
+		}
+		return q.portExpr(port, port)
+	case "portrange":
+		lo, hi, found := strings.Cut(value, "-")
+		if !found {
+			return nil, fmt.Errorf("invalid port range %q", value)
+		}
+		from, err := parsePort(lo)
+		if err != nil {
+			return nil, err
+		}
+		to, err := parsePort(hi)
+		if err != nil {
+			return nil, err
+		}
+		if from > to {
+			from, to = to, from
+		}
+		return q.portExpr(from, to)
+	case "proto":
+		return q.protoExpr(value)
+	default:
+		return nil, fmt.Errorf("unknown qualifier %q", q.kind)
+	}
+}
+
+func (q qualifiers) hostExpr(value string) (expr, error) {
+	if q.proto == "ether" {
+		mac, err := net.ParseMAC(value)
+		if err != nil || len(mac) != 6 {
+			return nil, fmt.Errorf("invalid MAC address %q", value)
+		}
+		return macExpr(q.dir, mac), nil
+	}
+
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		// a MAC address without the ether qualifier is still unambiguous
+		if mac, err := net.ParseMAC(value); err == nil && len(mac) == 6 && q.proto == "" {
+			return macExpr(q.dir, mac), nil
+		}
+		return nil, fmt.Errorf("invalid host %q", value)
+	}
+	return q.addrExpr(netip.PrefixFrom(addr, addr.BitLen()))
+}
+
+func (q qualifiers) netExpr(value string) (expr, error) {
+	prefix, err := netip.ParsePrefix(value)
+	if err != nil {
+		addr, addrErr := netip.ParseAddr(value)
+		if addrErr != nil {
+			return nil, fmt.Errorf("invalid network %q", value)
+		}
+		prefix = netip.PrefixFrom(addr, addr.BitLen())
+	}
+	return q.addrExpr(prefix.Masked())
+}
+
+// addrExpr matches the IPv4 or IPv6 addresses belonging to the prefix
+func (q qualifiers) addrExpr(prefix netip.Prefix) (expr, error) {
+	if prefix.Addr().Is4() {
+		ip := binary.BigEndian.Uint32(prefix.Addr().AsSlice())
+		mask := uint32(0xFFFFFFFF) << (32 - prefix.Bits())
+		if prefix.Bits() == 0 {
+			mask = 0
+		}
+
+		ipv4 := and(etherType(etherTypeIPv4), srcOrDst(q.dir, func(src bool) expr {
+			if src {
+				return maskedEquals(ipv4SrcOffset, mask, ip)
+			}
+			return maskedEquals(ipv4DstOffset, mask, ip)
+		}))
+		arp := and(etherType(etherTypeARP), srcOrDst(q.dir, func(src bool) expr {
+			if src {
+				return maskedEquals(arpSenderProtoOffset, mask, ip)
+			}
+			return maskedEquals(arpTargetProtoOffset, mask, ip)
+		}))
+
+		switch q.proto {
+		case "":
+			return or(ipv4, arp), nil
+		case "ip":
+			return ipv4, nil
+		case "arp":
+			return arp, nil
+		default:
+			return nil, fmt.Errorf("%s qualifier not valid for IPv4 addresses", q.proto)
+		}
+	}
+
+	if q.proto != "" && q.proto != "ip6" {
+		return nil, fmt.Errorf("%s qualifier not valid for IPv6 addresses", q.proto)
+	}
+
+	raw := prefix.Addr().As16()
+	return and(etherType(etherTypeIPv6), srcOrDst(q.dir, func(src bool) expr {
+		offset := uint32(ipv6DstOffset)
+		if src {
+			offset = ipv6SrcOffset
+		}
+
+		var words []expr
+		for i, bits := 0, prefix.Bits(); i < 4 && bits > 0; i, bits = i+1, bits-32 {
+			mask := uint32(0xFFFFFFFF)
+			if bits < 32 {
+				mask <<= 32 - bits
+			}
+			word := binary.BigEndian.Uint32(raw[i*4 : i*4+4])
+			words = append(words, maskedEquals(offset+uint32(i*4), mask, word))
+		}
+		if len(words) == 0 {
+			// "::/0" matches every IPv6 packet
+			return etherType(etherTypeIPv6)
+		}
+		return and(words...)
+	})), nil
+}
+
+// macExpr matches the frames with the given source and/or destination MAC address
+func macExpr(dir string, mac net.HardwareAddr) expr {
+	high := binary.BigEndian.Uint32(mac[0:4])
+	low := uint32(binary.BigEndian.Uint16(mac[4:6]))
+
+	return srcOrDst(dir, func(src bool) expr {
+		offset := uint32(ethDstOffset)
+		if src {
+			offset = ethSrcOffset
+		}
+		return and(
+			equals(syscall.BPF_W, offset, high),
+			equals(syscall.BPF_H, offset+4, low),
+		)
+	})
+}
+
+// portExpr matches the TCP and/or UDP segments whose port lies in the given range
+func (q qualifiers) portExpr(from, to uint32) (expr, error) {
+	var protos []uint32
+	switch q.proto {
+	case "":
+		protos = []uint32{ipProtocolNumbers["tcp"], ipProtocolNumbers["udp"]}
+	case "tcp", "udp":
+		protos = []uint32{ipProtocolNumbers[q.proto]}
+	default:
+		return nil, fmt.Errorf("%s qualifier not valid for ports", q.proto)
+	}
+
+	var exprs []expr
+	for _, proto := range protos {
+		// the port is read through the X register, loaded with the IPv4 header length.
+		// Non initial fragments do not carry the transport layer header and never match
+		ipv4 := and(
+			ipv4Protocol(proto),
+			notExpr{e: test{
+				load: []syscall.SockFilter{loadAbs(syscall.BPF_H, ipv4FragOffset)},
+				jump: syscall.BPF_JSET,
+				k:    0x1FFF,
+			}},
+			srcOrDst(q.dir, func(src bool) expr {
+				offset := uint32(l3Offset + 2)
+				if src {
+					offset = l3Offset
+				}
+				return portInRange(from, to, []syscall.SockFilter{
+					{Code: syscall.BPF_LDX | syscall.BPF_B | syscall.BPF_MSH, K: l3Offset},
+					{Code: syscall.BPF_LD | syscall.BPF_H | syscall.BPF_IND, K: offset},
+				})
+			}),
+		)
+		ipv6 := and(
+			etherType(etherTypeIPv6),
+			ipv6Direct(proto),
+			srcOrDst(q.dir, func(src bool) expr {
+				offset := uint32(ipv6PayloadOffset + 2)
+				if src {
+					offset = ipv6PayloadOffset
+				}
+				return portInRange(from, to, []syscall.SockFilter{loadAbs(syscall.BPF_H, offset)})
+			}),
+		)
+		// behind a Hop-by-Hop header, the port is read through the X register, loaded with the
+		// header length in 8-byte units, not including its first 8 bytes
+		hopByHop := and(
+			etherType(etherTypeIPv6),
+			ipv6AfterHopByHop(proto),
+			srcOrDst(q.dir, func(src bool) expr {
+				offset := uint32(ipv6PayloadOffset + 8 + 2)
+				if src {
+					offset = ipv6PayloadOffset + 8
+				}
+				return portInRange(from, to, []syscall.SockFilter{
+					loadAbs(syscall.BPF_B, ipv6HopByHopLenOffset),
+					{Code: syscall.BPF_ALU | syscall.BPF_LSH | syscall.BPF_K, K: 3},
+					{Code: syscall.BPF_MISC | syscall.BPF_TAX},
+					{Code: syscall.BPF_LD | syscall.BPF_H | syscall.BPF_IND, K: offset},
+				})
+			}),
+		)
+		exprs = append(exprs, ipv4, ipv6, hopByHop)
+	}
+	return or(exprs...), nil
+}
+
+// portInRange compares the port read by the load instructions with the given range
+func portInRange(from, to uint32, load []syscall.SockFilter) expr {
+	if from == to {
+		return test{load: load, jump: syscall.BPF_JEQ, k: from}
+	}
+	return and(
+		test{load: load, jump: syscall.BPF_JGE, k: from},
+		notExpr{e: test{load: load, jump: syscall.BPF_JGT, k: to}},
+	)
+}
+
+// protoExpr matches the packets whose EtherType or IP protocol is the given value,
+// as in "ether proto 0x0806" or "ip proto udp"
+func (q qualifiers) protoExpr(value string) (expr, error) {
+	if q.proto == "ether" {
+		n, ok := etherTypeNumbers[strings.ToLower(value)]
+		if !ok {
+			parsed, err := strconv.ParseUint(value, 0, 16)
+			if err != nil {
+				return nil, fmt.Errorf("invalid EtherType %q", value)
+			}
+			n = uint32(parsed)
+		}
+		return etherType(n), nil
+	}
+
+	n, ok := ipProtocolNumbers[strings.ToLower(value)]
+	if !ok {
+		parsed, err := strconv.ParseUint(value, 0, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IP protocol %q", value)
+		}
+		n = uint32(parsed)
+	}
+
+	switch q.proto {
+	case "":
+		return or(ipv4Protocol(n), ipv6Protocol(n)), nil
+	case "ip":
+		return ipv4Protocol(n), nil
+	case "ip6":
+		return ipv6Protocol(n), nil
+	default:
+		return nil, fmt.Errorf("%s qualifier not valid for protocols", q.proto)
+	}
+}
+
+func parsePort(value string) (uint32, error) {
+	port, err := strconv.ParseUint(value, 10, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid port %q", value)
+	}
+	return uint32(port), nil
+}
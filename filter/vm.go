@@ -0,0 +1,269 @@
+package filter
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"syscall"
+
+	"github.com/NamelessOne91/bisturi/capture"
+)
+
+// opcodes missing from the syscall package
+const (
+	bpfMod = 0x90
+	bpfXor = 0xa0
+)
+
+// loads from offsets starting at skfAdOff (SKF_AD_OFF) read data the kernel knows about the frame,
+// instead of the frame's bytes
+const (
+	skfAdOff            = 0xfffff000
+	skfAdPktType        = 4
+	skfAdVLANTag        = 44
+	skfAdVLANTagPresent = 48
+)
+
+var errFellOffProgram = errors.New("BPF program ended without a return instruction")
+
+// Run executes the classic BPF program against the frame, the same way the kernel does,
+// and returns the number of bytes of the frame to keep: 0 means the frame is rejected.
+// An error is returned if the program is invalid. Frames are rejected by the packet type ancillary load,
+// their data not being known, and carry no stripped VLAN tag
+func Run(prog []syscall.SockFilter, frame []byte) (uint32, error) {
+	return RunWithMetadata(prog, frame, capture.Metadata{})
+}
+
+// RunWithMetadata executes the program like Run, serving the packet type (SKF_AD_PKTTYPE) and VLAN tag
+// (SKF_AD_VLAN_TAG, SKF_AD_VLAN_TAG_PRESENT) ancillary loads from the frame's metadata.
+// Frames whose packet type is unknown are rejected by the load
+func RunWithMetadata(prog []syscall.SockFilter, frame []byte, md capture.Metadata) (uint32, error) {
+	var a, x uint32
+	var mem [syscall.BPF_MEMWORDS]uint32
+
+	// load returns the size bytes at the offset, reporting if they are out of the frame's bounds
+	load := func(offset uint32, size uint16) (uint32, bool) {
+		var n uint32
+		switch size {
+		case syscall.BPF_W:
+			n = 4
+		case syscall.BPF_H:
+			n = 2
+		case syscall.BPF_B:
+			n = 1
+		default:
+			return 0, false
+		}
+		if uint64(offset)+uint64(n) > uint64(len(frame)) {
+			return 0, false
+		}
+
+		switch n {
+		case 4:
+			return binary.BigEndian.Uint32(frame[offset:]), true
+		case 2:
+			return uint32(binary.BigEndian.Uint16(frame[offset:])), true
+		default:
+			return uint32(frame[offset]), true
+		}
+	}
+
+	for pc := 0; pc < len(prog); pc++ {
+		ins := prog[pc]
+		code := ins.Code
+
+		switch code & 0x07 {
+		case syscall.BPF_LD:
+			size := code & 0x18
+			switch code & 0xe0 {
+			case syscall.BPF_IMM:
+				a = ins.K
+			case syscall.BPF_MEM:
+				if ins.K >= syscall.BPF_MEMWORDS {
+					return 0, fmt.Errorf("invalid scratch memory index %d at instruction %d", ins.K, pc)
+				}
+				a = mem[ins.K]
+			case syscall.BPF_LEN:
+				a = uint32(len(frame))
+			case syscall.BPF_ABS, syscall.BPF_IND:
+				if code&0xe0 == syscall.BPF_ABS && ins.K >= skfAdOff {
+					v, ok := ancillary(ins.K-skfAdOff, md)
+					if !ok {
+						return 0, nil
+					}
+					a = v
+					break
+				}
+				offset := ins.K
+				if code&0xe0 == syscall.BPF_IND {
+					offset += x
+				}
+				v, ok := load(offset, size)
+				if !ok {
+					// out of bounds reads reject the frame
+					return 0, nil
+				}
+				a = v
+			default:
+				return 0, fmt.Errorf("invalid load instruction 0x%x at %d", code, pc)
+			}
+
+		case syscall.BPF_LDX:
+			switch code & 0xe0 {
+			case syscall.BPF_IMM:
+				x = ins.K
+			case syscall.BPF_MEM:
+				if ins.K >= syscall.BPF_MEMWORDS {
+					return 0, fmt.Errorf("invalid scratch memory index %d at instruction %d", ins.K, pc)
+				}
+				x = mem[ins.K]
+			case syscall.BPF_LEN:
+				x = uint32(len(frame))
+			case syscall.BPF_MSH:
+				v, ok := load(ins.K, syscall.BPF_B)
+				if !ok {
+					return 0, nil
+				}
+				x = 4 * (v & 0x0f)
+			default:
+				return 0, fmt.Errorf("invalid load instruction 0x%x at %d", code, pc)
+			}
+
+		case syscall.BPF_ST, syscall.BPF_STX:
+			if ins.K >= syscall.BPF_MEMWORDS {
+				return 0, fmt.Errorf("invalid scratch memory index %d at instruction %d", ins.K, pc)
+			}
+			if code&0x07 == syscall.BPF_ST {
+				mem[ins.K] = a
+			} else {
+				mem[ins.K] = x
+			}
+
+		case syscall.BPF_ALU:
+			operand := ins.K
+			if code&syscall.BPF_X != 0 {
+				operand = x
+			}
+
+			switch code & 0xf0 {
+			case syscall.BPF_ADD:
+				a += operand
+			case syscall.BPF_SUB:
+				a -= operand
+			case syscall.BPF_MUL:
+				a *= operand
+			case syscall.BPF_DIV:
+				if operand == 0 {
+					return 0, nil
+				}
+				a /= operand
+			case bpfMod:
+				if operand == 0 {
+					return 0, nil
+				}
+				a %= operand
+			case syscall.BPF_OR:
+				a |= operand
+			case syscall.BPF_AND:
+				a &= operand
+			case bpfXor:
+				a ^= operand
+			case syscall.BPF_LSH:
+				a <<= operand
+			case syscall.BPF_RSH:
+				a >>= operand
+			case syscall.BPF_NEG:
+				a = -a
+			default:
+				return 0, fmt.Errorf("invalid ALU instruction 0x%x at %d", code, pc)
+			}
+
+		case syscall.BPF_JMP:
+			if code&0xf0 == syscall.BPF_JA {
+				pc += int(ins.K)
+				continue
+			}
+
+			operand := ins.K
+			if code&syscall.BPF_X != 0 {
+				operand = x
+			}
+
+			var cond bool
+			switch code & 0xf0 {
+			case syscall.BPF_JEQ:
+				cond = a == operand
+			case syscall.BPF_JGT:
+				cond = a > operand
+			case syscall.BPF_JGE:
+				cond = a >= operand
+			case syscall.BPF_JSET:
+				cond = a&operand != 0
+			default:
+				return 0, fmt.Errorf("invalid jump instruction 0x%x at %d", code, pc)
+			}
+
+			if cond {
+				pc += int(ins.Jt)
+			} else {
+				pc += int(ins.Jf)
+			}
+
+		case syscall.BPF_RET:
+			switch code & 0x18 {
+			case syscall.BPF_K:
+				return ins.K, nil
+			case syscall.BPF_A:
+				return a, nil
+			default:
+				return 0, fmt.Errorf("invalid return instruction 0x%x at %d", code, pc)
+			}
+
+		case syscall.BPF_MISC:
+			if code&0xf8 == syscall.BPF_TXA {
+				a = x
+			} else {
+				x = a
+			}
+		}
+	}
+
+	return 0, errFellOffProgram
+}
+
+// ancillary returns the ancillary data at the offset, relative to SKF_AD_OFF, reporting if it is known
+func ancillary(offset uint32, md capture.Metadata) (uint32, bool) {
+	switch offset {
+	case skfAdPktType:
+		if md.PacketType == capture.PacketTypeUnknown {
+			return 0, false
+		}
+		// the kernel's PACKET_HOST, PACKET_BROADCAST, ... follow the same order, starting from 0
+		return uint32(md.PacketType - capture.PacketTypeHost), true
+	case skfAdVLANTag:
+		return uint32(md.VLAN.TCI), true
+	case skfAdVLANTagPresent:
+		if md.VLAN.Present {
+			return 1, true
+		}
+		return 0, true
+	default:
+		return 0, false
+	}
+}
+
+// Match reports whether the frame is accepted by the program.
+// Every frame is accepted by an empty program, while an invalid program accepts none
+func Match(prog []syscall.SockFilter, frame []byte) bool {
+	return MatchWithMetadata(prog, frame, capture.Metadata{})
+}
+
+// MatchWithMetadata reports whether the frame, described by the metadata, is accepted by the program
+func MatchWithMetadata(prog []syscall.SockFilter, frame []byte, md capture.Metadata) bool {
+	if len(prog) == 0 {
+		return true
+	}
+
+	n, err := RunWithMetadata(prog, frame, md)
+	return err == nil && n > 0
+}
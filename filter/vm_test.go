@@ -0,0 +1,117 @@
+package filter
+
+import (
+	"syscall"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		name        string
+		prog        []syscall.SockFilter
+		frame       []byte
+		expected    uint32
+		expectedErr bool
+	}{
+		{
+			name: "return constant",
+			prog: []syscall.SockFilter{
+				{Code: syscall.BPF_RET | syscall.BPF_K, K: 42},
+			},
+			frame:    []byte{0x00},
+			expected: 42,
+		},
+		{
+			name: "return accumulator loaded with the frame length",
+			prog: []syscall.SockFilter{
+				{Code: syscall.BPF_LD | syscall.BPF_W | syscall.BPF_LEN},
+				{Code: syscall.BPF_RET | syscall.BPF_A},
+			},
+			frame:    []byte{0x00, 0x01, 0x02},
+			expected: 3,
+		},
+		{
+			name: "indirect load through the IPv4 header length",
+			prog: []syscall.SockFilter{
+				{Code: syscall.BPF_LDX | syscall.BPF_B | syscall.BPF_MSH, K: 0},
+				{Code: syscall.BPF_LD | syscall.BPF_H | syscall.BPF_IND, K: 0},
+				{Code: syscall.BPF_RET | syscall.BPF_A},
+			},
+			frame: []byte{
+				0x45, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+				0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+				0x01, 0xbb,
+			},
+			expected: 443,
+		},
+		{
+			name: "scratch memory and ALU",
+			prog: []syscall.SockFilter{
+				{Code: syscall.BPF_LD | syscall.BPF_IMM, K: 6},
+				{Code: syscall.BPF_ST, K: 3},
+				{Code: syscall.BPF_LDX | syscall.BPF_MEM, K: 3},
+				{Code: syscall.BPF_ALU | syscall.BPF_MUL | syscall.BPF_X},
+				{Code: syscall.BPF_ALU | syscall.BPF_SUB | syscall.BPF_K, K: 1},
+				{Code: syscall.BPF_RET | syscall.BPF_A},
+			},
+			expected: 35,
+		},
+		{
+			name: "unconditional and conditional jumps",
+			prog: []syscall.SockFilter{
+				{Code: syscall.BPF_JMP | syscall.BPF_JA, K: 1},
+				{Code: syscall.BPF_RET | syscall.BPF_K, K: 1},
+				{Code: syscall.BPF_LD | syscall.BPF_B | syscall.BPF_ABS, K: 0},
+				{Code: syscall.BPF_JMP | syscall.BPF_JSET | syscall.BPF_K, Jt: 1, Jf: 0, K: 0x80},
+				{Code: syscall.BPF_RET | syscall.BPF_K, K: 2},
+				{Code: syscall.BPF_RET | syscall.BPF_K, K: 3},
+			},
+			frame:    []byte{0x81},
+			expected: 3,
+		},
+		{
+			name: "out of bounds load rejects the frame",
+			prog: []syscall.SockFilter{
+				{Code: syscall.BPF_LD | syscall.BPF_W | syscall.BPF_ABS, K: 2},
+				{Code: syscall.BPF_RET | syscall.BPF_K, K: 1},
+			},
+			frame:    []byte{0x00, 0x01, 0x02, 0x03},
+			expected: 0,
+		},
+		{
+			name: "division by zero rejects the frame",
+			prog: []syscall.SockFilter{
+				{Code: syscall.BPF_ALU | syscall.BPF_DIV | syscall.BPF_X},
+				{Code: syscall.BPF_RET | syscall.BPF_K, K: 1},
+			},
+			expected: 0,
+		},
+		{
+			name: "missing return",
+			prog: []syscall.SockFilter{
+				{Code: syscall.BPF_LD | syscall.BPF_IMM, K: 1},
+			},
+			expectedErr: true,
+		},
+		{
+			name: "invalid scratch memory index",
+			prog: []syscall.SockFilter{
+				{Code: syscall.BPF_ST, K: syscall.BPF_MEMWORDS},
+				{Code: syscall.BPF_RET | syscall.BPF_K, K: 1},
+			},
+			expectedErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n, err := Run(tt.prog, tt.frame)
+			if tt.expectedErr != (err != nil) {
+				t.Fatalf("expected error: %v - got %v", tt.expectedErr, err)
+			}
+			if n != tt.expected {
+				t.Errorf("expected result to be %d - got %d", tt.expected, n)
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/NamelessOne91/bisturi/capture"
+)
+
+// pcapng block types
+const (
+	blockSectionHeader        = 0x0A0D0D0A
+	blockInterfaceDesc        = 0x00000001
+	blockEnhancedPacket       = 0x00000006
+	byteOrderMagic            = 0x1A2B3C4D
+	optionEndOfOpt            = 0
+	optionInterfaceName       = 2
+	optionTimestampResolution = 9
+	// timestamps are written in nanoseconds (10^-9 seconds)
+	nanosecondResolution = 9
+)
+
+var ErrUnknownInterface = errors.New("pcapng interface ID not described in the section")
+
+// NgInterface describes an interface frames have been captured on
+type NgInterface struct {
+	Name     string
+	LinkType uint16
+	SnapLen  uint32
+}
+
+// NgWriter writes frames to a pcapng file, made of a single section
+type NgWriter struct {
+	w          io.Writer
+	interfaces []NgInterface
+}
+
+// NewNgWriter writes the section header block to the passed writer, followed by an
+// interface description block for every passed interface, and returns a NgWriter for the frames.
+// The interfaces are identified by their position, starting from 0
+func NewNgWriter(w io.Writer, interfaces ...NgInterface) (*NgWriter, error) {
+	body := make([]byte, 16)
+	binary.LittleEndian.PutUint32(body[0:4], byteOrderMagic)
+	binary.LittleEndian.PutUint16(body[4:6], 1)
+	binary.LittleEndian.PutUint16(body[6:8], 0)
+	// the section length is not known in advance
+	binary.LittleEndian.PutUint64(body[8:16], 0xFFFFFFFFFFFFFFFF)
+
+	ngw := &NgWriter{w: w}
+	if err := ngw.writeBlock(blockSectionHeader, body); err != nil {
+		return nil, err
+	}
+
+	for _, iface := range interfaces {
+		if _, err := ngw.AddInterface(iface); err != nil {
+			return nil, err
+		}
+	}
+	return ngw, nil
+}
+
+// AddInterface writes an interface description block, returning the interface's ID
+func (w *NgWriter) AddInterface(iface NgInterface) (int, error) {
+	body := make([]byte, 8)
+	binary.LittleEndian.PutUint16(body[0:2], iface.LinkType)
+	binary.LittleEndian.PutUint32(body[4:8], iface.SnapLen)
+
+	if iface.Name != "" {
+		body = appendOption(body, optionInterfaceName, []byte(iface.Name))
+	}
+	body = appendOption(body, optionTimestampResolution, []byte{nanosecondResolution})
+	body = appendOption(body, optionEndOfOpt, nil)
+
+	if err := w.writeBlock(blockInterfaceDesc, body); err != nil {
+		return 0, err
+	}
+	w.interfaces = append(w.interfaces, iface)
+
+	return len(w.interfaces) - 1, nil
+}
+
+// WritePacket writes an enhanced packet block for a frame captured on the first interface
+func (w *NgWriter) WritePacket(md capture.Metadata, data []byte) error {
+	return w.WriteInterfacePacket(0, md, data)
+}
+
+// WriteInterfacePacket writes an enhanced packet block for a frame captured on the interface with the given ID.
+// Frames longer than the interface's snaplen are truncated
+func (w *NgWriter) WriteInterfacePacket(ifaceID int, md capture.Metadata, data []byte) error {
+	if ifaceID < 0 || ifaceID >= len(w.interfaces) {
+		return ErrUnknownInterface
+	}
+	length := md.Length
+	if length < len(data) {
+		length = len(data)
+	}
+	if snapLen := w.interfaces[ifaceID].SnapLen; snapLen > 0 && uint32(len(data)) > snapLen {
+		data = data[:snapLen]
+	}
+	ts := uint64(md.Timestamp.UnixNano())
+
+	body := make([]byte, 20, 20+len(data)+3)
+	binary.LittleEndian.PutUint32(body[0:4], uint32(ifaceID))
+	binary.LittleEndian.PutUint32(body[4:8], uint32(ts>>32))
+	binary.LittleEndian.PutUint32(body[8:12], uint32(ts))
+	binary.LittleEndian.PutUint32(body[12:16], uint32(len(data)))
+	binary.LittleEndian.PutUint32(body[16:20], uint32(length))
+	body = append(body, data...)
+	body = append(body, make([]byte, padding(len(data)))...)
+
+	return w.writeBlock(blockEnhancedPacket, body)
+}
+
+// writeBlock writes a block, made of its type and total length, the body and the total length again
+func (w *NgWriter) writeBlock(blockType uint32, body []byte) error {
+	total := uint32(12 + len(body))
+
+	b := make([]byte, 0, total)
+	b = binary.LittleEndian.AppendUint32(b, blockType)
+	b = binary.LittleEndian.AppendUint32(b, total)
+	b = append(b, body...)
+	b = binary.LittleEndian.AppendUint32(b, total)
+
+	_, err := w.w.Write(b)
+	return err
+}
+
+// appendOption appends an option, padded to 32 bits, to the block's body
+func appendOption(body []byte, code uint16, value []byte) []byte {
+	body = binary.LittleEndian.AppendUint16(body, code)
+	body = binary.LittleEndian.AppendUint16(body, uint16(len(value)))
+	body = append(body, value...)
+	return append(body, make([]byte, padding(len(value)))...)
+}
+
+// padding returns the number of bytes needed to align n to 32 bits
+func padding(n int) int {
+	return (4 - n%4) % 4
+}
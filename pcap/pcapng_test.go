@@ -0,0 +1,110 @@
+package pcap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/NamelessOne91/bisturi/capture"
+)
+
+type ngBlock struct {
+	blockType uint32
+	body      []byte
+}
+
+// readBlocks splits a pcapng file in its blocks, checking their lengths
+func readBlocks(t *testing.T, raw []byte) []ngBlock {
+	var blocks []ngBlock
+	for len(raw) > 0 {
+		if len(raw) < 12 {
+			t.Fatalf("truncated block: %v", raw)
+		}
+		total := binary.LittleEndian.Uint32(raw[4:8])
+		if total%4 != 0 || int(total) > len(raw) {
+			t.Fatalf("invalid block length %d", total)
+		}
+		if trailing := binary.LittleEndian.Uint32(raw[total-4 : total]); trailing != total {
+			t.Fatalf("block lengths mismatch: %d and %d", total, trailing)
+		}
+		blocks = append(blocks, ngBlock{
+			blockType: binary.LittleEndian.Uint32(raw[0:4]),
+			body:      raw[8 : total-4],
+		})
+		raw = raw[total:]
+	}
+	return blocks
+}
+
+func TestNgWriter(t *testing.T) {
+	var b bytes.Buffer
+	w, err := NewNgWriter(&b, NgInterface{Name: "eth0", LinkType: LinkTypeEthernet, SnapLen: 65535})
+	if err != nil {
+		t.Fatalf("expected no error - got %v", err)
+	}
+	id, err := w.AddInterface(NgInterface{Name: "wlan0", LinkType: LinkTypeEthernet, SnapLen: 65535})
+	if err != nil || id != 1 {
+		t.Fatalf("expected interface ID 1 and no error - got %d and %v", id, err)
+	}
+
+	frame := []byte{0x00, 0x1A, 0xA0, 0xBB, 0xCC, 0xDD, 0x00, 0x1A, 0xB0, 0xCC, 0xDD, 0xEE, 0x08, 0x00, 0x45}
+	ts := time.Unix(1700000000, 123456789)
+	if err := w.WritePacket(capture.Metadata{Timestamp: ts, Length: 60}, frame); err != nil {
+		t.Fatalf("expected no error - got %v", err)
+	}
+	if err := w.WriteInterfacePacket(1, capture.Metadata{Timestamp: ts}, frame); err != nil {
+		t.Fatalf("expected no error - got %v", err)
+	}
+	if err := w.WriteInterfacePacket(2, capture.Metadata{Timestamp: ts}, frame); err != ErrUnknownInterface {
+		t.Errorf("expected error: %v - got %v", ErrUnknownInterface, err)
+	}
+
+	blocks := readBlocks(t, b.Bytes())
+	expectedTypes := []uint32{blockSectionHeader, blockInterfaceDesc, blockInterfaceDesc, blockEnhancedPacket, blockEnhancedPacket}
+	if len(blocks) != len(expectedTypes) {
+		t.Fatalf("expected %d blocks - got %d", len(expectedTypes), len(blocks))
+	}
+	for i, block := range blocks {
+		if block.blockType != expectedTypes[i] {
+			t.Errorf("expected block %d type to be 0x%X - got 0x%X", i, expectedTypes[i], block.blockType)
+		}
+	}
+
+	if magic := binary.LittleEndian.Uint32(blocks[0].body[0:4]); magic != byteOrderMagic {
+		t.Errorf("expected byte order magic to be 0x%X - got 0x%X", byteOrderMagic, magic)
+	}
+
+	idb := blocks[1].body
+	expectedIDB := []byte{
+		0x01, 0x00, 0x00, 0x00, 0xff, 0xff, 0x00, 0x00, // link type, reserved, snaplen
+		0x02, 0x00, 0x04, 0x00, 'e', 't', 'h', '0', // if_name
+		0x09, 0x00, 0x01, 0x00, 0x09, 0x00, 0x00, 0x00, // if_tsresol
+		0x00, 0x00, 0x00, 0x00, // opt_endofopt
+	}
+	if !reflect.DeepEqual(idb, expectedIDB) {
+		t.Errorf("expected interface description block body to be %v - got %v", expectedIDB, idb)
+	}
+
+	for i, block := range blocks[3:] {
+		epb := block.body
+		if ifaceID := binary.LittleEndian.Uint32(epb[0:4]); ifaceID != uint32(i) {
+			t.Errorf("expected interface ID to be %d - got %d", i, ifaceID)
+		}
+		gotTs := uint64(binary.LittleEndian.Uint32(epb[4:8]))<<32 | uint64(binary.LittleEndian.Uint32(epb[8:12]))
+		if gotTs != uint64(ts.UnixNano()) {
+			t.Errorf("expected timestamp to be %d - got %d", ts.UnixNano(), gotTs)
+		}
+		capLen := binary.LittleEndian.Uint32(epb[12:16])
+		if capLen != uint32(len(frame)) {
+			t.Errorf("expected captured length to be %d - got %d", len(frame), capLen)
+		}
+		if !reflect.DeepEqual(epb[20:20+capLen], frame) {
+			t.Errorf("expected frame to be %v - got %v", frame, epb[20:20+capLen])
+		}
+	}
+	if origLen := binary.LittleEndian.Uint32(blocks[3].body[16:20]); origLen != 60 {
+		t.Errorf("expected original length to be 60 - got %d", origLen)
+	}
+}
@@ -0,0 +1,207 @@
+// Package pcap reads capture files in the libpcap classic format and writes them in both the classic and pcapng formats.
+package pcap
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/NamelessOne91/bisturi/capture"
+)
+
+// magic numbers identifying the classic pcap format, as written by the capturing host
+const (
+	magicMicroseconds = 0xa1b2c3d4
+	magicNanoseconds  = 0xa1b23c4d
+)
+
+// LinkTypeEthernet is the link type of captures containing Ethernet frames
+const LinkTypeEthernet = 1
+
+const (
+	fileHeaderLen   = 24
+	recordHeaderLen = 16
+	// upper bound for the length of a single record, protecting from corrupted files
+	maxRecordLen = 0x40000
+)
+
+var (
+	ErrInvalidMagic       = errors.New("not a pcap file: unknown magic number")
+	ErrRecordTooLarge     = errors.New("pcap record larger than the maximum allowed length")
+	errTruncatedRecord    = errors.New("pcap file ends in the middle of a record")
+	errInvalidFileHeader  = errors.New("pcap file header must be 24 bytes")
+	errCaptureLenExceeded = errors.New("pcap record captured length greater than its original length")
+)
+
+// Reader reads the frames stored in a classic pcap file.
+// It implements capture.PacketSource
+type Reader struct {
+	r            io.Reader
+	read         atomic.Uint64
+	failed       bool
+	closed       atomic.Bool
+	order        binary.ByteOrder
+	nanoseconds  bool
+	versionMajor uint16
+	versionMinor uint16
+	snapLen      uint32
+	linkType     uint32
+	header       [recordHeaderLen]byte
+}
+
+// NewReader reads the pcap file header from the passed reader and returns a Reader for its records.
+// Both microsecond and nanosecond resolution files are supported, in either byte order.
+// An error is returned if the header is not valid
+func NewReader(r io.Reader) (*Reader, error) {
+	var h [fileHeaderLen]byte
+	if _, err := io.ReadFull(r, h[:]); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, errInvalidFileHeader
+		}
+		return nil, err
+	}
+
+	pr := &Reader{r: r}
+	switch magic := binary.LittleEndian.Uint32(h[0:4]); magic {
+	case magicMicroseconds:
+		pr.order = binary.LittleEndian
+	case magicNanoseconds:
+		pr.order = binary.LittleEndian
+		pr.nanoseconds = true
+	default:
+		switch binary.BigEndian.Uint32(h[0:4]) {
+		case magicMicroseconds:
+			pr.order = binary.BigEndian
+		case magicNanoseconds:
+			pr.order = binary.BigEndian
+			pr.nanoseconds = true
+		default:
+			return nil, ErrInvalidMagic
+		}
+	}
+
+	pr.versionMajor = pr.order.Uint16(h[4:6])
+	pr.versionMinor = pr.order.Uint16(h[6:8])
+	// bytes 8:16 hold the unused timezone offset and timestamps accuracy
+	pr.snapLen = pr.order.Uint32(h[16:20])
+	// the upper bits may contain the FCS length, the link type is in the lower 16
+	pr.linkType = pr.order.Uint32(h[20:24]) & 0xFFFF
+
+	return pr, nil
+}
+
+// OpenFile opens the pcap file at the given path and returns a Reader for its records,
+// which closes the file when closed
+func OpenFile(path string) (*Reader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// LinkType returns the link layer type of the frames in the file
+func (r *Reader) LinkType() uint32 {
+	return r.linkType
+}
+
+// SnapLen returns the maximum number of bytes stored for every frame
+func (r *Reader) SnapLen() uint32 {
+	return r.snapLen
+}
+
+// Version returns the major and minor version of the file format
+func (r *Reader) Version() (uint16, uint16) {
+	return r.versionMajor, r.versionMinor
+}
+
+// ReadPacket returns the next frame stored in the file together with its metadata.
+// The returned slice is newly allocated for every frame.
+// io.EOF is returned when there are no more records. The records following a corrupted one
+// can not be located, so after an error the file is considered ended
+func (r *Reader) ReadPacket() ([]byte, capture.Metadata, error) {
+	if r.closed.Load() {
+		return nil, capture.Metadata{}, capture.ErrSourceClosed
+	}
+	if r.failed {
+		return nil, capture.Metadata{}, io.EOF
+	}
+
+	data, md, err := r.readRecord()
+	if err != nil {
+		if r.closed.Load() {
+			return nil, capture.Metadata{}, capture.ErrSourceClosed
+		}
+		r.failed = err != io.EOF
+		return nil, capture.Metadata{}, err
+	}
+	r.read.Add(1)
+
+	return data, md, nil
+}
+
+// readRecord reads the next record header and the frame following it
+func (r *Reader) readRecord() ([]byte, capture.Metadata, error) {
+	if _, err := io.ReadFull(r.r, r.header[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = errTruncatedRecord
+		}
+		return nil, capture.Metadata{}, err
+	}
+
+	sec := int64(r.order.Uint32(r.header[0:4]))
+	frac := int64(r.order.Uint32(r.header[4:8]))
+	capLen := r.order.Uint32(r.header[8:12])
+	origLen := r.order.Uint32(r.header[12:16])
+
+	if capLen > maxRecordLen {
+		return nil, capture.Metadata{}, ErrRecordTooLarge
+	}
+	if capLen > origLen {
+		return nil, capture.Metadata{}, errCaptureLenExceeded
+	}
+
+	data := make([]byte, capLen)
+	if _, err := io.ReadFull(r.r, data); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = errTruncatedRecord
+		}
+		return nil, capture.Metadata{}, err
+	}
+
+	if !r.nanoseconds {
+		frac *= int64(time.Microsecond)
+	}
+
+	return data, capture.Metadata{
+		Timestamp:     time.Unix(sec, frac),
+		CaptureLength: int(capLen),
+		Length:        int(origLen),
+	}, nil
+}
+
+// Close closes the underlying reader, if it is an io.Closer.
+// Subsequent reads fail with capture.ErrSourceClosed
+func (r *Reader) Close() error {
+	if r.closed.Swap(true) {
+		return nil
+	}
+	if c, ok := r.r.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// Stats returns the number of frames read so far. Frames are never dropped
+func (r *Reader) Stats() (capture.Stats, error) {
+	return capture.Stats{Received: r.read.Load()}, nil
+}
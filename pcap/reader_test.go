@@ -0,0 +1,193 @@
+package pcap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/NamelessOne91/bisturi/capture"
+)
+
+// buildFile returns a pcap file containing the given frames, with one second between them
+func buildFile(order binary.ByteOrder, magic uint32, frames [][]byte) []byte {
+	var b bytes.Buffer
+
+	h := make([]byte, fileHeaderLen)
+	order.PutUint32(h[0:4], magic)
+	order.PutUint16(h[4:6], 2)
+	order.PutUint16(h[6:8], 4)
+	order.PutUint32(h[16:20], 65535)
+	order.PutUint32(h[20:24], LinkTypeEthernet)
+	b.Write(h)
+
+	for i, f := range frames {
+		rh := make([]byte, recordHeaderLen)
+		order.PutUint32(rh[0:4], uint32(1700000000+i))
+		order.PutUint32(rh[4:8], 123456)
+		order.PutUint32(rh[8:12], uint32(len(f)))
+		order.PutUint32(rh[12:16], uint32(len(f)+10))
+		b.Write(rh)
+		b.Write(f)
+	}
+	return b.Bytes()
+}
+
+func TestReader(t *testing.T) {
+	frames := [][]byte{
+		{0x00, 0x1A, 0xA0, 0xBB, 0xCC, 0xDD, 0x00, 0x1A, 0xB0, 0xCC, 0xDD, 0xEE, 0x08, 0x00},
+		{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x00, 0x1a, 0x2b, 0x3c, 0x4d, 0x5e, 0x08, 0x06, 0x00, 0x01},
+	}
+
+	tests := []struct {
+		name         string
+		order        binary.ByteOrder
+		magic        uint32
+		expectedFrac time.Duration
+	}{
+		{
+			name:         "little endian microseconds",
+			order:        binary.LittleEndian,
+			magic:        magicMicroseconds,
+			expectedFrac: 123456 * time.Microsecond,
+		},
+		{
+			name:         "big endian microseconds",
+			order:        binary.BigEndian,
+			magic:        magicMicroseconds,
+			expectedFrac: 123456 * time.Microsecond,
+		},
+		{
+			name:         "little endian nanoseconds",
+			order:        binary.LittleEndian,
+			magic:        magicNanoseconds,
+			expectedFrac: 123456 * time.Nanosecond,
+		},
+		{
+			name:         "big endian nanoseconds",
+			order:        binary.BigEndian,
+			magic:        magicNanoseconds,
+			expectedFrac: 123456 * time.Nanosecond,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := NewReader(bytes.NewReader(buildFile(tt.order, tt.magic, frames)))
+			if err != nil {
+				t.Fatalf("expected no error - got %v", err)
+			}
+			if r.LinkType() != LinkTypeEthernet {
+				t.Errorf("expected link type to be %d - got %d", LinkTypeEthernet, r.LinkType())
+			}
+			if r.SnapLen() != 65535 {
+				t.Errorf("expected snaplen to be 65535 - got %d", r.SnapLen())
+			}
+
+			for i, expected := range frames {
+				data, md, err := r.ReadPacket()
+				if err != nil {
+					t.Fatalf("expected no error reading frame %d - got %v", i, err)
+				}
+				if !reflect.DeepEqual(data, expected) {
+					t.Errorf("expected frame %d to be %v - got %v", i, expected, data)
+				}
+				expectedTs := time.Unix(int64(1700000000+i), 0).Add(tt.expectedFrac)
+				if !md.Timestamp.Equal(expectedTs) {
+					t.Errorf("expected timestamp to be %v - got %v", expectedTs, md.Timestamp)
+				}
+				if md.CaptureLength != len(expected) || md.Length != len(expected)+10 {
+					t.Errorf("expected lengths to be %d and %d - got %d and %d", len(expected), len(expected)+10, md.CaptureLength, md.Length)
+				}
+			}
+
+			if _, _, err := r.ReadPacket(); err != io.EOF {
+				t.Errorf("expected error: %v - got %v", io.EOF, err)
+			}
+		})
+	}
+}
+
+func TestReaderErrors(t *testing.T) {
+	valid := buildFile(binary.LittleEndian, magicMicroseconds, [][]byte{{0x01, 0x02, 0x03, 0x04}})
+
+	t.Run("invalid magic", func(t *testing.T) {
+		raw := append([]byte{0xde, 0xad, 0xbe, 0xef}, valid[4:]...)
+		if _, err := NewReader(bytes.NewReader(raw)); err != ErrInvalidMagic {
+			t.Errorf("expected error: %v - got %v", ErrInvalidMagic, err)
+		}
+	})
+
+	t.Run("short file header", func(t *testing.T) {
+		if _, err := NewReader(bytes.NewReader(valid[:10])); err != errInvalidFileHeader {
+			t.Errorf("expected error: %v - got %v", errInvalidFileHeader, err)
+		}
+	})
+
+	t.Run("truncated record", func(t *testing.T) {
+		r, err := NewReader(bytes.NewReader(valid[:len(valid)-1]))
+		if err != nil {
+			t.Fatalf("expected no error - got %v", err)
+		}
+		if _, _, err := r.ReadPacket(); err != errTruncatedRecord {
+			t.Errorf("expected error: %v - got %v", errTruncatedRecord, err)
+		}
+	})
+
+	t.Run("record too large", func(t *testing.T) {
+		raw := append([]byte{}, valid...)
+		binary.LittleEndian.PutUint32(raw[fileHeaderLen+8:], maxRecordLen+1)
+		binary.LittleEndian.PutUint32(raw[fileHeaderLen+12:], maxRecordLen+1)
+
+		r, err := NewReader(bytes.NewReader(raw))
+		if err != nil {
+			t.Fatalf("expected no error - got %v", err)
+		}
+		if _, _, err := r.ReadPacket(); err != ErrRecordTooLarge {
+			t.Errorf("expected error: %v - got %v", ErrRecordTooLarge, err)
+		}
+	})
+}
+
+func TestReaderSource(t *testing.T) {
+	frames := [][]byte{{0x01, 0x02, 0x03, 0x04}, {0x05, 0x06}}
+
+	t.Run("stats and close", func(t *testing.T) {
+		r, err := NewReader(bytes.NewReader(buildFile(binary.LittleEndian, magicNanoseconds, frames)))
+		if err != nil {
+			t.Fatalf("expected no error - got %v", err)
+		}
+		if _, _, err := r.ReadPacket(); err != nil {
+			t.Fatalf("expected no error - got %v", err)
+		}
+		if stats, _ := r.Stats(); stats.Received != 1 {
+			t.Errorf("expected 1 frame to be received - got %d", stats.Received)
+		}
+
+		if err := r.Close(); err != nil {
+			t.Fatalf("expected no error - got %v", err)
+		}
+		if _, _, err := r.ReadPacket(); err != capture.ErrSourceClosed {
+			t.Errorf("expected error: %v - got %v", capture.ErrSourceClosed, err)
+		}
+	})
+
+	t.Run("file ends after a corrupted record", func(t *testing.T) {
+		raw := buildFile(binary.LittleEndian, magicNanoseconds, frames)
+		binary.LittleEndian.PutUint32(raw[fileHeaderLen+8:], maxRecordLen+1)
+		binary.LittleEndian.PutUint32(raw[fileHeaderLen+12:], maxRecordLen+1)
+
+		r, err := NewReader(bytes.NewReader(raw))
+		if err != nil {
+			t.Fatalf("expected no error - got %v", err)
+		}
+		if _, _, err := r.ReadPacket(); err != ErrRecordTooLarge {
+			t.Errorf("expected error: %v - got %v", ErrRecordTooLarge, err)
+		}
+		if _, _, err := r.ReadPacket(); err != io.EOF {
+			t.Errorf("expected error: %v - got %v", io.EOF, err)
+		}
+	})
+}
@@ -0,0 +1,55 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"io"
+
+	"github.com/NamelessOne91/bisturi/capture"
+)
+
+// Writer writes frames to a classic pcap file with nanosecond resolution timestamps
+type Writer struct {
+	w       io.Writer
+	snapLen uint32
+	header  [recordHeaderLen]byte
+}
+
+// NewWriter writes the pcap file header to the passed writer and returns a Writer for the records.
+// Frames longer than snapLen are truncated, while a snapLen of 0 keeps them whole
+func NewWriter(w io.Writer, snapLen uint32, linkType uint32) (*Writer, error) {
+	var h [fileHeaderLen]byte
+	binary.LittleEndian.PutUint32(h[0:4], magicNanoseconds)
+	binary.LittleEndian.PutUint16(h[4:6], 2)
+	binary.LittleEndian.PutUint16(h[6:8], 4)
+	// timezone offset and timestamps accuracy are always 0
+	binary.LittleEndian.PutUint32(h[16:20], snapLen)
+	binary.LittleEndian.PutUint32(h[20:24], linkType)
+
+	if _, err := w.Write(h[:]); err != nil {
+		return nil, err
+	}
+	return &Writer{w: w, snapLen: snapLen}, nil
+}
+
+// WritePacket writes a record for the frame. The original length of the frame is taken
+// from the capture info, if greater than the number of bytes passed
+func (w *Writer) WritePacket(md capture.Metadata, data []byte) error {
+	length := md.Length
+	if length < len(data) {
+		length = len(data)
+	}
+	if w.snapLen > 0 && uint32(len(data)) > w.snapLen {
+		data = data[:w.snapLen]
+	}
+
+	binary.LittleEndian.PutUint32(w.header[0:4], uint32(md.Timestamp.Unix()))
+	binary.LittleEndian.PutUint32(w.header[4:8], uint32(md.Timestamp.Nanosecond()))
+	binary.LittleEndian.PutUint32(w.header[8:12], uint32(len(data)))
+	binary.LittleEndian.PutUint32(w.header[12:16], uint32(length))
+
+	if _, err := w.w.Write(w.header[:]); err != nil {
+		return err
+	}
+	_, err := w.w.Write(data)
+	return err
+}
@@ -0,0 +1,90 @@
+package pcap
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/NamelessOne91/bisturi/capture"
+)
+
+func TestWriterRoundTrip(t *testing.T) {
+	frames := [][]byte{
+		{0x00, 0x1A, 0xA0, 0xBB, 0xCC, 0xDD, 0x00, 0x1A, 0xB0, 0xCC, 0xDD, 0xEE, 0x08, 0x00, 0x45},
+		{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x00, 0x1a, 0x2b, 0x3c, 0x4d, 0x5e, 0x08, 0x06},
+	}
+	infos := []capture.Metadata{
+		{Timestamp: time.Unix(1700000000, 123456789), Length: 1500},
+		{Timestamp: time.Unix(1700000001, 1)},
+	}
+
+	var b bytes.Buffer
+	w, err := NewWriter(&b, 65535, LinkTypeEthernet)
+	if err != nil {
+		t.Fatalf("expected no error - got %v", err)
+	}
+	for i, f := range frames {
+		if err := w.WritePacket(infos[i], f); err != nil {
+			t.Fatalf("expected no error writing frame %d - got %v", i, err)
+		}
+	}
+
+	r, err := NewReader(&b)
+	if err != nil {
+		t.Fatalf("expected no error - got %v", err)
+	}
+	if r.LinkType() != LinkTypeEthernet || r.SnapLen() != 65535 {
+		t.Errorf("expected link type %d and snaplen 65535 - got %d and %d", LinkTypeEthernet, r.LinkType(), r.SnapLen())
+	}
+
+	expectedLengths := []int{1500, len(frames[1])}
+	for i, expected := range frames {
+		data, md, err := r.ReadPacket()
+		if err != nil {
+			t.Fatalf("expected no error reading frame %d - got %v", i, err)
+		}
+		if !reflect.DeepEqual(data, expected) {
+			t.Errorf("expected frame %d to be %v - got %v", i, expected, data)
+		}
+		if !md.Timestamp.Equal(infos[i].Timestamp) {
+			t.Errorf("expected timestamp to be %v - got %v", infos[i].Timestamp, md.Timestamp)
+		}
+		if md.Length != expectedLengths[i] {
+			t.Errorf("expected original length to be %d - got %d", expectedLengths[i], md.Length)
+		}
+	}
+}
+
+func TestWriterSnapLen(t *testing.T) {
+	tests := []struct {
+		name     string
+		snapLen  uint32
+		expected []byte
+	}{
+		{name: "longer frame is truncated", snapLen: 4, expected: []byte{1, 2, 3, 4}},
+		{name: "zero snaplen keeps the frame whole", snapLen: 0, expected: []byte{1, 2, 3, 4, 5, 6}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b bytes.Buffer
+			w, err := NewWriter(&b, tt.snapLen, LinkTypeEthernet)
+			if err != nil {
+				t.Fatalf("expected no error - got %v", err)
+			}
+			if err := w.WritePacket(capture.Metadata{Timestamp: time.Unix(0, 0)}, []byte{1, 2, 3, 4, 5, 6}); err != nil {
+				t.Fatalf("expected no error - got %v", err)
+			}
+
+			r, _ := NewReader(&b)
+			data, md, err := r.ReadPacket()
+			if err != nil {
+				t.Fatalf("expected no error - got %v", err)
+			}
+			if !reflect.DeepEqual(data, tt.expected) || md.Length != 6 {
+				t.Errorf("expected frame %v of original length 6 - got %v of length %d", tt.expected, data, md.Length)
+			}
+		})
+	}
+}
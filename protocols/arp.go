@@ -56,6 +56,11 @@ func ARPPacketFromBytes(raw []byte) (*ARPPacket, error) {
 	}, nil
 }
 
+// Frame returns the Ethernet frame carrying the packet
+func (p *ARPPacket) Frame() *EthernetFrame {
+	return &p.EthFrame
+}
+
 func (p ARPPacket) Destination() string {
 	return fmt.Sprintf("%s|%s", p.TargetHWAddr.String(), p.TargetProtoAddr.String())
 }
@@ -116,6 +116,55 @@ func TestARPPacketFromBytes(t *testing.T) {
 			expectedPacket: nil,
 			expectedErr:    errInvalidARPPacket,
 		},
+		{
+			name: "Valid ARP Request Packet in a QinQ frame",
+			raw: []byte{
+				// Ethernet Frame Header
+				0x00, 0x1a, 0x2b, 0x3c, 0x4d, 0x5e, // Destination MAC
+				0x00, 0x1a, 0x2b, 0x3c, 0x4d, 0x5f, // Source MAC
+				0x88, 0xa8, 0x00, 0x64, // 802.1ad tag (VLAN 100)
+				0x81, 0x00, 0x20, 0x0a, // 802.1Q tag (VLAN 10, PCP 1)
+				0x08, 0x06, // EtherType (ARP)
+
+				// ARP Packet
+				0x00, 0x01, // Hardware Type (Ethernet)
+				0x08, 0x00, // Protocol Type (IPv4)
+				0x06,       // Hardware Address Length (6 bytes)
+				0x04,       // Protocol Address Length (4 bytes)
+				0x00, 0x01, // Operation (ARP Request)
+				0x00, 0x1a, 0x2b, 0x3c, 0x4d, 0x5e, // Sender HW Address
+				0xc0, 0xa8, 0x01, 0x01, // Sender Protocol Address (192.168.1.1)
+				0x00, 0x1a, 0x2b, 0x3c, 0x4d, 0x5f, // Target HW Address
+				0xc0, 0xa8, 0x01, 0x02, // Target Protocol Address (192.168.1.2)
+			},
+			expectedPacket: &ARPPacket{
+				EthFrame: EthernetFrame{
+					DestinationMAC: net.HardwareAddr{0x00, 0x1a, 0x2b, 0x3c, 0x4d, 0x5e},
+					SourceMAC:      net.HardwareAddr{0x00, 0x1a, 0x2b, 0x3c, 0x4d, 0x5f},
+					EtherType:      0x0806,
+					VLANs: []VLANTag{
+						{TPID: 0x88A8, VID: 100},
+						{TPID: 0x8100, PCP: 1, VID: 10},
+					},
+					Payload: []byte{
+						0x00, 0x01, 0x08, 0x00, 0x06, 0x04, 0x00, 0x01,
+						0x00, 0x1a, 0x2b, 0x3c, 0x4d, 0x5e, 0xc0, 0xa8,
+						0x01, 0x01, 0x00, 0x1a, 0x2b, 0x3c, 0x4d, 0x5f,
+						0xc0, 0xa8, 0x01, 0x02,
+					},
+				},
+				HardwareType:    0x0001,
+				ProtocolType:    0x0800,
+				HardwareAddrLen: 6,
+				ProtocolAddrLen: 4,
+				Operation:       0x0001,
+				SenderHWAddr:    net.HardwareAddr{0x00, 0x1a, 0x2b, 0x3c, 0x4d, 0x5e},
+				SenderProtoAddr: net.IP{0xc0, 0xa8, 0x01, 0x01},
+				TargetHWAddr:    net.HardwareAddr{0x00, 0x1a, 0x2b, 0x3c, 0x4d, 0x5f},
+				TargetProtoAddr: net.IP{0xc0, 0xa8, 0x01, 0x02},
+			},
+			expectedErr: nil,
+		},
 		{
 			name: "Invalid ARP Packet (Malformed)",
 			raw: []byte{
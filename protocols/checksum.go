@@ -0,0 +1,261 @@
+package protocols
+
+import "encoding/binary"
+
+// IP protocol numbers of the upper-layer protocols whose checksum covers a pseudo-header
+const (
+	ipProtocolTCP    = 6
+	ipProtocolUDP    = 17
+	ipProtocolICMPv6 = 58
+)
+
+// sum16 adds the big endian 16-bit words of the data to the one's complement sum,
+// padding an odd number of bytes with a zero byte
+func sum16(data []byte, sum uint32) uint32 {
+	for len(data) >= 2 {
+		sum += uint32(binary.BigEndian.Uint16(data))
+		data = data[2:]
+	}
+	if len(data) == 1 {
+		sum += uint32(data[0]) << 8
+	}
+	return sum
+}
+
+// foldChecksum folds the carries into the 16-bit sum and returns its one's complement, the Internet checksum
+func foldChecksum(sum uint32) uint16 {
+	for sum > 0xFFFF {
+		sum = sum&0xFFFF + sum>>16
+	}
+	return ^uint16(sum)
+}
+
+// pseudoHeaderSum returns the sum of the IPv4 or IPv6 pseudo-header covered by the checksum of an upper-layer
+// packet of the given protocol and length, carried by the IP header
+func pseudoHeaderSum(ip IPHeader, proto uint8, length int) (uint32, bool) {
+	var sum uint32
+	switch h := ip.(type) {
+	case IPv4Header:
+		if !h.SourceIP.Is4() || !h.DestinationIP.Is4() {
+			return 0, false
+		}
+		src, dst := h.SourceIP.As4(), h.DestinationIP.As4()
+		sum = sum16(src[:], sum16(dst[:], 0))
+	case IPv6Header:
+		if !h.SourceIP.Is6() || !h.FinalDestination().Is6() {
+			return 0, false
+		}
+		src, dst := h.SourceIP.As16(), h.FinalDestination().As16()
+		sum = sum16(src[:], sum16(dst[:], 0))
+	default:
+		return 0, false
+	}
+	// the IPv6 pseudo-header has a 32-bit length, adding to the same sum for lengths below 64 KiB
+	return sum + uint32(proto) + uint32(length)&0xFFFF + uint32(length)>>16, true
+}
+
+// ChecksumStatus is the outcome of the verification of a checksum
+type ChecksumStatus uint8
+
+const (
+	// ChecksumUnverified is the status of the checksums which can not be verified: the ones of truncated or fragmented
+	// packets, the missing UDP checksums over IPv4 and the ones left to the network interface to compute
+	ChecksumUnverified ChecksumStatus = iota
+	ChecksumGood
+	ChecksumBad
+)
+
+func (s ChecksumStatus) String() string {
+	switch s {
+	case ChecksumGood:
+		return "good"
+	case ChecksumBad:
+		return "bad"
+	default:
+		return "unverified"
+	}
+}
+
+// Checksum is the checksum carried by a layer of a packet, together with the outcome of its verification
+type Checksum struct {
+	Layer  LayerType
+	Value  uint16
+	Status ChecksumStatus
+	// Expected is the correct value of a verified checksum
+	Expected uint16
+}
+
+// verified returns the checksum with the status resulting from the comparison with the expected value
+func verified(layer LayerType, value, expected uint16) Checksum {
+	status := ChecksumBad
+	if value == expected {
+		status = ChecksumGood
+	}
+	return Checksum{Layer: layer, Value: value, Status: status, Expected: expected}
+}
+
+// VerifyChecksums computes the checksums carried by the layers of the packet, ordered from the link layer up,
+// and compares them with the ones carried: the IPv4 header checksum, the ICMP checksum and the UDP, TCP and ICMPv6
+// checksums, covering the pseudo-header of the IP header below them
+func VerifyChecksums(p LayeredPacket) []Checksum {
+	layers := p.Layers()
+	var checksums []Checksum
+	for i, l := range layers {
+		switch h := l.(type) {
+		case IPv4Header:
+			checksums = append(checksums, verifyIPv4Checksum(h))
+		case *UDPHeader:
+			checksums = append(checksums, verifyUDPChecksum(h, layers[:i], upperPayload(layers[i+1:])))
+		case *TCPHeader:
+			checksums = append(checksums, verifyTCPChecksum(h, layers[:i], upperPayload(layers[i+1:])))
+		case *ICMPHeader:
+			checksums = append(checksums, verifyICMPChecksum(h, layers[:i], upperPayload(layers[i+1:])))
+		case *ICMPv6Header:
+			checksums = append(checksums, verifyICMPv6Checksum(h, layers[:i], icmpv6Message(p)))
+		}
+	}
+	return checksums
+}
+
+// upperPayload returns the data of the payload layer following a header, if any
+func upperPayload(above []Layer) []byte {
+	if len(above) > 0 {
+		if p, ok := above[0].(Payload); ok {
+			return p
+		}
+	}
+	return nil
+}
+
+// icmpv6Message returns the received bytes of the ICMPv6 message of the packet, header included.
+// They are checksummed as they are, as the NDP and MLD layers do not keep the bytes they do not decode
+func icmpv6Message(p LayeredPacket) []byte {
+	if icmp, ok := p.(*ICMPv6Packet); ok {
+		return icmp.IPPacket.Payload()
+	}
+	return nil
+}
+
+// upperLayerLength returns the length of the data carried by the IP header, following the IPv6 extension headers,
+// as declared by the header, or false if the header carries a fragment of it
+func upperLayerLength(ip IPHeader) (int, bool) {
+	switch h := ip.(type) {
+	case IPv4Header:
+		if h.MoreFragments() || h.FragmentOffset != 0 {
+			return 0, false
+		}
+		return int(h.TotalLength) - int(h.IHL)*4, true
+	case IPv6Header:
+		if f := h.FragmentHeader(); f != nil && (f.MoreFragments || f.FragmentOffset != 0) {
+			return 0, false
+		}
+		return int(h.PayloadLength) - (h.Len() - 40), true
+	default:
+		return 0, false
+	}
+}
+
+func verifyIPv4Checksum(h IPv4Header) Checksum {
+	header, err := h.SerializeTo(nil, nil, SerializeOptions{ComputeChecksums: true})
+	if err != nil {
+		return Checksum{Layer: LayerTypeIPv4, Value: h.HeaderChecksum}
+	}
+	return verified(LayerTypeIPv4, h.HeaderChecksum, binary.BigEndian.Uint16(header[10:12]))
+}
+
+// verifyUDPChecksum verifies the checksum of the datagram, whose length is declared by the header.
+// A zero checksum means none was computed, which is not allowed over IPv6
+func verifyUDPChecksum(h *UDPHeader, below []Layer, payload []byte) Checksum {
+	unverified := Checksum{Layer: LayerTypeUDP, Value: h.Checksum}
+	ip, ok := innermostIPHeader(below)
+	if !ok {
+		return unverified
+	}
+	if _, ipv4 := ip.(IPv4Header); ipv4 && h.Checksum == 0 {
+		return unverified
+	}
+	if _, ok := upperLayerLength(ip); !ok {
+		return unverified
+	}
+
+	n := int(h.Length) - 8
+	if n < 0 || len(payload) < n {
+		return unverified
+	}
+	segment, err := h.SerializeTo(payload[:n], below, SerializeOptions{ComputeChecksums: true})
+	if err != nil {
+		return unverified
+	}
+	return verified(LayerTypeUDP, h.Checksum, binary.BigEndian.Uint16(segment[6:8]))
+}
+
+// verifyTCPChecksum verifies the checksum of the segment, whose length is declared by the IP header below it
+func verifyTCPChecksum(h *TCPHeader, below []Layer, payload []byte) Checksum {
+	unverified := Checksum{Layer: LayerTypeTCP, Value: h.Checksum}
+	ip, ok := innermostIPHeader(below)
+	if !ok {
+		return unverified
+	}
+	length, ok := upperLayerLength(ip)
+	if !ok {
+		return unverified
+	}
+
+	n := length - int(h.RawOffset)*4
+	if n < 0 || len(payload) < n {
+		return unverified
+	}
+	segment, err := h.SerializeTo(payload[:n], below, SerializeOptions{ComputeChecksums: true})
+	if err != nil {
+		return unverified
+	}
+	return verified(LayerTypeTCP, h.Checksum, binary.BigEndian.Uint16(segment[16:18]))
+}
+
+// verifyICMPChecksum verifies the checksum of the message, whose length is declared by the IP header below it
+func verifyICMPChecksum(h *ICMPHeader, below []Layer, payload []byte) Checksum {
+	unverified := Checksum{Layer: LayerTypeICMPv4, Value: h.Checksum}
+	ip, ok := innermostIPHeader(below)
+	if !ok {
+		return unverified
+	}
+	length, ok := upperLayerLength(ip)
+	if !ok {
+		return unverified
+	}
+
+	n := length - 8
+	if n < 0 || len(payload) < n {
+		return unverified
+	}
+	message, err := h.SerializeTo(payload[:n], below, SerializeOptions{ComputeChecksums: true})
+	if err != nil {
+		return unverified
+	}
+	return verified(LayerTypeICMPv4, h.Checksum, binary.BigEndian.Uint16(message[2:4]))
+}
+
+// verifyICMPv6Checksum verifies the checksum of the received message, whose length is declared by the IP header below it
+func verifyICMPv6Checksum(h *ICMPv6Header, below []Layer, message []byte) Checksum {
+	unverified := Checksum{Layer: LayerTypeICMPv6, Value: h.Checksum}
+	ip, ok := innermostIPHeader(below)
+	if !ok {
+		return unverified
+	}
+	n, ok := upperLayerLength(ip)
+	if !ok {
+		return unverified
+	}
+
+	if n < h.headerLen() || len(message) < n {
+		return unverified
+	}
+	// the checksum is computed with its own field set to zero
+	message = append([]byte{}, message[:n]...)
+	message[2], message[3] = 0, 0
+	expected, err := transportChecksum(message, below, ipProtocolICMPv6)
+	if err != nil {
+		return unverified
+	}
+	return verified(LayerTypeICMPv6, h.Checksum, expected)
+}
@@ -0,0 +1,145 @@
+package protocols
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+// statuses returns the layer and status of every checksum
+func statuses(checksums []Checksum) map[LayerType]ChecksumStatus {
+	s := make(map[LayerType]ChecksumStatus, len(checksums))
+	for _, c := range checksums {
+		s[c.Layer] = c.Status
+	}
+	return s
+}
+
+func TestVerifyChecksums(t *testing.T) {
+	ip := IPv4Header{
+		Version:       4,
+		TTL:           64,
+		Protocol:      17,
+		SourceIP:      netip.MustParseAddr("192.168.0.1"),
+		DestinationIP: netip.MustParseAddr("192.168.0.2"),
+	}
+	udp := &UDPHeader{SourcePort: 40000, DestinationPort: 53}
+	datagram := func() []byte {
+		return ipFrame(t, ip, udp, Payload("bisturi checksum"))
+	}
+
+	ipv6 := IPv6Header{
+		Version:       6,
+		NextHeader:    6,
+		HopLimit:      64,
+		SourceIP:      netip.MustParseAddr("2001:db8::1"),
+		DestinationIP: netip.MustParseAddr("2001:db8::2"),
+	}
+	tcp := &TCPHeader{SourcePort: 40000, DestinationPort: 443, Flags: 0x02, WindowSize: 65535, Options: []TCPOption{{Kind: TCPOptionMSS, Data: []byte{0x05, 0xb4}}}}
+
+	tests := []struct {
+		name     string
+		packet   func() LayeredPacket
+		expected map[LayerType]ChecksumStatus
+	}{
+		{
+			name:     "UDP over IPv4",
+			packet:   func() LayeredPacket { return decode(t, datagram(), UDPPacketFromIPPacket) },
+			expected: map[LayerType]ChecksumStatus{LayerTypeIPv4: ChecksumGood, LayerTypeUDP: ChecksumGood},
+		},
+		{
+			name: "corrupted payload",
+			packet: func() LayeredPacket {
+				raw := datagram()
+				raw[len(raw)-1] ^= 0xFF
+				return decode(t, raw, UDPPacketFromIPPacket)
+			},
+			expected: map[LayerType]ChecksumStatus{LayerTypeIPv4: ChecksumGood, LayerTypeUDP: ChecksumBad},
+		},
+		{
+			name: "corrupted IPv4 header",
+			packet: func() LayeredPacket {
+				raw := datagram()
+				// the TTL, covered by the header checksum only
+				raw[22]--
+				return decode(t, raw, UDPPacketFromIPPacket)
+			},
+			expected: map[LayerType]ChecksumStatus{LayerTypeIPv4: ChecksumBad, LayerTypeUDP: ChecksumGood},
+		},
+		{
+			name: "padded Ethernet frame",
+			packet: func() LayeredPacket {
+				short := ipFrame(t, ip, udp, Payload("a"))
+				return decode(t, append(short, make([]byte, 60-len(short))...), UDPPacketFromIPPacket)
+			},
+			expected: map[LayerType]ChecksumStatus{LayerTypeIPv4: ChecksumGood, LayerTypeUDP: ChecksumGood},
+		},
+		{
+			name: "missing UDP checksum",
+			packet: func() LayeredPacket {
+				raw := datagram()
+				raw[40], raw[41] = 0, 0
+				return decode(t, raw, UDPPacketFromIPPacket)
+			},
+			expected: map[LayerType]ChecksumStatus{LayerTypeIPv4: ChecksumGood, LayerTypeUDP: ChecksumUnverified},
+		},
+		{
+			name: "truncated capture",
+			packet: func() LayeredPacket {
+				raw := datagram()
+				return decode(t, raw[:len(raw)-4], UDPPacketFromIPPacket)
+			},
+			expected: map[LayerType]ChecksumStatus{LayerTypeIPv4: ChecksumGood, LayerTypeUDP: ChecksumUnverified},
+		},
+		{
+			name: "first fragment",
+			packet: func() LayeredPacket {
+				fragment := ip
+				fragment.Flags = IPv4FlagMF
+				return decode(t, ipFrame(t, fragment, udp, Payload("bisturi checksum")), UDPPacketFromIPPacket)
+			},
+			expected: map[LayerType]ChecksumStatus{LayerTypeIPv4: ChecksumGood, LayerTypeUDP: ChecksumUnverified},
+		},
+		{
+			name:     "TCP over IPv6",
+			packet:   func() LayeredPacket { return decode(t, ipFrame(t, ipv6, tcp, Payload("hello")), TCPPacketFromIPPacket) },
+			expected: map[LayerType]ChecksumStatus{LayerTypeTCP: ChecksumGood},
+		},
+		{
+			name: "corrupted TCP header",
+			packet: func() LayeredPacket {
+				raw := ipFrame(t, ipv6, tcp, Payload("hello"))
+				// the window size
+				raw[68] ^= 0x01
+				return decode(t, raw, TCPPacketFromIPPacket)
+			},
+			expected: map[LayerType]ChecksumStatus{LayerTypeTCP: ChecksumBad},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := statuses(VerifyChecksums(tt.packet())); !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("expected checksums %v - got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestVerifyChecksumsExpected(t *testing.T) {
+	ip := IPv4Header{
+		Version:       4,
+		TTL:           64,
+		Protocol:      17,
+		SourceIP:      netip.MustParseAddr("192.168.0.1"),
+		DestinationIP: netip.MustParseAddr("192.168.0.2"),
+	}
+	raw := ipFrame(t, ip, &UDPHeader{SourcePort: 40000, DestinationPort: 53}, Payload("bisturi"))
+	good := decode(t, raw, UDPPacketFromIPPacket).Header.Checksum
+	raw[40], raw[41] = 0x12, 0x34
+
+	checksums := VerifyChecksums(decode(t, raw, UDPPacketFromIPPacket))
+	expected := Checksum{Layer: LayerTypeUDP, Value: 0x1234, Status: ChecksumBad, Expected: good}
+	if len(checksums) != 2 || checksums[1] != expected {
+		t.Errorf("expected UDP checksum %+v - got %+v", expected, checksums)
+	}
+}
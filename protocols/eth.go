@@ -15,31 +15,87 @@ var EtherTypesValues = map[uint16]string{
 	0x8535: "RARP",
 	0x86DD: "IPv6",
 	0x8808: "Ethernet flow control",
+	0x8100: "802.1Q",
+	0x88A8: "802.1ad",
 }
 
+// EtherTypes of the VLAN tags, the TPID: 802.1Q tags and the 802.1ad service tags of QinQ frames
+const (
+	etherTypeVLAN = 0x8100
+	etherTypeQinQ = 0x88A8
+)
+
 // EthernetFrame contains Layer 2 data
 type EthernetFrame struct {
 	DestinationMAC net.HardwareAddr
 	SourceMAC      net.HardwareAddr
-	EtherType      uint16
+	EtherType      uint16    // type of the payload, following the VLAN tags
+	VLANs          []VLANTag // 802.1Q tags, the outermost first
 	Payload        []byte
 }
 
+// VLANTag is an 802.1Q tag, identifying the virtual LAN a frame belongs to
+type VLANTag struct {
+	TPID uint16 // tag protocol identifier: 0x8100 for 802.1Q, 0x88A8 for 802.1ad service tags
+	PCP  uint8  // priority code point
+	DEI  bool   // drop eligible indicator
+	VID  uint16 // VLAN identifier
+}
+
+// VLANTagFromTCI returns the tag with the given protocol identifier and tag control information
+func VLANTagFromTCI(tpid, tci uint16) VLANTag {
+	return VLANTag{
+		TPID: tpid,
+		PCP:  uint8(tci >> 13),
+		DEI:  tci&0x1000 != 0,
+		VID:  tci & 0x0FFF,
+	}
+}
+
+// TCI returns the tag control information encoding the priority, drop eligible indicator and VLAN ID
+func (t VLANTag) TCI() uint16 {
+	tci := uint16(t.PCP)<<13 | t.VID&0x0FFF
+	if t.DEI {
+		tci |= 0x1000
+	}
+	return tci
+}
+
+// Framed is implemented by the packets keeping the Ethernet frame they were carried by
+type Framed interface {
+	Frame() *EthernetFrame
+}
+
 var errInvalidETHFrame = errors.New("ethernet frame header must be 14 bytes")
+var errInvalidVLANTag = errors.New("802.1Q tag must be 4 bytes, followed by an EtherType")
 
 // EthFrameFromBytes parses an array of bytes to the corresponding ETH frame and returns a pointer to it.
-// Returns an error if the number of bytes is less than 14
+// The 802.1Q and 802.1ad tags following the source MAC, if any, are decoded and the payload starts after them.
+// Returns an error if the number of bytes is less than 14 or a tag is truncated
 func EthFrameFromBytes(raw []byte) (*EthernetFrame, error) {
 	if len(raw) < 14 {
 		return nil, errInvalidETHFrame
 	}
 
-	return &EthernetFrame{
+	frame := &EthernetFrame{
 		DestinationMAC: net.HardwareAddr(raw[0:6]),
 		SourceMAC:      net.HardwareAddr(raw[6:12]),
-		EtherType:      binary.BigEndian.Uint16(raw[12:14]),
-		Payload:        raw[14:],
-	}, nil
+	}
+	// every tag is made of its TPID and TCI, and followed by the EtherType of what it carries: possibly another tag
+	offset := 12
+	etherType := binary.BigEndian.Uint16(raw[offset:])
+	for etherType == etherTypeVLAN || etherType == etherTypeQinQ {
+		if len(raw) < offset+6 {
+			return nil, errInvalidVLANTag
+		}
+		frame.VLANs = append(frame.VLANs, VLANTagFromTCI(etherType, binary.BigEndian.Uint16(raw[offset+2:])))
+		offset += 4
+		etherType = binary.BigEndian.Uint16(raw[offset:])
+	}
+
+	frame.EtherType = etherType
+	frame.Payload = raw[offset+2:]
+	return frame, nil
 }
 
 func (f EthernetFrame) Type() string {
@@ -50,7 +106,7 @@ func (f EthernetFrame) Type() string {
 func (f EthernetFrame) Info() string {
 	etv := EtherTypesValues[f.EtherType]
 
-	return fmt.Sprintf(`
+	info := fmt.Sprintf(`
 Ethernet Frame
 
 Destination MAC: %s
@@ -58,4 +114,8 @@ Source MAC: %s
 EtherType: 0x%X (%s)`,
 		f.DestinationMAC, f.SourceMAC, f.EtherType, etv,
 	)
+	for _, tag := range f.VLANs {
+		info += fmt.Sprintf("\nVLAN: %d (PCP %d, DEI %t, TPID 0x%04X)", tag.VID, tag.PCP, tag.DEI, tag.TPID)
+	}
+	return info
 }
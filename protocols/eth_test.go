@@ -1,6 +1,11 @@
 package protocols
 
-import "testing"
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
 
 func TestEthFrameFromBytes(t *testing.T) {
 	tests := []struct {
@@ -74,3 +79,93 @@ func TestEthFrameFromBytes(t *testing.T) {
 		})
 	}
 }
+
+func TestEthFrameFromBytesVLAN(t *testing.T) {
+	macs := []byte{0x00, 0x1A, 0xA0, 0xBB, 0xCC, 0xDD, 0x00, 0x1A, 0xB0, 0xCC, 0xDD, 0xEE}
+
+	tests := []struct {
+		name              string
+		tags              []byte
+		expectedVLANs     []VLANTag
+		expectedEtherType uint16
+		expectedPayload   []byte
+		expectedErr       error
+	}{
+		{
+			name:              "802.1Q tag",
+			tags:              []byte{0x81, 0x00, 0xb0, 0x64, 0x08, 0x00, 0x45},
+			expectedVLANs:     []VLANTag{{TPID: 0x8100, PCP: 5, DEI: true, VID: 100}},
+			expectedEtherType: 0x0800,
+			expectedPayload:   []byte{0x45},
+		},
+		{
+			name:              "QinQ tags",
+			tags:              []byte{0x88, 0xa8, 0x00, 0xc8, 0x81, 0x00, 0x00, 0x0a, 0x86, 0xdd, 0x60},
+			expectedVLANs:     []VLANTag{{TPID: 0x88A8, VID: 200}, {TPID: 0x8100, VID: 10}},
+			expectedEtherType: 0x86DD,
+			expectedPayload:   []byte{0x60},
+		},
+		{
+			name:        "truncated tag",
+			tags:        []byte{0x81, 0x00, 0x00, 0x0a},
+			expectedErr: errInvalidVLANTag,
+		},
+		{
+			name:        "truncated inner tag",
+			tags:        []byte{0x88, 0xa8, 0x00, 0xc8, 0x81, 0x00, 0x00},
+			expectedErr: errInvalidVLANTag,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frame, err := EthFrameFromBytes(append(append([]byte{}, macs...), tt.tags...))
+			if tt.expectedErr != err {
+				t.Fatalf("expected error to be: %v - got: %v", tt.expectedErr, err)
+			}
+			if tt.expectedErr != nil {
+				return
+			}
+
+			if !reflect.DeepEqual(tt.expectedVLANs, frame.VLANs) {
+				t.Errorf("expected VLAN tags to be %v - got %v", tt.expectedVLANs, frame.VLANs)
+			}
+			if tt.expectedEtherType != frame.EtherType {
+				t.Errorf("expected ethernet type to be 0x%X - got 0x%X", tt.expectedEtherType, frame.EtherType)
+			}
+			if !bytes.Equal(tt.expectedPayload, frame.Payload) {
+				t.Errorf("expected payload to be %v - got %v", tt.expectedPayload, frame.Payload)
+			}
+		})
+	}
+}
+
+func TestVLANTagFromTCI(t *testing.T) {
+	tests := []struct {
+		tpid     uint16
+		tci      uint16
+		expected VLANTag
+	}{
+		{tpid: 0x8100, tci: 0x000a, expected: VLANTag{TPID: 0x8100, VID: 10}},
+		{tpid: 0x88A8, tci: 0xbfff, expected: VLANTag{TPID: 0x88A8, PCP: 5, DEI: true, VID: 4095}},
+	}
+
+	for _, tt := range tests {
+		if got := VLANTagFromTCI(tt.tpid, tt.tci); got != tt.expected {
+			t.Errorf("expected tag to be %+v - got %+v", tt.expected, got)
+		}
+	}
+}
+
+func TestEthernetFrameInfoVLAN(t *testing.T) {
+	frame, err := EthFrameFromBytes([]byte{0x00, 0x1A, 0xA0, 0xBB, 0xCC, 0xDD, 0x00, 0x1A, 0xB0, 0xCC, 0xDD, 0xEE, 0x08, 0x00})
+	if err != nil {
+		t.Fatalf("expected no error - got %v", err)
+	}
+	frame.VLANs = []VLANTag{VLANTagFromTCI(0x8100, 0x6064)}
+
+	expected := "VLAN: 100 (PCP 3, DEI false, TPID 0x8100)"
+	if info := frame.Info(); !strings.Contains(info, expected) {
+		t.Errorf("expected info to contain %q - got %q", expected, info)
+	}
+}
@@ -0,0 +1,267 @@
+package protocols
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// ICMP message types
+const (
+	ICMPTypeEchoReply              = 0
+	ICMPTypeDestinationUnreachable = 3
+	ICMPTypeSourceQuench           = 4
+	ICMPTypeRedirect               = 5
+	ICMPTypeEchoRequest            = 8
+	ICMPTypeRouterAdvertisement    = 9
+	ICMPTypeRouterSolicitation     = 10
+	ICMPTypeTimeExceeded           = 11
+	ICMPTypeParameterProblem       = 12
+	ICMPTypeTimestamp              = 13
+	ICMPTypeTimestampReply         = 14
+)
+
+// ICMPCodeFragmentationNeeded is the destination unreachable code of the packets too large for the next hop,
+// which can not be fragmented
+const ICMPCodeFragmentationNeeded = 4
+
+var icmpTypeNames = map[uint8]string{
+	ICMPTypeEchoReply:              "Echo Reply",
+	ICMPTypeDestinationUnreachable: "Destination Unreachable",
+	ICMPTypeSourceQuench:           "Source Quench",
+	ICMPTypeRedirect:               "Redirect",
+	ICMPTypeEchoRequest:            "Echo Request",
+	ICMPTypeRouterAdvertisement:    "Router Advertisement",
+	ICMPTypeRouterSolicitation:     "Router Solicitation",
+	ICMPTypeTimeExceeded:           "Time Exceeded",
+	ICMPTypeParameterProblem:       "Parameter Problem",
+	ICMPTypeTimestamp:              "Timestamp",
+	ICMPTypeTimestampReply:         "Timestamp Reply",
+}
+
+// icmpCodeNames contains the meaning of the codes of the types having more than one
+var icmpCodeNames = map[uint8]map[uint8]string{
+	ICMPTypeDestinationUnreachable: {
+		0:  "Network Unreachable",
+		1:  "Host Unreachable",
+		2:  "Protocol Unreachable",
+		3:  "Port Unreachable",
+		4:  "Fragmentation Needed",
+		5:  "Source Route Failed",
+		6:  "Destination Network Unknown",
+		7:  "Destination Host Unknown",
+		8:  "Source Host Isolated",
+		9:  "Network Administratively Prohibited",
+		10: "Host Administratively Prohibited",
+		11: "Network Unreachable for ToS",
+		12: "Host Unreachable for ToS",
+		13: "Communication Administratively Prohibited",
+		14: "Host Precedence Violation",
+		15: "Precedence Cutoff in Effect",
+	},
+	ICMPTypeRedirect: {
+		0: "Redirect for Network",
+		1: "Redirect for Host",
+		2: "Redirect for ToS and Network",
+		3: "Redirect for ToS and Host",
+	},
+	ICMPTypeTimeExceeded: {
+		0: "TTL Exceeded in Transit",
+		1: "Fragment Reassembly Time Exceeded",
+	},
+	ICMPTypeParameterProblem: {
+		0: "Pointer Indicates the Error",
+		1: "Missing a Required Option",
+		2: "Bad Length",
+	},
+}
+
+// ICMPPacket is an ICMP message carried by an IPv4 packet
+type ICMPPacket struct {
+	IPPacket IPPacket
+	Header   ICMPHeader
+	// Original is the header of the packet which caused an error message, whose first bytes of data,
+	// like the UDP or TCP ports, are in OriginalData. It is nil for the other messages,
+	// or if the header is not valid
+	Original     *IPv4Header
+	OriginalData []byte
+}
+
+// ICMPHeader is the header of an ICMP message. The meaning of the 4 bytes following the checksum
+// depends on the type of the message
+type ICMPHeader struct {
+	Type         uint8
+	Code         uint8
+	Checksum     uint16
+	RestOfHeader [4]byte
+}
+
+var ErrInvalidICMPHeader = errors.New("ICMP header must be 8 bytes")
+
+// ICMPPacketFromIPPacket parses the payload of the passed IPv4 packet returning a struct containing the ICMP message.
+// The original packet embedded in error messages is decoded as well.
+// An error is returned if the header is too short
+func ICMPPacketFromIPPacket(ip IPPacket) (*ICMPPacket, error) {
+	h, err := ICMPHeaderFromBytes(ip.Payload())
+	if err != nil {
+		return nil, err
+	}
+
+	p := &ICMPPacket{
+		IPPacket: ip,
+		Header:   *h,
+	}
+	if h.IsError() {
+		data := ip.Payload()[8:]
+		if original, err := IPv4HeaderFromBytes(data); err == nil && original.Version == 4 && original.IHL >= 5 {
+			p.Original = original
+			p.OriginalData = data[original.Len():]
+		}
+	}
+	return p, nil
+}
+
+// ICMPHeaderFromBytes parses the passed bytes to a struct containing the ICMP header data and returns a pointer to it.
+// It expects an array of at least 8 bytes
+func ICMPHeaderFromBytes(raw []byte) (*ICMPHeader, error) {
+	if len(raw) < 8 {
+		return nil, ErrInvalidICMPHeader
+	}
+
+	h := &ICMPHeader{
+		Type:     raw[0],
+		Code:     raw[1],
+		Checksum: binary.BigEndian.Uint16(raw[2:4]),
+	}
+	copy(h.RestOfHeader[:], raw[4:8])
+	return h, nil
+}
+
+// IsError reports whether the message reports an error caused by another packet, which it embeds
+func (h ICMPHeader) IsError() bool {
+	switch h.Type {
+	case ICMPTypeDestinationUnreachable, ICMPTypeSourceQuench, ICMPTypeRedirect, ICMPTypeTimeExceeded, ICMPTypeParameterProblem:
+		return true
+	default:
+		return false
+	}
+}
+
+// TypeName returns the name of the message type
+func (h ICMPHeader) TypeName() string {
+	if name, ok := icmpTypeNames[h.Type]; ok {
+		return name
+	}
+	return "Unknown"
+}
+
+// CodeName returns the meaning of the code for the message type, or an empty string if the type has a single code
+func (h ICMPHeader) CodeName() string {
+	codes, ok := icmpCodeNames[h.Type]
+	if !ok {
+		return ""
+	}
+	if name, ok := codes[h.Code]; ok {
+		return name
+	}
+	return "Unknown"
+}
+
+// Identifier returns the identifier of an echo, timestamp or information message
+func (h ICMPHeader) Identifier() uint16 {
+	return binary.BigEndian.Uint16(h.RestOfHeader[0:2])
+}
+
+// SequenceNumber returns the sequence number of an echo, timestamp or information message
+func (h ICMPHeader) SequenceNumber() uint16 {
+	return binary.BigEndian.Uint16(h.RestOfHeader[2:4])
+}
+
+// Gateway returns the address of the router to send the packets to, carried by a redirect message
+func (h ICMPHeader) Gateway() netip.Addr {
+	return netip.AddrFrom4(h.RestOfHeader)
+}
+
+// NextHopMTU returns the MTU of the next hop, carried by a fragmentation needed message (RFC 1191)
+func (h ICMPHeader) NextHopMTU() uint16 {
+	return binary.BigEndian.Uint16(h.RestOfHeader[2:4])
+}
+
+// Pointer returns the offset of the byte which caused a parameter problem message
+func (h ICMPHeader) Pointer() uint8 {
+	return h.RestOfHeader[0]
+}
+
+// typeInfo returns the lines describing the fields which depend on the message type
+func (h ICMPHeader) typeInfo() string {
+	switch h.Type {
+	case ICMPTypeEchoRequest, ICMPTypeEchoReply, ICMPTypeTimestamp, ICMPTypeTimestampReply:
+		return fmt.Sprintf("\nIdentifier: %d\nSequence Number: %d", h.Identifier(), h.SequenceNumber())
+	case ICMPTypeRedirect:
+		return fmt.Sprintf("\nGateway: %s", h.Gateway())
+	case ICMPTypeDestinationUnreachable:
+		if h.Code == ICMPCodeFragmentationNeeded {
+			return fmt.Sprintf("\nNext-Hop MTU: %d", h.NextHopMTU())
+		}
+	case ICMPTypeParameterProblem:
+		return fmt.Sprintf("\nPointer: %d", h.Pointer())
+	}
+	return ""
+}
+
+// originalInfo describes the packet embedded in an error message, with the ports of its UDP or TCP header
+func (p ICMPPacket) originalInfo() string {
+	if p.Original == nil {
+		return ""
+	}
+
+	var sb strings.Builder
+	sb.WriteString("\n\nOriginal packet\n")
+	src, dst := p.Original.SourceIP.String(), p.Original.DestinationIP.String()
+	proto := p.Original.TransportLayerProtocol()
+	if (proto == "udp" || proto == "tcp") && len(p.OriginalData) >= 4 {
+		src = fmt.Sprintf("%s:%d", src, binary.BigEndian.Uint16(p.OriginalData[0:2]))
+		dst = fmt.Sprintf("%s:%d", dst, binary.BigEndian.Uint16(p.OriginalData[2:4]))
+	}
+	fmt.Fprintf(&sb, "\nSource: %s\nDestination: %s\nProtocol: %d (%s)\nTTL: %d\nIdentification: %d",
+		src, dst, p.Original.Protocol, proto, p.Original.TTL, p.Original.Identification)
+	return sb.String()
+}
+
+// Info return an human-readable string containing the main ICMP message data
+func (p ICMPPacket) Info() string {
+	code := fmt.Sprintf("%d", p.Header.Code)
+	if name := p.Header.CodeName(); name != "" {
+		code += fmt.Sprintf(" (%s)", name)
+	}
+	return fmt.Sprintf(`
+ICMP packet
+
+Type: %d (%s)
+Code: %s
+Checksum: %d%s%s
+
+===============================
+%s`,
+		p.Header.Type, p.Header.TypeName(), code, p.Header.Checksum, p.Header.typeInfo(), p.originalInfo(),
+		p.IPPacket.Info(),
+	)
+}
+
+// Frame returns the Ethernet frame carrying the packet, or nil if the IP packet does not keep it
+func (p *ICMPPacket) Frame() *EthernetFrame {
+	if f, ok := p.IPPacket.(Framed); ok {
+		return f.Frame()
+	}
+	return nil
+}
+
+func (p ICMPPacket) Source() string {
+	return p.IPPacket.Header().Source()
+}
+
+func (p ICMPPacket) Destination() string {
+	return p.IPPacket.Header().Destination()
+}
@@ -0,0 +1,177 @@
+package protocols
+
+import (
+	"errors"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func TestICMPPacketFromIPPacket(t *testing.T) {
+	ip := IPv4Header{
+		Version:       4,
+		TTL:           64,
+		Protocol:      1,
+		SourceIP:      netip.MustParseAddr("192.168.0.1"),
+		DestinationIP: netip.MustParseAddr("192.168.0.2"),
+	}
+
+	t.Run("echo request", func(t *testing.T) {
+		h := &ICMPHeader{Type: ICMPTypeEchoRequest, RestOfHeader: [4]byte{0x12, 0x34, 0x00, 0x07}}
+		p := decode(t, ipFrame(t, ip, h, Payload("bisturi ping")), ICMPPacketFromIPPacket)
+
+		if p.Header.Type != ICMPTypeEchoRequest || p.Header.Identifier() != 0x1234 || p.Header.SequenceNumber() != 7 {
+			t.Errorf("expected echo request id 0x1234 seq 7 - got %+v", p.Header)
+		}
+		if p.Original != nil {
+			t.Errorf("expected no original packet in an echo request - got %+v", p.Original)
+		}
+		if got := LayerNames(p); got != "Ethernet/IPv4/ICMPv4/Payload" {
+			t.Errorf("expected layers Ethernet/IPv4/ICMPv4/Payload - got %s", got)
+		}
+		if got := statuses(VerifyChecksums(p)); got[LayerTypeICMPv4] != ChecksumGood {
+			t.Errorf("expected a good ICMP checksum - got %s", got[LayerTypeICMPv4])
+		}
+		if info := p.Info(); !strings.Contains(info, "Type: 8 (Echo Request)") || !strings.Contains(info, "Identifier: 4660\nSequence Number: 7") {
+			t.Errorf("expected the details of the echo request - got %s", info)
+		}
+	})
+
+	t.Run("port unreachable", func(t *testing.T) {
+		// the IP header and the first 8 bytes of the datagram which could not be delivered
+		original := mustSerialize(t,
+			IPv4Header{
+				Version:        4,
+				TTL:            64,
+				Protocol:       17,
+				Identification: 42,
+				SourceIP:       netip.MustParseAddr("192.168.0.2"),
+				DestinationIP:  netip.MustParseAddr("192.168.0.1"),
+			},
+			&UDPHeader{SourcePort: 40000, DestinationPort: 53},
+			Payload("query"),
+		)[:28]
+		h := &ICMPHeader{Type: ICMPTypeDestinationUnreachable, Code: 3}
+		p := decode(t, ipFrame(t, ip, h, Payload(original)), ICMPPacketFromIPPacket)
+
+		if p.Original == nil {
+			t.Fatal("expected the original packet to be decoded")
+		}
+		if p.Original.Identification != 42 || p.Original.DestinationIP != netip.MustParseAddr("192.168.0.1") {
+			t.Errorf("expected the original header - got %+v", p.Original)
+		}
+		if len(p.OriginalData) != 8 {
+			t.Errorf("expected 8 bytes of original data - got %d", len(p.OriginalData))
+		}
+		info := p.Info()
+		for _, expected := range []string{"Code: 3 (Port Unreachable)", "Source: 192.168.0.2:40000", "Destination: 192.168.0.1:53", "Protocol: 17 (udp)"} {
+			if !strings.Contains(info, expected) {
+				t.Errorf("expected the details to contain %q - got %s", expected, info)
+			}
+		}
+	})
+
+	t.Run("invalid original packet", func(t *testing.T) {
+		h := &ICMPHeader{Type: ICMPTypeTimeExceeded}
+		p := decode(t, ipFrame(t, ip, h, Payload{0x45, 0x00}), ICMPPacketFromIPPacket)
+		if p.Original != nil {
+			t.Errorf("expected no original packet - got %+v", p.Original)
+		}
+	})
+
+	t.Run("Ethernet padding", func(t *testing.T) {
+		// a minimum-size Ethernet frame pads the echo request with 18 bytes
+		h := &ICMPHeader{Type: ICMPTypeEchoRequest, RestOfHeader: [4]byte{0x12, 0x34, 0x00, 0x01}}
+		p := decode(t, append(ipFrame(t, ip, h), make([]byte, 18)...), ICMPPacketFromIPPacket)
+
+		if got := LayerNames(p); got != "Ethernet/IPv4/ICMPv4" {
+			t.Errorf("expected layers Ethernet/IPv4/ICMPv4 - got %s", got)
+		}
+		if got := statuses(VerifyChecksums(p)); got[LayerTypeICMPv4] != ChecksumGood {
+			t.Errorf("expected a good ICMP checksum - got %s", got[LayerTypeICMPv4])
+		}
+	})
+
+	t.Run("too short", func(t *testing.T) {
+		if _, err := ICMPPacketFromIPPacket(&IPv4Packet{payload: []byte{0x08, 0x00, 0xf7}}); !errors.Is(err, ErrInvalidICMPHeader) {
+			t.Errorf("expected error %v - got %v", ErrInvalidICMPHeader, err)
+		}
+	})
+}
+
+func TestICMPHeaderFields(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   ICMPHeader
+		isError  bool
+		typeName string
+		codeName string
+		info     string
+	}{
+		{
+			name:     "echo reply",
+			header:   ICMPHeader{Type: ICMPTypeEchoReply, RestOfHeader: [4]byte{0x00, 0x01, 0x00, 0x02}},
+			typeName: "Echo Reply",
+			info:     "\nIdentifier: 1\nSequence Number: 2",
+		},
+		{
+			name:     "fragmentation needed",
+			header:   ICMPHeader{Type: ICMPTypeDestinationUnreachable, Code: ICMPCodeFragmentationNeeded, RestOfHeader: [4]byte{0x00, 0x00, 0x05, 0xdc}},
+			isError:  true,
+			typeName: "Destination Unreachable",
+			codeName: "Fragmentation Needed",
+			info:     "\nNext-Hop MTU: 1500",
+		},
+		{
+			name:     "host unreachable",
+			header:   ICMPHeader{Type: ICMPTypeDestinationUnreachable, Code: 1},
+			isError:  true,
+			typeName: "Destination Unreachable",
+			codeName: "Host Unreachable",
+		},
+		{
+			name:     "time exceeded",
+			header:   ICMPHeader{Type: ICMPTypeTimeExceeded, Code: 0},
+			isError:  true,
+			typeName: "Time Exceeded",
+			codeName: "TTL Exceeded in Transit",
+		},
+		{
+			name:     "redirect",
+			header:   ICMPHeader{Type: ICMPTypeRedirect, Code: 1, RestOfHeader: [4]byte{192, 168, 0, 254}},
+			isError:  true,
+			typeName: "Redirect",
+			codeName: "Redirect for Host",
+			info:     "\nGateway: 192.168.0.254",
+		},
+		{
+			name:     "parameter problem",
+			header:   ICMPHeader{Type: ICMPTypeParameterProblem, RestOfHeader: [4]byte{9}},
+			isError:  true,
+			typeName: "Parameter Problem",
+			codeName: "Pointer Indicates the Error",
+			info:     "\nPointer: 9",
+		},
+		{
+			name:     "unknown type",
+			header:   ICMPHeader{Type: 200},
+			typeName: "Unknown",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.header.IsError() != tt.isError {
+				t.Errorf("expected IsError to be %t", tt.isError)
+			}
+			if got := tt.header.TypeName(); got != tt.typeName {
+				t.Errorf("expected type %q - got %q", tt.typeName, got)
+			}
+			if got := tt.header.CodeName(); got != tt.codeName {
+				t.Errorf("expected code %q - got %q", tt.codeName, got)
+			}
+			if got := tt.header.typeInfo(); got != tt.info {
+				t.Errorf("expected info %q - got %q", tt.info, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,286 @@
+package protocols
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ICMPv6 message types
+const (
+	ICMPv6TypeDestinationUnreachable = 1
+	ICMPv6TypePacketTooBig           = 2
+	ICMPv6TypeTimeExceeded           = 3
+	ICMPv6TypeParameterProblem       = 4
+	ICMPv6TypeEchoRequest            = 128
+	ICMPv6TypeEchoReply              = 129
+	ICMPv6TypeMLDQuery               = 130
+	ICMPv6TypeMLDReport              = 131
+	ICMPv6TypeMLDDone                = 132
+	ICMPv6TypeRouterSolicitation     = 133
+	ICMPv6TypeRouterAdvertisement    = 134
+	ICMPv6TypeNeighborSolicitation   = 135
+	ICMPv6TypeNeighborAdvertisement  = 136
+	ICMPv6TypeRedirect               = 137
+	ICMPv6TypeMLDv2Report            = 143
+)
+
+var icmpv6TypeNames = map[uint8]string{
+	ICMPv6TypeDestinationUnreachable: "Destination Unreachable",
+	ICMPv6TypePacketTooBig:           "Packet Too Big",
+	ICMPv6TypeTimeExceeded:           "Time Exceeded",
+	ICMPv6TypeParameterProblem:       "Parameter Problem",
+	ICMPv6TypeEchoRequest:            "Echo Request",
+	ICMPv6TypeEchoReply:              "Echo Reply",
+	ICMPv6TypeMLDQuery:               "Multicast Listener Query",
+	ICMPv6TypeMLDReport:              "Multicast Listener Report",
+	ICMPv6TypeMLDDone:                "Multicast Listener Done",
+	ICMPv6TypeRouterSolicitation:     "Router Solicitation",
+	ICMPv6TypeRouterAdvertisement:    "Router Advertisement",
+	ICMPv6TypeNeighborSolicitation:   "Neighbor Solicitation",
+	ICMPv6TypeNeighborAdvertisement:  "Neighbor Advertisement",
+	ICMPv6TypeRedirect:               "Redirect",
+	ICMPv6TypeMLDv2Report:            "Multicast Listener Report v2",
+}
+
+// icmpv6CodeNames contains the meaning of the codes of the error messages
+var icmpv6CodeNames = map[uint8]map[uint8]string{
+	ICMPv6TypeDestinationUnreachable: {
+		0: "No Route to Destination",
+		1: "Communication Administratively Prohibited",
+		2: "Beyond Scope of Source Address",
+		3: "Address Unreachable",
+		4: "Port Unreachable",
+		5: "Source Address Failed Ingress/Egress Policy",
+		6: "Reject Route to Destination",
+		7: "Error in Source Routing Header",
+	},
+	ICMPv6TypeTimeExceeded: {
+		0: "Hop Limit Exceeded in Transit",
+		1: "Fragment Reassembly Time Exceeded",
+	},
+	ICMPv6TypeParameterProblem: {
+		0: "Erroneous Header Field",
+		1: "Unrecognized Next Header Type",
+		2: "Unrecognized IPv6 Option",
+	},
+}
+
+// ICMPv6Packet is an ICMPv6 message carried by an IPv6 packet. Neighbor Discovery and Multicast Listener Discovery
+// messages are decoded into NDP or MLD, while error messages embed the packet which caused them
+type ICMPv6Packet struct {
+	IPPacket IPPacket
+	Header   ICMPv6Header
+	NDP      *NDPMessage
+	MLD      *MLDMessage
+	// Original is the header of the packet which caused an error message, followed by as much of its data
+	// as fits in the minimum IPv6 MTU, in OriginalData. It is nil for the other messages, or if the header is not valid
+	Original     *IPv6Header
+	OriginalData []byte
+}
+
+// ICMPv6Header is the header of an ICMPv6 message. The meaning of the 4 bytes following the checksum
+// depends on the type of the message. For the NDP and MLD messages they are part of the NDPMessage or MLDMessage,
+// which serializes them
+type ICMPv6Header struct {
+	Type         uint8
+	Code         uint8
+	Checksum     uint16
+	RestOfHeader [4]byte
+}
+
+var ErrInvalidICMPv6Header = errors.New("ICMPv6 header must be 8 bytes")
+
+// ICMPv6PacketFromIPPacket parses the payload of the passed IPv6 packet returning a struct containing the ICMPv6 message.
+// The NDP and MLD messages, and the original packet embedded in error messages, are decoded as well.
+// An error is returned if the header is too short, or an NDP or MLD message is not valid
+func ICMPv6PacketFromIPPacket(ip IPPacket) (*ICMPv6Packet, error) {
+	h, err := ICMPv6HeaderFromBytes(ip.Payload())
+	if err != nil {
+		return nil, err
+	}
+
+	p := &ICMPv6Packet{
+		IPPacket: ip,
+		Header:   *h,
+	}
+	body := ip.Payload()[8:]
+	switch {
+	case h.IsError():
+		if original, err := IPv6HeaderFromBytes(body); err == nil && original.Version == 6 {
+			p.Original = original
+			p.OriginalData = body[original.Len():]
+		}
+	case h.isNDP():
+		if p.NDP, err = ndpMessageFromBytes(h, body); err != nil {
+			return nil, err
+		}
+	case h.isMLD():
+		if p.MLD, err = mldMessageFromBytes(h, body); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// ICMPv6HeaderFromBytes parses the passed bytes to a struct containing the ICMPv6 header data and returns a pointer to it.
+// It expects an array of at least 8 bytes
+func ICMPv6HeaderFromBytes(raw []byte) (*ICMPv6Header, error) {
+	if len(raw) < 8 {
+		return nil, ErrInvalidICMPv6Header
+	}
+
+	h := &ICMPv6Header{
+		Type:     raw[0],
+		Code:     raw[1],
+		Checksum: binary.BigEndian.Uint16(raw[2:4]),
+	}
+	copy(h.RestOfHeader[:], raw[4:8])
+	return h, nil
+}
+
+// IsError reports whether the message reports an error caused by another packet, which it embeds
+func (h ICMPv6Header) IsError() bool {
+	return h.Type < 128
+}
+
+// isNDP reports whether the message is a Neighbor Discovery one
+func (h ICMPv6Header) isNDP() bool {
+	return h.Type >= ICMPv6TypeRouterSolicitation && h.Type <= ICMPv6TypeRedirect
+}
+
+// isMLD reports whether the message is a Multicast Listener Discovery one
+func (h ICMPv6Header) isMLD() bool {
+	switch h.Type {
+	case ICMPv6TypeMLDQuery, ICMPv6TypeMLDReport, ICMPv6TypeMLDDone, ICMPv6TypeMLDv2Report:
+		return true
+	default:
+		return false
+	}
+}
+
+// headerLen returns the length of the header serialized by the ICMPv6Header layer: the bytes following
+// the checksum of NDP and MLD messages are serialized by their own layer
+func (h ICMPv6Header) headerLen() int {
+	if h.isNDP() || h.isMLD() {
+		return 4
+	}
+	return 8
+}
+
+// TypeName returns the name of the message type
+func (h ICMPv6Header) TypeName() string {
+	if name, ok := icmpv6TypeNames[h.Type]; ok {
+		return name
+	}
+	return "Unknown"
+}
+
+// CodeName returns the meaning of the code for the message type, or an empty string if the type has a single code
+func (h ICMPv6Header) CodeName() string {
+	codes, ok := icmpv6CodeNames[h.Type]
+	if !ok {
+		return ""
+	}
+	if name, ok := codes[h.Code]; ok {
+		return name
+	}
+	return "Unknown"
+}
+
+// Identifier returns the identifier of an echo message
+func (h ICMPv6Header) Identifier() uint16 {
+	return binary.BigEndian.Uint16(h.RestOfHeader[0:2])
+}
+
+// SequenceNumber returns the sequence number of an echo message
+func (h ICMPv6Header) SequenceNumber() uint16 {
+	return binary.BigEndian.Uint16(h.RestOfHeader[2:4])
+}
+
+// MTU returns the MTU of the next hop, carried by a packet too big message
+func (h ICMPv6Header) MTU() uint32 {
+	return binary.BigEndian.Uint32(h.RestOfHeader[:])
+}
+
+// Pointer returns the offset of the byte which caused a parameter problem message
+func (h ICMPv6Header) Pointer() uint32 {
+	return binary.BigEndian.Uint32(h.RestOfHeader[:])
+}
+
+// typeInfo returns the lines describing the fields which depend on the message type
+func (h ICMPv6Header) typeInfo() string {
+	switch h.Type {
+	case ICMPv6TypeEchoRequest, ICMPv6TypeEchoReply:
+		return fmt.Sprintf("\nIdentifier: %d\nSequence Number: %d", h.Identifier(), h.SequenceNumber())
+	case ICMPv6TypePacketTooBig:
+		return fmt.Sprintf("\nMTU: %d", h.MTU())
+	case ICMPv6TypeParameterProblem:
+		return fmt.Sprintf("\nPointer: %d", h.Pointer())
+	default:
+		return ""
+	}
+}
+
+// originalInfo describes the packet embedded in an error message, with the ports of its UDP or TCP header
+func (p ICMPv6Packet) originalInfo() string {
+	if p.Original == nil {
+		return ""
+	}
+
+	var sb strings.Builder
+	sb.WriteString("\n\nOriginal packet\n")
+	src, dst := p.Original.SourceIP.String(), p.Original.DestinationIP.String()
+	proto := p.Original.TransportLayerProtocol()
+	if (proto == "udp" || proto == "tcp") && len(p.OriginalData) >= 4 {
+		src = fmt.Sprintf("%s:%d", src, binary.BigEndian.Uint16(p.OriginalData[0:2]))
+		dst = fmt.Sprintf("%s:%d", dst, binary.BigEndian.Uint16(p.OriginalData[2:4]))
+	}
+	fmt.Fprintf(&sb, "\nSource: %s\nDestination: %s\nNext Header: %d (%s)\nHop Limit: %d",
+		src, dst, p.Original.UpperLayerProtocol(), proto, p.Original.HopLimit)
+	return sb.String()
+}
+
+// Info return an human-readable string containing the main ICMPv6 message data
+func (p ICMPv6Packet) Info() string {
+	code := fmt.Sprintf("%d", p.Header.Code)
+	if name := p.Header.CodeName(); name != "" {
+		code += fmt.Sprintf(" (%s)", name)
+	}
+
+	var message string
+	if p.NDP != nil {
+		message = p.NDP.info()
+	} else if p.MLD != nil {
+		message = p.MLD.info()
+	}
+	return fmt.Sprintf(`
+ICMPv6 packet
+
+Type: %d (%s)
+Code: %s
+Checksum: %d%s%s%s
+
+===============================
+%s`,
+		p.Header.Type, p.Header.TypeName(), code, p.Header.Checksum, p.Header.typeInfo(), message, p.originalInfo(),
+		p.IPPacket.Info(),
+	)
+}
+
+// Frame returns the Ethernet frame carrying the packet, or nil if the IP packet does not keep it
+func (p *ICMPv6Packet) Frame() *EthernetFrame {
+	if f, ok := p.IPPacket.(Framed); ok {
+		return f.Frame()
+	}
+	return nil
+}
+
+func (p ICMPv6Packet) Source() string {
+	return p.IPPacket.Header().Source()
+}
+
+func (p ICMPv6Packet) Destination() string {
+	return p.IPPacket.Header().Destination()
+}
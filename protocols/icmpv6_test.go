@@ -0,0 +1,317 @@
+package protocols
+
+import (
+	"bytes"
+	"errors"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+// icmpv6Header returns the IPv6 header of an ICMPv6 message from fe80::1 to the destination
+func icmpv6Header(dst string) IPv6Header {
+	return IPv6Header{
+		Version:       6,
+		NextHeader:    58,
+		HopLimit:      255,
+		SourceIP:      netip.MustParseAddr("fe80::1"),
+		DestinationIP: netip.MustParseAddr(dst),
+	}
+}
+
+// checkICMPv6 verifies the layers and the checksum of the decoded message, and that it serializes to the same bytes
+func checkICMPv6(t *testing.T, p *ICMPv6Packet, raw []byte, layers string) {
+	t.Helper()
+	if got := LayerNames(p); got != layers {
+		t.Errorf("expected layers %s - got %s", layers, got)
+	}
+	if got := statuses(VerifyChecksums(p)); got[LayerTypeICMPv6] != ChecksumGood {
+		t.Errorf("expected a good ICMPv6 checksum - got %s", got[LayerTypeICMPv6])
+	}
+	if serialized, err := SerializePacket(p, SerializeOptions{}); err != nil || !bytes.Equal(serialized, raw) {
+		t.Errorf("expected the message to serialize to\n% x\ngot (error %v)\n% x", raw, err, serialized)
+	}
+}
+
+func TestICMPv6PacketFromIPPacket(t *testing.T) {
+	t.Run("echo request", func(t *testing.T) {
+		h := &ICMPv6Header{Type: ICMPv6TypeEchoRequest, RestOfHeader: [4]byte{0x12, 0x34, 0x00, 0x07}}
+		raw := ipFrame(t, icmpv6Header("fe80::2"), h, Payload("bisturi ping"))
+		p := decode(t, raw, ICMPv6PacketFromIPPacket)
+
+		if p.Header.Identifier() != 0x1234 || p.Header.SequenceNumber() != 7 || p.NDP != nil || p.MLD != nil {
+			t.Errorf("expected echo request id 0x1234 seq 7 - got %+v", p)
+		}
+		checkICMPv6(t, p, raw, "Ethernet/IPv6/ICMPv6/Payload")
+		if info := p.Info(); !strings.Contains(info, "Type: 128 (Echo Request)") || !strings.Contains(info, "Identifier: 4660\nSequence Number: 7") {
+			t.Errorf("expected the details of the echo request - got %s", info)
+		}
+	})
+
+	t.Run("neighbor solicitation", func(t *testing.T) {
+		ns := &NDPMessage{
+			Type:          ICMPv6TypeNeighborSolicitation,
+			TargetAddress: netip.MustParseAddr("fe80::2"),
+			Options:       []NDPOption{{Type: NDPOptionSourceLinkLayerAddress, Data: testSrcMAC}},
+		}
+		raw := ipFrame(t, icmpv6Header("ff02::1:ff00:2"), &ICMPv6Header{Type: ICMPv6TypeNeighborSolicitation}, ns)
+		p := decode(t, raw, ICMPv6PacketFromIPPacket)
+
+		if p.NDP == nil || p.NDP.TargetAddress != ns.TargetAddress {
+			t.Fatalf("expected the solicitation for %s - got %+v", ns.TargetAddress, p.NDP)
+		}
+		o, ok := p.NDP.Option(NDPOptionSourceLinkLayerAddress)
+		if mac, _ := o.LinkLayerAddress(); !ok || mac.String() != testSrcMAC.String() {
+			t.Errorf("expected source link-layer address %s - got %v", testSrcMAC, o)
+		}
+		checkICMPv6(t, p, raw, "Ethernet/IPv6/ICMPv6/NDP")
+		info := p.Info()
+		for _, expected := range []string{"Type: 135 (Neighbor Solicitation)", "Target Address: fe80::2", "Source Link-Layer Address: " + testSrcMAC.String()} {
+			if !strings.Contains(info, expected) {
+				t.Errorf("expected the details to contain %q - got %s", expected, info)
+			}
+		}
+	})
+
+	t.Run("neighbor advertisement", func(t *testing.T) {
+		na := &NDPMessage{
+			Type:          ICMPv6TypeNeighborAdvertisement,
+			Flags:         NDPFlagSolicited | NDPFlagOverride,
+			TargetAddress: netip.MustParseAddr("fe80::2"),
+			Options:       []NDPOption{{Type: NDPOptionTargetLinkLayerAddress, Data: testDstMAC}},
+		}
+		raw := ipFrame(t, icmpv6Header("fe80::2"), &ICMPv6Header{Type: ICMPv6TypeNeighborAdvertisement}, na)
+		p := decode(t, raw, ICMPv6PacketFromIPPacket)
+
+		if p.NDP == nil || p.NDP.Flags != NDPFlagSolicited|NDPFlagOverride {
+			t.Fatalf("expected the solicited and override flags - got %+v", p.NDP)
+		}
+		checkICMPv6(t, p, raw, "Ethernet/IPv6/ICMPv6/NDP")
+		if info := p.Info(); !strings.Contains(info, "Flags: solicited, override") {
+			t.Errorf("expected the advertisement flags - got %s", info)
+		}
+	})
+
+	t.Run("router advertisement", func(t *testing.T) {
+		prefix := append([]byte{64, 0xC0, 0x00, 0x27, 0x8D, 0x00, 0x00, 0x09, 0x3A, 0x80, 0, 0, 0, 0},
+			netip.MustParseAddr("2001:db8:1::").AsSlice()...)
+		ra := &NDPMessage{
+			Type:           ICMPv6TypeRouterAdvertisement,
+			CurHopLimit:    64,
+			Flags:          NDPFlagOther,
+			RouterLifetime: 1800,
+			ReachableTime:  30000,
+			RetransTimer:   1000,
+			Options: []NDPOption{
+				{Type: NDPOptionPrefixInformation, Data: prefix},
+				{Type: NDPOptionMTU, Data: []byte{0, 0, 0, 0, 0x05, 0xDC}},
+			},
+		}
+		raw := ipFrame(t, icmpv6Header("ff02::1"), &ICMPv6Header{Type: ICMPv6TypeRouterAdvertisement}, ra)
+		p := decode(t, raw, ICMPv6PacketFromIPPacket)
+
+		if p.NDP == nil || p.NDP.CurHopLimit != 64 || p.NDP.RouterLifetime != 1800 || p.NDP.ReachableTime != 30000 {
+			t.Fatalf("expected the router advertisement fields - got %+v", p.NDP)
+		}
+		o, _ := p.NDP.Option(NDPOptionPrefixInformation)
+		info, ok := o.PrefixInfo()
+		expected := NDPPrefixInfo{
+			Prefix:            netip.MustParsePrefix("2001:db8:1::/64"),
+			OnLink:            true,
+			Autonomous:        true,
+			ValidLifetime:     2592000,
+			PreferredLifetime: 604800,
+		}
+		if !ok || info != expected {
+			t.Errorf("expected prefix information %+v - got %+v", expected, info)
+		}
+		o, _ = p.NDP.Option(NDPOptionMTU)
+		if mtu, ok := o.MTU(); !ok || mtu != 1500 {
+			t.Errorf("expected MTU 1500 - got %d", mtu)
+		}
+		checkICMPv6(t, p, raw, "Ethernet/IPv6/ICMPv6/NDP")
+		details := p.Info()
+		for _, expected := range []string{"Flags: other", "Router Lifetime: 1800s", "Prefix: 2001:db8:1::/64 [on-link, autonomous] (valid 2592000s, preferred 604800s)", "MTU: 1500"} {
+			if !strings.Contains(details, expected) {
+				t.Errorf("expected the details to contain %q - got %s", expected, details)
+			}
+		}
+	})
+
+	t.Run("MLDv2 report", func(t *testing.T) {
+		report := &MLDMessage{
+			Type:    ICMPv6TypeMLDv2Report,
+			Version: 2,
+			Records: []MLDAddressRecord{
+				{Type: 4, MulticastAddress: netip.MustParseAddr("ff02::fb")},
+				{Type: 1, MulticastAddress: netip.MustParseAddr("ff3e::1"), Sources: []netip.Addr{netip.MustParseAddr("2001:db8::9")}},
+			},
+		}
+		raw := ipFrame(t, icmpv6Header("ff02::16"), &ICMPv6Header{Type: ICMPv6TypeMLDv2Report}, report)
+		p := decode(t, raw, ICMPv6PacketFromIPPacket)
+
+		if p.MLD == nil || len(p.MLD.Records) != 2 || p.MLD.Records[1].Sources[0] != netip.MustParseAddr("2001:db8::9") {
+			t.Fatalf("expected the address records - got %+v", p.MLD)
+		}
+		checkICMPv6(t, p, raw, "Ethernet/IPv6/ICMPv6/MLD")
+		if info := p.Info(); !strings.Contains(info, "Record: CHANGE_TO_EXCLUDE_MODE ff02::fb") || !strings.Contains(info, "sources 2001:db8::9") {
+			t.Errorf("expected the records in the details - got %s", info)
+		}
+	})
+
+	t.Run("MLDv1 query", func(t *testing.T) {
+		query := &MLDMessage{Type: ICMPv6TypeMLDQuery, Version: 1, MaxResponseDelay: 10000, MulticastAddress: netip.IPv6Unspecified()}
+		raw := ipFrame(t, icmpv6Header("ff02::1"), &ICMPv6Header{Type: ICMPv6TypeMLDQuery}, query)
+		p := decode(t, raw, ICMPv6PacketFromIPPacket)
+
+		if p.MLD == nil || p.MLD.Version != 1 || p.MLD.MaxResponseDelay != 10000 {
+			t.Fatalf("expected a general MLDv1 query - got %+v", p.MLD)
+		}
+		checkICMPv6(t, p, raw, "Ethernet/IPv6/ICMPv6/MLD")
+	})
+
+	t.Run("packet too big", func(t *testing.T) {
+		original := mustSerialize(t,
+			IPv6Header{
+				Version:       6,
+				NextHeader:    17,
+				HopLimit:      64,
+				SourceIP:      netip.MustParseAddr("2001:db8::2"),
+				DestinationIP: netip.MustParseAddr("2001:db8::1"),
+			},
+			&UDPHeader{SourcePort: 40000, DestinationPort: 443},
+			Payload("quic"),
+		)
+		h := &ICMPv6Header{Type: ICMPv6TypePacketTooBig, RestOfHeader: [4]byte{0, 0, 0x05, 0x00}}
+		raw := ipFrame(t, icmpv6Header("2001:db8::2"), h, Payload(original))
+		p := decode(t, raw, ICMPv6PacketFromIPPacket)
+
+		if p.Original == nil || len(p.OriginalData) != 12 {
+			t.Fatalf("expected the original packet to be decoded - got %+v", p.Original)
+		}
+		checkICMPv6(t, p, raw, "Ethernet/IPv6/ICMPv6/Payload")
+		info := p.Info()
+		for _, expected := range []string{"MTU: 1280", "Source: 2001:db8::2:40000", "Destination: 2001:db8::1:443", "Next Header: 17 (udp)"} {
+			if !strings.Contains(info, expected) {
+				t.Errorf("expected the details to contain %q - got %s", expected, info)
+			}
+		}
+	})
+
+	t.Run("corrupted checksum", func(t *testing.T) {
+		raw := ipFrame(t, icmpv6Header("fe80::2"), &ICMPv6Header{Type: ICMPv6TypeRouterSolicitation}, &NDPMessage{Type: ICMPv6TypeRouterSolicitation})
+		raw[len(raw)-1] ^= 0x01
+		if got := statuses(VerifyChecksums(decode(t, raw, ICMPv6PacketFromIPPacket))); got[LayerTypeICMPv6] != ChecksumBad {
+			t.Errorf("expected a bad ICMPv6 checksum - got %s", got[LayerTypeICMPv6])
+		}
+	})
+
+	t.Run("checksum covering bytes not decoded", func(t *testing.T) {
+		// the trailing bytes of the report are not part of the MLD message, but are covered by the checksum
+		report := &MLDMessage{Type: ICMPv6TypeMLDReport, MulticastAddress: netip.MustParseAddr("ff02::fb")}
+		raw := ipFrame(t, icmpv6Header("ff02::fb"), &ICMPv6Header{Type: ICMPv6TypeMLDReport}, report, Payload{0xde, 0xad, 0xbe, 0xef})
+		if got := statuses(VerifyChecksums(decode(t, raw, ICMPv6PacketFromIPPacket))); got[LayerTypeICMPv6] != ChecksumGood {
+			t.Errorf("expected a good ICMPv6 checksum - got %s", got[LayerTypeICMPv6])
+		}
+	})
+
+	t.Run("Ethernet padding", func(t *testing.T) {
+		solicitation := &NDPMessage{Type: ICMPv6TypeNeighborSolicitation, TargetAddress: netip.MustParseAddr("fe80::2")}
+		raw := ipFrame(t, icmpv6Header("ff02::1:ff00:2"), &ICMPv6Header{Type: ICMPv6TypeNeighborSolicitation}, solicitation)
+		p := decode(t, append(raw, make([]byte, 8)...), ICMPv6PacketFromIPPacket)
+
+		if p.NDP == nil || len(p.NDP.Options) != 0 {
+			t.Errorf("expected a solicitation without options - got %+v", p.NDP)
+		}
+		if got := statuses(VerifyChecksums(p)); got[LayerTypeICMPv6] != ChecksumGood {
+			t.Errorf("expected a good ICMPv6 checksum - got %s", got[LayerTypeICMPv6])
+		}
+	})
+
+	t.Run("invalid messages", func(t *testing.T) {
+		tests := []struct {
+			name     string
+			payload  []byte
+			expected error
+		}{
+			{name: "too short", payload: []byte{0x80, 0x00, 0xf7}, expected: ErrInvalidICMPv6Header},
+			{name: "truncated solicitation", payload: make([]byte, 12), expected: ErrInvalidNDPMessage},
+			{name: "zero option length", payload: []byte{ICMPv6TypeRouterSolicitation, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0}, expected: ErrInvalidNDPMessage},
+			{name: "truncated report", payload: []byte{ICMPv6TypeMLDv2Report, 0, 0, 0, 0, 0, 0, 1}, expected: ErrInvalidMLDMessage},
+		}
+		tests[1].payload[0] = ICMPv6TypeNeighborSolicitation
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				if _, err := ICMPv6PacketFromIPPacket(&IPv6Packet{payload: tt.payload}); !errors.Is(err, tt.expected) {
+					t.Errorf("expected error %v - got %v", tt.expected, err)
+				}
+			})
+		}
+	})
+}
+
+func TestICMPv6HeaderFields(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   ICMPv6Header
+		isError  bool
+		typeName string
+		codeName string
+		info     string
+	}{
+		{
+			name:     "echo reply",
+			header:   ICMPv6Header{Type: ICMPv6TypeEchoReply, RestOfHeader: [4]byte{0x00, 0x01, 0x00, 0x02}},
+			typeName: "Echo Reply",
+			info:     "\nIdentifier: 1\nSequence Number: 2",
+		},
+		{
+			name:     "address unreachable",
+			header:   ICMPv6Header{Type: ICMPv6TypeDestinationUnreachable, Code: 3},
+			isError:  true,
+			typeName: "Destination Unreachable",
+			codeName: "Address Unreachable",
+		},
+		{
+			name:     "hop limit exceeded",
+			header:   ICMPv6Header{Type: ICMPv6TypeTimeExceeded},
+			isError:  true,
+			typeName: "Time Exceeded",
+			codeName: "Hop Limit Exceeded in Transit",
+		},
+		{
+			name:     "parameter problem",
+			header:   ICMPv6Header{Type: ICMPv6TypeParameterProblem, Code: 1, RestOfHeader: [4]byte{0, 0, 0, 40}},
+			isError:  true,
+			typeName: "Parameter Problem",
+			codeName: "Unrecognized Next Header Type",
+			info:     "\nPointer: 40",
+		},
+		{
+			name:     "redirect",
+			header:   ICMPv6Header{Type: ICMPv6TypeRedirect},
+			typeName: "Redirect",
+		},
+		{
+			name:     "unknown type",
+			header:   ICMPv6Header{Type: 200},
+			typeName: "Unknown",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.header.IsError() != tt.isError {
+				t.Errorf("expected IsError to be %t", tt.isError)
+			}
+			if got := tt.header.TypeName(); got != tt.typeName {
+				t.Errorf("expected type %q - got %q", tt.typeName, got)
+			}
+			if got := tt.header.CodeName(); got != tt.codeName {
+				t.Errorf("expected code %q - got %q", tt.codeName, got)
+			}
+			if got := tt.header.typeInfo(); got != tt.info {
+				t.Errorf("expected info %q - got %q", tt.info, got)
+			}
+		})
+	}
+}
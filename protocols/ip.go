@@ -4,7 +4,8 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
-	"net"
+	"net/netip"
+	"strings"
 )
 
 // maps the IP header values to the corresponding transport layer protocol
@@ -14,6 +15,8 @@ var protocolValues = map[uint8]string{
 	6:  "tcp",
 	17: "udp",
 	41: "ipv6",
+	50: "esp",
+	51: "ah",
 	58: "icmpv6",
 	89: "ospf",
 }
@@ -34,46 +37,59 @@ type IPHeader interface {
 	TransportLayerProtocol() string
 }
 
-// ipv4Packet contains the IP packet data (headers and payload)
-type ipv4Packet struct {
-	ethFrame EthernetFrame
-	header   ipv4Header
-	payload  []byte
-}
-
-// ipv6Packet contains the IP packet data (headers and payload)
-type ipv6Packet struct {
-	ethFrame EthernetFrame
-	header   ipv6Header
-	payload  []byte
-}
-
-type ipv4Header struct {
-	version        uint8
-	ihl            uint8
-	dscp           uint8
-	ecn            uint8
-	totalLength    uint16
-	identification uint16
-	flags          uint16
-	fragmentOffset uint16
-	ttl            uint8
-	protocol       uint8
-	headerChecksum uint16
-	sourceIP       net.IP
-	destinationIP  net.IP
-	options        []byte
-}
-
-type ipv6Header struct {
-	version       uint8
-	trafficClass  uint8
-	flowLabel     uint32
-	payloadLength uint16
-	nextHeader    uint8
-	hopLimit      uint8
-	sourceIP      net.IP
-	destinationIP net.IP
+// IPv4Packet contains the IP packet data (headers and payload)
+type IPv4Packet struct {
+	EthFrame   EthernetFrame
+	IPv4Header IPv4Header
+	// Fragments is the number of fragments the packet has been reassembled from, 0 if it was not fragmented
+	Fragments int
+	payload   []byte
+}
+
+// IPv6Packet contains the IP packet data (headers and payload)
+type IPv6Packet struct {
+	EthFrame   EthernetFrame
+	IPv6Header IPv6Header
+	// Fragments is the number of fragments the packet has been reassembled from, 0 if it was not fragmented
+	Fragments int
+	payload   []byte
+}
+
+// bits of the IPv4Header's Flags
+const (
+	IPv4FlagMF = 0x1 // more fragments follow
+	IPv4FlagDF = 0x2 // don't fragment
+)
+
+type IPv4Header struct {
+	Version        uint8
+	IHL            uint8 // header length, in 4-byte words
+	DSCP           uint8
+	ECN            uint8
+	TotalLength    uint16
+	Identification uint16
+	Flags          uint8  // the 3 flag bits: reserved, DF and MF
+	FragmentOffset uint16 // in 8-byte units
+	TTL            uint8
+	Protocol       uint8
+	HeaderChecksum uint16
+	SourceIP       netip.Addr
+	DestinationIP  netip.Addr
+	Options        []byte
+}
+
+type IPv6Header struct {
+	Version       uint8
+	TrafficClass  uint8
+	FlowLabel     uint32
+	PayloadLength uint16
+	NextHeader    uint8
+	HopLimit      uint8
+	SourceIP      netip.Addr
+	DestinationIP netip.Addr
+	// Extensions is the chain of extension headers between the fixed header and the upper-layer one,
+	// in the order of the packet
+	Extensions []IPv6ExtensionHeader
 }
 
 var errInvalidIPPacket = errors.New("invalid IP packet")
@@ -84,18 +100,22 @@ var errInvalidIPv6Header = errors.New("IPv6 header must be 40 bytes")
 
 // IPPacketFromBytes parses an IPv4 or IPv6 packet's data from the passed raw data and return the interface representing it.
 // An error is returned if the headers' constraints are not respected.
+// The IP header is read from the payload of the Ethernet frame, following its VLAN tags if any.
 func IPPacketFromBytes(raw []byte) (IPPacket, error) {
-	if len(raw) < 15 {
+	frame, err := EthFrameFromBytes(raw)
+	if err != nil {
+		return nil, err
+	}
+	if len(frame.Payload) < 1 {
 		return nil, errInvalidIPPacket
 	}
-	version := raw[14] >> 4
+	version := frame.Payload[0] >> 4
 
 	var packet IPPacket
-	var err error
 	if version == 4 {
-		packet, err = ipv4PacketFromBytes(raw)
+		packet, err = ipv4PacketFromFrame(frame)
 	} else if version == 6 {
-		packet, err = ipv6PacketFromBytes(raw)
+		packet, err = ipv6PacketFromFrame(frame)
 	} else {
 		return nil, errInvalidIPVersion
 	}
@@ -104,57 +124,94 @@ func IPPacketFromBytes(raw []byte) (IPPacket, error) {
 }
 
 // ipv4PacketsFromFromBytes parses an array of bytes to extract headers and payload, returning a struct pointer.
-func ipv4PacketFromBytes(raw []byte) (*ipv4Packet, error) {
+func ipv4PacketFromBytes(raw []byte) (*IPv4Packet, error) {
 	frame, err := EthFrameFromBytes(raw)
 	if err != nil {
 		return nil, err
 	}
+	return ipv4PacketFromFrame(frame)
+}
 
-	ipData := raw[14:]
-	h, err := ipv4HeaderFromBytes(ipData)
+// ipv4PacketFromFrame parses the payload of the Ethernet frame to extract headers and payload, returning a struct pointer.
+func ipv4PacketFromFrame(frame *EthernetFrame) (*IPv4Packet, error) {
+	ipData := frame.Payload
+	h, err := IPv4HeaderFromBytes(ipData)
 	if err != nil {
 		return nil, err
 	}
 
-	return &ipv4Packet{
-		ethFrame: *frame,
-		header:   *h,
-		payload:  ipData[h.Len():],
+	payload := ipData[h.Len():]
+	// the padding of short Ethernet frames follows the length declared by the header
+	if n := int(h.TotalLength) - h.Len(); n >= 0 && n < len(payload) {
+		payload = payload[:n]
+	}
+
+	return &IPv4Packet{
+		EthFrame:   *frame,
+		IPv4Header: *h,
+		payload:    payload,
 	}, nil
 }
 
 // TransportLayerProtocol returns the OSI Layer 4 procotol defined in the packet's header
-func (h ipv4Header) TransportLayerProtocol() string {
-	return protocolValues[h.protocol]
+func (h IPv4Header) TransportLayerProtocol() string {
+	return protocolValues[h.Protocol]
 }
 
 // HeaderLen returns the IPv4 header length in bytes
-func (h ipv4Header) Len() int {
-	return int(h.ihl) * 4
+func (h IPv4Header) Len() int {
+	return int(h.IHL) * 4
+}
+
+func (p IPv4Header) Source() string {
+	return p.SourceIP.String()
 }
 
-func (p ipv4Header) Source() string {
-	return p.sourceIP.String()
+func (p IPv4Header) Destination() string {
+	return p.DestinationIP.String()
 }
 
-func (p ipv4Header) Destination() string {
-	return p.destinationIP.String()
+// DontFragment reports whether the DF flag is set: the packet must be dropped rather than fragmented
+func (h IPv4Header) DontFragment() bool {
+	return h.Flags&IPv4FlagDF != 0
 }
 
-func (p ipv4Packet) Header() IPHeader {
-	return p.header
+// MoreFragments reports whether the MF flag is set: the packet is a fragment, followed by others
+func (h IPv4Header) MoreFragments() bool {
+	return h.Flags&IPv4FlagMF != 0
+}
+
+// flagNames returns the names of the flags set, like "DF" or "MF"
+func (h IPv4Header) flagNames() string {
+	var names []string
+	if h.DontFragment() {
+		names = append(names, "DF")
+	}
+	if h.MoreFragments() {
+		names = append(names, "MF")
+	}
+	return strings.Join(names, ", ")
 }
 
-func (p ipv4Packet) Version() uint8 {
-	return p.header.version
+func (p *IPv4Packet) Frame() *EthernetFrame {
+	return &p.EthFrame
 }
 
-func (p ipv4Packet) Payload() []byte {
+func (p IPv4Packet) Header() IPHeader {
+	return p.IPv4Header
+}
+
+func (p IPv4Packet) Version() uint8 {
+	return p.IPv4Header.Version
+}
+
+func (p IPv4Packet) Payload() []byte {
 	return p.payload
 }
 
 // Info returns an human-readable string containing the main IPv4 packet data
-func (p ipv4Packet) Info() string {
+func (p IPv4Packet) Info() string {
+	h := p.IPv4Header
 	return fmt.Sprintf(`
 IPv4 packet
 
@@ -164,27 +221,27 @@ DSCP: %d
 ECN: %d
 Total Length: %d
 Identification: %d
-Flags: %d
+Flags: 0x%X (%s)
 Fragment Offset: %d
 TTL: %d
 Transport Layer Protocol: %d (%s)
 Header Checksum: %d
 Source IP: %s
 Destination IP: %s
-Options: %v
+Options: %v%s
 
 ===============================
 %s`,
-		p.header.version, p.header.ihl*4, p.header.dscp, p.header.ecn, p.header.totalLength, p.header.identification,
-		p.header.flags, p.header.fragmentOffset, p.header.ttl, p.header.protocol, p.header.TransportLayerProtocol(), p.header.headerChecksum,
-		p.header.sourceIP, p.header.destinationIP, p.header.options,
-		p.ethFrame.Info(),
+		h.Version, h.Len(), h.DSCP, h.ECN, h.TotalLength, h.Identification,
+		h.Flags, h.flagNames(), h.FragmentOffset, h.TTL, h.Protocol, h.TransportLayerProtocol(), h.HeaderChecksum,
+		h.SourceIP, h.DestinationIP, h.Options, reassembledInfo(p.Fragments),
+		p.EthFrame.Info(),
 	)
 }
 
-// ipv4HeaderFromBytes parses the passed bytes to a struct containing the IP header data and returns a pointer to it.
+// IPv4HeaderFromBytes parses the passed bytes to a struct containing the IP header data and returns a pointer to it.
 // It expects an array of at least 20 bytes or the defined IHL
-func ipv4HeaderFromBytes(raw []byte) (*ipv4Header, error) {
+func IPv4HeaderFromBytes(raw []byte) (*IPv4Header, error) {
 	if len(raw) < 20 {
 		return nil, errIPv4HeaderTooShort
 	}
@@ -195,80 +252,140 @@ func ipv4HeaderFromBytes(raw []byte) (*ipv4Header, error) {
 		return nil, errIPv4HeaderLenLessThanIHL
 	}
 
-	h := &ipv4Header{
-		version:        raw[0] >> 4,
-		ihl:            ihl,
-		dscp:           raw[1] >> 2,
-		ecn:            raw[1] & 0x03,
-		totalLength:    binary.BigEndian.Uint16(raw[2:4]),
-		identification: binary.BigEndian.Uint16(raw[4:6]),
-		flags:          binary.BigEndian.Uint16(raw[6:8]) >> 13,
-		fragmentOffset: binary.BigEndian.Uint16(raw[6:8]) & 0x1FFF,
-		ttl:            raw[8],
-		protocol:       raw[9],
-		headerChecksum: binary.BigEndian.Uint16(raw[10:12]),
-		sourceIP:       net.IP(raw[12:16]),
-		destinationIP:  net.IP(raw[16:20]),
+	h := &IPv4Header{
+		Version:        raw[0] >> 4,
+		IHL:            ihl,
+		DSCP:           raw[1] >> 2,
+		ECN:            raw[1] & 0x03,
+		TotalLength:    binary.BigEndian.Uint16(raw[2:4]),
+		Identification: binary.BigEndian.Uint16(raw[4:6]),
+		Flags:          raw[6] >> 5,
+		FragmentOffset: binary.BigEndian.Uint16(raw[6:8]) & 0x1FFF,
+		TTL:            raw[8],
+		Protocol:       raw[9],
+		HeaderChecksum: binary.BigEndian.Uint16(raw[10:12]),
+		SourceIP:       netip.AddrFrom4([4]byte(raw[12:16])),
+		DestinationIP:  netip.AddrFrom4([4]byte(raw[16:20])),
 	}
 
 	if hLen > 20 {
-		h.options = raw[20:hLen]
+		h.Options = raw[20:hLen]
 	}
 	return h, nil
 }
 
 // ipv6PacketsFromFromBytes parses a slice of bytes to extract headers and payload, returning a struct pointer.
-func ipv6PacketFromBytes(raw []byte) (*ipv6Packet, error) {
+func ipv6PacketFromBytes(raw []byte) (*IPv6Packet, error) {
 	frame, err := EthFrameFromBytes(raw)
 	if err != nil {
 		return nil, err
 	}
+	return ipv6PacketFromFrame(frame)
+}
 
-	ipData := raw[14:]
-	h, err := ipv6HeaderFromBytes(ipData)
+// ipv6PacketFromFrame parses the payload of the Ethernet frame to extract headers and payload, returning a struct pointer.
+func ipv6PacketFromFrame(frame *EthernetFrame) (*IPv6Packet, error) {
+	ipData := frame.Payload
+	h, err := IPv6HeaderFromBytes(ipData)
 	if err != nil {
 		return nil, err
 	}
 
-	return &ipv6Packet{
-		ethFrame: *frame,
-		header:   *h,
-		payload:  ipData[h.Len():],
+	payload := ipData[h.Len():]
+	// the padding of short Ethernet frames follows the length declared by the header,
+	// while a 0 payload length is left to jumbograms and offloaded segments
+	if n := int(h.PayloadLength) - (h.Len() - 40); h.PayloadLength > 0 && n >= 0 && n < len(payload) {
+		payload = payload[:n]
+	}
+
+	return &IPv6Packet{
+		EthFrame:   *frame,
+		IPv6Header: *h,
+		payload:    payload,
 	}, nil
 }
 
-// TransportLayerProtocol returns the OSI Layer 4 procotol defined in the packet's header
-func (h ipv6Header) TransportLayerProtocol() string {
-	return protocolValues[h.nextHeader]
+// TransportLayerProtocol returns the OSI Layer 4 procotol following the packet's extension headers
+func (h IPv6Header) TransportLayerProtocol() string {
+	return protocolValues[h.UpperLayerProtocol()]
+}
+
+// UpperLayerProtocol returns the Next Header value of the last header of the chain, identifying the upper-layer protocol
+func (h IPv6Header) UpperLayerProtocol() uint8 {
+	if n := len(h.Extensions); n > 0 {
+		return h.Extensions[n-1].Next()
+	}
+	return h.NextHeader
+}
+
+// HeaderLen returns the length in bytes of the IPv6 header (40) and its extension headers
+func (p IPv6Header) Len() int {
+	length := 40
+	for _, ext := range p.Extensions {
+		length += ext.Len()
+	}
+	return length
 }
 
-// HeaderLen returns the IPv6 header length (40) in bytes
-func (p ipv6Header) Len() int {
-	return 40
+// FragmentHeader returns the fragment extension header of the packet, or nil if it is not a fragment
+func (h IPv6Header) FragmentHeader() *IPv6FragmentHeader {
+	for _, ext := range h.Extensions {
+		if f, ok := ext.(*IPv6FragmentHeader); ok {
+			return f
+		}
+	}
+	return nil
 }
 
-func (p ipv6Packet) Version() uint8 {
-	return p.header.version
+// FinalDestination returns the address the packet is finally delivered to, covered by the upper-layer checksums:
+// the last address of a routing header with segments left, otherwise the destination address
+func (h IPv6Header) FinalDestination() netip.Addr {
+	for _, ext := range h.Extensions {
+		if r, ok := ext.(*IPv6RoutingHeader); ok {
+			if dst, ok := r.finalDestination(); ok {
+				return dst
+			}
+		}
+	}
+	return h.DestinationIP
 }
 
-func (p ipv6Header) Source() string {
-	return p.sourceIP.String()
+// extensionsInfo returns a line describing every extension header
+func (h IPv6Header) extensionsInfo() string {
+	var sb strings.Builder
+	for _, ext := range h.Extensions {
+		sb.WriteString("\n" + ext.info())
+	}
+	return sb.String()
 }
 
-func (p ipv6Header) Destination() string {
-	return p.destinationIP.String()
+func (p IPv6Packet) Version() uint8 {
+	return p.IPv6Header.Version
 }
 
-func (p ipv6Packet) Header() IPHeader {
-	return p.header
+func (p IPv6Header) Source() string {
+	return p.SourceIP.String()
 }
 
-func (p ipv6Packet) Payload() []byte {
+func (p IPv6Header) Destination() string {
+	return p.DestinationIP.String()
+}
+
+func (p *IPv6Packet) Frame() *EthernetFrame {
+	return &p.EthFrame
+}
+
+func (p IPv6Packet) Header() IPHeader {
+	return p.IPv6Header
+}
+
+func (p IPv6Packet) Payload() []byte {
 	return p.payload
 }
 
 // Info returns an human-readable string containing the main IPv6 packet data
-func (p ipv6Packet) Info() string {
+func (p IPv6Packet) Info() string {
+	h := p.IPv6Header
 	return fmt.Sprintf(`
 IPv6 packet
 
@@ -276,35 +393,51 @@ Version: %d
 Traffic Class: %d
 Flow Label: %d
 Payload Length: %d
+Next Header: %d
 Transport Layer Protocol: %d (%s)
 Hop Limit: %d
 Source IP: %s
-Destination IP: %s
+Destination IP: %s%s%s
 ===============================
 %s
 ===============================
 `,
-		p.header.version, p.header.trafficClass, p.header.flowLabel, p.header.payloadLength,
-		p.header.nextHeader, p.header.TransportLayerProtocol(), p.header.hopLimit, p.header.sourceIP, p.header.destinationIP,
-		p.ethFrame.Info(),
+		h.Version, h.TrafficClass, h.FlowLabel, h.PayloadLength, h.NextHeader,
+		h.UpperLayerProtocol(), h.TransportLayerProtocol(), h.HopLimit, h.SourceIP, h.DestinationIP, h.extensionsInfo(),
+		reassembledInfo(p.Fragments),
+		p.EthFrame.Info(),
 	)
 }
 
-// ipv6HeaderFromBytes parses the passed bytes to a struct containing the IP header data and returns a pointer to it.
-// It expects an array of at least 40 bytes
-func ipv6HeaderFromBytes(raw []byte) (*ipv6Header, error) {
+// reassembledInfo returns a line with the number of fragments a packet has been reassembled from, if any
+func reassembledInfo(fragments int) string {
+	if fragments == 0 {
+		return ""
+	}
+	return fmt.Sprintf("\nReassembled from %d fragments", fragments)
+}
+
+// IPv6HeaderFromBytes parses the passed bytes to a struct containing the IP header data and returns a pointer to it.
+// It expects an array of at least 40 bytes, followed by the extension headers, if any
+func IPv6HeaderFromBytes(raw []byte) (*IPv6Header, error) {
 	if len(raw) < 40 {
 		return nil, errInvalidIPv6Header
 	}
 
-	return &ipv6Header{
-		version:       raw[0] >> 4,
-		trafficClass:  (raw[0]&0x0F)<<4 | raw[1]>>4,
-		flowLabel:     uint32(raw[1]&0x0F)<<16 | uint32(raw[2])<<8 | uint32(raw[3]),
-		payloadLength: binary.BigEndian.Uint16(raw[4:6]),
-		nextHeader:    raw[6],
-		hopLimit:      raw[7],
-		sourceIP:      net.IP(raw[8:24]),
-		destinationIP: net.IP(raw[24:40]),
-	}, nil
+	h := &IPv6Header{
+		Version:       raw[0] >> 4,
+		TrafficClass:  (raw[0]&0x0F)<<4 | raw[1]>>4,
+		FlowLabel:     uint32(raw[1]&0x0F)<<16 | uint32(raw[2])<<8 | uint32(raw[3]),
+		PayloadLength: binary.BigEndian.Uint16(raw[4:6]),
+		NextHeader:    raw[6],
+		HopLimit:      raw[7],
+		SourceIP:      netip.AddrFrom16([16]byte(raw[8:24])),
+		DestinationIP: netip.AddrFrom16([16]byte(raw[24:40])),
+	}
+	extensions, err := ipv6ExtensionsFromBytes(h.NextHeader, raw[40:])
+	if err != nil {
+		return nil, err
+	}
+	h.Extensions = extensions
+	return h, nil
 }
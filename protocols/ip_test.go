@@ -1,12 +1,52 @@
 package protocols
 
 import (
-	"net"
+	"net/netip"
 	"reflect"
 	"testing"
 )
 
+var (
+	// testIPv4Header is the header of the IPv4 packets built by the tests, before its lengths and checksum are computed
+	testIPv4Header = IPv4Header{
+		Version:        4,
+		Identification: 0x1c46,
+		Flags:          IPv4FlagDF,
+		TTL:            64,
+		Protocol:       6,
+		SourceIP:       netip.MustParseAddr("192.168.0.104"),
+		DestinationIP:  netip.MustParseAddr("192.168.0.1"),
+	}
+	// testIPv6Header is the header of the IPv6 packets built by the tests, before its payload length is computed
+	testIPv6Header = IPv6Header{
+		Version:       6,
+		NextHeader:    17,
+		HopLimit:      64,
+		SourceIP:      netip.MustParseAddr("fe80::21c:7eff:fee4:2c00"),
+		DestinationIP: netip.MustParseAddr("fe80::21c:7eff:fee4:2c01"),
+	}
+)
+
+// testIPv4Frame returns an Ethernet frame carrying the test IPv4 header, followed by the options
+func testIPv4Frame(t *testing.T, options ...byte) []byte {
+	t.Helper()
+	h := testIPv4Header
+	h.Options = options
+	return ipFrame(t, h)
+}
+
+// testIPv6Frame returns an Ethernet frame carrying the test IPv6 header and a UDP datagram 20 bytes long
+func testIPv6Frame(t *testing.T) []byte {
+	t.Helper()
+	return ipFrame(t, testIPv6Header, &UDPHeader{SourcePort: 40000, DestinationPort: 53}, Payload("bisturi test"))
+}
+
 func TestIPPacketFromBytes(t *testing.T) {
+	vlan := testIPv4Header
+	vlan.Protocol = 17
+	badVersion := testIPv4Frame(t)
+	badVersion[14] = 0x35
+
 	tests := []struct {
 		name                   string
 		raw                    []byte
@@ -16,59 +56,43 @@ func TestIPPacketFromBytes(t *testing.T) {
 		expectedErr            error
 	}{
 		{
-			name: "Valid IPv4 packet ",
-			raw: []byte{
-				// Ethernet Frame
-				0x00, 0x1A, 0xA0, 0xBB, 0xCC, 0xDD, 0x00, 0x1A, 0xB0, 0xCC, 0xDD, 0xEE, 0x08, 0x00,
-				// IPv4 Header
-				0x45, 0x00, 0x00, 0x3c, 0x1c, 0x46, 0x40, 0x00,
-				0x40, 0x06, 0xb1, 0xe6, 0xc0, 0xa8, 0x00, 0x68,
-				0xc0, 0xa8, 0x00, 0x01,
-			},
+			name:                   "Valid IPv4 packet ",
+			raw:                    testIPv4Frame(t),
 			headerLen:              20,
 			version:                4,
 			transportLayerProtocol: "tcp",
 			expectedErr:            nil,
 		},
 		{
-			name: "Valid IPv6 packet",
-			raw: []byte{
-				// Ethernet Frame
-				0x00, 0x1A, 0xA0, 0xBB, 0xCC, 0xDD, 0x00, 0x1A, 0xB0, 0xCC, 0xDD, 0xEE, 0x86, 0xDD,
-				// IPv6 Header
-				0x60, 0x00, 0x00, 0x00, 0x00, 0x14, 0x11, 0x40,
-				0xfe, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
-				0x02, 0x1c, 0x7e, 0xff, 0xfe, 0xe4, 0x2c, 0x00,
-				0xfe, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
-				0x02, 0x1c, 0x7e, 0xff, 0xfe, 0xe4, 0x2c, 0x01,
-			},
+			name:                   "Valid IPv6 packet",
+			raw:                    testIPv6Frame(t),
 			headerLen:              40,
 			version:                6,
 			transportLayerProtocol: "udp",
 			expectedErr:            nil,
 		},
 		{
-			name: "Invalid IP packet",
-			raw: []byte{
-				// Ethernet Frame
-				0x00, 0x1A, 0xA0, 0xBB, 0xCC, 0xDD, 0x00, 0x1A, 0xB0, 0xCC, 0xDD, 0xEE, 0x08, 0x00,
-				// missing Header
-			},
+			name: "Valid IPv4 packet in an 802.1Q frame",
+			raw: mustSerialize(t,
+				&EthernetFrame{DestinationMAC: testDstMAC, SourceMAC: testSrcMAC, EtherType: 0x0800, VLANs: []VLANTag{{TPID: 0x8100, VID: 42}}},
+				vlan,
+			),
+			headerLen:              20,
+			version:                4,
+			transportLayerProtocol: "udp",
+			expectedErr:            nil,
+		},
+		{
+			name:                   "Invalid IP packet",
+			raw:                    testIPv4Frame(t)[:14],
 			headerLen:              0,
 			version:                0,
 			transportLayerProtocol: "",
 			expectedErr:            errInvalidIPPacket,
 		},
 		{
-			name: "Invalid IP packet version",
-			raw: []byte{
-				// Ethernet Frame
-				0x00, 0x1A, 0xA0, 0xBB, 0xCC, 0xDD, 0x00, 0x1A, 0xB0, 0xCC, 0xDD, 0xEE, 0x08, 0x00,
-				// Ipv4 Header with invalid version
-				0x33, 0x00, 0x00, 0x3c, 0x1c, 0x46, 0x40, 0x00,
-				0x40, 0x06, 0xb1, 0xe6, 0xc0, 0xa8, 0x00, 0x68,
-				0xc0, 0xa8, 0x00, 0x01,
-			},
+			name:                   "Invalid IP packet version",
+			raw:                    badVersion,
 			headerLen:              0,
 			version:                0,
 			transportLayerProtocol: "",
@@ -98,124 +122,90 @@ func TestIPPacketFromBytes(t *testing.T) {
 }
 
 func TestIPv4PacketFromBytes(t *testing.T) {
+	raw := testIPv4Frame(t)
+	// IHL = 6, header length = 24 bytes
+	withOptions := testIPv4Frame(t, 0x01, 0x02, 0x03, 0x04)
+
 	tests := []struct {
 		name           string
 		raw            []byte
-		expectedPacket *ipv4Packet
+		expectedPacket *IPv4Packet
 		expectedErr    error
 	}{
 		{
 			name: "Valid IPv4 packet",
-			raw: []byte{
-				// Ethernet Frame
-				0x00, 0x1A, 0xA0, 0xBB, 0xCC, 0xDD, 0x00, 0x1A, 0xB0, 0xCC, 0xDD, 0xEE, 0x08, 0x00,
-				// IPv4 Header
-				0x45, 0x00, 0x00, 0x3c, 0x1c, 0x46, 0x40, 0x00,
-				0x40, 0x06, 0xb1, 0xe6, 0xc0, 0xa8, 0x00, 0x68,
-				0xc0, 0xa8, 0x00, 0x01,
-			},
-			expectedPacket: &ipv4Packet{
-				ethFrame: EthernetFrame{
-					DestinationMAC: net.HardwareAddr([]byte{0x00, 0x1A, 0xA0, 0xBB, 0xCC, 0xDD}),
-					SourceMAC:      net.HardwareAddr([]byte{0x00, 0x1A, 0xB0, 0xCC, 0xDD, 0xEE}),
+			raw:  raw,
+			expectedPacket: &IPv4Packet{
+				EthFrame: EthernetFrame{
+					DestinationMAC: testDstMAC,
+					SourceMAC:      testSrcMAC,
 					EtherType:      0x0800,
-					Payload: []byte{
-						0x45, 0x00, 0x00, 0x3c, 0x1c, 0x46, 0x40, 0x00,
-						0x40, 0x06, 0xb1, 0xe6, 0xc0, 0xa8, 0x00, 0x68,
-						0xc0, 0xa8, 0x00, 0x01,
-					},
+					Payload:        raw[14:],
 				},
-				header: ipv4Header{
-					version:        4,
-					ihl:            5,
-					dscp:           0,
-					ecn:            0,
-					totalLength:    0x003c,
-					identification: 0x1c46,
-					flags:          2,
-					fragmentOffset: 0,
-					ttl:            0x40,
-					protocol:       0x06,
-					headerChecksum: 0xb1e6,
-					sourceIP:       net.IP([]byte{192, 168, 0, 104}),
-					destinationIP:  net.IP([]byte{192, 168, 0, 1}),
-					options:        nil,
+				IPv4Header: IPv4Header{
+					Version:        4,
+					IHL:            5,
+					DSCP:           0,
+					ECN:            0,
+					TotalLength:    20,
+					Identification: 0x1c46,
+					Flags:          2,
+					FragmentOffset: 0,
+					TTL:            0x40,
+					Protocol:       0x06,
+					HeaderChecksum: 0x9ce4,
+					SourceIP:       netip.AddrFrom4([4]byte{192, 168, 0, 104}),
+					DestinationIP:  netip.AddrFrom4([4]byte{192, 168, 0, 1}),
+					Options:        nil,
 				},
 			},
 			expectedErr: nil,
 		},
 		{
 			name: "Valid IPv4 packet with options",
-			raw: []byte{
-				// Ethernet Frame
-				0x00, 0x1A, 0xA0, 0xBB, 0xCC, 0xDD, 0x00, 0x1A, 0xB0, 0xCC, 0xDD, 0xEE, 0x08, 0x00,
-				// IPv4 Header with options (IHL = 6, header length = 24 bytes)
-				0x46, 0x00, 0x00, 0x3c, 0x1c, 0x46, 0x40, 0x00,
-				0x40, 0x06, 0xb1, 0xe6, 0xc0, 0xa8, 0x00, 0x68,
-				0xc0, 0xa8, 0x00, 0x01,
-				// Options (4 bytes)
-				0x01, 0x02, 0x03, 0x04,
-			},
-			expectedPacket: &ipv4Packet{
-				ethFrame: EthernetFrame{
-					DestinationMAC: net.HardwareAddr([]byte{0x00, 0x1A, 0xA0, 0xBB, 0xCC, 0xDD}),
-					SourceMAC:      net.HardwareAddr([]byte{0x00, 0x1A, 0xB0, 0xCC, 0xDD, 0xEE}),
+			raw:  withOptions,
+			expectedPacket: &IPv4Packet{
+				EthFrame: EthernetFrame{
+					DestinationMAC: testDstMAC,
+					SourceMAC:      testSrcMAC,
 					EtherType:      0x0800,
-					Payload: []byte{
-						0x46, 0x00, 0x00, 0x3c, 0x1c, 0x46, 0x40, 0x00,
-						0x40, 0x06, 0xb1, 0xe6, 0xc0, 0xa8, 0x00, 0x68,
-						0xc0, 0xa8, 0x00, 0x01, 0x01, 0x02, 0x03, 0x04,
-					},
+					Payload:        withOptions[14:],
 				},
-				header: ipv4Header{
-					version:        4,
-					ihl:            6,
-					dscp:           0,
-					ecn:            0,
-					totalLength:    0x003c,
-					identification: 0x1c46,
-					flags:          2,
-					fragmentOffset: 0,
-					ttl:            0x40,
-					protocol:       0x06,
-					headerChecksum: 0xb1e6,
-					sourceIP:       net.IP([]byte{192, 168, 0, 104}),
-					destinationIP:  net.IP([]byte{192, 168, 0, 1}),
-					options:        []byte{0x01, 0x02, 0x03, 0x04},
+				IPv4Header: IPv4Header{
+					Version:        4,
+					IHL:            6,
+					DSCP:           0,
+					ECN:            0,
+					TotalLength:    24,
+					Identification: 0x1c46,
+					Flags:          2,
+					FragmentOffset: 0,
+					TTL:            0x40,
+					Protocol:       0x06,
+					HeaderChecksum: 0x97da,
+					SourceIP:       netip.AddrFrom4([4]byte{192, 168, 0, 104}),
+					DestinationIP:  netip.AddrFrom4([4]byte{192, 168, 0, 1}),
+					Options:        []byte{0x01, 0x02, 0x03, 0x04},
 				},
 			},
 			expectedErr: nil,
 		},
 		{
-			name: "Invalid Ethernet frame",
-			raw: []byte{
-				// Incomplete Ethernet Frame
-				0x00, 0x1A, 0xA0, 0xBB, 0xCC, 0xDD, 0x00,
-			},
+			name:           "Invalid Ethernet frame",
+			raw:            raw[:7],
 			expectedPacket: nil,
 			expectedErr:    errInvalidETHFrame,
 		},
 		{
-			name: "Invalid IPv4 header",
-			raw: []byte{
-				// Ethernet Frame
-				0x00, 0x1A, 0xA0, 0xBB, 0xCC, 0xDD, 0x00, 0x1A, 0xB0, 0xCC, 0xDD, 0xEE, 0x08, 0x00,
-				// Incomplete IPv4 Header
-				0x45, 0x00, 0x00, 0x3c, 0x1c,
-			},
+			name:           "Invalid IPv4 header",
+			raw:            raw[:19],
 			expectedPacket: nil,
 			expectedErr:    errIPv4HeaderTooShort,
 		},
 		{
-			name: "IHL does not match actual header length",
-			raw: []byte{
-				// Ethernet Frame
-				0x00, 0x1A, 0xA0, 0xBB, 0xCC, 0xDD, 0x00, 0x1A, 0xB0, 0xCC, 0xDD, 0xEE, 0x08, 0x00,
-				// IPv4 Header with IHL indicating 6 words (24 bytes) but actual length is only 20 bytes
-				0x46, 0x00, 0x00, 0x3c, 0x1c, 0x46, 0x40, 0x00,
-				0x40, 0x06, 0xb1, 0xe6, 0xc0, 0xa8, 0x00, 0x68,
-				0xc0, 0xa8, 0x00, 0x01,
-			},
+			// IHL indicating 6 words (24 bytes) but actual length is only 20 bytes
+			name:           "IHL does not match actual header length",
+			raw:            withOptions[:34],
 			expectedPacket: nil,
 			expectedErr:    errIPv4HeaderLenLessThanIHL,
 		},
@@ -234,11 +224,67 @@ func TestIPv4PacketFromBytes(t *testing.T) {
 	}
 }
 
+func TestIPPacketPayloadPadding(t *testing.T) {
+	payload := Payload{0xde, 0xad, 0xbe, 0xef}
+	padding := make([]byte, 8)
+	ipv4 := IPv4Header{
+		Version:       4,
+		TTL:           64,
+		Protocol:      17,
+		SourceIP:      netip.MustParseAddr("192.168.0.104"),
+		DestinationIP: netip.MustParseAddr("192.168.0.1"),
+	}
+	ipv6 := IPv6Header{
+		Version:       6,
+		NextHeader:    17,
+		HopLimit:      64,
+		SourceIP:      netip.MustParseAddr("fe80::1"),
+		DestinationIP: netip.MustParseAddr("fe80::2"),
+	}
+	ipv6Ext := ipv6
+	ipv6Ext.NextHeader = 0
+	ipv6Ext.Extensions = []IPv6ExtensionHeader{&IPv6HopByHopHeader{NextHeader: 17}}
+
+	tests := []struct {
+		name string
+		ip   SerializableLayer
+		// offset of the length field in the frame, cleared when not -1
+		zeroLength int
+		expected   []byte
+	}{
+		{name: "IPv4", ip: ipv4, zeroLength: -1, expected: payload},
+		{name: "IPv6", ip: ipv6, zeroLength: -1, expected: payload},
+		{name: "IPv6 with extension headers", ip: ipv6Ext, zeroLength: -1, expected: payload},
+		{name: "IPv4 without total length", ip: ipv4, zeroLength: 16, expected: append(payload, padding...)},
+		{name: "IPv6 without payload length", ip: ipv6, zeroLength: 18, expected: append(payload, padding...)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw := append(ipFrame(t, tt.ip, payload), padding...)
+			if tt.zeroLength >= 0 {
+				raw[tt.zeroLength], raw[tt.zeroLength+1] = 0, 0
+			}
+
+			p, err := IPPacketFromBytes(raw)
+			if err != nil {
+				t.Fatalf("expected no error - got %v", err)
+			}
+			if !reflect.DeepEqual(p.Payload(), tt.expected) {
+				t.Errorf("expected payload to be %v - got %v", tt.expected, p.Payload())
+			}
+		})
+	}
+}
+
 func TestIPv4HeaderFromBytes(t *testing.T) {
+	raw := testIPv4Frame(t)[14:]
+	withOptions := testIPv4Frame(t, 0x01, 0x02, 0x03, 0x04)[14:]
+
 	tests := []struct {
 		name           string
 		raw            []byte
-		expectedHeader *ipv4Header
+		expectedHeader *IPv4Header
 		expectedErr    error
 	}{
 		{
@@ -249,69 +295,55 @@ func TestIPv4HeaderFromBytes(t *testing.T) {
 		},
 		{
 			name:           "Incomplete header (less than 20 bytes)",
-			raw:            []byte{0x45, 0x00, 0x00, 0x3c, 0x1c, 0x46, 0x40, 0x00, 0x40, 0x06},
+			raw:            raw[:10],
 			expectedHeader: nil,
 			expectedErr:    errIPv4HeaderTooShort,
 		},
 		{
-			name: "Header length less than indicated IHL",
-			raw: []byte{
-				0x46, 0x00, 0x00, 0x3c, 0x1c, 0x46, 0x40, 0x00,
-				0x40, 0x06, 0xb1, 0xe6, 0xc0, 0xa8, 0x00, 0x68,
-				0xc0, 0xa8, 0x00, 0x01,
-			},
+			name:           "Header length less than indicated IHL",
+			raw:            withOptions[:20],
 			expectedHeader: nil,
 			expectedErr:    errIPv4HeaderLenLessThanIHL,
 		},
 		{
 			name: "Valid header without options",
-			raw: []byte{
-				0x45, 0x00, 0x00, 0x3c, 0x1c, 0x46, 0x40, 0x00,
-				0x40, 0x06, 0xb1, 0xe6, 0xc0, 0xa8, 0x00, 0x68,
-				0xc0, 0xa8, 0x00, 0x01,
-			},
-			expectedHeader: &ipv4Header{
-				version:        4,
-				ihl:            5,
-				dscp:           0,
-				ecn:            0,
-				totalLength:    0x003c,
-				identification: 0x1c46,
-				flags:          2,
-				fragmentOffset: 0,
-				ttl:            0x40,
-				protocol:       0x06,
-				headerChecksum: 0xb1e6,
-				sourceIP:       net.IP([]byte{192, 168, 0, 104}),
-				destinationIP:  net.IP([]byte{192, 168, 0, 1}),
-				options:        nil,
+			raw:  raw,
+			expectedHeader: &IPv4Header{
+				Version:        4,
+				IHL:            5,
+				DSCP:           0,
+				ECN:            0,
+				TotalLength:    20,
+				Identification: 0x1c46,
+				Flags:          2,
+				FragmentOffset: 0,
+				TTL:            0x40,
+				Protocol:       0x06,
+				HeaderChecksum: 0x9ce4,
+				SourceIP:       netip.AddrFrom4([4]byte{192, 168, 0, 104}),
+				DestinationIP:  netip.AddrFrom4([4]byte{192, 168, 0, 1}),
+				Options:        nil,
 			},
 			expectedErr: nil,
 		},
 		{
 			name: "Valid header with options",
-			raw: []byte{
-				0x46, 0x00, 0x00, 0x3c, 0x1c, 0x46, 0x40, 0x00,
-				0x40, 0x06, 0xb1, 0xe6, 0xc0, 0xa8, 0x00, 0x68,
-				0xc0, 0xa8, 0x00, 0x01,
-				// Options (4 bytes of options)
-				0x01, 0x02, 0x03, 0x04,
-			},
-			expectedHeader: &ipv4Header{
-				version:        4,
-				ihl:            6,
-				dscp:           0,
-				ecn:            0,
-				totalLength:    0x003c,
-				identification: 0x1c46,
-				flags:          2,
-				fragmentOffset: 0,
-				ttl:            0x40,
-				protocol:       0x06,
-				headerChecksum: 0xb1e6,
-				sourceIP:       net.IP([]byte{192, 168, 0, 104}),
-				destinationIP:  net.IP([]byte{192, 168, 0, 1}),
-				options:        []byte{0x01, 0x02, 0x03, 0x04},
+			raw:  withOptions,
+			expectedHeader: &IPv4Header{
+				Version:        4,
+				IHL:            6,
+				DSCP:           0,
+				ECN:            0,
+				TotalLength:    24,
+				Identification: 0x1c46,
+				Flags:          2,
+				FragmentOffset: 0,
+				TTL:            0x40,
+				Protocol:       0x06,
+				HeaderChecksum: 0x97da,
+				SourceIP:       netip.AddrFrom4([4]byte{192, 168, 0, 104}),
+				DestinationIP:  netip.AddrFrom4([4]byte{192, 168, 0, 1}),
+				Options:        []byte{0x01, 0x02, 0x03, 0x04},
 			},
 			expectedErr: nil,
 		},
@@ -319,7 +351,7 @@ func TestIPv4HeaderFromBytes(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			h, err := ipv4HeaderFromBytes(tt.raw)
+			h, err := IPv4HeaderFromBytes(tt.raw)
 			if tt.expectedErr != err {
 				t.Errorf("expected error: %v - got %v", tt.expectedErr, err)
 			}
@@ -330,68 +362,83 @@ func TestIPv4HeaderFromBytes(t *testing.T) {
 	}
 }
 
+func TestIPv4HeaderFlags(t *testing.T) {
+	tests := []struct {
+		name          string
+		flagsAndFrag  [2]byte
+		dontFragment  bool
+		moreFragments bool
+		offset        uint16
+	}{
+		{name: "DF", flagsAndFrag: [2]byte{0x40, 0x00}, dontFragment: true},
+		{name: "first fragment", flagsAndFrag: [2]byte{0x20, 0x00}, moreFragments: true},
+		{name: "middle fragment", flagsAndFrag: [2]byte{0x20, 0xb9}, moreFragments: true, offset: 185},
+		{name: "last fragment", flagsAndFrag: [2]byte{0x01, 0x72}, offset: 370},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the flags and fragment offset are written by hand to check their bit layout
+			raw := testIPv4Frame(t)[14:]
+			raw[6], raw[7] = tt.flagsAndFrag[0], tt.flagsAndFrag[1]
+			h, err := IPv4HeaderFromBytes(raw)
+			if err != nil {
+				t.Fatalf("expected no error - got %v", err)
+			}
+			if h.DontFragment() != tt.dontFragment || h.MoreFragments() != tt.moreFragments {
+				t.Errorf("expected DF %v and MF %v - got %v and %v", tt.dontFragment, tt.moreFragments, h.DontFragment(), h.MoreFragments())
+			}
+			if h.FragmentOffset != tt.offset {
+				t.Errorf("expected fragment offset %d - got %d", tt.offset, h.FragmentOffset)
+			}
+			if expected := netip.MustParseAddr("192.168.0.104"); h.SourceIP != expected {
+				t.Errorf("expected source %s - got %s", expected, h.SourceIP)
+			}
+		})
+	}
+}
+
 func TestIPv6PacketFromBytes(t *testing.T) {
+	raw := testIPv6Frame(t)
+
 	tests := []struct {
 		name           string
 		raw            []byte
-		expectedPacket *ipv6Packet
+		expectedPacket *IPv6Packet
 		expectedErr    error
 	}{
 		{
 			name: "Valid IPv6 packet",
-			raw: []byte{
-				// Ethernet Frame
-				0x00, 0x1A, 0xA0, 0xBB, 0xCC, 0xDD, 0x00, 0x1A, 0xB0, 0xCC, 0xDD, 0xEE, 0x86, 0xDD,
-				// IPv6 Header
-				0x60, 0x00, 0x00, 0x00, 0x00, 0x14, 0x11, 0x40,
-				0xfe, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
-				0x02, 0x1c, 0x7e, 0xff, 0xfe, 0xe4, 0x2c, 0x00,
-				0xfe, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
-				0x02, 0x1c, 0x7e, 0xff, 0xfe, 0xe4, 0x2c, 0x01,
-			},
-			expectedPacket: &ipv6Packet{
-				ethFrame: EthernetFrame{
-					DestinationMAC: net.HardwareAddr([]byte{0x00, 0x1A, 0xA0, 0xBB, 0xCC, 0xDD}),
-					SourceMAC:      net.HardwareAddr([]byte{0x00, 0x1A, 0xB0, 0xCC, 0xDD, 0xEE}),
+			raw:  raw,
+			expectedPacket: &IPv6Packet{
+				EthFrame: EthernetFrame{
+					DestinationMAC: testDstMAC,
+					SourceMAC:      testSrcMAC,
 					EtherType:      0x86DD,
-					Payload: []byte{
-						0x60, 0x00, 0x00, 0x00, 0x00, 0x14, 0x11, 0x40,
-						0xfe, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
-						0x02, 0x1c, 0x7e, 0xff, 0xfe, 0xe4, 0x2c, 0x00,
-						0xfe, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
-						0x02, 0x1c, 0x7e, 0xff, 0xfe, 0xe4, 0x2c, 0x01,
-					},
+					Payload:        raw[14:],
 				},
-				header: ipv6Header{
-					version:       6,
-					trafficClass:  0,
-					flowLabel:     0,
-					payloadLength: 0x0014,
-					nextHeader:    0x11,
-					hopLimit:      0x40,
-					sourceIP:      net.IP([]byte{0xfe, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0x1c, 0x7e, 0xff, 0xfe, 0xe4, 0x2c, 0x00}),
-					destinationIP: net.IP([]byte{0xfe, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0x1c, 0x7e, 0xff, 0xfe, 0xe4, 0x2c, 0x01}),
+				IPv6Header: IPv6Header{
+					Version:       6,
+					TrafficClass:  0,
+					FlowLabel:     0,
+					PayloadLength: 0x0014,
+					NextHeader:    0x11,
+					HopLimit:      0x40,
+					SourceIP:      netip.AddrFrom16([16]byte{0xfe, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0x1c, 0x7e, 0xff, 0xfe, 0xe4, 0x2c, 0x00}),
+					DestinationIP: netip.AddrFrom16([16]byte{0xfe, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0x1c, 0x7e, 0xff, 0xfe, 0xe4, 0x2c, 0x01}),
 				},
 			},
 			expectedErr: nil,
 		},
 		{
-			name: "Invalid Ethernet frame",
-			raw: []byte{
-				// Incomplete Ethernet Frame
-				0x00, 0x1A, 0xA0, 0xBB, 0xCC, 0xDD, 0x00,
-			},
+			name:           "Invalid Ethernet frame",
+			raw:            raw[:7],
 			expectedPacket: nil,
 			expectedErr:    errInvalidETHFrame,
 		},
 		{
-			name: "Invalid IPv6 header",
-			raw: []byte{
-				// Ethernet Frame
-				0x00, 0x1A, 0xA0, 0xBB, 0xCC, 0xDD, 0x00, 0x1A, 0xB0, 0xCC, 0xDD, 0xEE, 0x86, 0xDD,
-				// Incomplete IPv6 Header
-				0x60, 0x00, 0x00, 0x00, 0x00, 0x14, 0x11,
-			},
+			name:           "Invalid IPv6 header",
+			raw:            raw[:21],
 			expectedPacket: nil,
 			expectedErr:    errInvalidIPv6Header,
 		},
@@ -411,10 +458,17 @@ func TestIPv6PacketFromBytes(t *testing.T) {
 }
 
 func TestIPv6HeaderFromBytes(t *testing.T) {
+	raw := testIPv6Frame(t)[14:]
+	addr := netip.MustParseAddr("2001:db8:85a3::8a2e:370:7334")
+	another := mustSerialize(t,
+		IPv6Header{Version: 6, NextHeader: 6, HopLimit: 64, SourceIP: addr, DestinationIP: addr},
+		&TCPHeader{SourcePort: 40000, DestinationPort: 443, Flags: 0x02, WindowSize: 65535},
+	)
+
 	tests := []struct {
 		name           string
 		raw            []byte
-		expectedHeader *ipv6Header
+		expectedHeader *IPv6Header
 		expectedErr    error
 	}{
 		{
@@ -425,49 +479,37 @@ func TestIPv6HeaderFromBytes(t *testing.T) {
 		},
 		{
 			name:           "Incomplete header (less than 40 bytes)",
-			raw:            []byte{0x60, 0x00, 0x00, 0x00, 0x00, 0x14, 0x11},
+			raw:            raw[:7],
 			expectedHeader: nil,
 			expectedErr:    errInvalidIPv6Header,
 		},
 		{
 			name: "Valid header",
-			raw: []byte{
-				0x60, 0x00, 0x00, 0x00, 0x00, 0x14, 0x11, 0x40,
-				0xfe, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
-				0x02, 0x1c, 0x7e, 0xff, 0xfe, 0xe4, 0x2c, 0x00,
-				0xfe, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
-				0x02, 0x1c, 0x7e, 0xff, 0xfe, 0xe4, 0x2c, 0x01,
-			},
-			expectedHeader: &ipv6Header{
-				version:       6,
-				trafficClass:  0,
-				flowLabel:     0,
-				payloadLength: 0x0014,
-				nextHeader:    0x11,
-				hopLimit:      0x40,
-				sourceIP:      net.IP([]byte{0xfe, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0x1c, 0x7e, 0xff, 0xfe, 0xe4, 0x2c, 0x00}),
-				destinationIP: net.IP([]byte{0xfe, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0x1c, 0x7e, 0xff, 0xfe, 0xe4, 0x2c, 0x01}),
+			raw:  raw,
+			expectedHeader: &IPv6Header{
+				Version:       6,
+				TrafficClass:  0,
+				FlowLabel:     0,
+				PayloadLength: 0x0014,
+				NextHeader:    0x11,
+				HopLimit:      0x40,
+				SourceIP:      netip.AddrFrom16([16]byte{0xfe, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0x1c, 0x7e, 0xff, 0xfe, 0xe4, 0x2c, 0x00}),
+				DestinationIP: netip.AddrFrom16([16]byte{0xfe, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0x1c, 0x7e, 0xff, 0xfe, 0xe4, 0x2c, 0x01}),
 			},
 			expectedErr: nil,
 		},
 		{
 			name: "Another valid header",
-			raw: []byte{
-				0x60, 0x00, 0x00, 0x00, 0x00, 0x14, 0x06, 0x40,
-				0x20, 0x01, 0x0d, 0xb8, 0x85, 0xa3, 0x00, 0x00,
-				0x00, 0x00, 0x8a, 0x2e, 0x03, 0x70, 0x73, 0x34,
-				0x20, 0x01, 0x0d, 0xb8, 0x85, 0xa3, 0x00, 0x00,
-				0x00, 0x00, 0x8a, 0x2e, 0x03, 0x70, 0x73, 0x34,
-			},
-			expectedHeader: &ipv6Header{
-				version:       6,
-				trafficClass:  0,
-				flowLabel:     0,
-				payloadLength: 0x0014,
-				nextHeader:    0x06,
-				hopLimit:      0x40,
-				sourceIP:      net.IP([]byte{0x20, 0x01, 0x0d, 0xb8, 0x85, 0xa3, 0x00, 0x00, 0x00, 0x00, 0x8a, 0x2e, 0x03, 0x70, 0x73, 0x34}),
-				destinationIP: net.IP([]byte{0x20, 0x01, 0x0d, 0xb8, 0x85, 0xa3, 0x00, 0x00, 0x00, 0x00, 0x8a, 0x2e, 0x03, 0x70, 0x73, 0x34}),
+			raw:  another,
+			expectedHeader: &IPv6Header{
+				Version:       6,
+				TrafficClass:  0,
+				FlowLabel:     0,
+				PayloadLength: 0x0014,
+				NextHeader:    0x06,
+				HopLimit:      0x40,
+				SourceIP:      netip.AddrFrom16([16]byte{0x20, 0x01, 0x0d, 0xb8, 0x85, 0xa3, 0x00, 0x00, 0x00, 0x00, 0x8a, 0x2e, 0x03, 0x70, 0x73, 0x34}),
+				DestinationIP: netip.AddrFrom16([16]byte{0x20, 0x01, 0x0d, 0xb8, 0x85, 0xa3, 0x00, 0x00, 0x00, 0x00, 0x8a, 0x2e, 0x03, 0x70, 0x73, 0x34}),
 			},
 			expectedErr: nil,
 		},
@@ -475,7 +517,7 @@ func TestIPv6HeaderFromBytes(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			h, err := ipv6HeaderFromBytes(tt.raw)
+			h, err := IPv6HeaderFromBytes(tt.raw)
 			if tt.expectedErr != err {
 				t.Errorf("expected error: %v - got %v", tt.expectedErr, err)
 			}
@@ -486,31 +528,10 @@ func TestIPv6HeaderFromBytes(t *testing.T) {
 	}
 }
 
-func compareIPv4Packets(a, b *ipv4Packet) bool {
-	return reflect.DeepEqual(a.ethFrame, b.ethFrame) &&
-		a.header.version == b.header.version &&
-		a.header.ihl == b.header.ihl &&
-		a.header.dscp == b.header.dscp &&
-		a.header.ecn == b.header.ecn &&
-		a.header.totalLength == b.header.totalLength &&
-		a.header.flags == b.header.flags &&
-		a.header.identification == b.header.identification &&
-		a.header.fragmentOffset == b.header.fragmentOffset &&
-		a.header.protocol == b.header.protocol &&
-		a.header.headerChecksum == b.header.headerChecksum &&
-		a.header.sourceIP.Equal(b.header.sourceIP) &&
-		a.header.destinationIP.Equal(b.header.destinationIP) &&
-		reflect.DeepEqual(a.header.options, b.header.options)
+func compareIPv4Packets(a, b *IPv4Packet) bool {
+	return reflect.DeepEqual(a.EthFrame, b.EthFrame) && reflect.DeepEqual(a.IPv4Header, b.IPv4Header)
 }
 
-func compareIPv6Packets(a, b *ipv6Packet) bool {
-	return reflect.DeepEqual(a.ethFrame, b.ethFrame) &&
-		a.header.version == b.header.version &&
-		a.header.trafficClass == b.header.trafficClass &&
-		a.header.flowLabel == b.header.flowLabel &&
-		a.header.payloadLength == b.header.payloadLength &&
-		a.header.nextHeader == b.header.nextHeader &&
-		a.header.hopLimit == b.header.hopLimit &&
-		a.header.sourceIP.Equal(b.header.sourceIP) &&
-		a.header.destinationIP.Equal(b.header.destinationIP)
+func compareIPv6Packets(a, b *IPv6Packet) bool {
+	return reflect.DeepEqual(a.EthFrame, b.EthFrame) && reflect.DeepEqual(a.IPv6Header, b.IPv6Header)
 }
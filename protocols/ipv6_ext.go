@@ -0,0 +1,466 @@
+package protocols
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// Next Header values identifying the IPv6 extension headers
+const (
+	IPv6ExtHopByHop           = 0
+	IPv6ExtRouting            = 43
+	IPv6ExtFragment           = 44
+	IPv6ExtAuthentication     = 51
+	IPv6ExtDestinationOptions = 60
+)
+
+var (
+	errIPv6ExtensionTruncated = errors.New("IPv6 extension header exceeds the packet")
+	errIPv6ExtensionAligned   = errors.New("IPv6 extension header must be a multiple of 8 bytes")
+)
+
+// IPv6ExtensionHeader is an extension header of an IPv6 packet, between the fixed header and the upper-layer one.
+// Its concrete type is *IPv6HopByHopHeader, *IPv6RoutingHeader, *IPv6FragmentHeader, *IPv6AuthenticationHeader
+// or *IPv6DestinationOptionsHeader
+type IPv6ExtensionHeader interface {
+	// Type returns the Next Header value identifying the extension header in the header preceding it
+	Type() uint8
+	// Next returns the Next Header field of the extension header, identifying the header following it
+	Next() uint8
+	// Len returns the length of the extension header in bytes, a multiple of 8
+	Len() int
+	serialize(fix bool) ([]byte, error)
+	info() string
+}
+
+// IPv6Option is a TLV-encoded option of a Hop-by-Hop or Destination Options header.
+// Pad1 options have type 0 and no length nor data
+type IPv6Option struct {
+	Type uint8
+	Data []byte
+}
+
+// IPv6 option types
+const (
+	IPv6OptionPad1                     = 0
+	IPv6OptionPadN                     = 1
+	IPv6OptionTunnelEncapsulationLimit = 4
+	IPv6OptionRouterAlert              = 5
+	IPv6OptionJumboPayload             = 0xC2
+	IPv6OptionHomeAddress              = 0xC9
+)
+
+// ipv6RouterAlertMLD is the value of the router alert option of MLD messages
+const ipv6RouterAlertMLD = 0
+
+var ipv6OptionNames = map[uint8]string{
+	IPv6OptionPad1:                     "Pad1",
+	IPv6OptionPadN:                     "PadN",
+	IPv6OptionTunnelEncapsulationLimit: "Tunnel Encapsulation Limit",
+	IPv6OptionRouterAlert:              "Router Alert",
+	IPv6OptionJumboPayload:             "Jumbo Payload",
+	IPv6OptionHomeAddress:              "Home Address",
+}
+
+// IPv6HopByHopHeader carries the options examined by every node along the path, like the router alert of MLD messages
+type IPv6HopByHopHeader struct {
+	NextHeader uint8
+	Options    []IPv6Option
+}
+
+// IPv6DestinationOptionsHeader carries the options examined by the destination of the packet
+type IPv6DestinationOptionsHeader struct {
+	NextHeader uint8
+	Options    []IPv6Option
+}
+
+// IPv6RoutingHeader lists the intermediate nodes the packet must visit on its way to the destination
+type IPv6RoutingHeader struct {
+	NextHeader   uint8
+	RoutingType  uint8
+	SegmentsLeft uint8
+	// Data contains the type-specific bytes following the segments left field
+	Data []byte
+}
+
+// IPv6FragmentHeader is carried by the fragments of a packet too large for the path MTU
+type IPv6FragmentHeader struct {
+	NextHeader     uint8
+	FragmentOffset uint16 // in 8-byte units
+	MoreFragments  bool
+	Identification uint32
+}
+
+// IPv6AuthenticationHeader is the IPsec Authentication Header (RFC 4302)
+type IPv6AuthenticationHeader struct {
+	NextHeader     uint8
+	SPI            uint32
+	SequenceNumber uint32
+	// ICV is the Integrity Check Value, whose length is a multiple of 8 bytes minus 4 over IPv6
+	ICV []byte
+}
+
+// ipv6ExtensionsFromBytes decodes the chain of extension headers starting with the next header type.
+// The chain ends with the first upper-layer header, or with a fragment header not carrying the first fragment,
+// whose data follows it. An Encapsulating Security Payload ends the chain as well, as its content is encrypted
+func ipv6ExtensionsFromBytes(next uint8, raw []byte) ([]IPv6ExtensionHeader, error) {
+	var extensions []IPv6ExtensionHeader
+	for {
+		var ext IPv6ExtensionHeader
+		var err error
+		switch next {
+		case IPv6ExtHopByHop, IPv6ExtDestinationOptions:
+			ext, err = ipv6OptionsHeaderFromBytes(next, raw)
+		case IPv6ExtRouting:
+			ext, err = ipv6RoutingHeaderFromBytes(raw)
+		case IPv6ExtFragment:
+			ext, err = ipv6FragmentHeaderFromBytes(raw)
+		case IPv6ExtAuthentication:
+			ext, err = ipv6AuthenticationHeaderFromBytes(raw)
+		default:
+			return extensions, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		extensions = append(extensions, ext)
+		raw = raw[ext.Len():]
+		next = ext.Next()
+		if f, ok := ext.(*IPv6FragmentHeader); ok && f.FragmentOffset != 0 {
+			return extensions, nil
+		}
+	}
+}
+
+// ipv6ExtensionLen returns the length of the extension header whose length field, in 8-byte units
+// not including the first 8 bytes, is the second byte
+func ipv6ExtensionLen(raw []byte) (int, error) {
+	if len(raw) < 8 {
+		return 0, errIPv6ExtensionTruncated
+	}
+	length := (int(raw[1]) + 1) * 8
+	if len(raw) < length {
+		return 0, errIPv6ExtensionTruncated
+	}
+	return length, nil
+}
+
+func ipv6OptionsHeaderFromBytes(t uint8, raw []byte) (IPv6ExtensionHeader, error) {
+	length, err := ipv6ExtensionLen(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	var options []IPv6Option
+	for data := raw[2:length]; len(data) > 0; {
+		if data[0] == IPv6OptionPad1 {
+			options = append(options, IPv6Option{Type: IPv6OptionPad1})
+			data = data[1:]
+			continue
+		}
+		if len(data) < 2 || len(data) < 2+int(data[1]) {
+			return nil, fmt.Errorf("%w: option %d", errIPv6ExtensionTruncated, data[0])
+		}
+		options = append(options, IPv6Option{Type: data[0], Data: data[2 : 2+int(data[1])]})
+		data = data[2+int(data[1]):]
+	}
+
+	if t == IPv6ExtHopByHop {
+		return &IPv6HopByHopHeader{NextHeader: raw[0], Options: options}, nil
+	}
+	return &IPv6DestinationOptionsHeader{NextHeader: raw[0], Options: options}, nil
+}
+
+func ipv6RoutingHeaderFromBytes(raw []byte) (IPv6ExtensionHeader, error) {
+	length, err := ipv6ExtensionLen(raw)
+	if err != nil {
+		return nil, err
+	}
+	return &IPv6RoutingHeader{
+		NextHeader:   raw[0],
+		RoutingType:  raw[2],
+		SegmentsLeft: raw[3],
+		Data:         raw[4:length],
+	}, nil
+}
+
+func ipv6FragmentHeaderFromBytes(raw []byte) (IPv6ExtensionHeader, error) {
+	if len(raw) < 8 {
+		return nil, errIPv6ExtensionTruncated
+	}
+	offset := binary.BigEndian.Uint16(raw[2:4])
+	return &IPv6FragmentHeader{
+		NextHeader:     raw[0],
+		FragmentOffset: offset >> 3,
+		MoreFragments:  offset&0x1 != 0,
+		Identification: binary.BigEndian.Uint32(raw[4:8]),
+	}, nil
+}
+
+func ipv6AuthenticationHeaderFromBytes(raw []byte) (IPv6ExtensionHeader, error) {
+	if len(raw) < 12 {
+		return nil, errIPv6ExtensionTruncated
+	}
+	// the payload length is in 4-byte units, not including the first 8 bytes
+	length := (int(raw[1]) + 2) * 4
+	if length < 12 || len(raw) < length {
+		return nil, errIPv6ExtensionTruncated
+	}
+	if length%8 != 0 {
+		return nil, errIPv6ExtensionAligned
+	}
+	return &IPv6AuthenticationHeader{
+		NextHeader:     raw[0],
+		SPI:            binary.BigEndian.Uint32(raw[4:8]),
+		SequenceNumber: binary.BigEndian.Uint32(raw[8:12]),
+		ICV:            raw[12:length],
+	}, nil
+}
+
+func (h *IPv6HopByHopHeader) Type() uint8 {
+	return IPv6ExtHopByHop
+}
+
+func (h *IPv6HopByHopHeader) Next() uint8 {
+	return h.NextHeader
+}
+
+func (h *IPv6HopByHopHeader) Len() int {
+	return ipv6OptionsLen(h.Options)
+}
+
+func (h *IPv6DestinationOptionsHeader) Type() uint8 {
+	return IPv6ExtDestinationOptions
+}
+
+func (h *IPv6DestinationOptionsHeader) Next() uint8 {
+	return h.NextHeader
+}
+
+func (h *IPv6DestinationOptionsHeader) Len() int {
+	return ipv6OptionsLen(h.Options)
+}
+
+func (h *IPv6RoutingHeader) Type() uint8 {
+	return IPv6ExtRouting
+}
+
+func (h *IPv6RoutingHeader) Next() uint8 {
+	return h.NextHeader
+}
+
+func (h *IPv6RoutingHeader) Len() int {
+	return (4 + len(h.Data) + 7) / 8 * 8
+}
+
+func (h *IPv6FragmentHeader) Type() uint8 {
+	return IPv6ExtFragment
+}
+
+func (h *IPv6FragmentHeader) Next() uint8 {
+	return h.NextHeader
+}
+
+func (h *IPv6FragmentHeader) Len() int {
+	return 8
+}
+
+func (h *IPv6AuthenticationHeader) Type() uint8 {
+	return IPv6ExtAuthentication
+}
+
+func (h *IPv6AuthenticationHeader) Next() uint8 {
+	return h.NextHeader
+}
+
+func (h *IPv6AuthenticationHeader) Len() int {
+	return (12 + len(h.ICV) + 7) / 8 * 8
+}
+
+// ipv6OptionsLen returns the length of the options header, padded to a multiple of 8 bytes
+func ipv6OptionsLen(options []IPv6Option) int {
+	length := 2
+	for _, o := range options {
+		length += o.len()
+	}
+	return (length + 7) / 8 * 8
+}
+
+// len returns the encoded length of the option
+func (o IPv6Option) len() int {
+	if o.Type == IPv6OptionPad1 {
+		return 1
+	}
+	return 2 + len(o.Data)
+}
+
+// String returns a human-readable description of the option
+func (o IPv6Option) String() string {
+	name, ok := ipv6OptionNames[o.Type]
+	if !ok {
+		name = fmt.Sprintf("Option %d", o.Type)
+	}
+	switch {
+	case o.Type == IPv6OptionRouterAlert && len(o.Data) == 2:
+		value := binary.BigEndian.Uint16(o.Data)
+		if value == ipv6RouterAlertMLD {
+			return name + ": MLD"
+		}
+		return fmt.Sprintf("%s: %d", name, value)
+	case o.Type == IPv6OptionJumboPayload && len(o.Data) == 4:
+		return fmt.Sprintf("%s: %d", name, binary.BigEndian.Uint32(o.Data))
+	case o.Type == IPv6OptionTunnelEncapsulationLimit && len(o.Data) == 1:
+		return fmt.Sprintf("%s: %d", name, o.Data[0])
+	case o.Type == IPv6OptionHomeAddress && len(o.Data) == 16:
+		return fmt.Sprintf("%s: %s", name, netip.AddrFrom16([16]byte(o.Data)))
+	case o.Type == IPv6OptionPad1 || o.Type == IPv6OptionPadN:
+		return fmt.Sprintf("%s (%d bytes)", name, o.len())
+	default:
+		return fmt.Sprintf("%s: % x", name, o.Data)
+	}
+}
+
+// Addresses returns the addresses listed by a routing header of type 0 (deprecated), 2 (Mobile IPv6)
+// or 4 (Segment Routing), following 4 type-specific bytes
+func (h *IPv6RoutingHeader) Addresses() []netip.Addr {
+	if h.RoutingType != 0 && h.RoutingType != 2 && h.RoutingType != 4 || len(h.Data) < 4 {
+		return nil
+	}
+	var addrs []netip.Addr
+	for data := h.Data[4:]; len(data) >= 16; data = data[16:] {
+		addrs = append(addrs, netip.AddrFrom16([16]byte(data[:16])))
+	}
+	return addrs
+}
+
+// finalDestination returns the last address the packet is routed to, if it is not the destination of the IPv6 header.
+// The segment list of a Segment Routing header is in reverse order
+func (h *IPv6RoutingHeader) finalDestination() (netip.Addr, bool) {
+	addrs := h.Addresses()
+	if h.SegmentsLeft == 0 || len(addrs) == 0 {
+		return netip.Addr{}, false
+	}
+	if h.RoutingType == 4 {
+		return addrs[0], true
+	}
+	return addrs[len(addrs)-1], true
+}
+
+// serializeIPv6Options encodes an options header, padding it with a Pad1 or PadN option if fix is set
+func serializeIPv6Options(next uint8, options []IPv6Option, fix bool) ([]byte, error) {
+	header := []byte{next, 0}
+	for _, o := range options {
+		if o.Type == IPv6OptionPad1 {
+			header = append(header, IPv6OptionPad1)
+			continue
+		}
+		if len(o.Data) > 0xFF {
+			return nil, fmt.Errorf("IPv6 option %d is too long: %d bytes", o.Type, len(o.Data))
+		}
+		header = append(append(header, o.Type, uint8(len(o.Data))), o.Data...)
+	}
+
+	if pad := (8 - len(header)%8) % 8; pad > 0 {
+		if !fix {
+			return nil, errIPv6ExtensionAligned
+		}
+		if pad == 1 {
+			header = append(header, IPv6OptionPad1)
+		} else {
+			header = append(append(header, IPv6OptionPadN, uint8(pad-2)), make([]byte, pad-2)...)
+		}
+	}
+	header[1] = uint8(len(header)/8 - 1)
+	return header, nil
+}
+
+func (h *IPv6HopByHopHeader) serialize(fix bool) ([]byte, error) {
+	return serializeIPv6Options(h.NextHeader, h.Options, fix)
+}
+
+func (h *IPv6DestinationOptionsHeader) serialize(fix bool) ([]byte, error) {
+	return serializeIPv6Options(h.NextHeader, h.Options, fix)
+}
+
+func (h *IPv6RoutingHeader) serialize(fix bool) ([]byte, error) {
+	header := append([]byte{h.NextHeader, 0, h.RoutingType, h.SegmentsLeft}, h.Data...)
+	if pad := (8 - len(header)%8) % 8; pad > 0 {
+		if !fix {
+			return nil, errIPv6ExtensionAligned
+		}
+		header = append(header, make([]byte, pad)...)
+	}
+	header[1] = uint8(len(header)/8 - 1)
+	return header, nil
+}
+
+func (h *IPv6FragmentHeader) serialize(fix bool) ([]byte, error) {
+	header := make([]byte, 8)
+	header[0] = h.NextHeader
+	offset := h.FragmentOffset << 3
+	if h.MoreFragments {
+		offset |= 0x1
+	}
+	binary.BigEndian.PutUint16(header[2:4], offset)
+	binary.BigEndian.PutUint32(header[4:8], h.Identification)
+	return header, nil
+}
+
+func (h *IPv6AuthenticationHeader) serialize(fix bool) ([]byte, error) {
+	header := make([]byte, 12, 12+len(h.ICV))
+	header[0] = h.NextHeader
+	binary.BigEndian.PutUint32(header[4:8], h.SPI)
+	binary.BigEndian.PutUint32(header[8:12], h.SequenceNumber)
+	header = append(header, h.ICV...)
+	if pad := (8 - len(header)%8) % 8; pad > 0 {
+		if !fix {
+			return nil, errIPv6ExtensionAligned
+		}
+		header = append(header, make([]byte, pad)...)
+	}
+	header[1] = uint8(len(header)/4 - 2)
+	return header, nil
+}
+
+// optionsInfo joins the descriptions of the options, leaving out the padding
+func optionsInfo(options []IPv6Option) string {
+	var s []string
+	for _, o := range options {
+		if o.Type != IPv6OptionPad1 && o.Type != IPv6OptionPadN {
+			s = append(s, o.String())
+		}
+	}
+	return strings.Join(s, ", ")
+}
+
+func (h *IPv6HopByHopHeader) info() string {
+	return fmt.Sprintf("Hop-by-Hop Options: %s", optionsInfo(h.Options))
+}
+
+func (h *IPv6DestinationOptionsHeader) info() string {
+	return fmt.Sprintf("Destination Options: %s", optionsInfo(h.Options))
+}
+
+func (h *IPv6RoutingHeader) info() string {
+	info := fmt.Sprintf("Routing: type %d, %d segments left", h.RoutingType, h.SegmentsLeft)
+	if addrs := h.Addresses(); len(addrs) > 0 {
+		info += ", addresses " + joinAddrs(addrs)
+	}
+	return info
+}
+
+func (h *IPv6FragmentHeader) info() string {
+	more := ""
+	if h.MoreFragments {
+		more = ", more fragments"
+	}
+	return fmt.Sprintf("Fragment: identification %d, offset %d%s", h.Identification, h.FragmentOffset, more)
+}
+
+func (h *IPv6AuthenticationHeader) info() string {
+	return fmt.Sprintf("Authentication: SPI 0x%08X, sequence number %d", h.SPI, h.SequenceNumber)
+}
@@ -0,0 +1,197 @@
+package protocols
+
+import (
+	"bytes"
+	"errors"
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// ipv6Header returns the header of an IPv6 packet from 2001:db8::1 to 2001:db8::2 with the extension headers
+func ipv6Header(next uint8, extensions []IPv6ExtensionHeader) IPv6Header {
+	return IPv6Header{
+		Version:       6,
+		NextHeader:    next,
+		HopLimit:      64,
+		SourceIP:      netip.MustParseAddr("2001:db8::1"),
+		DestinationIP: netip.MustParseAddr("2001:db8::2"),
+		Extensions:    extensions,
+	}
+}
+
+func TestIPv6ExtensionHeaders(t *testing.T) {
+	home := netip.MustParseAddr("2001:db8::99")
+	routing := &IPv6RoutingHeader{
+		NextHeader:   6,
+		RoutingType:  2,
+		SegmentsLeft: 1,
+		Data:         append([]byte{0, 0, 0, 0}, home.AsSlice()...),
+	}
+	auth := &IPv6AuthenticationHeader{NextHeader: 17, SPI: 0x1000, SequenceNumber: 7, ICV: bytes.Repeat([]byte{0xAA}, 12)}
+	udp := &UDPHeader{SourcePort: 40000, DestinationPort: 53}
+	tcp := &TCPHeader{SourcePort: 40000, DestinationPort: 443, Flags: 0x02, WindowSize: 65535}
+
+	tests := []struct {
+		name       string
+		raw        []byte
+		extensions string
+		headerLen  int
+		protocol   string
+		checksums  map[LayerType]ChecksumStatus
+	}{
+		{
+			name: "MLD report after Hop-by-Hop",
+			raw: ipFrame(t,
+				ipv6Header(IPv6ExtHopByHop, []IPv6ExtensionHeader{&IPv6HopByHopHeader{NextHeader: 58, Options: []IPv6Option{{Type: IPv6OptionRouterAlert, Data: []byte{0, 0}}}}}),
+				&ICMPv6Header{Type: ICMPv6TypeMLDv2Report},
+				&MLDMessage{Type: ICMPv6TypeMLDv2Report, Records: []MLDAddressRecord{{Type: 4, MulticastAddress: netip.MustParseAddr("ff02::fb")}}},
+			),
+			extensions: "Hop-by-Hop Options: Router Alert: MLD",
+			headerLen:  48,
+			protocol:   "icmpv6",
+			checksums:  map[LayerType]ChecksumStatus{LayerTypeICMPv6: ChecksumGood},
+		},
+		{
+			name: "TCP after Destination Options and Routing",
+			raw: ipFrame(t,
+				ipv6Header(IPv6ExtDestinationOptions, []IPv6ExtensionHeader{
+					&IPv6DestinationOptionsHeader{NextHeader: IPv6ExtRouting, Options: []IPv6Option{{Type: IPv6OptionTunnelEncapsulationLimit, Data: []byte{4}}}},
+					routing,
+				}),
+				tcp, Payload("hello"),
+			),
+			extensions: "Destination Options: Tunnel Encapsulation Limit: 4\nRouting: type 2, 1 segments left, addresses 2001:db8::99",
+			headerLen:  72,
+			protocol:   "tcp",
+			checksums:  map[LayerType]ChecksumStatus{LayerTypeTCP: ChecksumGood},
+		},
+		{
+			name:       "UDP after Authentication",
+			raw:        ipFrame(t, ipv6Header(IPv6ExtAuthentication, []IPv6ExtensionHeader{auth}), udp, Payload("query")),
+			extensions: "Authentication: SPI 0x00001000, sequence number 7",
+			headerLen:  64,
+			protocol:   "udp",
+			checksums:  map[LayerType]ChecksumStatus{LayerTypeUDP: ChecksumGood},
+		},
+		{
+			name: "first fragment",
+			raw: ipFrame(t,
+				ipv6Header(IPv6ExtFragment, []IPv6ExtensionHeader{&IPv6FragmentHeader{NextHeader: 17, MoreFragments: true, Identification: 42}}),
+				udp, Payload("query"),
+			),
+			extensions: "Fragment: identification 42, offset 0, more fragments",
+			headerLen:  48,
+			protocol:   "udp",
+			checksums:  map[LayerType]ChecksumStatus{LayerTypeUDP: ChecksumUnverified},
+		},
+		{
+			name: "following fragment",
+			raw: ipFrame(t,
+				ipv6Header(IPv6ExtFragment, []IPv6ExtensionHeader{&IPv6FragmentHeader{NextHeader: IPv6ExtHopByHop, FragmentOffset: 185, Identification: 42}}),
+				// the data could be mistaken for a Hop-by-Hop header
+				Payload{17, 0, 1, 2, 3, 4, 5, 6},
+			),
+			extensions: "Fragment: identification 42, offset 185",
+			headerLen:  48,
+		},
+		{
+			name:      "Encapsulating Security Payload",
+			raw:       ipFrame(t, ipv6Header(50, nil), Payload{0, 0, 0x10, 0, 0, 0, 0, 1, 0xde, 0xad}),
+			headerLen: 40,
+			protocol:  "esp",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ip, err := IPPacketFromBytes(tt.raw)
+			if err != nil {
+				t.Fatalf("expected no error decoding the packet - got %v", err)
+			}
+			h := ip.Header().(IPv6Header)
+			if got := h.Len(); got != tt.headerLen {
+				t.Errorf("expected headers of %d bytes - got %d", tt.headerLen, got)
+			}
+			if got := h.TransportLayerProtocol(); got != tt.protocol {
+				t.Errorf("expected upper-layer protocol %q - got %q", tt.protocol, got)
+			}
+			if !bytes.Equal(ip.Payload(), tt.raw[14+tt.headerLen:]) {
+				t.Errorf("expected the payload to follow the extension headers - got % x", ip.Payload())
+			}
+			if got := h.extensionsInfo(); got != "\n"+tt.extensions && tt.extensions != "" {
+				t.Errorf("expected extension headers %q - got %q", tt.extensions, got)
+			}
+			if serialized, err := SerializePacket(ip.(LayeredPacket), SerializeOptions{}); err != nil || !bytes.Equal(serialized, tt.raw) {
+				t.Errorf("expected the packet to serialize to\n% x\ngot (error %v)\n% x", tt.raw, err, serialized)
+			}
+
+			var p LayeredPacket
+			switch tt.protocol {
+			case "udp":
+				p, err = UDPPacketFromIPPacket(ip)
+			case "tcp":
+				p, err = TCPPacketFromIPPacket(ip)
+			case "icmpv6":
+				p, err = ICMPv6PacketFromIPPacket(ip)
+			default:
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error decoding the %s packet - got %v", tt.protocol, err)
+			}
+			if got := statuses(VerifyChecksums(p)); !reflect.DeepEqual(got, tt.checksums) {
+				t.Errorf("expected checksums %v - got %v", tt.checksums, got)
+			}
+		})
+	}
+}
+
+func TestIPv6ExtensionHeadersErrors(t *testing.T) {
+	header := func(next uint8, ext ...byte) []byte {
+		raw := make([]byte, 40, 40+len(ext))
+		raw[0], raw[6] = 0x60, next
+		return append(raw, ext...)
+	}
+
+	tests := []struct {
+		name     string
+		raw      []byte
+		expected error
+	}{
+		{name: "truncated Hop-by-Hop", raw: header(IPv6ExtHopByHop, 17, 0, 1, 0), expected: errIPv6ExtensionTruncated},
+		{name: "length beyond the packet", raw: header(IPv6ExtRouting, 17, 1, 2, 0, 0, 0, 0, 0), expected: errIPv6ExtensionTruncated},
+		{name: "option beyond the header", raw: header(IPv6ExtDestinationOptions, 17, 0, 0x1E, 8, 0, 0, 0, 0), expected: errIPv6ExtensionTruncated},
+		{name: "misaligned Authentication", raw: header(IPv6ExtAuthentication, 17, 1, 0, 0, 0, 0, 0, 1, 0, 0, 0, 1, 0, 0, 0, 0), expected: errIPv6ExtensionAligned},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := IPv6HeaderFromBytes(tt.raw); !errors.Is(err, tt.expected) {
+				t.Errorf("expected error %v - got %v", tt.expected, err)
+			}
+		})
+	}
+}
+
+func TestSerializeIPv6ExtensionHeaders(t *testing.T) {
+	hopByHop := &IPv6HopByHopHeader{NextHeader: 17, Options: []IPv6Option{{Type: IPv6OptionRouterAlert, Data: []byte{0, 0}}}}
+	raw := ipFrame(t, ipv6Header(IPv6ExtHopByHop, []IPv6ExtensionHeader{hopByHop}), &UDPHeader{SourcePort: 1, DestinationPort: 2})
+
+	// the router alert is padded with a PadN option to 8 bytes, and the payload length covers the extension header
+	expected := []byte{17, 0, IPv6OptionRouterAlert, 2, 0, 0, IPv6OptionPadN, 0}
+	if got := raw[54:62]; !bytes.Equal(got, expected) {
+		t.Errorf("expected Hop-by-Hop header % x - got % x", expected, got)
+	}
+	if raw[18] != 0 || raw[19] != 16 {
+		t.Errorf("expected payload length 16 - got % x", raw[18:20])
+	}
+
+	ip := IPv6Header{Version: 6, SourceIP: netip.IPv6Loopback(), DestinationIP: netip.IPv6Loopback(), Extensions: []IPv6ExtensionHeader{hopByHop}}
+	if _, err := ip.SerializeTo(nil, nil, SerializeOptions{}); !errors.Is(err, errIPv6ExtensionAligned) {
+		t.Errorf("expected error %v without FixLengths - got %v", errIPv6ExtensionAligned, err)
+	}
+
+	if !strings.Contains(decode(t, raw, UDPPacketFromIPPacket).IPPacket.Info(), "Hop-by-Hop Options: Router Alert: MLD") {
+		t.Error("expected the extension headers in the IPv6 details")
+	}
+}
@@ -0,0 +1,255 @@
+package protocols
+
+import (
+	"fmt"
+	"strings"
+)
+
+// LayerType identifies the protocol of a layer decoded from a packet
+type LayerType uint8
+
+const (
+	LayerTypeEthernet LayerType = iota + 1 // *EthernetFrame
+	LayerTypeVLAN                          // VLANTag, one layer for every 802.1Q or 802.1ad tag
+	LayerTypeARP                           // *ARPPacket
+	LayerTypeIPv4                          // IPv4Header
+	LayerTypeIPv6                          // IPv6Header
+	LayerTypeUDP                           // *UDPHeader
+	LayerTypeTCP                           // *TCPHeader
+	LayerTypeICMPv4                        // *ICMPHeader
+	LayerTypeICMPv6                        // *ICMPv6Header
+	LayerTypeNDP                           // *NDPMessage
+	LayerTypeMLD                           // *MLDMessage
+	LayerTypePayload                       // Payload, the bytes following the last decoded header
+)
+
+func (t LayerType) String() string {
+	switch t {
+	case LayerTypeEthernet:
+		return "Ethernet"
+	case LayerTypeVLAN:
+		return "VLAN"
+	case LayerTypeARP:
+		return "ARP"
+	case LayerTypeIPv4:
+		return "IPv4"
+	case LayerTypeIPv6:
+		return "IPv6"
+	case LayerTypeUDP:
+		return "UDP"
+	case LayerTypeTCP:
+		return "TCP"
+	case LayerTypeICMPv4:
+		return "ICMPv4"
+	case LayerTypeICMPv6:
+		return "ICMPv6"
+	case LayerTypeNDP:
+		return "NDP"
+	case LayerTypeMLD:
+		return "MLD"
+	case LayerTypePayload:
+		return "Payload"
+	default:
+		return fmt.Sprintf("LayerType(%d)", uint8(t))
+	}
+}
+
+// Layer is a protocol header decoded from a packet. Its concrete type depends on the LayerType
+type Layer interface {
+	LayerType() LayerType
+}
+
+// LayeredPacket is implemented by the decoded packets, exposing the layers they are made of
+// ordered from the link layer up, like Ethernet, VLAN, IPv4, TCP and Payload
+type LayeredPacket interface {
+	Layers() []Layer
+	// Layer returns the first layer of the given type, or nil if the packet has none
+	Layer(t LayerType) Layer
+}
+
+// Payload is the data following the last header decoded from a packet
+type Payload []byte
+
+func (Payload) LayerType() LayerType {
+	return LayerTypePayload
+}
+
+func (f *EthernetFrame) LayerType() LayerType {
+	return LayerTypeEthernet
+}
+
+func (t VLANTag) LayerType() LayerType {
+	return LayerTypeVLAN
+}
+
+func (p *ARPPacket) LayerType() LayerType {
+	return LayerTypeARP
+}
+
+func (h IPv4Header) LayerType() LayerType {
+	return LayerTypeIPv4
+}
+
+func (h IPv6Header) LayerType() LayerType {
+	return LayerTypeIPv6
+}
+
+func (h *UDPHeader) LayerType() LayerType {
+	return LayerTypeUDP
+}
+
+func (h *TCPHeader) LayerType() LayerType {
+	return LayerTypeTCP
+}
+
+func (h *ICMPHeader) LayerType() LayerType {
+	return LayerTypeICMPv4
+}
+
+func (h *ICMPv6Header) LayerType() LayerType {
+	return LayerTypeICMPv6
+}
+
+func (m *NDPMessage) LayerType() LayerType {
+	return LayerTypeNDP
+}
+
+func (m *MLDMessage) LayerType() LayerType {
+	return LayerTypeMLD
+}
+
+// frameLayers returns the layers of the Ethernet frame: the frame itself followed by its VLAN tags
+func frameLayers(f *EthernetFrame) []Layer {
+	layers := []Layer{f}
+	for _, tag := range f.VLANs {
+		layers = append(layers, tag)
+	}
+	return layers
+}
+
+// payloadLayers returns a payload layer for the data, if any
+func payloadLayers(data []byte) []Layer {
+	if len(data) == 0 {
+		return nil
+	}
+	return []Layer{Payload(data)}
+}
+
+// findLayer returns the first of the layers with the given type, or nil
+func findLayer(layers []Layer, t LayerType) Layer {
+	for _, l := range layers {
+		if l.LayerType() == t {
+			return l
+		}
+	}
+	return nil
+}
+
+// LayerNames returns the types of the packet's layers, joined by slashes: "Ethernet/IPv4/UDP/Payload"
+func LayerNames(p LayeredPacket) string {
+	layers := p.Layers()
+	names := make([]string, len(layers))
+	for i, l := range layers {
+		names[i] = l.LayerType().String()
+	}
+	return strings.Join(names, "/")
+}
+
+func (p *ARPPacket) Layers() []Layer {
+	return append(frameLayers(&p.EthFrame), p)
+}
+
+func (p *ARPPacket) Layer(t LayerType) Layer {
+	return findLayer(p.Layers(), t)
+}
+
+func (p *IPv4Packet) Layers() []Layer {
+	return append(append(frameLayers(&p.EthFrame), p.IPv4Header), payloadLayers(p.payload)...)
+}
+
+func (p *IPv4Packet) Layer(t LayerType) Layer {
+	return findLayer(p.Layers(), t)
+}
+
+func (p *IPv6Packet) Layers() []Layer {
+	return append(append(frameLayers(&p.EthFrame), p.IPv6Header), payloadLayers(p.payload)...)
+}
+
+func (p *IPv6Packet) Layer(t LayerType) Layer {
+	return findLayer(p.Layers(), t)
+}
+
+// ipLayers returns the layers of the IP packet up to its header, without its payload.
+// Only the IP header is known for packets not exposing their layers
+func ipLayers(ip IPPacket) []Layer {
+	lp, ok := ip.(LayeredPacket)
+	if !ok {
+		if l, ok := ip.Header().(Layer); ok {
+			return []Layer{l}
+		}
+		return nil
+	}
+
+	layers := lp.Layers()
+	if n := len(layers); n > 0 && layers[n-1].LayerType() == LayerTypePayload {
+		layers = layers[:n-1]
+	}
+	return layers
+}
+
+func (p *UDPPacket) Layers() []Layer {
+	layers := append(ipLayers(p.IPPacket), &p.Header)
+	if payload := p.IPPacket.Payload(); len(payload) > 8 {
+		layers = append(layers, payloadLayers(payload[8:])...)
+	}
+	return layers
+}
+
+func (p *UDPPacket) Layer(t LayerType) Layer {
+	return findLayer(p.Layers(), t)
+}
+
+func (p *TCPPacket) Layers() []Layer {
+	layers := append(ipLayers(p.IPPacket), &p.Header)
+	if payload, hLen := p.IPPacket.Payload(), int(p.Header.RawOffset)*4; len(payload) > hLen {
+		layers = append(layers, payloadLayers(payload[hLen:])...)
+	}
+	return layers
+}
+
+func (p *TCPPacket) Layer(t LayerType) Layer {
+	return findLayer(p.Layers(), t)
+}
+
+func (p *ICMPPacket) Layers() []Layer {
+	layers := append(ipLayers(p.IPPacket), &p.Header)
+	if payload := p.IPPacket.Payload(); len(payload) > 8 {
+		layers = append(layers, payloadLayers(payload[8:])...)
+	}
+	return layers
+}
+
+func (p *ICMPPacket) Layer(t LayerType) Layer {
+	return findLayer(p.Layers(), t)
+}
+
+// Layers returns the layers of the ICMPv6 message: its header is followed by the NDP or MLD message,
+// or by the data of the other messages
+func (p *ICMPv6Packet) Layers() []Layer {
+	layers := append(ipLayers(p.IPPacket), &p.Header)
+	switch {
+	case p.NDP != nil:
+		layers = append(layers, p.NDP)
+	case p.MLD != nil:
+		layers = append(layers, p.MLD)
+	default:
+		if payload := p.IPPacket.Payload(); len(payload) > 8 {
+			layers = append(layers, payloadLayers(payload[8:])...)
+		}
+	}
+	return layers
+}
+
+func (p *ICMPv6Packet) Layer(t LayerType) Layer {
+	return findLayer(p.Layers(), t)
+}
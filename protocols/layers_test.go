@@ -0,0 +1,90 @@
+package protocols
+
+import (
+	"bytes"
+	"net/netip"
+	"testing"
+)
+
+func TestLayers(t *testing.T) {
+	udp := mustSerialize(t,
+		&EthernetFrame{DestinationMAC: testDstMAC, SourceMAC: testSrcMAC, EtherType: 0x0800, VLANs: []VLANTag{{TPID: 0x8100, VID: 42}}},
+		IPv4Header{Version: 4, TTL: 64, Protocol: 17, SourceIP: netip.MustParseAddr("192.168.0.104"), DestinationIP: netip.MustParseAddr("192.168.0.1")},
+		&UDPHeader{SourcePort: 1234, DestinationPort: 53},
+		Payload("abc"),
+	)
+	tcp := ipFrame(t,
+		IPv6Header{Version: 6, NextHeader: 6, HopLimit: 64, SourceIP: netip.MustParseAddr("fe80::1"), DestinationIP: netip.MustParseAddr("fe80::2")},
+		&TCPHeader{SourcePort: 40000, DestinationPort: 443, Flags: 0x02, Options: []TCPOption{{Kind: TCPOptionMSS, Data: []byte{0x05, 0xb4}}}},
+	)
+	arp := []byte{
+		// Ethernet Frame
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x00, 0x1a, 0x2b, 0x3c, 0x4d, 0x5e, 0x08, 0x06,
+		// ARP Packet
+		0x00, 0x01, 0x08, 0x00, 0x06, 0x04, 0x00, 0x01,
+		0x00, 0x1a, 0x2b, 0x3c, 0x4d, 0x5e, 0xc0, 0xa8, 0x01, 0x01,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xc0, 0xa8, 0x01, 0x02,
+	}
+
+	ipPacket := func(raw []byte) IPPacket {
+		p, err := IPPacketFromBytes(raw)
+		if err != nil {
+			t.Fatalf("expected no error - got %v", err)
+		}
+		return p
+	}
+	udpPacket, err := UDPPacketFromIPPacket(ipPacket(udp))
+	if err != nil {
+		t.Fatalf("expected no error - got %v", err)
+	}
+	tcpPacket, err := TCPPacketFromIPPacket(ipPacket(tcp))
+	if err != nil {
+		t.Fatalf("expected no error - got %v", err)
+	}
+	arpPacket, err := ARPPacketFromBytes(arp)
+	if err != nil {
+		t.Fatalf("expected no error - got %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		packet   LayeredPacket
+		expected string
+	}{
+		{name: "UDP over IPv4 with VLAN", packet: udpPacket, expected: "Ethernet/VLAN/IPv4/UDP/Payload"},
+		{name: "TCP over IPv6 without payload", packet: tcpPacket, expected: "Ethernet/IPv6/TCP"},
+		{name: "IPv4", packet: ipPacket(udp).(LayeredPacket), expected: "Ethernet/VLAN/IPv4/Payload"},
+		{name: "ARP", packet: arpPacket, expected: "Ethernet/ARP"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := LayerNames(tt.packet); got != tt.expected {
+				t.Errorf("expected layers to be %s - got %s", tt.expected, got)
+			}
+		})
+	}
+
+	// layers are returned with their concrete types
+	if h, ok := udpPacket.Layer(LayerTypeUDP).(*UDPHeader); !ok || h.SourcePort != 1234 {
+		t.Errorf("expected the UDP layer to be the UDP header - got %v", udpPacket.Layer(LayerTypeUDP))
+	}
+	if tag, ok := udpPacket.Layer(LayerTypeVLAN).(VLANTag); !ok || tag.VID != 42 {
+		t.Errorf("expected the VLAN layer to be the 802.1Q tag - got %v", udpPacket.Layer(LayerTypeVLAN))
+	}
+	if h, ok := udpPacket.Layer(LayerTypeIPv4).(IPHeader); !ok || h.Source() != "192.168.0.104" {
+		t.Errorf("expected the IPv4 layer to be the IPv4 header - got %v", udpPacket.Layer(LayerTypeIPv4))
+	}
+	if payload, ok := udpPacket.Layer(LayerTypePayload).(Payload); !ok || !bytes.Equal(payload, []byte("abc")) {
+		t.Errorf("expected the payload layer to be %q - got %v", "abc", udpPacket.Layer(LayerTypePayload))
+	}
+	if h, ok := tcpPacket.Layer(LayerTypeTCP).(*TCPHeader); !ok || h.DestinationPort != 443 {
+		t.Errorf("expected the TCP layer to be the TCP header - got %v", tcpPacket.Layer(LayerTypeTCP))
+	}
+	if l := udpPacket.Layer(LayerTypeTCP); l != nil {
+		t.Errorf("expected no TCP layer in a UDP packet - got %v", l)
+	}
+	if f, ok := arpPacket.Layer(LayerTypeEthernet).(*EthernetFrame); !ok || f != arpPacket.Frame() {
+		t.Errorf("expected the Ethernet layer to be the packet's frame - got %v", arpPacket.Layer(LayerTypeEthernet))
+	}
+}
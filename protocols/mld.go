@@ -0,0 +1,142 @@
+package protocols
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+var ErrInvalidMLDMessage = errors.New("invalid MLD message")
+
+// MLDMessage is a Multicast Listener Discovery message, version 1 (RFC 2710) or 2 (RFC 3810), carried by ICMPv6.
+// Which fields are meaningful depends on the type and version of the message
+type MLDMessage struct {
+	// Type is the ICMPv6 type of the message
+	Type uint8
+	// Version is 2 for the reports of type ICMPv6TypeMLDv2Report and the queries longer than the MLDv1 ones, 1 otherwise
+	Version uint8
+	// MaxResponseDelay of a query is in milliseconds for MLDv1 and encoded as the Maximum Response Code for MLDv2
+	MaxResponseDelay uint16
+	// MulticastAddress is the address being queried, reported or left. It is unspecified for general queries
+	MulticastAddress netip.Addr
+	// Flags (suppress router-side processing and querier's robustness variable), QQIC and Sources
+	// belong to MLDv2 queries
+	Flags   uint8
+	QQIC    uint8
+	Sources []netip.Addr
+	// Records are the multicast address records of an MLDv2 report
+	Records []MLDAddressRecord
+}
+
+// MLDAddressRecord reports the sources a listener is interested in for a multicast address
+type MLDAddressRecord struct {
+	// Type is the record type, like MODE_IS_INCLUDE (1) or CHANGE_TO_EXCLUDE_MODE (4)
+	Type             uint8
+	MulticastAddress netip.Addr
+	Sources          []netip.Addr
+	AuxData          []byte
+}
+
+var mldRecordTypeNames = map[uint8]string{
+	1: "MODE_IS_INCLUDE",
+	2: "MODE_IS_EXCLUDE",
+	3: "CHANGE_TO_INCLUDE_MODE",
+	4: "CHANGE_TO_EXCLUDE_MODE",
+	5: "ALLOW_NEW_SOURCES",
+	6: "BLOCK_OLD_SOURCES",
+}
+
+// mldMessageFromBytes decodes the MLD message following the first 8 bytes of the ICMPv6 header
+func mldMessageFromBytes(h *ICMPv6Header, body []byte) (*MLDMessage, error) {
+	m := &MLDMessage{Type: h.Type, Version: 1}
+	if h.Type == ICMPv6TypeMLDv2Report {
+		m.Version = 2
+		records := int(binary.BigEndian.Uint16(h.RestOfHeader[2:4]))
+		for i := 0; i < records; i++ {
+			if len(body) < 20 {
+				return nil, fmt.Errorf("%w: truncated address record", ErrInvalidMLDMessage)
+			}
+			r := MLDAddressRecord{Type: body[0], MulticastAddress: netip.AddrFrom16([16]byte(body[4:20]))}
+			sources, aux := int(binary.BigEndian.Uint16(body[2:4])), int(body[1])*4
+			if len(body) < 20+sources*16+aux {
+				return nil, fmt.Errorf("%w: truncated address record", ErrInvalidMLDMessage)
+			}
+			r.Sources = mldSources(body[20:], sources)
+			r.AuxData = body[20+sources*16 : 20+sources*16+aux]
+			m.Records = append(m.Records, r)
+			body = body[20+sources*16+aux:]
+		}
+		return m, nil
+	}
+
+	if len(body) < 16 {
+		return nil, fmt.Errorf("%w: message must be at least 24 bytes", ErrInvalidMLDMessage)
+	}
+	m.MulticastAddress = netip.AddrFrom16([16]byte(body[0:16]))
+	if h.Type != ICMPv6TypeMLDQuery {
+		return m, nil
+	}
+	m.MaxResponseDelay = binary.BigEndian.Uint16(h.RestOfHeader[0:2])
+	// MLDv2 queries are at least 28 bytes long
+	if len(body) >= 20 {
+		m.Version = 2
+		m.Flags = body[16]
+		m.QQIC = body[17]
+		sources := int(binary.BigEndian.Uint16(body[18:20]))
+		if len(body) < 20+sources*16 {
+			return nil, fmt.Errorf("%w: truncated query sources", ErrInvalidMLDMessage)
+		}
+		m.Sources = mldSources(body[20:], sources)
+	}
+	return m, nil
+}
+
+// mldSources returns the n addresses at the beginning of the bytes
+func mldSources(raw []byte, n int) []netip.Addr {
+	if n == 0 {
+		return nil
+	}
+	sources := make([]netip.Addr, n)
+	for i := range sources {
+		sources[i] = netip.AddrFrom16([16]byte(raw[i*16 : i*16+16]))
+	}
+	return sources
+}
+
+// info returns the lines describing the message fields
+func (m MLDMessage) info() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "\nMLD Version: %d", m.Version)
+	switch {
+	case m.Type == ICMPv6TypeMLDv2Report:
+		for _, r := range m.Records {
+			name, ok := mldRecordTypeNames[r.Type]
+			if !ok {
+				name = "Unknown"
+			}
+			fmt.Fprintf(&sb, "\nRecord: %s %s", name, r.MulticastAddress)
+			if len(r.Sources) > 0 {
+				fmt.Fprintf(&sb, " sources %s", joinAddrs(r.Sources))
+			}
+		}
+	case m.Type == ICMPv6TypeMLDQuery:
+		fmt.Fprintf(&sb, "\nMulticast Address: %s\nMax Response Delay: %d", m.MulticastAddress, m.MaxResponseDelay)
+		if len(m.Sources) > 0 {
+			fmt.Fprintf(&sb, "\nSources: %s", joinAddrs(m.Sources))
+		}
+	default:
+		fmt.Fprintf(&sb, "\nMulticast Address: %s", m.MulticastAddress)
+	}
+	return sb.String()
+}
+
+// joinAddrs returns the addresses separated by commas
+func joinAddrs(addrs []netip.Addr) string {
+	s := make([]string, len(addrs))
+	for i, a := range addrs {
+		s[i] = a.String()
+	}
+	return strings.Join(s, ", ")
+}
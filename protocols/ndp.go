@@ -0,0 +1,235 @@
+package protocols
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+)
+
+// NDP option types
+const (
+	NDPOptionSourceLinkLayerAddress = 1
+	NDPOptionTargetLinkLayerAddress = 2
+	NDPOptionPrefixInformation      = 3
+	NDPOptionRedirectedHeader       = 4
+	NDPOptionMTU                    = 5
+)
+
+// Flags of the router and neighbor advertisements
+const (
+	NDPFlagManaged   = 0x80 // router advertisement: addresses are available via DHCPv6
+	NDPFlagOther     = 0x40 // router advertisement: other configuration is available via DHCPv6
+	NDPFlagRouter    = 0x80 // neighbor advertisement: the sender is a router
+	NDPFlagSolicited = 0x40 // neighbor advertisement: sent in response to a neighbor solicitation
+	NDPFlagOverride  = 0x20 // neighbor advertisement: the cached link-layer address must be updated
+)
+
+var ErrInvalidNDPMessage = errors.New("invalid NDP message")
+
+// NDPMessage is a Neighbor Discovery message (RFC 4861), the IPv6 counterpart of ARP, carried by ICMPv6.
+// Which fields are meaningful depends on the type of the message
+type NDPMessage struct {
+	// Type is the ICMPv6 type of the message, between ICMPv6TypeRouterSolicitation and ICMPv6TypeRedirect
+	Type uint8
+	// CurHopLimit, RouterLifetime (seconds), ReachableTime and RetransTimer (milliseconds) are announced by routers
+	CurHopLimit    uint8
+	RouterLifetime uint16
+	ReachableTime  uint32
+	RetransTimer   uint32
+	// Flags are the NDPFlag* bits of the router and neighbor advertisements
+	Flags uint8
+	// TargetAddress is the address being resolved by a neighbor solicitation, the one a neighbor advertisement is for,
+	// or the better first hop of a redirect
+	TargetAddress netip.Addr
+	// DestinationAddress is the address redirected to the target
+	DestinationAddress netip.Addr
+	Options            []NDPOption
+}
+
+// NDPOption is an option of an NDP message
+type NDPOption struct {
+	Type uint8
+	// Data contains the bytes following the type and the length, in units of 8 bytes, of the option
+	Data []byte
+}
+
+// NDPPrefixInfo is the content of a prefix information option, announcing a prefix of the link
+type NDPPrefixInfo struct {
+	Prefix netip.Prefix
+	// OnLink reports whether the addresses of the prefix are reachable without a router
+	OnLink bool
+	// Autonomous reports whether the prefix can be used for stateless address autoconfiguration
+	Autonomous bool
+	// ValidLifetime and PreferredLifetime are in seconds, 0xFFFFFFFF meaning infinity
+	ValidLifetime     uint32
+	PreferredLifetime uint32
+}
+
+// ndpMessageFromBytes decodes the NDP message following the first 8 bytes of the ICMPv6 header
+func ndpMessageFromBytes(h *ICMPv6Header, body []byte) (*NDPMessage, error) {
+	m := &NDPMessage{Type: h.Type}
+	switch h.Type {
+	case ICMPv6TypeRouterAdvertisement:
+		if len(body) < 8 {
+			return nil, fmt.Errorf("%w: router advertisement must be at least 16 bytes", ErrInvalidNDPMessage)
+		}
+		m.CurHopLimit = h.RestOfHeader[0]
+		m.Flags = h.RestOfHeader[1]
+		m.RouterLifetime = binary.BigEndian.Uint16(h.RestOfHeader[2:4])
+		m.ReachableTime = binary.BigEndian.Uint32(body[0:4])
+		m.RetransTimer = binary.BigEndian.Uint32(body[4:8])
+		body = body[8:]
+	case ICMPv6TypeNeighborSolicitation, ICMPv6TypeNeighborAdvertisement:
+		if len(body) < 16 {
+			return nil, fmt.Errorf("%w: neighbor message must be at least 24 bytes", ErrInvalidNDPMessage)
+		}
+		if h.Type == ICMPv6TypeNeighborAdvertisement {
+			m.Flags = h.RestOfHeader[0]
+		}
+		m.TargetAddress = netip.AddrFrom16([16]byte(body[0:16]))
+		body = body[16:]
+	case ICMPv6TypeRedirect:
+		if len(body) < 32 {
+			return nil, fmt.Errorf("%w: redirect must be at least 40 bytes", ErrInvalidNDPMessage)
+		}
+		m.TargetAddress = netip.AddrFrom16([16]byte(body[0:16]))
+		m.DestinationAddress = netip.AddrFrom16([16]byte(body[16:32]))
+		body = body[32:]
+	}
+
+	for len(body) > 0 {
+		if len(body) < 2 {
+			return nil, fmt.Errorf("%w: truncated option", ErrInvalidNDPMessage)
+		}
+		// the length of a valid option is never zero
+		length := int(body[1]) * 8
+		if length == 0 || length > len(body) {
+			return nil, fmt.Errorf("%w: option %d has invalid length %d", ErrInvalidNDPMessage, body[0], body[1])
+		}
+		m.Options = append(m.Options, NDPOption{Type: body[0], Data: body[2:length]})
+		body = body[length:]
+	}
+	return m, nil
+}
+
+// Option returns the first option of the given type, if any
+func (m NDPMessage) Option(t uint8) (NDPOption, bool) {
+	for _, o := range m.Options {
+		if o.Type == t {
+			return o, true
+		}
+	}
+	return NDPOption{}, false
+}
+
+// LinkLayerAddress returns the address carried by a source or target link-layer address option
+func (o NDPOption) LinkLayerAddress() (net.HardwareAddr, bool) {
+	if o.Type != NDPOptionSourceLinkLayerAddress && o.Type != NDPOptionTargetLinkLayerAddress || len(o.Data) < 6 {
+		return nil, false
+	}
+	return net.HardwareAddr(o.Data[:6]), true
+}
+
+// PrefixInfo returns the content of a prefix information option
+func (o NDPOption) PrefixInfo() (NDPPrefixInfo, bool) {
+	if o.Type != NDPOptionPrefixInformation || len(o.Data) != 30 {
+		return NDPPrefixInfo{}, false
+	}
+	prefix := netip.PrefixFrom(netip.AddrFrom16([16]byte(o.Data[14:30])), int(o.Data[0]))
+	if !prefix.IsValid() {
+		return NDPPrefixInfo{}, false
+	}
+	return NDPPrefixInfo{
+		Prefix:            prefix,
+		OnLink:            o.Data[1]&0x80 != 0,
+		Autonomous:        o.Data[1]&0x40 != 0,
+		ValidLifetime:     binary.BigEndian.Uint32(o.Data[2:6]),
+		PreferredLifetime: binary.BigEndian.Uint32(o.Data[6:10]),
+	}, true
+}
+
+// MTU returns the MTU of the link carried by an MTU option
+func (o NDPOption) MTU() (uint32, bool) {
+	if o.Type != NDPOptionMTU || len(o.Data) != 6 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint32(o.Data[2:6]), true
+}
+
+// String returns a human-readable description of the option
+func (o NDPOption) String() string {
+	switch o.Type {
+	case NDPOptionSourceLinkLayerAddress, NDPOptionTargetLinkLayerAddress:
+		name := "Source"
+		if o.Type == NDPOptionTargetLinkLayerAddress {
+			name = "Target"
+		}
+		if addr, ok := o.LinkLayerAddress(); ok {
+			return fmt.Sprintf("%s Link-Layer Address: %s", name, addr)
+		}
+	case NDPOptionPrefixInformation:
+		if info, ok := o.PrefixInfo(); ok {
+			var flags []string
+			if info.OnLink {
+				flags = append(flags, "on-link")
+			}
+			if info.Autonomous {
+				flags = append(flags, "autonomous")
+			}
+			return fmt.Sprintf("Prefix: %s [%s] (valid %s, preferred %s)",
+				info.Prefix, strings.Join(flags, ", "), ndpLifetime(info.ValidLifetime), ndpLifetime(info.PreferredLifetime))
+		}
+	case NDPOptionRedirectedHeader:
+		return fmt.Sprintf("Redirected Header: %d bytes", max(len(o.Data)-6, 0))
+	case NDPOptionMTU:
+		if mtu, ok := o.MTU(); ok {
+			return fmt.Sprintf("MTU: %d", mtu)
+		}
+	}
+	return fmt.Sprintf("Option %d: % x", o.Type, o.Data)
+}
+
+// ndpLifetime formats a lifetime in seconds
+func ndpLifetime(seconds uint32) string {
+	if seconds == 0xFFFFFFFF {
+		return "infinite"
+	}
+	return fmt.Sprintf("%ds", seconds)
+}
+
+// info returns the lines describing the message fields and its options
+func (m NDPMessage) info() string {
+	var sb strings.Builder
+	switch m.Type {
+	case ICMPv6TypeRouterAdvertisement:
+		fmt.Fprintf(&sb, "\nCur Hop Limit: %d\nFlags: %s\nRouter Lifetime: %ds\nReachable Time: %dms\nRetrans Timer: %dms",
+			m.CurHopLimit, ndpFlags(m.Flags, "managed", "other"), m.RouterLifetime, m.ReachableTime, m.RetransTimer)
+	case ICMPv6TypeNeighborSolicitation:
+		fmt.Fprintf(&sb, "\nTarget Address: %s", m.TargetAddress)
+	case ICMPv6TypeNeighborAdvertisement:
+		fmt.Fprintf(&sb, "\nTarget Address: %s\nFlags: %s", m.TargetAddress, ndpFlags(m.Flags, "router", "solicited", "override"))
+	case ICMPv6TypeRedirect:
+		fmt.Fprintf(&sb, "\nTarget Address: %s\nDestination Address: %s", m.TargetAddress, m.DestinationAddress)
+	}
+	for _, o := range m.Options {
+		sb.WriteString("\n" + o.String())
+	}
+	return sb.String()
+}
+
+// ndpFlags returns the names of the flags set, from the most significant bit down
+func ndpFlags(flags uint8, names ...string) string {
+	var set []string
+	for i, name := range names {
+		if flags&(0x80>>i) != 0 {
+			set = append(set, name)
+		}
+	}
+	if len(set) == 0 {
+		return "none"
+	}
+	return strings.Join(set, ", ")
+}
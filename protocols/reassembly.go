@@ -0,0 +1,407 @@
+package protocols
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+)
+
+// defaults of the ReassemblyOptions
+const (
+	DefaultReassemblyTimeout  = 30 * time.Second
+	DefaultReassemblyMaxBytes = 4 << 20
+)
+
+// maxDatagramLen is the maximum length of a reassembled IPv4 packet, or of the payload of an IPv6 one
+const maxDatagramLen = 0xFFFF
+
+var (
+	ErrInvalidFragment  = errors.New("invalid IP fragment")
+	ErrFragmentOverlap  = errors.New("IP fragment overlaps another one with different data")
+	ErrFragmentTooLarge = errors.New("reassembled IP packet exceeds 65535 bytes")
+)
+
+// ReassemblyOptions tunes the resources used by a Reassembler
+type ReassemblyOptions struct {
+	// Timeout is how long the fragments of a packet are kept since the first one arrived,
+	// DefaultReassemblyTimeout if zero
+	Timeout time.Duration
+	// MaxBytes caps the fragment data kept for all the incomplete packets, DefaultReassemblyMaxBytes if zero:
+	// the oldest packets are discarded to make room for new fragments
+	MaxBytes int
+}
+
+// ReassemblyStats counts the packets handled by a Reassembler
+type ReassemblyStats struct {
+	Reassembled uint64 // packets reassembled from their fragments
+	TimedOut    uint64 // incomplete packets discarded when their timeout expired
+	Evicted     uint64 // incomplete packets discarded to respect MaxBytes
+	Overlapping uint64 // packets discarded because of fragments overlapping with different data
+}
+
+// Reassembler rebuilds the IPv4 and IPv6 packets fragmented along their path.
+// Fragments are grouped by source, destination, protocol and identification, and kept until the whole packet
+// has arrived: the bytes of the fragments passed to it must not be reused until then. It is safe for concurrent use,
+// so the fragments of a packet captured by different sockets can be reassembled together
+type Reassembler struct {
+	opts ReassemblyOptions
+
+	mu        sync.Mutex
+	packets   map[fragmentKey]*fragmentedPacket
+	buffered  int       // bytes of fragment data kept
+	nextCheck time.Time // earliest expiration of the incomplete packets
+	stats     ReassemblyStats
+}
+
+// fragmentKey identifies the fragments of the same packet. IPv6 fragments are not keyed by protocol,
+// as the headers preceding the fragmentable part may differ between them (RFC 8200)
+type fragmentKey struct {
+	src, dst netip.Addr
+	protocol uint8
+	id       uint32
+}
+
+// fragment is the data carried by a fragment, at its offset in bytes from the start of the fragmentable part
+type fragment struct {
+	offset int
+	data   []byte
+}
+
+func (f fragment) end() int {
+	return f.offset + len(f.data)
+}
+
+// fragmentedPacket collects the fragments of a packet
+type fragmentedPacket struct {
+	first     IPPacket // the fragment at offset 0, whose headers are kept, nil until it arrives
+	fragments []fragment
+	length    int // of the fragmentable part, -1 until the last fragment arrives
+	size      int // bytes of fragment data kept
+	arrival   time.Time
+}
+
+// NewReassembler returns a Reassembler using the options, replacing their zero values with the defaults
+func NewReassembler(opts ReassemblyOptions) *Reassembler {
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultReassemblyTimeout
+	}
+	if opts.MaxBytes <= 0 {
+		opts.MaxBytes = DefaultReassemblyMaxBytes
+	}
+	return &Reassembler{
+		opts:    opts,
+		packets: make(map[fragmentKey]*fragmentedPacket),
+	}
+}
+
+// Reassemble handles an IP packet captured at the given time. Packets which are not fragments are returned as they are,
+// while the fragments are kept until the last one of their packet arrives: the reassembled packet is then returned,
+// with the Ethernet frame and the headers of the first fragment, and nil before.
+// A fragment overlapping another one with different data discards the whole packet, returning ErrFragmentOverlap
+func (r *Reassembler) Reassemble(ip IPPacket, ts time.Time) (IPPacket, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.expire(ts)
+
+	key, f, ok, err := fragmentOf(ip)
+	if err != nil || !ok {
+		return ip, err
+	}
+	for r.buffered+len(f.data) > r.opts.MaxBytes && len(r.packets) > 0 {
+		r.discard(r.oldest())
+		r.stats.Evicted++
+	}
+	if r.buffered+len(f.data) > r.opts.MaxBytes {
+		r.stats.Evicted++
+		return nil, nil
+	}
+
+	p, ok := r.packets[key]
+	if !ok {
+		p = &fragmentedPacket{length: -1, arrival: ts}
+		r.packets[key] = p
+		if r.nextCheck.IsZero() || ts.Add(r.opts.Timeout).Before(r.nextCheck) {
+			r.nextCheck = ts.Add(r.opts.Timeout)
+		}
+	}
+
+	more := isMoreFragments(ip)
+	size := p.size
+	if err := p.add(f, more); err != nil {
+		r.discard(key)
+		if errors.Is(err, ErrFragmentOverlap) {
+			r.stats.Overlapping++
+		}
+		return nil, err
+	}
+	r.buffered += p.size - size
+	if f.offset == 0 && p.first == nil {
+		p.first = ip
+	}
+
+	data, ok := p.reassemble()
+	if !ok {
+		return nil, nil
+	}
+	r.discard(key)
+
+	packet, err := rebuildPacket(p.first, data, len(p.fragments))
+	if err != nil {
+		return nil, err
+	}
+	r.stats.Reassembled++
+	return packet, nil
+}
+
+// Stats returns the counters of the packets handled so far
+func (r *Reassembler) Stats() ReassemblyStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.stats
+}
+
+// Pending returns the number of packets whose fragments have not all arrived yet
+func (r *Reassembler) Pending() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return len(r.packets)
+}
+
+// expire discards the incomplete packets whose first fragment arrived more than Timeout before ts
+func (r *Reassembler) expire(ts time.Time) {
+	if len(r.packets) == 0 || ts.Before(r.nextCheck) {
+		return
+	}
+
+	r.nextCheck = time.Time{}
+	for key, p := range r.packets {
+		deadline := p.arrival.Add(r.opts.Timeout)
+		if !ts.Before(deadline) {
+			r.discard(key)
+			r.stats.TimedOut++
+			continue
+		}
+		if r.nextCheck.IsZero() || deadline.Before(r.nextCheck) {
+			r.nextCheck = deadline
+		}
+	}
+}
+
+// oldest returns the key of the incomplete packet whose first fragment arrived first
+func (r *Reassembler) oldest() fragmentKey {
+	var oldest fragmentKey
+	var arrival time.Time
+	for key, p := range r.packets {
+		if arrival.IsZero() || p.arrival.Before(arrival) {
+			oldest, arrival = key, p.arrival
+		}
+	}
+	return oldest
+}
+
+func (r *Reassembler) discard(key fragmentKey) {
+	if p, ok := r.packets[key]; ok {
+		r.buffered -= p.size
+		delete(r.packets, key)
+	}
+}
+
+// add stores the fragment, unless another one already carries all its data.
+// Fragments overlapping with the same data are retransmissions, while different data is an error
+func (p *fragmentedPacket) add(f fragment, more bool) error {
+	if more && len(f.data)%8 != 0 {
+		return fmt.Errorf("%w: %d bytes not multiple of 8 before the last fragment", ErrInvalidFragment, len(f.data))
+	}
+	if !more {
+		if p.length >= 0 && p.length != f.end() {
+			return fmt.Errorf("%w: last fragments ending at %d and %d", ErrInvalidFragment, p.length, f.end())
+		}
+		p.length = f.end()
+	}
+
+	for _, stored := range append(p.fragments, f) {
+		if p.length >= 0 && stored.end() > p.length {
+			return fmt.Errorf("%w: fragment ending at %d after the last one", ErrInvalidFragment, stored.end())
+		}
+	}
+	for _, stored := range p.fragments {
+		start, end := max(f.offset, stored.offset), min(f.end(), stored.end())
+		if start >= end {
+			continue
+		}
+		if string(f.data[start-f.offset:end-f.offset]) != string(stored.data[start-stored.offset:end-stored.offset]) {
+			return ErrFragmentOverlap
+		}
+		if start == f.offset && end == f.end() {
+			// a duplicate
+			return nil
+		}
+	}
+
+	p.fragments = append(p.fragments, f)
+	p.size += len(f.data)
+	return nil
+}
+
+// reassemble returns the fragmentable part of the packet, or false if some fragments are still missing
+func (p *fragmentedPacket) reassemble() ([]byte, bool) {
+	if p.first == nil || p.length < 0 {
+		return nil, false
+	}
+
+	slices.SortFunc(p.fragments, func(a, b fragment) int {
+		return a.offset - b.offset
+	})
+	covered := 0
+	for _, f := range p.fragments {
+		if f.offset > covered {
+			return nil, false
+		}
+		covered = max(covered, f.end())
+	}
+	if covered < p.length {
+		return nil, false
+	}
+
+	data := make([]byte, p.length)
+	for _, f := range p.fragments {
+		copy(data[f.offset:], f.data)
+	}
+	return data, true
+}
+
+// isMoreFragments reports whether the fragment is followed by others
+func isMoreFragments(ip IPPacket) bool {
+	switch h := ip.Header().(type) {
+	case IPv4Header:
+		return h.MoreFragments()
+	case IPv6Header:
+		return h.FragmentHeader().MoreFragments
+	default:
+		return false
+	}
+}
+
+// fragmentOf returns the key and the data of a fragment, or false if the packet is not fragmented.
+// Its data is the payload declared by the header, leaving out the padding of the Ethernet frame
+func fragmentOf(ip IPPacket) (fragmentKey, fragment, bool, error) {
+	switch h := ip.Header().(type) {
+	case IPv4Header:
+		if !h.MoreFragments() && h.FragmentOffset == 0 {
+			return fragmentKey{}, fragment{}, false, nil
+		}
+		n := int(h.TotalLength) - h.Len()
+		if n < 0 || len(ip.Payload()) < n {
+			return fragmentKey{}, fragment{}, false, fmt.Errorf("%w: truncated to %d bytes", ErrInvalidFragment, len(ip.Payload()))
+		}
+		key := fragmentKey{src: h.SourceIP, dst: h.DestinationIP, protocol: h.Protocol, id: uint32(h.Identification)}
+		f := fragment{offset: int(h.FragmentOffset) * 8, data: ip.Payload()[:n]}
+		if f.end() > maxDatagramLen-h.Len() {
+			return fragmentKey{}, fragment{}, false, ErrFragmentTooLarge
+		}
+		return key, f, true, nil
+	case IPv6Header:
+		i := slices.IndexFunc(h.Extensions, func(ext IPv6ExtensionHeader) bool {
+			return ext.Type() == IPv6ExtFragment
+		})
+		if i < 0 {
+			return fragmentKey{}, fragment{}, false, nil
+		}
+		f := h.Extensions[i].(*IPv6FragmentHeader)
+		// an atomic fragment is a whole packet (RFC 6946)
+		if !f.MoreFragments && f.FragmentOffset == 0 {
+			return fragmentKey{}, fragment{}, false, nil
+		}
+
+		// the extension headers following the fragment one belong to the fragmentable part
+		var data []byte
+		for _, ext := range h.Extensions[i+1:] {
+			b, err := ext.serialize(false)
+			if err != nil {
+				return fragmentKey{}, fragment{}, false, err
+			}
+			data = append(data, b...)
+		}
+		data = append(data, ip.Payload()...)
+
+		n := int(h.PayloadLength) - ipv6ExtensionsLen(h.Extensions[:i+1])
+		if n < 0 || len(data) < n {
+			return fragmentKey{}, fragment{}, false, fmt.Errorf("%w: truncated to %d bytes", ErrInvalidFragment, len(data))
+		}
+		key := fragmentKey{src: h.SourceIP, dst: h.DestinationIP, protocol: IPv6ExtFragment, id: f.Identification}
+		frag := fragment{offset: int(f.FragmentOffset) * 8, data: data[:n]}
+		if frag.end() > maxDatagramLen-ipv6ExtensionsLen(h.Extensions[:i]) {
+			return fragmentKey{}, fragment{}, false, ErrFragmentTooLarge
+		}
+		return key, frag, true, nil
+	default:
+		return fragmentKey{}, fragment{}, false, nil
+	}
+}
+
+// ipv6ExtensionsLen returns the length in bytes of the extension headers
+func ipv6ExtensionsLen(extensions []IPv6ExtensionHeader) int {
+	length := 0
+	for _, ext := range extensions {
+		length += ext.Len()
+	}
+	return length
+}
+
+// rebuildPacket decodes the packet made of the headers of the first fragment and the reassembled data,
+// carried by a copy of the first fragment's Ethernet frame
+func rebuildPacket(first IPPacket, data []byte, fragments int) (IPPacket, error) {
+	switch h := first.Header().(type) {
+	case IPv4Header:
+		h.Flags &^= IPv4FlagMF
+		h.FragmentOffset = 0
+		h.TotalLength = uint16(h.Len() + len(data))
+		raw, err := h.SerializeTo(data, nil, SerializeOptions{ComputeChecksums: true})
+		if err != nil {
+			return nil, err
+		}
+
+		frame := first.(*IPv4Packet).EthFrame
+		frame.Payload = raw
+		p, err := ipv4PacketFromFrame(&frame)
+		if err != nil {
+			return nil, err
+		}
+		p.Fragments = fragments
+		return p, nil
+	case IPv6Header:
+		// the fragment header is removed, and the header preceding it takes its Next Header value
+		i := slices.IndexFunc(h.Extensions, func(ext IPv6ExtensionHeader) bool {
+			return ext.Type() == IPv6ExtFragment
+		})
+		next := h.Extensions[i].Next()
+		h.Extensions = h.Extensions[:i]
+		h.PayloadLength = uint16(ipv6ExtensionsLen(h.Extensions) + len(data))
+		raw, err := h.SerializeTo(data, nil, SerializeOptions{})
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			raw[6] = next
+		} else {
+			raw[40+ipv6ExtensionsLen(h.Extensions[:i-1])] = next
+		}
+
+		frame := first.(*IPv6Packet).EthFrame
+		frame.Payload = raw
+		p, err := ipv6PacketFromFrame(&frame)
+		if err != nil {
+			return nil, err
+		}
+		p.Fragments = fragments
+		return p, nil
+	default:
+		return nil, errInvalidIPVersion
+	}
+}
@@ -0,0 +1,260 @@
+package protocols
+
+import (
+	"bytes"
+	"errors"
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var reassemblyData = bytes.Repeat([]byte("bisturi reassembly "), 10)
+
+// splitFragments returns the data split in fragments of the given sizes, the last one taking the rest
+func splitFragments(data []byte, sizes ...int) []fragment {
+	var fragments []fragment
+	offset := 0
+	for _, size := range sizes {
+		fragments = append(fragments, fragment{offset: offset, data: data[offset : offset+size]})
+		offset += size
+	}
+	return append(fragments, fragment{offset: offset, data: data[offset:]})
+}
+
+// ipv4Fragments returns the frames carrying the fragments of a UDP datagram
+func ipv4Fragments(t *testing.T, id uint16, sizes ...int) [][]byte {
+	ip := IPv4Header{
+		Version:        4,
+		TTL:            64,
+		Protocol:       17,
+		Identification: id,
+		SourceIP:       netip.MustParseAddr("192.168.0.1"),
+		DestinationIP:  netip.MustParseAddr("192.168.0.2"),
+	}
+	segment := mustSerialize(t, ip, &UDPHeader{SourcePort: 40000, DestinationPort: 53}, Payload(reassemblyData))[20:]
+
+	fragments := splitFragments(segment, sizes...)
+	frames := make([][]byte, len(fragments))
+	for i, f := range fragments {
+		h := ip
+		h.FragmentOffset = uint16(f.offset / 8)
+		if i < len(fragments)-1 {
+			h.Flags = IPv4FlagMF
+		}
+		frames[i] = ipFrame(t, h, Payload(f.data))
+	}
+	return frames
+}
+
+// ipv6Fragments returns the frames carrying the fragments of a UDP datagram, following a Hop-by-Hop header
+func ipv6Fragments(t *testing.T, id uint32, sizes ...int) [][]byte {
+	ip := IPv6Header{
+		Version:       6,
+		NextHeader:    17,
+		HopLimit:      64,
+		SourceIP:      netip.MustParseAddr("2001:db8::1"),
+		DestinationIP: netip.MustParseAddr("2001:db8::2"),
+	}
+	segment := mustSerialize(t, ip, &UDPHeader{SourcePort: 40000, DestinationPort: 53}, Payload(reassemblyData))[40:]
+
+	fragments := splitFragments(segment, sizes...)
+	frames := make([][]byte, len(fragments))
+	for i, f := range fragments {
+		fragmentHeader := &IPv6FragmentHeader{NextHeader: 17, FragmentOffset: uint16(f.offset / 8), MoreFragments: i < len(fragments)-1, Identification: id}
+		hopByHop := &IPv6HopByHopHeader{NextHeader: IPv6ExtFragment, Options: []IPv6Option{{Type: IPv6OptionRouterAlert, Data: []byte{0, 0}}}}
+		frames[i] = ipFrame(t, ipv6Header(IPv6ExtHopByHop, []IPv6ExtensionHeader{hopByHop, fragmentHeader}), Payload(f.data))
+	}
+	return frames
+}
+
+// reassemble passes the frames to the reassembler one second apart, expecting a packet only from the last one
+func reassemble(t *testing.T, r *Reassembler, frames ...[]byte) IPPacket {
+	t.Helper()
+	ts := time.Unix(1700000000, 0)
+	var packet IPPacket
+	for i, raw := range frames {
+		ip, err := IPPacketFromBytes(raw)
+		if err != nil {
+			t.Fatalf("expected no error decoding fragment %d - got %v", i, err)
+		}
+		packet, err = r.Reassemble(ip, ts.Add(time.Duration(i)*time.Second))
+		if err != nil {
+			t.Fatalf("expected no error reassembling fragment %d - got %v", i, err)
+		}
+		if i < len(frames)-1 && packet != nil {
+			t.Fatalf("expected no packet before the last fragment - got one from fragment %d", i)
+		}
+	}
+	if packet == nil {
+		t.Fatal("expected a packet after the last fragment")
+	}
+	return packet
+}
+
+func TestReassemble(t *testing.T) {
+	v4 := ipv4Fragments(t, 7, 64, 80)
+	v6 := ipv6Fragments(t, 7, 96)
+	overlapping := ipv4Fragments(t, 7, 64, 16)
+
+	tests := []struct {
+		name      string
+		frames    [][]byte
+		fragments int
+	}{
+		{name: "IPv4 in order", frames: v4, fragments: 3},
+		{name: "IPv4 out of order", frames: [][]byte{v4[2], v4[0], v4[1]}, fragments: 3},
+		{name: "IPv4 duplicate", frames: [][]byte{v4[1], v4[0], v4[1], v4[2]}, fragments: 3},
+		{name: "IPv4 overlapping with the same data", frames: [][]byte{overlapping[0], overlapping[1], v4[1], overlapping[2]}, fragments: 4},
+		{name: "IPv6 in order", frames: v6, fragments: 2},
+		{name: "IPv6 out of order", frames: [][]byte{v6[1], v6[0]}, fragments: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewReassembler(ReassemblyOptions{})
+			packet := reassemble(t, r, tt.frames...)
+
+			p, err := UDPPacketFromIPPacket(packet)
+			if err != nil {
+				t.Fatalf("expected no error decoding the reassembled datagram - got %v", err)
+			}
+			if !bytes.Equal(p.IPPacket.Payload()[8:], reassemblyData) {
+				t.Errorf("expected the reassembled payload %q - got %q", reassemblyData, p.IPPacket.Payload()[8:])
+			}
+			expected := map[LayerType]ChecksumStatus{LayerTypeUDP: ChecksumGood}
+			if packet.Version() == 4 {
+				expected[LayerTypeIPv4] = ChecksumGood
+			}
+			if got := statuses(VerifyChecksums(p)); !reflect.DeepEqual(got, expected) {
+				t.Errorf("expected checksums %v - got %v", expected, got)
+			}
+			if !strings.Contains(packet.Info(), "Reassembled from") {
+				t.Error("expected the number of fragments in the IP details")
+			}
+			if stats := r.Stats(); stats.Reassembled != 1 || r.Pending() != 0 {
+				t.Errorf("expected 1 packet reassembled and none pending - got %+v, %d pending", stats, r.Pending())
+			}
+
+			switch ip := packet.(type) {
+			case *IPv4Packet:
+				if ip.Fragments != tt.fragments || ip.IPv4Header.MoreFragments() || ip.IPv4Header.TotalLength != uint16(20+8+len(reassemblyData)) {
+					t.Errorf("expected a whole packet from %d fragments - got %+v from %d", tt.fragments, ip.IPv4Header, ip.Fragments)
+				}
+			case *IPv6Packet:
+				h := ip.IPv6Header
+				if ip.Fragments != tt.fragments || h.FragmentHeader() != nil || h.UpperLayerProtocol() != 17 || len(h.Extensions) != 1 {
+					t.Errorf("expected a whole packet from %d fragments following the Hop-by-Hop header - got %+v from %d", tt.fragments, h, ip.Fragments)
+				}
+				if int(h.PayloadLength) != 8+8+len(reassemblyData) {
+					t.Errorf("expected payload length %d - got %d", 8+8+len(reassemblyData), h.PayloadLength)
+				}
+			}
+		})
+	}
+}
+
+func TestReassembleNotFragmented(t *testing.T) {
+	udp := &UDPHeader{SourcePort: 40000, DestinationPort: 53}
+	atomic := ipFrame(t, ipv6Header(IPv6ExtFragment, []IPv6ExtensionHeader{&IPv6FragmentHeader{NextHeader: 17, Identification: 1}}), udp, Payload("query"))
+	whole := ipv4Fragments(t, 7)
+
+	r := NewReassembler(ReassemblyOptions{})
+	for _, raw := range [][]byte{whole[0], atomic} {
+		ip, err := IPPacketFromBytes(raw)
+		if err != nil {
+			t.Fatalf("expected no error decoding the packet - got %v", err)
+		}
+		if got, err := r.Reassemble(ip, time.Now()); got != ip || err != nil {
+			t.Errorf("expected the packet to be returned as it is - got %v, error %v", got, err)
+		}
+	}
+	if r.Pending() != 0 {
+		t.Errorf("expected no packet pending - got %d", r.Pending())
+	}
+
+	if p := decode(t, atomic, UDPPacketFromIPPacket); statuses(VerifyChecksums(p))[LayerTypeUDP] != ChecksumGood {
+		t.Error("expected the checksum of an atomic fragment to be verified")
+	}
+}
+
+func TestReassembleErrors(t *testing.T) {
+	conflicting := ipv4Fragments(t, 7, 64, 16)
+	conflicting[1][14+20] ^= 0xFF
+	conflictingV6 := ipv6Fragments(t, 7, 88)
+	conflictingV6[1][14+40+8+8] ^= 0xFF
+	// the MF flag is cleared from a fragment in the middle
+	early := ipv4Fragments(t, 7, 64, 80)
+	early[1][14+6] &^= IPv4FlagMF << 5
+
+	tests := []struct {
+		name     string
+		frames   [][]byte
+		expected error
+	}{
+		{name: "overlap with different data", frames: append(ipv4Fragments(t, 7, 64, 80)[:2], conflicting[1]), expected: ErrFragmentOverlap},
+		{name: "fragment not multiple of 8 bytes", frames: ipv4Fragments(t, 7, 60), expected: ErrInvalidFragment},
+		{name: "last fragments ending differently", frames: [][]byte{early[2], early[1]}, expected: ErrInvalidFragment},
+		{name: "IPv6 overlap with different data", frames: [][]byte{ipv6Fragments(t, 7, 96)[0], conflictingV6[1]}, expected: ErrFragmentOverlap},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewReassembler(ReassemblyOptions{})
+			var err error
+			for _, raw := range tt.frames {
+				ip, decodeErr := IPPacketFromBytes(raw)
+				if decodeErr != nil {
+					t.Fatalf("expected no error decoding the fragment - got %v", decodeErr)
+				}
+				if _, err = r.Reassemble(ip, time.Now()); err != nil {
+					break
+				}
+			}
+			if !errors.Is(err, tt.expected) {
+				t.Errorf("expected error %v - got %v", tt.expected, err)
+			}
+			if r.Pending() != 0 {
+				t.Errorf("expected the packet to be discarded - got %d pending", r.Pending())
+			}
+		})
+	}
+}
+
+func TestReassembleTimeoutAndMemory(t *testing.T) {
+	ts := time.Unix(1700000000, 0)
+	fragment := func(t *testing.T, raw []byte) IPPacket {
+		ip, err := IPPacketFromBytes(raw)
+		if err != nil {
+			t.Fatalf("expected no error decoding the fragment - got %v", err)
+		}
+		return ip
+	}
+
+	t.Run("timeout", func(t *testing.T) {
+		r := NewReassembler(ReassemblyOptions{Timeout: 10 * time.Second})
+		frames := ipv4Fragments(t, 7, 64)
+		r.Reassemble(fragment(t, frames[0]), ts)
+		if got, _ := r.Reassemble(fragment(t, frames[1]), ts.Add(10*time.Second)); got != nil {
+			t.Fatalf("expected no packet once the first fragment expired - got %v", got)
+		}
+		if stats := r.Stats(); stats.TimedOut != 1 || r.Pending() != 1 {
+			t.Errorf("expected 1 packet timed out and the last fragment pending - got %+v, %d pending", stats, r.Pending())
+		}
+	})
+
+	t.Run("memory cap", func(t *testing.T) {
+		r := NewReassembler(ReassemblyOptions{MaxBytes: 200})
+		first, second := ipv4Fragments(t, 1, 128), ipv4Fragments(t, 2, 128)
+		r.Reassemble(fragment(t, first[0]), ts)
+		r.Reassemble(fragment(t, second[0]), ts.Add(time.Second))
+		if stats := r.Stats(); stats.Evicted != 1 || r.Pending() != 1 {
+			t.Fatalf("expected the oldest packet evicted - got %+v, %d pending", stats, r.Pending())
+		}
+		if got, _ := r.Reassemble(fragment(t, second[1]), ts.Add(2*time.Second)); got == nil {
+			t.Error("expected the packet kept to be reassembled")
+		}
+		if got, _ := r.Reassemble(fragment(t, first[1]), ts.Add(3*time.Second)); got != nil {
+			t.Errorf("expected no packet from the evicted fragments - got %v", got)
+		}
+	})
+}
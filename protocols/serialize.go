@@ -0,0 +1,424 @@
+package protocols
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/netip"
+)
+
+// SerializeOptions controls which fields of the layers are computed while serializing them,
+// instead of being copied from the structs
+type SerializeOptions struct {
+	// FixLengths sets the length fields from the size of the payload, and the header lengths from the options,
+	// padding the IPv4 and TCP options to a multiple of 4 bytes and the IPv6 extension headers to a multiple of 8 bytes
+	FixLengths bool
+	// ComputeChecksums sets the IPv4 header checksum, the ICMP checksum and the UDP, TCP and ICMPv6 checksums, covering the pseudo-header
+	ComputeChecksums bool
+}
+
+// SerializableLayer is a layer which can be encoded to its wire format
+type SerializableLayer interface {
+	Layer
+	// SerializeTo returns the bytes of the layer followed by the payload. The layers below it,
+	// from the link layer up, provide the IP header the UDP and TCP checksums depend on
+	SerializeTo(payload []byte, below []Layer, opts SerializeOptions) ([]byte, error)
+}
+
+var (
+	errIPv4Addresses     = errors.New("IPv4 header addresses must be IPv4 addresses")
+	errIPv6Addresses     = errors.New("IPv6 header addresses must be IPv6 addresses")
+	errMissingIPLayer    = errors.New("checksum requires an IPv4 or IPv6 layer below")
+	errOptionsNotAligned = errors.New("options must be a multiple of 4 bytes")
+)
+
+// Serialize returns the wire bytes of the layers, ordered from the link layer up, computing their lengths and checksums:
+//
+//	Serialize(&EthernetFrame{...}, IPv4Header{...}, &UDPHeader{...}, Payload("hello"))
+func Serialize(layers ...SerializableLayer) ([]byte, error) {
+	return SerializeLayers(SerializeOptions{FixLengths: true, ComputeChecksums: true}, layers...)
+}
+
+// SerializeLayers returns the wire bytes of the layers, ordered from the link layer up, according to the options.
+// The layers are serialized from the last one, so that every layer knows its payload
+func SerializeLayers(opts SerializeOptions, layers ...SerializableLayer) ([]byte, error) {
+	below := make([]Layer, len(layers))
+	for i, l := range layers {
+		below[i] = l
+	}
+
+	var data []byte
+	for i := len(layers) - 1; i >= 0; i-- {
+		var err error
+		data, err = layers[i].SerializeTo(data, below[:i], opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to serialize %s layer: %v", layers[i].LayerType(), err)
+		}
+	}
+	return data, nil
+}
+
+// SerializePacket returns the wire bytes of the decoded packet's layers, after they have possibly been modified,
+// according to the options. The VLAN tags are serialized with the Ethernet frame carrying them
+func SerializePacket(p LayeredPacket, opts SerializeOptions) ([]byte, error) {
+	var layers []SerializableLayer
+	for _, l := range p.Layers() {
+		if sl, ok := l.(SerializableLayer); ok {
+			layers = append(layers, sl)
+		}
+	}
+	return SerializeLayers(opts, layers...)
+}
+
+// prepend returns the header followed by the payload, in a newly allocated slice
+func prepend(header, payload []byte) []byte {
+	return append(header, payload...)
+}
+
+// innermostIPHeader returns the last IPv4 or IPv6 header among the layers
+func innermostIPHeader(layers []Layer) (IPHeader, bool) {
+	for i := len(layers) - 1; i >= 0; i-- {
+		switch h := layers[i].(type) {
+		case IPv4Header:
+			return h, true
+		case *IPv4Header:
+			return *h, true
+		case IPv6Header:
+			return h, true
+		case *IPv6Header:
+			return *h, true
+		}
+	}
+	return nil, false
+}
+
+// transportChecksum returns the checksum of the UDP, TCP or ICMPv6 segment, covering the pseudo-header
+// of the innermost IP header below it
+func transportChecksum(segment []byte, below []Layer, proto uint8) (uint16, error) {
+	ip, ok := innermostIPHeader(below)
+	if !ok {
+		return 0, errMissingIPLayer
+	}
+	sum, ok := pseudoHeaderSum(ip, proto, len(segment))
+	if !ok {
+		return 0, errMissingIPLayer
+	}
+	return foldChecksum(sum16(segment, sum)), nil
+}
+
+// padOptions pads the options with zeros to a multiple of 4 bytes if fix is set, or fails if they are not aligned
+func padOptions(options []byte, fix bool) ([]byte, error) {
+	if len(options)%4 == 0 {
+		return options, nil
+	}
+	if !fix {
+		return nil, errOptionsNotAligned
+	}
+	return append(append([]byte{}, options...), make([]byte, 4-len(options)%4)...), nil
+}
+
+// SerializeTo returns the Ethernet header, including the VLAN tags, followed by the payload.
+// The Payload field of the frame is ignored
+func (f *EthernetFrame) SerializeTo(payload []byte, below []Layer, opts SerializeOptions) ([]byte, error) {
+	if len(f.DestinationMAC) != 6 || len(f.SourceMAC) != 6 {
+		return nil, errors.New("MAC addresses must be 6 bytes")
+	}
+
+	header := make([]byte, 0, 14+4*len(f.VLANs))
+	header = append(header, f.DestinationMAC...)
+	header = append(header, f.SourceMAC...)
+	for _, tag := range f.VLANs {
+		header = binary.BigEndian.AppendUint16(header, tag.TPID)
+		header = binary.BigEndian.AppendUint16(header, tag.TCI())
+	}
+	header = binary.BigEndian.AppendUint16(header, f.EtherType)
+	return prepend(header, payload), nil
+}
+
+// SerializeTo returns the ARP packet followed by the payload. The EthFrame field is ignored, and must be
+// serialized as a layer of its own. With FixLengths, the address lengths are the ones of the addresses
+func (p *ARPPacket) SerializeTo(payload []byte, below []Layer, opts SerializeOptions) ([]byte, error) {
+	senderProto, targetProto := p.SenderProtoAddr, p.TargetProtoAddr
+	if ip4 := senderProto.To4(); ip4 != nil {
+		senderProto = ip4
+	}
+	if ip4 := targetProto.To4(); ip4 != nil {
+		targetProto = ip4
+	}
+
+	hwLen, protoLen := p.HardwareAddrLen, p.ProtocolAddrLen
+	if opts.FixLengths {
+		hwLen, protoLen = uint8(len(p.SenderHWAddr)), uint8(len(senderProto))
+	}
+	if len(p.SenderHWAddr) != int(hwLen) || len(p.TargetHWAddr) != int(hwLen) ||
+		len(senderProto) != int(protoLen) || len(targetProto) != int(protoLen) {
+		return nil, errors.New("ARP addresses do not match their lengths")
+	}
+
+	header := make([]byte, 8, 8+2*int(hwLen)+2*int(protoLen))
+	binary.BigEndian.PutUint16(header[0:2], p.HardwareType)
+	binary.BigEndian.PutUint16(header[2:4], p.ProtocolType)
+	header[4] = hwLen
+	header[5] = protoLen
+	binary.BigEndian.PutUint16(header[6:8], p.Operation)
+	header = append(header, p.SenderHWAddr...)
+	header = append(header, senderProto...)
+	header = append(header, p.TargetHWAddr...)
+	header = append(header, targetProto...)
+	return prepend(header, payload), nil
+}
+
+// SerializeTo returns the IPv4 header followed by the payload
+func (h IPv4Header) SerializeTo(payload []byte, below []Layer, opts SerializeOptions) ([]byte, error) {
+	if !h.SourceIP.Is4() || !h.DestinationIP.Is4() {
+		return nil, errIPv4Addresses
+	}
+	options, err := padOptions(h.Options, opts.FixLengths)
+	if err != nil {
+		return nil, err
+	}
+
+	ihl, totalLength := h.IHL, h.TotalLength
+	if opts.FixLengths {
+		ihl = uint8(5 + len(options)/4)
+		totalLength = uint16(20 + len(options) + len(payload))
+	}
+	if int(ihl)*4 != 20+len(options) {
+		return nil, fmt.Errorf("IHL %d does not match %d bytes of options", ihl, len(options))
+	}
+
+	header := make([]byte, 20, 20+len(options))
+	header[0] = h.Version<<4 | ihl&0x0F
+	header[1] = h.DSCP<<2 | h.ECN&0x03
+	binary.BigEndian.PutUint16(header[2:4], totalLength)
+	binary.BigEndian.PutUint16(header[4:6], h.Identification)
+	binary.BigEndian.PutUint16(header[6:8], uint16(h.Flags)<<13|h.FragmentOffset&0x1FFF)
+	header[8] = h.TTL
+	header[9] = h.Protocol
+	src, dst := h.SourceIP.As4(), h.DestinationIP.As4()
+	copy(header[12:16], src[:])
+	copy(header[16:20], dst[:])
+	header = append(header, options...)
+
+	checksum := h.HeaderChecksum
+	if opts.ComputeChecksums {
+		checksum = foldChecksum(sum16(header, 0))
+	}
+	binary.BigEndian.PutUint16(header[10:12], checksum)
+	return prepend(header, payload), nil
+}
+
+// SerializeTo returns the IPv6 header and its extension headers followed by the payload.
+// The extension headers are padded to a multiple of 8 bytes if FixLengths is set
+func (h IPv6Header) SerializeTo(payload []byte, below []Layer, opts SerializeOptions) ([]byte, error) {
+	if !h.SourceIP.Is6() || !h.DestinationIP.Is6() {
+		return nil, errIPv6Addresses
+	}
+
+	header := make([]byte, 40, h.Len())
+	for _, ext := range h.Extensions {
+		b, err := ext.serialize(opts.FixLengths)
+		if err != nil {
+			return nil, err
+		}
+		header = append(header, b...)
+	}
+
+	payloadLength := h.PayloadLength
+	if opts.FixLengths {
+		payloadLength = uint16(len(header) - 40 + len(payload))
+	}
+
+	binary.BigEndian.PutUint32(header[0:4], uint32(h.Version)<<28|uint32(h.TrafficClass)<<20|h.FlowLabel&0xFFFFF)
+	binary.BigEndian.PutUint16(header[4:6], payloadLength)
+	header[6] = h.NextHeader
+	header[7] = h.HopLimit
+	src, dst := h.SourceIP.As16(), h.DestinationIP.As16()
+	copy(header[8:24], src[:])
+	copy(header[24:40], dst[:])
+	return prepend(header, payload), nil
+}
+
+// SerializeTo returns the UDP header followed by the payload. The checksum requires an IP layer below
+func (h *UDPHeader) SerializeTo(payload []byte, below []Layer, opts SerializeOptions) ([]byte, error) {
+	length := h.Length
+	if opts.FixLengths {
+		length = uint16(8 + len(payload))
+	}
+
+	segment := make([]byte, 8, 8+len(payload))
+	binary.BigEndian.PutUint16(segment[0:2], h.SourcePort)
+	binary.BigEndian.PutUint16(segment[2:4], h.DestinationPort)
+	binary.BigEndian.PutUint16(segment[4:6], length)
+	segment = append(segment, payload...)
+
+	checksum := h.Checksum
+	if opts.ComputeChecksums {
+		var err error
+		if checksum, err = transportChecksum(segment, below, ipProtocolUDP); err != nil {
+			return nil, err
+		}
+		// a zero checksum means none was computed
+		if checksum == 0 {
+			checksum = 0xFFFF
+		}
+	}
+	binary.BigEndian.PutUint16(segment[6:8], checksum)
+	return segment, nil
+}
+
+// SerializeTo returns the TCP header followed by the payload. The checksum requires an IP layer below
+func (h *TCPHeader) SerializeTo(payload []byte, below []Layer, opts SerializeOptions) ([]byte, error) {
+	options, err := serializeTCPOptions(h.Options)
+	if err != nil {
+		return nil, err
+	}
+	if options, err = padOptions(options, opts.FixLengths); err != nil {
+		return nil, err
+	}
+
+	offset := h.RawOffset
+	if opts.FixLengths {
+		offset = uint8(5 + len(options)/4)
+	}
+	if int(offset)*4 != 20+len(options) {
+		return nil, fmt.Errorf("data offset %d does not match %d bytes of options", offset, len(options))
+	}
+
+	segment := make([]byte, 20, 20+len(options)+len(payload))
+	binary.BigEndian.PutUint16(segment[0:2], h.SourcePort)
+	binary.BigEndian.PutUint16(segment[2:4], h.DestinationPort)
+	binary.BigEndian.PutUint32(segment[4:8], h.SequenceNumber)
+	binary.BigEndian.PutUint32(segment[8:12], h.AckNumber)
+	segment[12] = offset << 4
+	segment[13] = h.Flags
+	binary.BigEndian.PutUint16(segment[14:16], h.WindowSize)
+	binary.BigEndian.PutUint16(segment[18:20], h.UrgentPointer)
+	segment = append(segment, options...)
+	segment = append(segment, payload...)
+
+	checksum := h.Checksum
+	if opts.ComputeChecksums {
+		if checksum, err = transportChecksum(segment, below, ipProtocolTCP); err != nil {
+			return nil, err
+		}
+	}
+	binary.BigEndian.PutUint16(segment[16:18], checksum)
+	return segment, nil
+}
+
+// SerializeTo returns the ICMP header followed by the payload. The checksum covers the whole message
+func (h *ICMPHeader) SerializeTo(payload []byte, below []Layer, opts SerializeOptions) ([]byte, error) {
+	message := make([]byte, 8, 8+len(payload))
+	message[0] = h.Type
+	message[1] = h.Code
+	copy(message[4:8], h.RestOfHeader[:])
+	message = append(message, payload...)
+
+	checksum := h.Checksum
+	if opts.ComputeChecksums {
+		checksum = foldChecksum(sum16(message, 0))
+	}
+	binary.BigEndian.PutUint16(message[2:4], checksum)
+	return message, nil
+}
+
+// SerializeTo returns the ICMPv6 header followed by the payload. The checksum covers the whole message and the pseudo-header.
+// The 4 bytes following the checksum of the NDP and MLD messages are serialized by the NDPMessage or MLDMessage layer
+// above the header instead of being copied from RestOfHeader
+func (h *ICMPv6Header) SerializeTo(payload []byte, below []Layer, opts SerializeOptions) ([]byte, error) {
+	message := make([]byte, h.headerLen(), h.headerLen()+len(payload))
+	message[0] = h.Type
+	message[1] = h.Code
+	copy(message[4:], h.RestOfHeader[:])
+	message = append(message, payload...)
+
+	checksum := h.Checksum
+	if opts.ComputeChecksums {
+		var err error
+		if checksum, err = transportChecksum(message, below, ipProtocolICMPv6); err != nil {
+			return nil, err
+		}
+	}
+	binary.BigEndian.PutUint16(message[2:4], checksum)
+	return message, nil
+}
+
+// SerializeTo returns the NDP message, starting from the 4 bytes following the ICMPv6 checksum, followed by the payload.
+// The options are padded with zeros to a multiple of 8 bytes
+func (m *NDPMessage) SerializeTo(payload []byte, below []Layer, opts SerializeOptions) ([]byte, error) {
+	message := make([]byte, 4, 64+len(payload))
+	switch m.Type {
+	case ICMPv6TypeRouterAdvertisement:
+		message[0] = m.CurHopLimit
+		message[1] = m.Flags
+		binary.BigEndian.PutUint16(message[2:4], m.RouterLifetime)
+		message = binary.BigEndian.AppendUint32(message, m.ReachableTime)
+		message = binary.BigEndian.AppendUint32(message, m.RetransTimer)
+	case ICMPv6TypeNeighborSolicitation, ICMPv6TypeNeighborAdvertisement:
+		if m.Type == ICMPv6TypeNeighborAdvertisement {
+			message[0] = m.Flags
+		}
+		target := m.TargetAddress.As16()
+		message = append(message, target[:]...)
+	case ICMPv6TypeRedirect:
+		target, dst := m.TargetAddress.As16(), m.DestinationAddress.As16()
+		message = append(append(message, target[:]...), dst[:]...)
+	}
+
+	for _, o := range m.Options {
+		length := (2 + len(o.Data) + 7) / 8
+		if length > 0xFF {
+			return nil, fmt.Errorf("NDP option %d is too long: %d bytes", o.Type, len(o.Data))
+		}
+		message = append(message, o.Type, uint8(length))
+		message = append(message, o.Data...)
+		message = append(message, make([]byte, length*8-2-len(o.Data))...)
+	}
+	return prepend(message, payload), nil
+}
+
+// SerializeTo returns the MLD message, starting from the 4 bytes following the ICMPv6 checksum, followed by the payload.
+// The number of sources and records is computed from the slices
+func (m *MLDMessage) SerializeTo(payload []byte, below []Layer, opts SerializeOptions) ([]byte, error) {
+	message := make([]byte, 4, 64+len(payload))
+	appendAddr := func(b []byte, addr netip.Addr) []byte {
+		a := addr.As16()
+		return append(b, a[:]...)
+	}
+
+	if m.Type == ICMPv6TypeMLDv2Report {
+		binary.BigEndian.PutUint16(message[2:4], uint16(len(m.Records)))
+		for _, r := range m.Records {
+			if len(r.AuxData)%4 != 0 {
+				return nil, fmt.Errorf("MLD record auxiliary data must be a multiple of 4 bytes: %d bytes", len(r.AuxData))
+			}
+			message = append(message, r.Type, uint8(len(r.AuxData)/4))
+			message = binary.BigEndian.AppendUint16(message, uint16(len(r.Sources)))
+			message = appendAddr(message, r.MulticastAddress)
+			for _, src := range r.Sources {
+				message = appendAddr(message, src)
+			}
+			message = append(message, r.AuxData...)
+		}
+		return prepend(message, payload), nil
+	}
+
+	if m.Type == ICMPv6TypeMLDQuery {
+		binary.BigEndian.PutUint16(message[0:2], m.MaxResponseDelay)
+	}
+	message = appendAddr(message, m.MulticastAddress)
+	if m.Type == ICMPv6TypeMLDQuery && m.Version == 2 {
+		message = append(message, m.Flags, m.QQIC)
+		message = binary.BigEndian.AppendUint16(message, uint16(len(m.Sources)))
+		for _, src := range m.Sources {
+			message = appendAddr(message, src)
+		}
+	}
+	return prepend(message, payload), nil
+}
+
+// SerializeTo returns the payload followed by the bytes of the layers above it, if any
+func (p Payload) SerializeTo(payload []byte, below []Layer, opts SerializeOptions) ([]byte, error) {
+	return prepend(append([]byte{}, p...), payload), nil
+}
@@ -0,0 +1,293 @@
+package protocols
+
+import (
+	"bytes"
+	"net"
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+var (
+	testSrcMAC = net.HardwareAddr{0x00, 0x1A, 0xB0, 0xCC, 0xDD, 0xEE}
+	testDstMAC = net.HardwareAddr{0x00, 0x1A, 0xA0, 0xBB, 0xCC, 0xDD}
+)
+
+// mustSerialize returns the wire bytes of the layers, computing their lengths and checksums
+func mustSerialize(t *testing.T, layers ...SerializableLayer) []byte {
+	t.Helper()
+	raw, err := Serialize(layers...)
+	if err != nil {
+		t.Fatalf("expected no error serializing the layers - got %v", err)
+	}
+	return raw
+}
+
+// ipFrame returns an Ethernet frame carrying the IPv4 or IPv6 header, followed by the layers
+func ipFrame(t *testing.T, ip SerializableLayer, layers ...SerializableLayer) []byte {
+	t.Helper()
+	frame := &EthernetFrame{DestinationMAC: testDstMAC, SourceMAC: testSrcMAC, EtherType: 0x0800}
+	if ip.LayerType() == LayerTypeIPv6 {
+		frame.EtherType = 0x86DD
+	}
+	return mustSerialize(t, append([]SerializableLayer{frame, ip}, layers...)...)
+}
+
+// decode decodes the IP packet carried by the frame, then the upper layer with the passed function
+func decode[P any](t *testing.T, raw []byte, fromIP func(IPPacket) (P, error)) P {
+	t.Helper()
+	ip, err := IPPacketFromBytes(raw)
+	if err != nil {
+		t.Fatalf("expected no error decoding the IP packet - got %v", err)
+	}
+	p, err := fromIP(ip)
+	if err != nil {
+		t.Fatalf("expected no error decoding the upper layer - got %v", err)
+	}
+	return p
+}
+
+// validTransportChecksum reports whether the checksum of the UDP or TCP segment, carried by the IP header, is correct
+func validTransportChecksum(ip IPHeader, proto uint8, segment []byte) bool {
+	sum, ok := pseudoHeaderSum(ip, proto, len(segment))
+	return ok && foldChecksum(sum16(segment, sum)) == 0
+}
+
+func TestSerializeIPv4Checksum(t *testing.T) {
+	h := IPv4Header{
+		Version:       4,
+		Flags:         IPv4FlagDF,
+		TTL:           64,
+		Protocol:      17,
+		SourceIP:      netip.MustParseAddr("192.168.0.1"),
+		DestinationIP: netip.MustParseAddr("192.168.0.199"),
+	}
+	raw := mustSerialize(t, h, Payload(make([]byte, 95)))
+
+	expected := []byte{
+		0x45, 0x00, 0x00, 0x73, 0x00, 0x00, 0x40, 0x00,
+		0x40, 0x11, 0xb8, 0x61, 0xc0, 0xa8, 0x00, 0x01,
+		0xc0, 0xa8, 0x00, 0xc7,
+	}
+	if !bytes.Equal(raw[:20], expected) {
+		t.Errorf("expected IPv4 header to be % x - got % x", expected, raw[:20])
+	}
+}
+
+func TestSerializeUDP(t *testing.T) {
+	frame := &EthernetFrame{
+		DestinationMAC: testDstMAC,
+		SourceMAC:      testSrcMAC,
+		EtherType:      0x0800,
+		VLANs:          []VLANTag{{TPID: 0x8100, PCP: 3, VID: 42}},
+	}
+	ip := IPv4Header{
+		Version:        4,
+		Identification: 0x1c46,
+		TTL:            64,
+		Protocol:       17,
+		SourceIP:       netip.MustParseAddr("192.168.0.104"),
+		DestinationIP:  netip.MustParseAddr("192.168.0.1"),
+	}
+	udp := &UDPHeader{SourcePort: 1234, DestinationPort: 53}
+	raw := mustSerialize(t, frame, ip, udp, Payload("hello"))
+
+	ipPacket, err := IPPacketFromBytes(raw)
+	if err != nil {
+		t.Fatalf("expected no error - got %v", err)
+	}
+	packet, err := UDPPacketFromIPPacket(ipPacket)
+	if err != nil {
+		t.Fatalf("expected no error - got %v", err)
+	}
+
+	if tag := packet.Frame().VLANs; len(tag) != 1 || tag[0] != frame.VLANs[0] {
+		t.Errorf("expected VLAN tags to be %v - got %v", frame.VLANs, tag)
+	}
+	decodedIP := packet.Layer(LayerTypeIPv4).(IPv4Header)
+	if decodedIP.TotalLength != 33 || decodedIP.IHL != 5 {
+		t.Errorf("expected total length 33 and IHL 5 - got %d and %d", decodedIP.TotalLength, decodedIP.IHL)
+	}
+	if decodedIP.SourceIP != ip.SourceIP || decodedIP.DestinationIP != ip.DestinationIP {
+		t.Errorf("expected addresses %s and %s - got %s and %s", ip.SourceIP, ip.DestinationIP, decodedIP.SourceIP, decodedIP.DestinationIP)
+	}
+	if foldChecksum(sum16(raw[18:38], 0)) != 0 {
+		t.Errorf("expected a valid IPv4 header checksum")
+	}
+	if packet.Header.Length != 13 || packet.Header.SourcePort != 1234 || packet.Header.DestinationPort != 53 {
+		t.Errorf("expected UDP header %+v - got %+v", udp, packet.Header)
+	}
+	if !validTransportChecksum(decodedIP, 17, ipPacket.Payload()) {
+		t.Errorf("expected a valid UDP checksum - got 0x%04x", packet.Header.Checksum)
+	}
+	if payload := packet.Layer(LayerTypePayload).(Payload); string(payload) != "hello" {
+		t.Errorf("expected payload %q - got %q", "hello", payload)
+	}
+}
+
+func TestSerializeTCP(t *testing.T) {
+	ip := IPv6Header{
+		Version:       6,
+		FlowLabel:     0xbeef,
+		NextHeader:    6,
+		HopLimit:      64,
+		SourceIP:      netip.MustParseAddr("fe80::21c:7eff:fee4:2c00"),
+		DestinationIP: netip.MustParseAddr("fe80::21c:7eff:fee4:2c01"),
+	}
+	tcp := &TCPHeader{
+		SourcePort:      40000,
+		DestinationPort: 443,
+		SequenceNumber:  1,
+		Flags:           0x02,
+		WindowSize:      0x2000,
+		Options:         []TCPOption{{Kind: TCPOptionWindowScale, Data: []byte{0x07}}}, // padded to 4 bytes
+	}
+	raw := ipFrame(t, ip, tcp)
+
+	ipPacket, err := IPPacketFromBytes(raw)
+	if err != nil {
+		t.Fatalf("expected no error - got %v", err)
+	}
+	packet, err := TCPPacketFromIPPacket(ipPacket)
+	if err != nil {
+		t.Fatalf("expected no error - got %v", err)
+	}
+
+	decodedIP := packet.Layer(LayerTypeIPv6).(IPv6Header)
+	if decodedIP.PayloadLength != 24 || decodedIP.FlowLabel != 0xbeef {
+		t.Errorf("expected payload length 24 and flow label 0xbeef - got %d and 0x%x", decodedIP.PayloadLength, decodedIP.FlowLabel)
+	}
+	padded := []TCPOption{{Kind: TCPOptionWindowScale, Data: []byte{0x07}}, {Kind: TCPOptionEndOfList}}
+	if packet.Header.RawOffset != 6 || !reflect.DeepEqual(packet.Header.Options, padded) {
+		t.Errorf("expected data offset 6 and padded options - got %d and %v", packet.Header.RawOffset, packet.Header.Options)
+	}
+	if packet.Header.SequenceNumber != 1 || packet.Header.Flags != 0x02 || packet.Header.WindowSize != 0x2000 {
+		t.Errorf("expected TCP header %+v - got %+v", tcp, packet.Header)
+	}
+	if !validTransportChecksum(decodedIP, 6, ipPacket.Payload()) {
+		t.Errorf("expected a valid TCP checksum - got 0x%04x", packet.Header.Checksum)
+	}
+}
+
+func TestSerializeARP(t *testing.T) {
+	frame := &EthernetFrame{
+		DestinationMAC: net.HardwareAddr{0x00, 0x1a, 0x2b, 0x3c, 0x4d, 0x5e},
+		SourceMAC:      net.HardwareAddr{0x00, 0x1a, 0x2b, 0x3c, 0x4d, 0x5f},
+		EtherType:      0x0806,
+	}
+	arp := &ARPPacket{
+		HardwareType:    1,
+		ProtocolType:    0x0800,
+		Operation:       1,
+		SenderHWAddr:    net.HardwareAddr{0x00, 0x1a, 0x2b, 0x3c, 0x4d, 0x5e},
+		SenderProtoAddr: net.ParseIP("192.168.1.1"),
+		TargetHWAddr:    net.HardwareAddr{0x00, 0x1a, 0x2b, 0x3c, 0x4d, 0x5f},
+		TargetProtoAddr: net.ParseIP("192.168.1.2"),
+	}
+
+	expected := []byte{
+		0x00, 0x1a, 0x2b, 0x3c, 0x4d, 0x5e, 0x00, 0x1a, 0x2b, 0x3c, 0x4d, 0x5f, 0x08, 0x06,
+		0x00, 0x01, 0x08, 0x00, 0x06, 0x04, 0x00, 0x01,
+		0x00, 0x1a, 0x2b, 0x3c, 0x4d, 0x5e, 0xc0, 0xa8, 0x01, 0x01,
+		0x00, 0x1a, 0x2b, 0x3c, 0x4d, 0x5f, 0xc0, 0xa8, 0x01, 0x02,
+	}
+	if raw := mustSerialize(t, frame, arp); !bytes.Equal(raw, expected) {
+		t.Errorf("expected ARP frame to be % x - got % x", expected, raw)
+	}
+}
+
+func TestSerializeLayersOptions(t *testing.T) {
+	ip := IPv4Header{
+		Version:        4,
+		IHL:            5,
+		TotalLength:    1000,
+		HeaderChecksum: 0xdead,
+		TTL:            64,
+		Protocol:       17,
+		SourceIP:       netip.MustParseAddr("10.0.0.1"),
+		DestinationIP:  netip.MustParseAddr("10.0.0.2"),
+	}
+	udp := &UDPHeader{SourcePort: 1, DestinationPort: 2, Length: 1000, Checksum: 0xbeef}
+
+	// without options the fields are copied as they are, to craft invalid packets
+	raw, err := SerializeLayers(SerializeOptions{}, ip, udp)
+	if err != nil {
+		t.Fatalf("expected no error - got %v", err)
+	}
+	h, err := IPv4HeaderFromBytes(raw)
+	if err != nil {
+		t.Fatalf("expected no error - got %v", err)
+	}
+	if h.TotalLength != 1000 || h.HeaderChecksum != 0xdead {
+		t.Errorf("expected total length 1000 and checksum 0xdead - got %d and 0x%x", h.TotalLength, h.HeaderChecksum)
+	}
+	u, err := UDPHeaderFromBytes(raw[20:])
+	if err != nil {
+		t.Fatalf("expected no error - got %v", err)
+	}
+	if u.Length != 1000 || u.Checksum != 0xbeef {
+		t.Errorf("expected length 1000 and checksum 0xbeef - got %d and 0x%x", u.Length, u.Checksum)
+	}
+
+	errorTests := []struct {
+		name   string
+		opts   SerializeOptions
+		layers []SerializableLayer
+	}{
+		{name: "unaligned options", layers: []SerializableLayer{&TCPHeader{RawOffset: 5, Options: []TCPOption{{Kind: TCPOptionNOP}}}}},
+		{name: "checksum without IP layer", opts: SerializeOptions{ComputeChecksums: true}, layers: []SerializableLayer{udp}},
+		{name: "IPv6 address in IPv4 header", layers: []SerializableLayer{IPv4Header{Version: 4, IHL: 5, SourceIP: netip.IPv6Loopback(), DestinationIP: netip.IPv6Loopback()}}},
+		{name: "short MAC address", layers: []SerializableLayer{&EthernetFrame{DestinationMAC: testDstMAC, SourceMAC: testSrcMAC[:4]}}},
+	}
+	for _, tt := range errorTests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := SerializeLayers(tt.opts, tt.layers...); err == nil {
+				t.Errorf("expected an error serializing the layers")
+			}
+		})
+	}
+}
+
+func TestSerializePacket(t *testing.T) {
+	raw := mustSerialize(t,
+		&EthernetFrame{DestinationMAC: testDstMAC, SourceMAC: testSrcMAC, EtherType: 0x0800, VLANs: []VLANTag{{TPID: 0x8100, VID: 7}}},
+		IPv4Header{Version: 4, TTL: 64, Protocol: 17, SourceIP: netip.MustParseAddr("10.0.0.1"), DestinationIP: netip.MustParseAddr("10.0.0.2")},
+		&UDPHeader{SourcePort: 5000, DestinationPort: 53},
+		Payload("query"),
+	)
+	ipPacket, err := IPPacketFromBytes(raw)
+	if err != nil {
+		t.Fatalf("expected no error - got %v", err)
+	}
+	packet, err := UDPPacketFromIPPacket(ipPacket)
+	if err != nil {
+		t.Fatalf("expected no error - got %v", err)
+	}
+
+	// the decoded packet, modified, is serialized again with its checksums recomputed
+	ipv4 := ipPacket.(*IPv4Packet)
+	ipv4.IPv4Header.SourceIP = netip.MustParseAddr("172.16.0.1")
+	packet.Header.DestinationPort = 5353
+	rewritten, err := SerializePacket(packet, SerializeOptions{FixLengths: true, ComputeChecksums: true})
+	if err != nil {
+		t.Fatalf("expected no error - got %v", err)
+	}
+	if len(rewritten) != len(raw) {
+		t.Fatalf("expected %d bytes - got %d", len(raw), len(rewritten))
+	}
+
+	decoded, err := IPPacketFromBytes(rewritten)
+	if err != nil {
+		t.Fatalf("expected no error - got %v", err)
+	}
+	h := decoded.Header().(IPv4Header)
+	if h.Source() != "172.16.0.1" || foldChecksum(sum16(rewritten[18:38], 0)) != 0 {
+		t.Errorf("expected source 172.16.0.1 with a valid checksum - got %s (checksum 0x%04x)", h.Source(), h.HeaderChecksum)
+	}
+	if !validTransportChecksum(h, 17, decoded.Payload()) {
+		t.Errorf("expected a valid UDP checksum")
+	}
+	if !bytes.Equal(rewritten[:18], raw[:18]) || !bytes.HasSuffix(rewritten, []byte("query")) {
+		t.Errorf("expected the Ethernet frame and payload to be kept - got % x", rewritten)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"strings"
 )
 
 type TCPPacket struct {
@@ -21,7 +22,7 @@ type TCPHeader struct {
 	WindowSize      uint16
 	Checksum        uint16
 	UrgentPointer   uint16
-	Options         []byte
+	Options         []TCPOption
 }
 
 var (
@@ -64,26 +65,52 @@ func TCPHeaderFromBytes(raw []byte) (*TCPHeader, error) {
 		UrgentPointer:   binary.BigEndian.Uint16(raw[18:20]),
 	}
 	if hLen > 20 {
-		h.Options = raw[20:hLen]
+		h.Options = tcpOptionsFromBytes(raw[20:hLen])
 	}
 	return h, nil
 }
 
 // Info return an human-readable string containing the main TCP packet data
 func (p TCPPacket) Info() string {
+	h := p.Header
 	return fmt.Sprintf(`
 TCP packet
 
 Source Port: %d
 Destination Port: %d
+Sequence Number: %d
+Acknowledgment Number: %d
+Flags: 0x%02X (%s)
+Window Size: %d
 Checksum: %d
+Options: %s
 
 ===============================
 %s`,
-		p.Header.SourcePort, p.Header.SourcePort, p.Header.Checksum, p.IPPacket.Info(),
+		h.SourcePort, h.DestinationPort, h.SequenceNumber, h.AckNumber, h.Flags, h.flagNames(),
+		h.WindowSize, h.Checksum, h.optionsInfo(), p.IPPacket.Info(),
 	)
 }
 
+// flagNames returns the names of the flags set, like "SYN, ACK"
+func (h TCPHeader) flagNames() string {
+	var names []string
+	for i, name := range []string{"FIN", "SYN", "RST", "PSH", "ACK", "URG", "ECE", "CWR"} {
+		if h.Flags&(1<<i) != 0 {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// Frame returns the Ethernet frame carrying the packet, or nil if the IP packet does not keep it
+func (p *TCPPacket) Frame() *EthernetFrame {
+	if f, ok := p.IPPacket.(Framed); ok {
+		return f.Frame()
+	}
+	return nil
+}
+
 func (p TCPPacket) Source() string {
 	return fmt.Sprintf("%s:%d", p.IPPacket.Header().Source(), p.Header.SourcePort)
 }
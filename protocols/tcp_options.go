@@ -0,0 +1,339 @@
+package protocols
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// TCP option kinds
+const (
+	TCPOptionEndOfList      = 0
+	TCPOptionNOP            = 1
+	TCPOptionMSS            = 2
+	TCPOptionWindowScale    = 3
+	TCPOptionSACKPermitted  = 4
+	TCPOptionSACK           = 5
+	TCPOptionTimestamps     = 8
+	TCPOptionUserTimeout    = 28
+	TCPOptionAuthentication = 29
+	TCPOptionMPTCP          = 30
+	TCPOptionFastOpen       = 34
+	TCPOptionExperimental   = 254
+)
+
+// tcpFastOpenMagic identifies the experimental option used by TCP Fast Open before kind 34 was assigned
+const tcpFastOpenMagic = 0xF989
+
+// tcpMaxWindowScale is the largest shift count allowed by RFC 7323
+const tcpMaxWindowScale = 14
+
+// TCP flags
+const (
+	TCPFlagFIN = 0x01
+	TCPFlagSYN = 0x02
+	TCPFlagRST = 0x04
+	TCPFlagPSH = 0x08
+	TCPFlagACK = 0x10
+	TCPFlagURG = 0x20
+	TCPFlagECE = 0x40
+	TCPFlagCWR = 0x80
+)
+
+// Multipath TCP option subtypes (RFC 8684)
+const (
+	MPTCPCapable    = 0
+	MPTCPJoin       = 1
+	MPTCPDSS        = 2
+	MPTCPAddAddr    = 3
+	MPTCPRemoveAddr = 4
+	MPTCPPrio       = 5
+	MPTCPFail       = 6
+	MPTCPFastClose  = 7
+	MPTCPReset      = 8
+)
+
+var ErrInvalidTCPOption = errors.New("invalid TCP option")
+
+var tcpOptionNames = map[uint8]string{
+	TCPOptionEndOfList:      "End of Option List",
+	TCPOptionNOP:            "NOP",
+	TCPOptionMSS:            "MSS",
+	TCPOptionWindowScale:    "Window Scale",
+	TCPOptionSACKPermitted:  "SACK Permitted",
+	TCPOptionSACK:           "SACK",
+	TCPOptionTimestamps:     "Timestamps",
+	TCPOptionUserTimeout:    "User Timeout",
+	TCPOptionAuthentication: "TCP-AO",
+	TCPOptionMPTCP:          "MPTCP",
+	TCPOptionFastOpen:       "Fast Open",
+	TCPOptionExperimental:   "Experimental",
+}
+
+var mptcpSubtypeNames = map[uint8]string{
+	MPTCPCapable:    "MP_CAPABLE",
+	MPTCPJoin:       "MP_JOIN",
+	MPTCPDSS:        "DSS",
+	MPTCPAddAddr:    "ADD_ADDR",
+	MPTCPRemoveAddr: "REMOVE_ADDR",
+	MPTCPPrio:       "MP_PRIO",
+	MPTCPFail:       "MP_FAIL",
+	MPTCPFastClose:  "MP_FASTCLOSE",
+	MPTCPReset:      "MP_TCPRST",
+}
+
+// TCPOption is an option of the TCP header. NOP options have no length nor data, while the Data of
+// the End of Option List holds the bytes following it up to the end of the header, normally zeros.
+// The options without a dedicated accessor, like unknown ones, are available through their raw Data
+type TCPOption struct {
+	Kind uint8
+	Data []byte
+	// Malformed options, whose length is invalid or exceeds the header, end the options:
+	// their Data holds the bytes following the kind, length included, up to the end of the header
+	Malformed bool
+}
+
+// SACKBlock is a block of data received out of order, reported by a SACK option
+type SACKBlock struct {
+	Left  uint32 // sequence number of the first byte of the block
+	Right uint32 // sequence number following the last byte of the block
+}
+
+// MPTCPOption is a Multipath TCP option, whose subtype-specific fields are kept raw
+type MPTCPOption struct {
+	Subtype uint8
+	// Data follows the subtype, starting with the 4 bits sharing its byte
+	Data []byte
+}
+
+// tcpOptionsFromBytes decodes the options of a TCP header, which end with the header, an End of Option List
+// or a malformed option, kept raw together with the rest of the header
+func tcpOptionsFromBytes(raw []byte) []TCPOption {
+	var options []TCPOption
+	for len(raw) > 0 {
+		switch raw[0] {
+		case TCPOptionEndOfList:
+			o := TCPOption{Kind: TCPOptionEndOfList}
+			if len(raw) > 1 {
+				o.Data = raw[1:]
+			}
+			return append(options, o)
+		case TCPOptionNOP:
+			options = append(options, TCPOption{Kind: TCPOptionNOP})
+			raw = raw[1:]
+			continue
+		}
+		if len(raw) < 2 || raw[1] < 2 || len(raw) < int(raw[1]) {
+			o := TCPOption{Kind: raw[0], Malformed: true}
+			if len(raw) > 1 {
+				o.Data = raw[1:]
+			}
+			return append(options, o)
+		}
+		o := TCPOption{Kind: raw[0]}
+		if raw[1] > 2 {
+			o.Data = raw[2:raw[1]]
+		}
+		options = append(options, o)
+		raw = raw[raw[1]:]
+	}
+	return options
+}
+
+// serializeTCPOptions encodes the options, not padded
+func serializeTCPOptions(options []TCPOption) ([]byte, error) {
+	var raw []byte
+	for _, o := range options {
+		switch {
+		case o.Malformed, o.Kind == TCPOptionEndOfList:
+			raw = append(append(raw, o.Kind), o.Data...)
+			continue
+		case o.Kind == TCPOptionNOP:
+			raw = append(raw, o.Kind)
+			continue
+		}
+		if len(o.Data) > 0xFF-2 {
+			return nil, fmt.Errorf("%w: kind %d is too long: %d bytes", ErrInvalidTCPOption, o.Kind, len(o.Data))
+		}
+		raw = append(append(raw, o.Kind, uint8(2+len(o.Data))), o.Data...)
+	}
+	return raw, nil
+}
+
+// Option returns the first well-formed option of the given kind, if any
+func (h TCPHeader) Option(kind uint8) (TCPOption, bool) {
+	for _, o := range h.Options {
+		if o.Kind == kind && !o.Malformed {
+			return o, true
+		}
+	}
+	return TCPOption{}, false
+}
+
+// MSS returns the maximum segment size announced by a SYN segment
+func (h TCPHeader) MSS() (uint16, bool) {
+	o, ok := h.Option(TCPOptionMSS)
+	if !ok || len(o.Data) != 2 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint16(o.Data), true
+}
+
+// WindowScale returns the shift count announced by a SYN segment, applying to the windows of the following
+// segments sent by the same side of the connection. Shifts larger than 14 are reduced to 14
+func (h TCPHeader) WindowScale() (uint8, bool) {
+	o, ok := h.Option(TCPOptionWindowScale)
+	if !ok || len(o.Data) != 1 {
+		return 0, false
+	}
+	return min(o.Data[0], tcpMaxWindowScale), true
+}
+
+// SACKPermitted reports whether a SYN segment allows selective acknowledgments
+func (h TCPHeader) SACKPermitted() bool {
+	_, ok := h.Option(TCPOptionSACKPermitted)
+	return ok
+}
+
+// SACKBlocks returns the blocks reported by a SACK option
+func (h TCPHeader) SACKBlocks() []SACKBlock {
+	o, ok := h.Option(TCPOptionSACK)
+	if !ok || len(o.Data)%8 != 0 {
+		return nil
+	}
+	var blocks []SACKBlock
+	for data := o.Data; len(data) > 0; data = data[8:] {
+		blocks = append(blocks, SACKBlock{Left: binary.BigEndian.Uint32(data[0:4]), Right: binary.BigEndian.Uint32(data[4:8])})
+	}
+	return blocks
+}
+
+// Timestamps returns the timestamp value and echo reply of a timestamps option
+func (h TCPHeader) Timestamps() (value, echo uint32, ok bool) {
+	o, ok := h.Option(TCPOptionTimestamps)
+	if !ok || len(o.Data) != 8 {
+		return 0, 0, false
+	}
+	return binary.BigEndian.Uint32(o.Data[0:4]), binary.BigEndian.Uint32(o.Data[4:8]), true
+}
+
+// FastOpenCookie returns the cookie of a TCP Fast Open option, empty if the client requests one.
+// The experimental option used before kind 34 was assigned is recognized as well
+func (h TCPHeader) FastOpenCookie() ([]byte, bool) {
+	for _, o := range h.Options {
+		if cookie, ok := o.fastOpenCookie(); ok {
+			return cookie, true
+		}
+	}
+	return nil, false
+}
+
+func (o TCPOption) fastOpenCookie() ([]byte, bool) {
+	switch {
+	case o.Malformed:
+		return nil, false
+	case o.Kind == TCPOptionFastOpen:
+		return o.Data, true
+	case o.Kind == TCPOptionExperimental && len(o.Data) >= 2 && binary.BigEndian.Uint16(o.Data) == tcpFastOpenMagic:
+		return o.Data[2:], true
+	default:
+		return nil, false
+	}
+}
+
+// MPTCP returns the Multipath TCP options of the segment
+func (h TCPHeader) MPTCP() []MPTCPOption {
+	var options []MPTCPOption
+	for _, o := range h.Options {
+		if o.Kind == TCPOptionMPTCP && !o.Malformed && len(o.Data) > 0 {
+			options = append(options, MPTCPOption{Subtype: o.Data[0] >> 4, Data: o.Data})
+		}
+	}
+	return options
+}
+
+// ScaledWindow returns the receive window advertised by the segment, shifted by the window scale announced
+// by the SYN of the same side of the connection. The window of SYN segments is never scaled
+func (h TCPHeader) ScaledWindow(shift uint8) uint32 {
+	if h.Flags&TCPFlagSYN != 0 {
+		return uint32(h.WindowSize)
+	}
+	return uint32(h.WindowSize) << min(shift, tcpMaxWindowScale)
+}
+
+// String returns a human-readable description of the option
+func (o MPTCPOption) String() string {
+	name, ok := mptcpSubtypeNames[o.Subtype]
+	if !ok {
+		name = fmt.Sprintf("subtype %d", o.Subtype)
+	}
+	switch o.Subtype {
+	case MPTCPCapable:
+		return fmt.Sprintf("%s version %d", name, o.Data[0]&0x0F)
+	case MPTCPJoin, MPTCPAddAddr, MPTCPRemoveAddr:
+		if len(o.Data) >= 2 {
+			return fmt.Sprintf("%s address ID %d", name, o.Data[1])
+		}
+	}
+	return name
+}
+
+// String returns a human-readable description of the option
+func (o TCPOption) String() string {
+	name, ok := tcpOptionNames[o.Kind]
+	if !ok {
+		name = fmt.Sprintf("Option %d", o.Kind)
+	}
+	if o.Malformed {
+		return fmt.Sprintf("%s: malformed % x", name, o.Data)
+	}
+	h := TCPHeader{Options: []TCPOption{o}}
+	switch o.Kind {
+	case TCPOptionMSS:
+		if mss, ok := h.MSS(); ok {
+			return fmt.Sprintf("%s: %d", name, mss)
+		}
+	case TCPOptionWindowScale:
+		if len(o.Data) == 1 {
+			return fmt.Sprintf("%s: %d (x%d)", name, o.Data[0], 1<<min(o.Data[0], tcpMaxWindowScale))
+		}
+	case TCPOptionSACKPermitted, TCPOptionNOP, TCPOptionEndOfList:
+		return name
+	case TCPOptionSACK:
+		if blocks := h.SACKBlocks(); blocks != nil {
+			s := make([]string, len(blocks))
+			for i, b := range blocks {
+				s[i] = fmt.Sprintf("%d-%d", b.Left, b.Right)
+			}
+			return fmt.Sprintf("%s: %s", name, strings.Join(s, ", "))
+		}
+	case TCPOptionTimestamps:
+		if value, echo, ok := h.Timestamps(); ok {
+			return fmt.Sprintf("%s: value %d, echo reply %d", name, value, echo)
+		}
+	case TCPOptionMPTCP:
+		if options := h.MPTCP(); len(options) > 0 {
+			return fmt.Sprintf("%s: %s", name, options[0])
+		}
+	case TCPOptionFastOpen, TCPOptionExperimental:
+		if cookie, ok := o.fastOpenCookie(); ok {
+			if len(cookie) == 0 {
+				return "Fast Open: cookie request"
+			}
+			return fmt.Sprintf("Fast Open: cookie % x", cookie)
+		}
+	}
+	return fmt.Sprintf("%s: % x", name, o.Data)
+}
+
+// optionsInfo joins the descriptions of the options, leaving out the padding
+func (h TCPHeader) optionsInfo() string {
+	var s []string
+	for _, o := range h.Options {
+		if o.Kind != TCPOptionNOP && o.Kind != TCPOptionEndOfList {
+			s = append(s, o.String())
+		}
+	}
+	return strings.Join(s, ", ")
+}
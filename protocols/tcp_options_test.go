@@ -0,0 +1,229 @@
+package protocols
+
+import (
+	"bytes"
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// tcpHeaderWithOptions returns a TCP header with the flags and the options, which must be a multiple of 4 bytes
+func tcpHeaderWithOptions(flags uint8, options ...byte) []byte {
+	raw := []byte{
+		0x9c, 0x40, 0x01, 0xbb, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00,
+		uint8(5+len(options)/4) << 4, flags, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+	return append(raw, options...)
+}
+
+func TestTCPOptions(t *testing.T) {
+	syn, err := TCPHeaderFromBytes(tcpHeaderWithOptions(TCPFlagSYN,
+		0x02, 0x04, 0x05, 0xb4, // MSS 1460
+		0x04, 0x02, // SACK permitted
+		0x08, 0x0a, 0x00, 0x00, 0x00, 0x2a, 0x00, 0x00, 0x00, 0x00, // timestamps 42, 0
+		0x01,             // NOP
+		0x03, 0x03, 0x07, // window scale 7
+	))
+	if err != nil {
+		t.Fatalf("expected no error decoding the SYN - got %v", err)
+	}
+	if mss, ok := syn.MSS(); !ok || mss != 1460 {
+		t.Errorf("expected MSS 1460 - got %d, %v", mss, ok)
+	}
+	if shift, ok := syn.WindowScale(); !ok || shift != 7 {
+		t.Errorf("expected window scale 7 - got %d, %v", shift, ok)
+	}
+	if !syn.SACKPermitted() {
+		t.Error("expected SACK to be permitted")
+	}
+	if value, echo, ok := syn.Timestamps(); !ok || value != 42 || echo != 0 {
+		t.Errorf("expected timestamps 42 and 0 - got %d, %d, %v", value, echo, ok)
+	}
+	if got := syn.ScaledWindow(7); got != 256 {
+		t.Errorf("expected the window of a SYN not to be scaled - got %d", got)
+	}
+	expected := "MSS: 1460, SACK Permitted, Timestamps: value 42, echo reply 0, Window Scale: 7 (x128)"
+	if got := syn.optionsInfo(); got != expected {
+		t.Errorf("expected options %q - got %q", expected, got)
+	}
+
+	ack, err := TCPHeaderFromBytes(tcpHeaderWithOptions(TCPFlagACK,
+		0x01, 0x01, 0x05, 0x12, // SACK with 2 blocks
+		0x00, 0x00, 0x03, 0xe8, 0x00, 0x00, 0x07, 0xd0,
+		0x00, 0x00, 0x0b, 0xb8, 0x00, 0x00, 0x0f, 0xa0,
+	))
+	if err != nil {
+		t.Fatalf("expected no error decoding the ACK - got %v", err)
+	}
+	blocks := []SACKBlock{{Left: 1000, Right: 2000}, {Left: 3000, Right: 4000}}
+	if got := ack.SACKBlocks(); !reflect.DeepEqual(got, blocks) {
+		t.Errorf("expected SACK blocks %v - got %v", blocks, got)
+	}
+	if got := ack.ScaledWindow(7); got != 256<<7 {
+		t.Errorf("expected window %d - got %d", 256<<7, got)
+	}
+	if _, ok := ack.MSS(); ok || ack.SACKPermitted() {
+		t.Error("expected no MSS and SACK permitted options")
+	}
+}
+
+func TestTCPOptionsExtensions(t *testing.T) {
+	tests := []struct {
+		name     string
+		options  []byte
+		expected string
+		check    func(t *testing.T, h *TCPHeader)
+	}{
+		{
+			name:     "Fast Open cookie",
+			options:  []byte{0x22, 0x0a, 0xde, 0xad, 0xbe, 0xef, 0x01, 0x02, 0x03, 0x04, 0x00, 0x00},
+			expected: "Fast Open: cookie de ad be ef 01 02 03 04",
+			check: func(t *testing.T, h *TCPHeader) {
+				if cookie, ok := h.FastOpenCookie(); !ok || !bytes.Equal(cookie, []byte{0xde, 0xad, 0xbe, 0xef, 0x01, 0x02, 0x03, 0x04}) {
+					t.Errorf("expected the Fast Open cookie - got % x, %v", cookie, ok)
+				}
+			},
+		},
+		{
+			name:     "experimental Fast Open cookie request",
+			options:  []byte{0xfe, 0x04, 0xf9, 0x89},
+			expected: "Fast Open: cookie request",
+			check: func(t *testing.T, h *TCPHeader) {
+				if cookie, ok := h.FastOpenCookie(); !ok || len(cookie) != 0 {
+					t.Errorf("expected an empty Fast Open cookie - got % x, %v", cookie, ok)
+				}
+			},
+		},
+		{
+			name:     "MPTCP MP_CAPABLE",
+			options:  []byte{0x1e, 0x0c, 0x01, 0x81, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77, 0x88},
+			expected: "MPTCP: MP_CAPABLE version 1",
+			check: func(t *testing.T, h *TCPHeader) {
+				options := h.MPTCP()
+				if len(options) != 1 || options[0].Subtype != MPTCPCapable || len(options[0].Data) != 10 {
+					t.Errorf("expected an MP_CAPABLE option - got %+v", options)
+				}
+			},
+		},
+		{
+			name:     "MPTCP ADD_ADDR",
+			options:  []byte{0x1e, 0x08, 0x30, 0x02, 0x0a, 0x00, 0x00, 0x02},
+			expected: "MPTCP: ADD_ADDR address ID 2",
+		},
+		{
+			name:     "unknown option kept raw",
+			options:  []byte{0x63, 0x04, 0xaa, 0xbb},
+			expected: "Option 99: aa bb",
+			check: func(t *testing.T, h *TCPHeader) {
+				if o, ok := h.Option(99); !ok || !bytes.Equal(o.Data, []byte{0xaa, 0xbb}) {
+					t.Errorf("expected the raw data of the unknown option - got %+v, %v", o, ok)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, err := TCPHeaderFromBytes(tcpHeaderWithOptions(TCPFlagSYN, tt.options...))
+			if err != nil {
+				t.Fatalf("expected no error - got %v", err)
+			}
+			if got := h.optionsInfo(); got != tt.expected {
+				t.Errorf("expected options %q - got %q", tt.expected, got)
+			}
+			if tt.check != nil {
+				tt.check(t, h)
+			}
+		})
+	}
+}
+
+func TestTCPOptionsMalformed(t *testing.T) {
+	tests := []struct {
+		name     string
+		options  []byte
+		expected []TCPOption
+		info     string
+	}{
+		{
+			name:     "length beyond the header",
+			options:  []byte{0x02, 0x04, 0x05, 0xb4, 0x08, 0x0a, 0x00, 0x01},
+			expected: []TCPOption{{Kind: TCPOptionMSS, Data: []byte{0x05, 0xb4}}, {Kind: TCPOptionTimestamps, Data: []byte{0x0a, 0x00, 0x01}, Malformed: true}},
+			info:     "MSS: 1460, Timestamps: malformed 0a 00 01",
+		},
+		{
+			name:     "length too short",
+			options:  []byte{0x02, 0x01, 0x00, 0x00},
+			expected: []TCPOption{{Kind: TCPOptionMSS, Data: []byte{0x01, 0x00, 0x00}, Malformed: true}},
+			info:     "MSS: malformed 01 00 00",
+		},
+		{
+			name:     "missing length",
+			options:  []byte{0x01, 0x01, 0x01, 0x02},
+			expected: []TCPOption{{Kind: TCPOptionNOP}, {Kind: TCPOptionNOP}, {Kind: TCPOptionNOP}, {Kind: TCPOptionMSS, Malformed: true}},
+			info:     "MSS: malformed ",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, err := TCPHeaderFromBytes(tcpHeaderWithOptions(TCPFlagSYN, tt.options...))
+			if err != nil {
+				t.Fatalf("expected no error - got %v", err)
+			}
+			if !reflect.DeepEqual(h.Options, tt.expected) {
+				t.Errorf("expected options %+v - got %+v", tt.expected, h.Options)
+			}
+			if got := h.optionsInfo(); got != tt.info {
+				t.Errorf("expected options %q - got %q", tt.info, got)
+			}
+			if _, _, ok := h.Timestamps(); ok {
+				t.Error("expected no timestamps from a malformed option")
+			}
+			if raw, err := serializeTCPOptions(h.Options); err != nil || !bytes.Equal(raw, tt.options) {
+				t.Errorf("expected the options to serialize to % x - got % x (error %v)", tt.options, raw, err)
+			}
+		})
+	}
+}
+
+func TestTCPOptionsRoundTrip(t *testing.T) {
+	ip := IPv4Header{
+		Version:       4,
+		TTL:           64,
+		Protocol:      6,
+		SourceIP:      netip.MustParseAddr("192.168.0.1"),
+		DestinationIP: netip.MustParseAddr("192.168.0.2"),
+	}
+	// the End of Option List is followed by padding which is not zeros
+	tcp := &TCPHeader{
+		SourcePort:      40000,
+		DestinationPort: 443,
+		Flags:           TCPFlagSYN,
+		WindowSize:      0xFFFF,
+		Options: []TCPOption{
+			{Kind: TCPOptionMSS, Data: []byte{0x05, 0xb4}},
+			{Kind: TCPOptionNOP},
+			{Kind: TCPOptionWindowScale, Data: []byte{0x08}},
+			{Kind: TCPOptionEndOfList, Data: []byte{0xff, 0xff, 0xff}},
+		},
+	}
+	raw := ipFrame(t, ip, tcp, Payload("hello"))
+
+	p := decode(t, raw, TCPPacketFromIPPacket)
+	if !reflect.DeepEqual(p.Header.Options, tcp.Options) {
+		t.Errorf("expected options %v - got %v", tcp.Options, p.Header.Options)
+	}
+	if got := statuses(VerifyChecksums(p)); got[LayerTypeTCP] != ChecksumGood {
+		t.Errorf("expected a good TCP checksum - got %v", got)
+	}
+	if serialized, err := SerializePacket(p, SerializeOptions{}); err != nil || !bytes.Equal(serialized, raw) {
+		t.Errorf("expected the packet to serialize to\n% x\ngot (error %v)\n% x", raw, err, serialized)
+	}
+
+	info := p.Info()
+	for _, line := range []string{"Destination Port: 443", "Flags: 0x02 (SYN)", "Options: MSS: 1460, Window Scale: 8 (x256)"} {
+		if !strings.Contains(info, line) {
+			t.Errorf("expected %q in the TCP details", line)
+		}
+	}
+}
@@ -1,91 +1,85 @@
 package protocols
 
 import (
+	"net/netip"
 	"reflect"
 	"testing"
 )
 
+// serializeTCPHeader returns the bytes of the TCP header, copying its data offset and checksum as they are
+func serializeTCPHeader(t *testing.T, h TCPHeader) []byte {
+	t.Helper()
+	raw, err := SerializeLayers(SerializeOptions{}, &h)
+	if err != nil {
+		t.Fatalf("expected no error serializing the TCP header - got %v", err)
+	}
+	return raw
+}
+
+var (
+	// the timestamps option is cut short by the end of the header
+	tcpTestOptions = []TCPOption{{Kind: TCPOptionNOP}, {Kind: TCPOptionNOP}, {Kind: TCPOptionTimestamps, Data: []byte{0x0a, 0x00, 0x00, 0x00, 0x01}, Malformed: true}}
+
+	tcpTestHeader = TCPHeader{
+		SourcePort:      80,
+		DestinationPort: 443,
+		SequenceNumber:  474378072,
+		AckNumber:       0,
+		RawOffset:       5,
+		Flags:           0x2,
+		WindowSize:      8192,
+		Checksum:        0xe057,
+		UrgentPointer:   0,
+	}
+	tcpTestHeaderWithOptions = TCPHeader{
+		SourcePort:      80,
+		DestinationPort: 443,
+		SequenceNumber:  474378072,
+		AckNumber:       0,
+		RawOffset:       7,
+		Flags:           0x2,
+		WindowSize:      8192,
+		Checksum:        0xe057,
+		UrgentPointer:   0,
+		Options:         tcpTestOptions,
+	}
+)
+
 func TestTCPPacketFromIPPacket(t *testing.T) {
 	tests := []struct {
-		name              string
-		IPPacket          IPPacket
-		expectedErr       error
-		expectedTCPPacket *TCPPacket
+		name string
+		ip   SerializableLayer
 	}{
 		{
 			name: "IPv4 Packet - Valid",
-			IPPacket: ipv4Packet{
-				payload: []byte{
-					0x00, 0x50, 0x01, 0xbb, 0x1c, 0x46, 0x6f, 0x58, 0x00, 0x00, 0x00, 0x00,
-					0x70, 0x02, 0x20, 0x00, 0xe0, 0x57, 0x00, 0x00,
-					0x01, 0x01, 0x08, 0x0a, 0x00, 0x00, 0x00, 0x01,
-				},
-			},
-			expectedErr: nil,
-			expectedTCPPacket: &TCPPacket{
-				IPPacket: ipv4Packet{
-					payload: []byte{
-						0x00, 0x50, 0x01, 0xbb, 0x1c, 0x46, 0x6f, 0x58, 0x00, 0x00, 0x00, 0x00,
-						0x70, 0x02, 0x20, 0x00, 0xe0, 0x57, 0x00, 0x00,
-						0x01, 0x01, 0x08, 0x0a, 0x00, 0x00, 0x00, 0x01,
-					},
-				},
-				Header: TCPHeader{
-					SourcePort:      80,
-					DestinationPort: 443,
-					SequenceNumber:  474378072,
-					AckNumber:       0,
-					RawOffset:       7,
-					Flags:           0x2,
-					WindowSize:      8192,
-					Checksum:        0xe057,
-					UrgentPointer:   0,
-					Options:         []byte{0x01, 0x01, 0x08, 0x0a, 0x00, 0x00, 0x00, 0x01},
-				},
-			},
+			ip:   IPv4Header{Version: 4, TTL: 64, Protocol: 6, SourceIP: netip.MustParseAddr("192.168.0.1"), DestinationIP: netip.MustParseAddr("192.168.0.2")},
 		},
 		{
 			name: "IPv6 Packet - Valid",
-			IPPacket: ipv6Packet{
-				payload: []byte{
-					0x00, 0x50, 0x01, 0xbb, 0x1c, 0x46, 0x6f, 0x58, 0x00, 0x00, 0x00, 0x00,
-					0x70, 0x02, 0x20, 0x00, 0xe0, 0x57, 0x00, 0x00,
-					0x01, 0x01, 0x08, 0x0a, 0x00, 0x00, 0x00, 0x01,
-				},
-			},
-			expectedErr: nil,
-			expectedTCPPacket: &TCPPacket{
-				IPPacket: ipv6Packet{
-					payload: []byte{
-						0x00, 0x50, 0x01, 0xbb, 0x1c, 0x46, 0x6f, 0x58, 0x00, 0x00, 0x00, 0x00,
-						0x70, 0x02, 0x20, 0x00, 0xe0, 0x57, 0x00, 0x00,
-						0x01, 0x01, 0x08, 0x0a, 0x00, 0x00, 0x00, 0x01,
-					},
-				},
-				Header: TCPHeader{
-					SourcePort:      80,
-					DestinationPort: 443,
-					SequenceNumber:  474378072,
-					AckNumber:       0,
-					RawOffset:       7,
-					Flags:           0x2,
-					WindowSize:      8192,
-					Checksum:        0xe057,
-					UrgentPointer:   0,
-					Options:         []byte{0x01, 0x01, 0x08, 0x0a, 0x00, 0x00, 0x00, 0x01},
-				},
-			},
+			ip:   IPv6Header{Version: 6, NextHeader: 6, HopLimit: 64, SourceIP: netip.MustParseAddr("2001:db8::1"), DestinationIP: netip.MustParseAddr("2001:db8::2")},
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			tcp, err := TCPPacketFromIPPacket(tt.IPPacket)
-			if tt.expectedErr != err {
-				t.Errorf("expected error: %v - got %v", tt.expectedErr, err)
+			h := tcpTestHeaderWithOptions
+			ip, err := IPPacketFromBytes(ipFrame(t, tt.ip, &h))
+			if err != nil {
+				t.Fatalf("expected no error decoding the IP packet - got %v", err)
+			}
+
+			tcp, err := TCPPacketFromIPPacket(ip)
+			if err != nil {
+				t.Fatalf("expected no error - got %v", err)
+			}
+			// the checksum is computed by the serialization
+			expected := &TCPPacket{IPPacket: ip, Header: tcpTestHeaderWithOptions}
+			expected.Header.Checksum = tcp.Header.Checksum
+			if !reflect.DeepEqual(tcp, expected) {
+				t.Errorf("expected TCP packet to be %+v - got %+v", expected, tcp)
 			}
-			if tt.expectedErr == nil && !reflect.DeepEqual(tcp, tt.expectedTCPPacket) {
-				t.Errorf("expected TCP packet to be %+v - got %+v", tt.expectedTCPPacket, tcp)
+			if got := statuses(VerifyChecksums(tcp)); got[LayerTypeTCP] != ChecksumGood {
+				t.Errorf("expected a good TCP checksum - got %s", got[LayerTypeTCP])
 			}
 		})
 	}
@@ -99,78 +93,33 @@ func TestTCPHeaderFromBytes(t *testing.T) {
 		expectedErr    error
 	}{
 		{
-			name: "Valid TCP Header without Options",
-			raw: []byte{
-				0x00, 0x50, 0x01, 0xbb, 0x1c, 0x46, 0x6f, 0x58, 0x00, 0x00, 0x00, 0x00,
-				0x50, 0x02, 0x20, 0x00, 0xe0, 0x57, 0x00, 0x00,
-			},
-			expectedHeader: &TCPHeader{
-				SourcePort:      80,
-				DestinationPort: 443,
-				SequenceNumber:  474378072,
-				AckNumber:       0,
-				RawOffset:       5,
-				Flags:           0x2,
-				WindowSize:      8192,
-				Checksum:        0xe057,
-				UrgentPointer:   0,
-				Options:         nil,
-			},
-			expectedErr: nil,
+			name:           "Valid TCP Header without Options",
+			raw:            serializeTCPHeader(t, tcpTestHeader),
+			expectedHeader: &tcpTestHeader,
+			expectedErr:    nil,
 		},
 		{
-			name: "Valid TCP Header with Options",
-			raw: []byte{
-				0x00, 0x50, 0x01, 0xbb, 0x1c, 0x46, 0x6f, 0x58, 0x00, 0x00, 0x00, 0x00,
-				0x70, 0x02, 0x20, 0x00, 0xe0, 0x57, 0x00, 0x00,
-				0x01, 0x01, 0x08, 0x0a, 0x00, 0x00, 0x00, 0x01,
-			},
-			expectedHeader: &TCPHeader{
-				SourcePort:      80,
-				DestinationPort: 443,
-				SequenceNumber:  474378072,
-				AckNumber:       0,
-				RawOffset:       7,
-				Flags:           0x2,
-				WindowSize:      8192,
-				Checksum:        0xe057,
-				UrgentPointer:   0,
-				Options:         []byte{0x01, 0x01, 0x08, 0x0a, 0x00, 0x00, 0x00, 0x01},
-			},
-			expectedErr: nil,
+			name:           "Valid TCP Header with Options",
+			raw:            serializeTCPHeader(t, tcpTestHeaderWithOptions),
+			expectedHeader: &tcpTestHeaderWithOptions,
+			expectedErr:    nil,
 		},
 		{
 			name:           "Invalid TCP Header (too short)",
-			raw:            []byte{0x00, 0x50},
+			raw:            serializeTCPHeader(t, tcpTestHeader)[:2],
 			expectedHeader: nil,
 			expectedErr:    ErrTCPHeaderTooShort,
 		},
 		{
-			name: "Valid TCP Header with minimum length",
-			raw: []byte{
-				0x00, 0x50, 0x01, 0xbb, 0x1c, 0x46, 0x6f, 0x58, 0x00, 0x00, 0x00, 0x00,
-				0x50, 0x02, 0x20, 0x00, 0xe0, 0x57, 0x00, 0x00,
-			},
-			expectedHeader: &TCPHeader{
-				SourcePort:      80,
-				DestinationPort: 443,
-				SequenceNumber:  474378072,
-				AckNumber:       0,
-				RawOffset:       5,
-				Flags:           0x2,
-				WindowSize:      8192,
-				Checksum:        0xe057,
-				UrgentPointer:   0,
-				Options:         nil,
-			},
-			expectedErr: nil,
+			name:           "Valid TCP Header with minimum length",
+			raw:            serializeTCPHeader(t, tcpTestHeader),
+			expectedHeader: &tcpTestHeader,
+			expectedErr:    nil,
 		},
 		{
-			name: "Invalid TCP Header (length mismatch)",
-			raw: []byte{
-				0x00, 0x50, 0x01, 0xbb, 0x1c, 0x46, 0x6f, 0x58, 0x00, 0x00, 0x00, 0x00,
-				0x80, 0x02, 0x20, 0x00, 0xe0, 0x57, 0x00, 0x00,
-			},
+			// the data offset counts the 8 bytes of options which were cut off
+			name:           "Invalid TCP Header (length mismatch)",
+			raw:            serializeTCPHeader(t, tcpTestHeaderWithOptions)[:20],
 			expectedHeader: nil,
 			expectedErr:    ErrTCPHeaderLenMismatch,
 		},
@@ -50,6 +50,14 @@ Checksum: %d
 	)
 }
 
+// Frame returns the Ethernet frame carrying the packet, or nil if the IP packet does not keep it
+func (p *UDPPacket) Frame() *EthernetFrame {
+	if f, ok := p.IPPacket.(Framed); ok {
+		return f.Frame()
+	}
+	return nil
+}
+
 func (p UDPPacket) Source() string {
 	return fmt.Sprintf("%s:%d", p.IPPacket.Header().Source(), p.Header.SourcePort)
 }
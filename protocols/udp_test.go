@@ -14,11 +14,11 @@ func TestUDPPacketFromIPPacket(t *testing.T) {
 	}{
 		{
 			name: "valid IPv4 packet with UDP payload",
-			IPPacket: ipv4Packet{
+			IPPacket: IPv4Packet{
 				payload: []byte{0x1f, 0x90, 0x23, 0xc4, 0x00, 0x10, 0x27, 0x10},
 			},
 			expectedUDPPacket: &UDPPacket{
-				IPPacket: ipv4Packet{
+				IPPacket: IPv4Packet{
 					payload: []byte{0x1f, 0x90, 0x23, 0xc4, 0x00, 0x10, 0x27, 0x10},
 				},
 				Header: UDPHeader{
@@ -32,7 +32,7 @@ func TestUDPPacketFromIPPacket(t *testing.T) {
 		},
 		{
 			name: "IPv4 packet with too short UDP payload",
-			IPPacket: ipv4Packet{
+			IPPacket: IPv4Packet{
 				payload: []byte{0x1f, 0x90, 0x23},
 			},
 			expectedUDPPacket: nil,
@@ -40,11 +40,11 @@ func TestUDPPacketFromIPPacket(t *testing.T) {
 		},
 		{
 			name: "Valid IPv6 packet with UDP payload",
-			IPPacket: ipv6Packet{
+			IPPacket: IPv6Packet{
 				payload: []byte{0x00, 0x01, 0x00, 0x02, 0x00, 0x08, 0x00, 0x00},
 			},
 			expectedUDPPacket: &UDPPacket{
-				IPPacket: ipv6Packet{
+				IPPacket: IPv6Packet{
 					payload: []byte{0x00, 0x01, 0x00, 0x02, 0x00, 0x08, 0x00, 0x00},
 				},
 				Header: UDPHeader{
@@ -58,11 +58,11 @@ func TestUDPPacketFromIPPacket(t *testing.T) {
 		},
 		{
 			name: "IPv6 packet with zero Length UDP payload",
-			IPPacket: ipv6Packet{
+			IPPacket: IPv6Packet{
 				payload: []byte{0x12, 0x34, 0x56, 0x78, 0x00, 0x00, 0x9a, 0xbc},
 			},
 			expectedUDPPacket: &UDPPacket{
-				IPPacket: ipv6Packet{
+				IPPacket: IPv6Packet{
 					payload: []byte{0x12, 0x34, 0x56, 0x78, 0x00, 0x00, 0x9a, 0xbc},
 				},
 				Header: UDPHeader{
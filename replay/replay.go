@@ -0,0 +1,108 @@
+// Package replay transmits the frames read from a capture, like a pcap file, reproducing their original timing
+// and optionally rewriting their addresses.
+package replay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"time"
+
+	"github.com/NamelessOne91/bisturi/capture"
+)
+
+// Options controls the timing of the replay and the addresses rewritten in the frames
+type Options struct {
+	// Speed multiplies the rate at which the frames are sent: 1 reproduces the original timing,
+	// 2 sends them twice as fast. Zero sends them as fast as possible
+	Speed float64
+	// SrcMAC and DstMAC, if set, replace the source and destination MAC addresses of every frame
+	SrcMAC net.HardwareAddr
+	DstMAC net.HardwareAddr
+	// IPs maps the IPv4 and IPv6 addresses to replace, as either source or destination, to their replacement.
+	// The addresses of ARP packets are rewritten as well
+	IPs map[netip.Addr]netip.Addr
+}
+
+// Stats reports the frames sent by a replay
+type Stats struct {
+	Packets uint64
+	Bytes   uint64
+	// Rewritten counts the frames whose IP addresses have been replaced
+	Rewritten uint64
+	Duration  time.Duration
+}
+
+var errInvalidSpeed = errors.New("replay speed must not be negative")
+
+// Replay reads the frames from src until io.EOF, and writes them to dst, like a RawSocket bound to a network interface.
+// Every frame is sent at the time it was captured, relative to the first one, divided by the speed.
+// The frames are rewritten according to the options, updating the checksums covering the replaced addresses.
+// Replay returns when all the frames have been sent, the context is cancelled or a read or write fails
+func Replay(ctx context.Context, src capture.PacketSource, dst io.Writer, opts Options) (stats Stats, err error) {
+	if opts.Speed < 0 {
+		return stats, errInvalidSpeed
+	}
+	rw, err := newRewriter(opts)
+	if err != nil {
+		return stats, err
+	}
+
+	var first time.Time
+	start := time.Now()
+	defer func() { stats.Duration = time.Since(start) }()
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return stats, err
+		}
+		data, md, err := src.ReadPacket()
+		if err == io.EOF {
+			return stats, nil
+		}
+		if err != nil {
+			return stats, fmt.Errorf("failed to read frame %d: %v", stats.Packets+1, err)
+		}
+
+		if opts.Speed > 0 {
+			if stats.Packets == 0 {
+				first = md.Timestamp
+			}
+			// scheduling every frame from the start does not accumulate the delays of the previous ones
+			offset := time.Duration(float64(md.Timestamp.Sub(first)) / opts.Speed)
+			if err := sleepUntil(ctx, start.Add(offset)); err != nil {
+				return stats, err
+			}
+		}
+
+		if rw.rewrite(data) {
+			stats.Rewritten++
+		}
+		if _, err := dst.Write(data); err != nil {
+			return stats, fmt.Errorf("failed to send frame %d: %v", stats.Packets+1, err)
+		}
+		stats.Packets++
+		stats.Bytes += uint64(len(data))
+	}
+}
+
+// sleepUntil waits until the given time, or the context is cancelled.
+// Frames captured out of order, or sent late, do not wait
+func sleepUntil(ctx context.Context, t time.Time) error {
+	d := time.Until(t)
+	if d <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,244 @@
+package replay
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/NamelessOne91/bisturi/capture"
+	"github.com/NamelessOne91/bisturi/protocols"
+)
+
+var (
+	srcMAC = net.HardwareAddr{0x00, 0x1A, 0xB0, 0xCC, 0xDD, 0xEE}
+	dstMAC = net.HardwareAddr{0x00, 0x1A, 0xA0, 0xBB, 0xCC, 0xDD}
+)
+
+// recorder is a writer storing the frames and the time they were sent at
+type recorder struct {
+	frames [][]byte
+	times  []time.Time
+}
+
+func (r *recorder) Write(frame []byte) (int, error) {
+	r.frames = append(r.frames, append([]byte{}, frame...))
+	r.times = append(r.times, time.Now())
+	return len(frame), nil
+}
+
+// ethernetFrame returns an Ethernet frame of the type, between srcMAC and dstMAC, carrying the layers
+func ethernetFrame(t *testing.T, etherType uint16, vlans []protocols.VLANTag, layers ...protocols.SerializableLayer) []byte {
+	t.Helper()
+	frame := &protocols.EthernetFrame{DestinationMAC: dstMAC, SourceMAC: srcMAC, EtherType: etherType, VLANs: vlans}
+	raw, err := protocols.Serialize(append([]protocols.SerializableLayer{frame}, layers...)...)
+	if err != nil {
+		t.Fatalf("expected no error serializing the frame - got %v", err)
+	}
+	return raw
+}
+
+// udpFrame returns an Ethernet frame carrying a UDP datagram between the addresses
+func udpFrame(t *testing.T, src, dst netip.Addr, vlans ...protocols.VLANTag) []byte {
+	t.Helper()
+	ip := protocols.IPv4Header{Version: 4, TTL: 64, Protocol: 17, SourceIP: src, DestinationIP: dst}
+	udp := &protocols.UDPHeader{SourcePort: 40000, DestinationPort: 53}
+	return ethernetFrame(t, 0x0800, vlans, ip, udp, protocols.Payload("bisturi replay"))
+}
+
+// tcpFrame returns an Ethernet frame carrying a TCP segment between the IPv6 addresses, following the extension headers
+func tcpFrame(t *testing.T, src, dst netip.Addr, extensions ...protocols.IPv6ExtensionHeader) []byte {
+	t.Helper()
+	ip := protocols.IPv6Header{Version: 6, NextHeader: 6, HopLimit: 64, SourceIP: src, DestinationIP: dst, Extensions: extensions}
+	if len(extensions) > 0 {
+		ip.NextHeader = extensions[0].Type()
+	}
+	tcp := &protocols.TCPHeader{SourcePort: 40000, DestinationPort: 443, SequenceNumber: 1, Flags: 0x02, WindowSize: 65535}
+	return ethernetFrame(t, 0x86DD, nil, ip, tcp, protocols.Payload("bisturi replay"))
+}
+
+// arpFrame returns an Ethernet frame carrying an ARP request between the addresses
+func arpFrame(t *testing.T, sender, target netip.Addr) []byte {
+	t.Helper()
+	arp := &protocols.ARPPacket{
+		HardwareType:    1,
+		ProtocolType:    0x0800,
+		Operation:       1,
+		SenderHWAddr:    srcMAC,
+		SenderProtoAddr: sender.AsSlice(),
+		TargetHWAddr:    make(net.HardwareAddr, 6),
+		TargetProtoAddr: target.AsSlice(),
+	}
+	return ethernetFrame(t, 0x0806, nil, arp)
+}
+
+func TestReplayTiming(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	frame := udpFrame(t, netip.MustParseAddr("192.168.0.1"), netip.MustParseAddr("192.168.0.2"))
+	packets := []capture.Packet{
+		{Data: frame, Metadata: capture.Metadata{Timestamp: start}},
+		{Data: frame, Metadata: capture.Metadata{Timestamp: start.Add(100 * time.Millisecond)}},
+		{Data: frame, Metadata: capture.Metadata{Timestamp: start.Add(200 * time.Millisecond)}},
+	}
+
+	tests := []struct {
+		name  string
+		speed float64
+		min   time.Duration
+		max   time.Duration
+	}{
+		{name: "original timing", speed: 1, min: 200 * time.Millisecond, max: time.Second},
+		{name: "twice as fast", speed: 2, min: 100 * time.Millisecond, max: 190 * time.Millisecond},
+		{name: "top speed", speed: 0, min: 0, max: 50 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &recorder{}
+			stats, err := Replay(context.Background(), capture.NewReplaySource(packets...), r, Options{Speed: tt.speed})
+			if err != nil {
+				t.Fatalf("expected no error - got %v", err)
+			}
+			if stats.Packets != 3 || stats.Bytes != uint64(3*len(frame)) || len(r.frames) != 3 {
+				t.Fatalf("expected 3 frames to be sent - got %+v", stats)
+			}
+
+			elapsed := r.times[2].Sub(r.times[0])
+			if elapsed < tt.min || elapsed > tt.max {
+				t.Errorf("expected the frames to be sent in %v to %v - got %v", tt.min, tt.max, elapsed)
+			}
+		})
+	}
+}
+
+func TestReplayCancelled(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	frame := udpFrame(t, netip.MustParseAddr("192.168.0.1"), netip.MustParseAddr("192.168.0.2"))
+	src := capture.NewReplaySource(
+		capture.Packet{Data: frame, Metadata: capture.Metadata{Timestamp: start}},
+		capture.Packet{Data: frame, Metadata: capture.Metadata{Timestamp: start.Add(time.Hour)}},
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	stats, err := Replay(ctx, src, &recorder{}, Options{Speed: 1})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the replay to be cancelled - got %v", err)
+	}
+	if stats.Packets != 1 {
+		t.Errorf("expected 1 frame to be sent - got %d", stats.Packets)
+	}
+}
+
+func TestReplayRewrite(t *testing.T) {
+	client4, server4, service4 := netip.MustParseAddr("192.168.0.1"), netip.MustParseAddr("192.168.0.2"), netip.MustParseAddr("10.0.0.2")
+	client6, server6, service6 := netip.MustParseAddr("2001:db8::1"), netip.MustParseAddr("2001:db8::2"), netip.MustParseAddr("fd00::2")
+	newDstMAC := net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x02}
+	opts := Options{
+		DstMAC: newDstMAC,
+		IPs:    map[netip.Addr]netip.Addr{server4: service4, server6: service6},
+	}
+	tag := protocols.VLANTag{TPID: 0x8100, VID: 42}
+	hopByHop := &protocols.IPv6HopByHopHeader{NextHeader: protocols.IPv6ExtDestinationOptions}
+	destOpts := &protocols.IPv6DestinationOptionsHeader{NextHeader: 6, Options: []protocols.IPv6Option{{Type: 0x1E, Data: []byte{1, 2, 3, 4}}}}
+
+	tests := []struct {
+		name      string
+		frame     []byte
+		expected  []byte
+		rewritten bool
+	}{
+		{
+			name:      "UDP over IPv4",
+			frame:     udpFrame(t, client4, server4),
+			expected:  udpFrame(t, client4, service4),
+			rewritten: true,
+		},
+		{
+			name:      "UDP over IPv4 in an 802.1Q frame",
+			frame:     udpFrame(t, server4, client4, tag),
+			expected:  udpFrame(t, service4, client4, tag),
+			rewritten: true,
+		},
+		{
+			name:      "TCP over IPv6",
+			frame:     tcpFrame(t, client6, server6),
+			expected:  tcpFrame(t, client6, service6),
+			rewritten: true,
+		},
+		{
+			name:      "TCP over IPv6 following extension headers",
+			frame:     tcpFrame(t, client6, server6, hopByHop, destOpts),
+			expected:  tcpFrame(t, client6, service6, hopByHop, destOpts),
+			rewritten: true,
+		},
+		{
+			name:      "ARP",
+			frame:     arpFrame(t, client4, server4),
+			expected:  arpFrame(t, client4, service4),
+			rewritten: true,
+		},
+		{
+			name:     "unmapped addresses",
+			frame:    udpFrame(t, client4, netip.MustParseAddr("192.168.0.3")),
+			expected: udpFrame(t, client4, netip.MustParseAddr("192.168.0.3")),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &recorder{}
+			src := capture.NewReplaySource(capture.Packet{Data: tt.frame})
+			stats, err := Replay(context.Background(), src, r, opts)
+			if err != nil {
+				t.Fatalf("expected no error - got %v", err)
+			}
+			if tt.rewritten != (stats.Rewritten == 1) {
+				t.Errorf("expected the frame to be rewritten: %t - got %d rewritten", tt.rewritten, stats.Rewritten)
+			}
+
+			// the checksums are updated as if the packet had been crafted with the new addresses
+			copy(tt.expected[0:6], newDstMAC)
+			if !bytes.Equal(r.frames[0], tt.expected) {
+				t.Errorf("expected frame\n% x\ngot\n% x", tt.expected, r.frames[0])
+			}
+		})
+	}
+}
+
+func TestReplayInvalidOptions(t *testing.T) {
+	invalid := []Options{
+		{Speed: -1},
+		{SrcMAC: net.HardwareAddr{0x00, 0x01}},
+		{IPs: map[netip.Addr]netip.Addr{netip.MustParseAddr("192.168.0.1"): netip.MustParseAddr("fd00::1")}},
+	}
+	for _, opts := range invalid {
+		if _, err := Replay(context.Background(), capture.NewReplaySource(), &recorder{}, opts); err == nil {
+			t.Errorf("expected an error replaying with %+v", opts)
+		}
+	}
+}
+
+func TestUpdateChecksum(t *testing.T) {
+	// the header of TestSerializeIPv4Checksum, whose destination address becomes 192.168.0.1
+	header := []byte{
+		0x45, 0x00, 0x00, 0x73, 0x00, 0x00, 0x40, 0x00,
+		0x40, 0x11, 0xb8, 0x61, 0xc0, 0xa8, 0x00, 0x01,
+		0xc0, 0xa8, 0x00, 0xc7,
+	}
+	got := updateChecksum(0xb861, header[16:20], []byte{0xc0, 0xa8, 0x00, 0x01})
+
+	copy(header[16:20], []byte{0xc0, 0xa8, 0x00, 0x01})
+	header[10], header[11] = 0, 0
+	var sum uint32
+	for i := 0; i < len(header); i += 2 {
+		sum += uint32(header[i])<<8 | uint32(header[i+1])
+	}
+	for sum > 0xFFFF {
+		sum = sum&0xFFFF + sum>>16
+	}
+	if expected := ^uint16(sum); got != expected {
+		t.Errorf("expected checksum 0x%04x - got 0x%04x", expected, got)
+	}
+}
@@ -0,0 +1,173 @@
+package replay
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/netip"
+	"syscall"
+
+	"github.com/NamelessOne91/bisturi/protocols"
+)
+
+// IP protocol numbers of the upper-layer protocols whose checksum covers the addresses, with the offset of the checksum
+var pseudoHeaderChecksums = map[uint8]int{
+	6:  16, // TCP
+	17: 6,  // UDP
+	58: 2,  // ICMPv6
+}
+
+// rewriter replaces the addresses of the frames in place
+type rewriter struct {
+	srcMAC net.HardwareAddr
+	dstMAC net.HardwareAddr
+	ips    map[netip.Addr]netip.Addr
+}
+
+// newRewriter validates the addresses to rewrite
+func newRewriter(opts Options) (*rewriter, error) {
+	for _, mac := range []net.HardwareAddr{opts.SrcMAC, opts.DstMAC} {
+		if mac != nil && len(mac) != 6 {
+			return nil, fmt.Errorf("%s is not an Ethernet MAC address", mac)
+		}
+	}
+	for from, to := range opts.IPs {
+		if !from.IsValid() || !to.IsValid() || from.Is4() != to.Is4() {
+			return nil, fmt.Errorf("can not rewrite %s to %s: both must be IPv4 or IPv6 addresses", from, to)
+		}
+	}
+	return &rewriter{srcMAC: opts.SrcMAC, dstMAC: opts.DstMAC, ips: opts.IPs}, nil
+}
+
+// rewrite replaces the MAC addresses of the frame and the IP addresses of the packet it carries, if any,
+// reporting whether an IP address has been replaced. Frames too short to carry an address are left as they are
+func (rw *rewriter) rewrite(data []byte) bool {
+	frame, err := protocols.EthFrameFromBytes(data)
+	if err != nil {
+		return false
+	}
+	if rw.dstMAC != nil {
+		copy(frame.DestinationMAC, rw.dstMAC)
+	}
+	if rw.srcMAC != nil {
+		copy(frame.SourceMAC, rw.srcMAC)
+	}
+	if len(rw.ips) == 0 {
+		return false
+	}
+
+	switch frame.EtherType {
+	case syscall.ETH_P_IP:
+		return rw.rewriteIPv4(frame.Payload)
+	case syscall.ETH_P_IPV6:
+		return rw.rewriteIPv6(frame.Payload)
+	case syscall.ETH_P_ARP:
+		return rw.rewriteARP(frame.Payload)
+	default:
+		return false
+	}
+}
+
+// rewriteIPv4 replaces the addresses of the IPv4 header, updating its checksum and the one of the TCP or UDP header
+// following it. Only the first fragment of a packet carries the upper-layer header
+func (rw *rewriter) rewriteIPv4(p []byte) bool {
+	if len(p) < 20 || int(p[0]&0x0F)*4 < 20 || len(p) < int(p[0]&0x0F)*4 {
+		return false
+	}
+	ihl := int(p[0]&0x0F) * 4
+	old := append([]byte{}, p[12:20]...)
+	src, dst := rw.replace(p[12:16]), rw.replace(p[16:20])
+	if !src && !dst {
+		return false
+	}
+
+	binary.BigEndian.PutUint16(p[10:12], updateChecksum(binary.BigEndian.Uint16(p[10:12]), old, p[12:20]))
+	if binary.BigEndian.Uint16(p[6:8])&0x1FFF == 0 {
+		updateTransportChecksum(p[9], p[ihl:], old, p[12:20], false)
+	}
+	return true
+}
+
+// rewriteIPv6 replaces the addresses of the IPv6 header, updating the checksum of the TCP, UDP or ICMPv6 header
+// following its extension headers. Only the first fragment of a packet carries the upper-layer header, and the final
+// destination listed by a routing header, which is not replaced, takes the place of the destination in the pseudo-header
+func (rw *rewriter) rewriteIPv6(p []byte) bool {
+	if len(p) < 40 {
+		return false
+	}
+	h, err := protocols.IPv6HeaderFromBytes(p)
+	old := append([]byte{}, p[8:40]...)
+	src, dst := rw.replace(p[8:24]), rw.replace(p[24:40])
+	if !src && !dst {
+		return false
+	}
+	if err != nil {
+		return true
+	}
+	if f := h.FragmentHeader(); f != nil && f.FragmentOffset != 0 {
+		return true
+	}
+
+	if h.FinalDestination() != h.DestinationIP {
+		updateTransportChecksum(h.UpperLayerProtocol(), p[h.Len():], old[:16], p[8:24], true)
+	} else {
+		updateTransportChecksum(h.UpperLayerProtocol(), p[h.Len():], old, p[8:40], true)
+	}
+	return true
+}
+
+// rewriteARP replaces the protocol addresses of an ARP packet for IPv4 over Ethernet
+func (rw *rewriter) rewriteARP(p []byte) bool {
+	if len(p) < 28 || p[4] != 6 || p[5] != 4 {
+		return false
+	}
+	sender, target := rw.replace(p[14:18]), rw.replace(p[24:28])
+	return sender || target
+}
+
+// replace overwrites the address with its replacement, if it has one
+func (rw *rewriter) replace(addr []byte) bool {
+	from, ok := netip.AddrFromSlice(addr)
+	if !ok {
+		return false
+	}
+	to, ok := rw.ips[from]
+	if !ok {
+		return false
+	}
+	copy(addr, to.AsSlice())
+	return true
+}
+
+// updateTransportChecksum updates the checksum of the upper-layer header covering the replaced addresses
+// in its pseudo-header. A zero UDP checksum over IPv4 means none was computed, and is left as it is
+func updateTransportChecksum(proto uint8, segment, old, new []byte, ipv6 bool) {
+	offset, ok := pseudoHeaderChecksums[proto]
+	if !ok || (proto == 58 && !ipv6) || len(segment) < offset+2 {
+		return
+	}
+	checksum := binary.BigEndian.Uint16(segment[offset:])
+	if proto == 17 && checksum == 0 && !ipv6 {
+		return
+	}
+
+	checksum = updateChecksum(checksum, old, new)
+	if proto == 17 && checksum == 0 {
+		checksum = 0xFFFF
+	}
+	binary.BigEndian.PutUint16(segment[offset:], checksum)
+}
+
+// updateChecksum returns the Internet checksum after the 16-bit words it covers have been replaced,
+// without summing the rest of the data again (RFC 1624)
+func updateChecksum(checksum uint16, old, new []byte) uint16 {
+	sum := uint32(^checksum)
+	for i := 0; i+1 < len(old); i += 2 {
+		sum += uint32(^binary.BigEndian.Uint16(old[i:]))
+		sum += uint32(binary.BigEndian.Uint16(new[i:]))
+	}
+	for sum > 0xFFFF {
+		sum = sum&0xFFFF + sum>>16
+	}
+	return ^uint16(sum)
+}
@@ -0,0 +1,29 @@
+package sockets
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// AttachFilter attaches the provided classic BPF program to the raw socket (SO_ATTACH_FILTER),
+// so that unwanted frames are discarded by the kernel before reaching userspace.
+// Attaching an empty program removes any previously attached filter
+func (rs *RawSocket) AttachFilter(prog []syscall.SockFilter) error {
+	if len(prog) == 0 {
+		return rs.DetachFilter()
+	}
+	if err := syscall.AttachLsf(rs.fd, prog); err != nil {
+		return fmt.Errorf("failed to attach BPF filter: %v", err)
+	}
+	return nil
+}
+
+// DetachFilter removes the BPF program attached to the raw socket, if any
+func (rs *RawSocket) DetachFilter() error {
+	err := syscall.DetachLsf(rs.fd)
+	// ENOENT means no filter was attached at all
+	if err != nil && err != syscall.ENOENT {
+		return fmt.Errorf("failed to detach BPF filter: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+package sockets
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/NamelessOne91/bisturi/capture"
+)
+
+// rejectAll is a BPF program discarding every frame, so that reads on the socket block
+var rejectAll = []syscall.SockFilter{{Code: syscall.BPF_RET | syscall.BPF_K, K: 0}}
+
+// openIdle opens a loopback socket which never receives a frame
+func openIdle(t *testing.T, opts ...Option) *RawSocket {
+	t.Helper()
+
+	rs := openLoopback(t, opts...)
+	if err := rs.AttachFilter(rejectAll); err != nil {
+		rs.Close()
+		t.Fatalf("expected no error - got %v", err)
+	}
+	// frames queued before the filter was attached
+	rs.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	for {
+		if _, _, err := rs.ReadPacket(); err != nil {
+			break
+		}
+	}
+	rs.SetReadDeadline(time.Time{})
+	return rs
+}
+
+var backends = []struct {
+	name string
+	opts []Option
+}{
+	{name: "recvmsg"},
+	{name: "ring", opts: []Option{WithRing(DefaultRingConfig)}},
+}
+
+func TestReadDeadline(t *testing.T) {
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			rs := openIdle(t, b.opts...)
+			defer rs.Close()
+
+			start := time.Now()
+			rs.SetReadDeadline(start.Add(50 * time.Millisecond))
+
+			if _, _, err := rs.ReadPacket(); !errors.Is(err, os.ErrDeadlineExceeded) {
+				t.Fatalf("expected error: %v - got %v", os.ErrDeadlineExceeded, err)
+			}
+			if elapsed := time.Since(start); elapsed < 50*time.Millisecond || elapsed > time.Second {
+				t.Errorf("expected the read to time out after 50ms - got %v", elapsed)
+			}
+		})
+	}
+}
+
+func TestCloseInterruptsRead(t *testing.T) {
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			rs := openIdle(t, b.opts...)
+
+			done := make(chan error)
+			go func() {
+				_, _, err := rs.ReadPacket()
+				done <- err
+			}()
+			time.Sleep(20 * time.Millisecond)
+
+			start := time.Now()
+			if err := rs.Close(); err != nil {
+				t.Fatalf("expected no error - got %v", err)
+			}
+			// Close waits for the pending read to return before releasing the descriptor
+			if elapsed := time.Since(start); elapsed > 2*pollInterval {
+				t.Errorf("expected Close to return within %v - got %v", 2*pollInterval, elapsed)
+			}
+
+			select {
+			case err := <-done:
+				if !errors.Is(err, capture.ErrSourceClosed) {
+					t.Errorf("expected error: %v - got %v", capture.ErrSourceClosed, err)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("expected the read to return after closing the socket")
+			}
+		})
+	}
+}
+
+// blockingSource is a PacketSource whose reads block until it is closed
+type blockingSource struct {
+	once   sync.Once
+	closed chan struct{}
+}
+
+func (s *blockingSource) ReadPacket() ([]byte, capture.Metadata, error) {
+	<-s.closed
+	return nil, capture.Metadata{}, capture.ErrSourceClosed
+}
+
+func (s *blockingSource) Close() error {
+	s.once.Do(func() { close(s.closed) })
+	return nil
+}
+
+func (s *blockingSource) Stats() (capture.Stats, error) {
+	return capture.Stats{}, nil
+}
+
+func TestReadToChanCancel(t *testing.T) {
+	tests := []struct {
+		name string
+		src  capture.PacketSource
+	}{
+		{
+			name: "read in progress",
+			src:  &blockingSource{closed: make(chan struct{})},
+		},
+		{
+			// nobody receives from the channels, so sending the packet blocks
+			name: "send in progress",
+			src: capture.NewReplaySource(capture.Packet{Data: []byte{
+				0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x00, 0x1a, 0x2b, 0x3c, 0x4d, 0x5e, 0x08, 0x06,
+				0x00, 0x01, 0x08, 0x00, 0x06, 0x04, 0x00, 0x01,
+				0x00, 0x1a, 0x2b, 0x3c, 0x4d, 0x5e, 0xc0, 0xa8, 0x01, 0x01,
+				0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xc0, 0xa8, 0x01, 0x02,
+			}}),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				ReadToChan(ctx, tt.src, make(chan CapturedPacket), make(chan error))
+				close(done)
+			}()
+
+			time.Sleep(20 * time.Millisecond)
+			cancel()
+
+			select {
+			case <-done:
+			case <-time.After(time.Second):
+				t.Fatal("expected ReadToChan to return after the context is cancelled")
+			}
+			if _, _, err := tt.src.ReadPacket(); err != capture.ErrSourceClosed {
+				t.Errorf("expected the source to be closed - got %v", err)
+			}
+		})
+	}
+}
+
+func TestReadToChanDeadline(t *testing.T) {
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			rs := openIdle(t, b.opts...)
+			defer rs.Close()
+			rs.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+
+			// nobody receives from the channels, so a reported error would block ReadToChan
+			done := make(chan struct{})
+			go func() {
+				ReadToChan(context.Background(), rs, make(chan CapturedPacket), make(chan error))
+				close(done)
+			}()
+
+			select {
+			case <-done:
+			case <-time.After(time.Second):
+				t.Fatal("expected ReadToChan to return after the read deadline expires")
+			}
+		})
+	}
+}
@@ -0,0 +1,241 @@
+package sockets
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/NamelessOne91/bisturi/capture"
+	"golang.org/x/sys/unix"
+)
+
+// FanoutMode selects how the kernel distributes the frames of an interface among the sockets of a fanout group
+type FanoutMode uint16
+
+const (
+	FanoutHash        FanoutMode = unix.PACKET_FANOUT_HASH // by flow: the frames of a flow always reach the same socket
+	FanoutLoadBalance FanoutMode = unix.PACKET_FANOUT_LB   // round robin
+	FanoutCPU         FanoutMode = unix.PACKET_FANOUT_CPU  // by the CPU which received the frame
+)
+
+func (m FanoutMode) String() string {
+	switch m {
+	case FanoutHash:
+		return "hash"
+	case FanoutLoadBalance:
+		return "lb"
+	case FanoutCPU:
+		return "cpu"
+	default:
+		return fmt.Sprintf("FanoutMode(%d)", uint16(m))
+	}
+}
+
+// ParseFanoutMode returns the fanout mode with the given name: "hash", "lb" or "cpu"
+func ParseFanoutMode(name string) (FanoutMode, error) {
+	for _, m := range []FanoutMode{FanoutHash, FanoutLoadBalance, FanoutCPU} {
+		if m.String() == name {
+			return m, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown fanout mode %q: expected hash, lb or cpu", name)
+}
+
+// FanoutGroup is a PACKET_FANOUT group, sharing the frames of an interface among several sockets
+// so that they can be read and decoded in parallel. The kernel assigns the group an unused ID
+// when the first socket joins it
+type FanoutGroup struct {
+	mode FanoutMode
+
+	mu     sync.Mutex
+	id     uint16
+	joined bool
+}
+
+// NewFanoutGroup returns a group distributing the frames among its sockets according to the mode
+func NewFanoutGroup(mode FanoutMode) *FanoutGroup {
+	return &FanoutGroup{mode: mode}
+}
+
+// Mode returns how the frames are distributed among the sockets of the group
+func (g *FanoutGroup) Mode() FanoutMode {
+	return g.mode
+}
+
+// join adds the socket with the given file descriptor to the group
+func (g *FanoutGroup) join(fd int) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.joined {
+		if err := unix.SetsockoptInt(fd, unix.SOL_PACKET, unix.PACKET_FANOUT, int(g.id)|int(g.mode)<<16); err != nil {
+			return fmt.Errorf("failed to join fanout group %d: %v", g.id, err)
+		}
+		return nil
+	}
+
+	// the kernel picks an ID not used by other processes, then returned together with the mode
+	arg := int(g.mode|unix.PACKET_FANOUT_FLAG_UNIQUEID) << 16
+	if err := unix.SetsockoptInt(fd, unix.SOL_PACKET, unix.PACKET_FANOUT, arg); err != nil {
+		return fmt.Errorf("failed to create fanout group: %v", err)
+	}
+	val, err := unix.GetsockoptInt(fd, unix.SOL_PACKET, unix.PACKET_FANOUT)
+	if err != nil {
+		return fmt.Errorf("failed to read the fanout group ID: %v", err)
+	}
+	g.id = uint16(val)
+	g.joined = true
+	return nil
+}
+
+// WithFanout makes the socket join the fanout group when it is bound. All the sockets of a group must be
+// opened for the same protocol and bound to the same interface
+func WithFanout(g *FanoutGroup) Option {
+	return func(rs *RawSocket) error {
+		rs.fanout = g
+		return nil
+	}
+}
+
+// workerBuffer is the number of decoded packets every worker can send ahead of the merge stage
+const workerBuffer = 256
+
+// ReadToChanParallel reads and decodes the frames of every source in its own goroutine, like the sockets
+// of a fanout group, and sends their representation to the passed channel. Errors are sent to another passed channel.
+// A zero window forwards the packets as soon as they are decoded, preserving the order of the frames of a flow
+// only if each flow is read from a single source, as with FanoutHash. Otherwise the packets are merged by timestamp,
+// waiting at most the window for the packets of the slower sources.
+// When the context is cancelled the sources are closed and ReadToChanParallel returns
+func ReadToChanParallel(ctx context.Context, sources []capture.PacketSource, window time.Duration, dataChan chan<- CapturedPacket, errChan chan<- error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	decoded := make(chan workerPacket, workerBuffer)
+	// the fragments of a packet may be read by different sources, unless they are distributed by flow
+	defrag := newReassembler()
+	var wg sync.WaitGroup
+	for i, src := range sources {
+		i, src := i, src
+		workerChan := make(chan CapturedPacket, workerBuffer)
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			defer close(workerChan)
+			readToChan(ctx, src, defrag, workerChan, errChan)
+		}()
+		// packets are tagged with the worker which decoded them, to be merged by timestamp
+		go func() {
+			defer wg.Done()
+			for p := range workerChan {
+				if !send(ctx, decoded, workerPacket{worker: i, packet: p, arrived: time.Now()}) {
+					return
+				}
+			}
+			send(ctx, decoded, workerPacket{worker: i, done: true})
+		}()
+	}
+
+	if window == 0 {
+		forwardPackets(ctx, len(sources), decoded, dataChan)
+	} else {
+		mergePackets(ctx, len(sources), window, decoded, dataChan)
+	}
+	// the workers still running are stopped, closing their sources
+	cancel()
+	wg.Wait()
+}
+
+// workerPacket is a packet decoded by a worker, or the notice that the worker has returned
+type workerPacket struct {
+	worker  int
+	packet  CapturedPacket
+	arrived time.Time
+	done    bool
+}
+
+// forwardPackets sends the decoded packets in the order they are received, until all the workers have returned
+func forwardPackets(ctx context.Context, workers int, decoded <-chan workerPacket, dataChan chan<- CapturedPacket) {
+	for workers > 0 {
+		var wp workerPacket
+		select {
+		case wp = <-decoded:
+		case <-ctx.Done():
+			return
+		}
+
+		if wp.done {
+			workers--
+			continue
+		}
+		if !send(ctx, dataChan, wp.packet) {
+			return
+		}
+	}
+}
+
+// mergePackets sends the decoded packets ordered by timestamp, until all the workers have returned.
+// The earliest packet is sent once every running worker has decoded a packet, or after it has waited for the window
+func mergePackets(ctx context.Context, workers int, window time.Duration, decoded <-chan workerPacket, dataChan chan<- CapturedPacket) {
+	queues := make([][]workerPacket, workers)
+	done := make([]bool, workers)
+	running := workers
+	timer := time.NewTimer(window)
+	defer timer.Stop()
+
+	for {
+		// the earliest packet among the heads of the workers' queues
+		next := -1
+		complete := true
+		for i, q := range queues {
+			if len(q) == 0 {
+				complete = complete && done[i]
+				continue
+			}
+			if next < 0 || q[0].packet.Timestamp().Before(queues[next][0].packet.Timestamp()) {
+				next = i
+			}
+		}
+
+		if next < 0 && running == 0 {
+			return
+		}
+		if next >= 0 {
+			waited := time.Since(queues[next][0].arrived)
+			if complete || waited >= window {
+				if !send(ctx, dataChan, queues[next][0].packet) {
+					return
+				}
+				queues[next] = queues[next][1:]
+				continue
+			}
+			resetTimer(timer, window-waited)
+		} else {
+			resetTimer(timer, window)
+		}
+
+		select {
+		case wp := <-decoded:
+			if wp.done {
+				done[wp.worker] = true
+				running--
+			} else {
+				queues[wp.worker] = append(queues[wp.worker], wp)
+			}
+		case <-timer.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// resetTimer makes the timer fire after d, draining it if it already fired
+func resetTimer(t *time.Timer, d time.Duration) {
+	if !t.Stop() {
+		select {
+		case <-t.C:
+		default:
+		}
+	}
+	t.Reset(d)
+}
@@ -0,0 +1,260 @@
+package sockets
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/NamelessOne91/bisturi/capture"
+	"github.com/NamelessOne91/bisturi/protocols"
+)
+
+// udpFrame returns an Ethernet frame carrying a UDP datagram from 192.168.0.104 with the given source port
+func udpFrame(srcPort uint16) []byte {
+	return []byte{
+		// Ethernet Frame
+		0x00, 0x1A, 0xA0, 0xBB, 0xCC, 0xDD, 0x00, 0x1A, 0xB0, 0xCC, 0xDD, 0xEE, 0x08, 0x00,
+		// IPv4 Header
+		0x45, 0x00, 0x00, 0x1c, 0x1c, 0x46, 0x40, 0x00,
+		0x40, 0x11, 0x00, 0x00, 0xc0, 0xa8, 0x00, 0x68,
+		0xc0, 0xa8, 0x00, 0x01,
+		// UDP Header
+		byte(srcPort >> 8), byte(srcPort), 0x00, 0x35, 0x00, 0x08, 0x00, 0x00,
+	}
+}
+
+// flowPackets returns a packet of the flow with the given source port for every offset from the start
+func flowPackets(srcPort uint16, start time.Time, offsets ...time.Duration) []capture.Packet {
+	packets := make([]capture.Packet, len(offsets))
+	for i, offset := range offsets {
+		packets[i] = capture.Packet{Data: udpFrame(srcPort), Metadata: capture.Metadata{Timestamp: start.Add(offset)}}
+	}
+	return packets
+}
+
+func TestParseFanoutMode(t *testing.T) {
+	for _, mode := range []FanoutMode{FanoutHash, FanoutLoadBalance, FanoutCPU} {
+		got, err := ParseFanoutMode(mode.String())
+		if err != nil || got != mode {
+			t.Errorf("expected mode %s - got %s (error %v)", mode, got, err)
+		}
+	}
+	if _, err := ParseFanoutMode("rollover"); err == nil {
+		t.Errorf("expected an error parsing an unsupported mode")
+	}
+}
+
+func TestFanoutGroup(t *testing.T) {
+	group := NewFanoutGroup(FanoutLoadBalance)
+	first := openLoopback(t, WithFanout(group))
+	defer first.Close()
+	second := openLoopback(t, WithFanout(group))
+	defer second.Close()
+
+	payload := []byte("bisturi fanout test")
+	stop := make(chan struct{})
+	defer close(stop)
+	sendUDP(t, payload, stop)
+
+	// frames are distributed in round robin, so both sockets receive some of the datagrams
+	for i, rs := range []*RawSocket{first, second} {
+		rs.SetReadDeadline(time.Now().Add(2 * time.Second))
+		for {
+			frame, _, err := rs.ReadPacket()
+			if err != nil {
+				t.Fatalf("expected socket %d to receive the datagrams - got %v", i, err)
+			}
+			if bytes.HasSuffix(frame, payload) {
+				break
+			}
+		}
+	}
+}
+
+func TestReadToChanParallel(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	ms := time.Millisecond
+
+	t.Run("merged by timestamp", func(t *testing.T) {
+		// flows are split among the sources, as with the load balancing and CPU modes
+		sources := []capture.PacketSource{
+			capture.NewReplaySource(append(flowPackets(1000, start, 0, 3*ms), flowPackets(2000, start, 4*ms)...)...),
+			capture.NewReplaySource(append(flowPackets(1000, start, 1*ms), flowPackets(2000, start, 2*ms, 5*ms)...)...),
+		}
+		dataChan := make(chan CapturedPacket, 6)
+		errChan := make(chan error, 6)
+		ReadToChanParallel(context.Background(), sources, time.Second, dataChan, errChan)
+		close(dataChan)
+
+		previous := time.Time{}
+		count := 0
+		for p := range dataChan {
+			if p.Timestamp().Before(previous) {
+				t.Errorf("expected packet at %v to follow the one at %v", p.Timestamp(), previous)
+			}
+			previous = p.Timestamp()
+			count++
+		}
+		if count != 6 {
+			t.Errorf("expected 6 packets - got %d", count)
+		}
+	})
+
+	t.Run("forwarded by flow", func(t *testing.T) {
+		// every flow is read from a single source, as with the hash mode
+		sources := []capture.PacketSource{
+			capture.NewReplaySource(flowPackets(1000, start, 0, 2*ms, 4*ms)...),
+			capture.NewReplaySource(flowPackets(2000, start, 1*ms, 3*ms, 5*ms)...),
+		}
+		dataChan := make(chan CapturedPacket, 6)
+		errChan := make(chan error, 6)
+		ReadToChanParallel(context.Background(), sources, 0, dataChan, errChan)
+		close(dataChan)
+
+		lastByFlow := map[string]time.Time{}
+		count := 0
+		for p := range dataChan {
+			if p.Timestamp().Before(lastByFlow[p.Source()]) {
+				t.Errorf("expected the packets of %s to be in order", p.Source())
+			}
+			lastByFlow[p.Source()] = p.Timestamp()
+			count++
+		}
+		if count != 6 {
+			t.Errorf("expected 6 packets - got %d", count)
+		}
+	})
+
+	t.Run("fragments split among the sources", func(t *testing.T) {
+		fragments, segment := udpFragments(t, start)
+		sources := []capture.PacketSource{
+			capture.NewReplaySource(fragments[0]),
+			capture.NewReplaySource(fragments[1]),
+		}
+		dataChan := make(chan CapturedPacket, 2)
+		errChan := make(chan error, 2)
+		ReadToChanParallel(context.Background(), sources, time.Second, dataChan, errChan)
+		close(dataChan)
+		close(errChan)
+
+		for err := range errChan {
+			t.Errorf("expected no errors - got %v", err)
+		}
+		if len(dataChan) != 1 {
+			t.Fatalf("expected 1 reassembled packet - got %d", len(dataChan))
+		}
+		udp, ok := (<-dataChan).NetworkPacket.(*protocols.UDPPacket)
+		if !ok || !bytes.Equal(udp.IPPacket.Payload(), segment) {
+			t.Errorf("expected the reassembled datagram - got %v", udp)
+		}
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		blocking := &blockingSource{closed: make(chan struct{})}
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			ReadToChanParallel(ctx, []capture.PacketSource{blocking, capture.NewReplaySource()}, time.Second, make(chan CapturedPacket), make(chan error))
+		}()
+
+		cancel()
+		select {
+		case <-done:
+		case <-time.After(2 * time.Second):
+			t.Fatal("expected ReadToChanParallel to return after the context is cancelled")
+		}
+	})
+}
+
+// benchmarkDecode decodes b.N frames of 64 flows, spread among the given number of sources and read by run
+func benchmarkDecode(b *testing.B, workers int, run func(sources []capture.PacketSource, dataChan chan<- CapturedPacket, errChan chan<- error)) {
+	start := time.Unix(1700000000, 0)
+	packets := make([][]capture.Packet, workers)
+	for i := 0; i < b.N; i++ {
+		w := i % workers
+		packets[w] = append(packets[w], flowPackets(uint16(1000+i%64), start, time.Duration(i))...)
+	}
+	sources := make([]capture.PacketSource, workers)
+	for i := range sources {
+		sources[i] = capture.NewReplaySource(packets[i]...)
+	}
+
+	dataChan := make(chan CapturedPacket, workerBuffer)
+	errChan := make(chan error, workerBuffer)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for range dataChan {
+		}
+	}()
+
+	b.SetBytes(int64(len(udpFrame(0))))
+	b.ResetTimer()
+	run(sources, dataChan, errChan)
+	close(dataChan)
+	wg.Wait()
+}
+
+func BenchmarkReadToChan(b *testing.B) {
+	benchmarkDecode(b, 1, func(sources []capture.PacketSource, dataChan chan<- CapturedPacket, errChan chan<- error) {
+		ReadToChan(context.Background(), sources[0], dataChan, errChan)
+	})
+}
+
+func BenchmarkReadToChanParallel(b *testing.B) {
+	for _, workers := range []int{1, 2, 4, 8} {
+		for _, window := range []time.Duration{0, time.Second} {
+			name := fmt.Sprintf("workers=%d/forwarded", workers)
+			if window > 0 {
+				name = fmt.Sprintf("workers=%d/merged", workers)
+			}
+			b.Run(name, func(b *testing.B) {
+				benchmarkDecode(b, workers, func(sources []capture.PacketSource, dataChan chan<- CapturedPacket, errChan chan<- error) {
+					ReadToChanParallel(context.Background(), sources, window, dataChan, errChan)
+				})
+			})
+		}
+	}
+}
+
+// BenchmarkFanout reads b.N frames of loopback UDP traffic from the sockets of a fanout group
+func BenchmarkFanout(b *testing.B) {
+	for _, workers := range []int{1, 2, 4} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			group := NewFanoutGroup(FanoutLoadBalance)
+			sources := make([]capture.PacketSource, workers)
+			for i := range sources {
+				sources[i] = openLoopback(b, WithFanout(group), WithRing(DefaultRingConfig))
+			}
+
+			stop := make(chan struct{})
+			defer close(stop)
+			sendUDP(b, make([]byte, 512), stop)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			dataChan := make(chan CapturedPacket, workerBuffer)
+			errChan := make(chan error, workerBuffer)
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				ReadToChanParallel(ctx, sources, 0, dataChan, errChan)
+			}()
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				select {
+				case <-dataChan:
+				case <-errChan:
+				}
+			}
+			b.StopTimer()
+			cancel()
+			<-done
+		})
+	}
+}
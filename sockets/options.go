@@ -0,0 +1,58 @@
+package sockets
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// Option configures a RawSocket when it is opened
+type Option func(rs *RawSocket) error
+
+// WithPromiscuous puts the interface the socket is bound to in promiscuous mode, so that frames
+// not addressed to the host, like the ones mirrored to a SPAN port, are captured too.
+// The interface leaves promiscuous mode when the socket is closed, unless other sockets requested it
+func WithPromiscuous() Option {
+	return withMembership(unix.PACKET_MR_PROMISC)
+}
+
+// WithAllMulticast makes the interface the socket is bound to receive all multicast frames,
+// including the ones of groups the host has not joined. It is reverted when the socket is closed
+func WithAllMulticast() Option {
+	return withMembership(unix.PACKET_MR_ALLMULTI)
+}
+
+// withMembership requests a packet membership of the given type to be added when binding the socket
+func withMembership(mrType uint16) Option {
+	return func(rs *RawSocket) error {
+		rs.memberships = append(rs.memberships, mrType)
+		return nil
+	}
+}
+
+// addMemberships adds the requested packet memberships for the interface with the given index.
+// If any of them fails, the ones already added are dropped
+func (rs *RawSocket) addMemberships(ifindex int) error {
+	for _, mrType := range rs.memberships {
+		mreq := unix.PacketMreq{Ifindex: int32(ifindex), Type: mrType}
+		if err := unix.SetsockoptPacketMreq(rs.fd, unix.SOL_PACKET, unix.PACKET_ADD_MEMBERSHIP, &mreq); err != nil {
+			rs.dropMemberships()
+			return fmt.Errorf("failed to add packet membership %d: %v", mrType, err)
+		}
+		rs.joined = append(rs.joined, mreq)
+	}
+	return nil
+}
+
+// dropMemberships drops the packet memberships added to the socket, returning the first error encountered
+func (rs *RawSocket) dropMemberships() error {
+	var firstErr error
+	for _, mreq := range rs.joined {
+		err := unix.SetsockoptPacketMreq(rs.fd, unix.SOL_PACKET, unix.PACKET_DROP_MEMBERSHIP, &mreq)
+		if err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("failed to drop packet membership %d: %v", mreq.Type, err)
+		}
+	}
+	rs.joined = nil
+	return firstErr
+}
@@ -0,0 +1,45 @@
+package sockets
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// loopbackFlags returns the flags of the loopback interface, as exposed by sysfs
+func loopbackFlags(t *testing.T) uint64 {
+	t.Helper()
+
+	raw, err := os.ReadFile("/sys/class/net/lo/flags")
+	if err != nil {
+		t.Skipf("interface flags not available: %v", err)
+	}
+	flags, err := strconv.ParseUint(strings.TrimPrefix(strings.TrimSpace(string(raw)), "0x"), 16, 64)
+	if err != nil {
+		t.Fatalf("expected no error - got %v", err)
+	}
+	return flags
+}
+
+func TestWithPromiscuous(t *testing.T) {
+	if loopbackFlags(t)&syscall.IFF_PROMISC != 0 {
+		t.Skip("loopback interface already in promiscuous mode")
+	}
+
+	rs := openLoopback(t, WithPromiscuous(), WithAllMulticast())
+	if len(rs.joined) != 2 {
+		t.Errorf("expected 2 packet memberships - got %d", len(rs.joined))
+	}
+	if loopbackFlags(t)&syscall.IFF_PROMISC == 0 {
+		t.Errorf("expected the interface to be in promiscuous mode after binding")
+	}
+
+	if err := rs.Close(); err != nil {
+		t.Fatalf("expected no error - got %v", err)
+	}
+	if loopbackFlags(t)&syscall.IFF_PROMISC != 0 {
+		t.Errorf("expected the interface to leave promiscuous mode after closing")
+	}
+}
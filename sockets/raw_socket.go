@@ -1,50 +1,108 @@
 package sockets
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"net"
-	"strings"
+	"os"
+	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
+	"unsafe"
 
+	"github.com/NamelessOne91/bisturi/capture"
 	"github.com/NamelessOne91/bisturi/protocols"
+	"golang.org/x/sys/unix"
 )
 
 const mask = 0xff00
 
+// oobSize fits the control messages carrying the receive timestamp and the PACKET_AUXDATA of a frame
+var oobSize = syscall.CmsgSpace(int(unsafe.Sizeof(syscall.Timespec{}))) + syscall.CmsgSpace(int(unsafe.Sizeof(unix.TpacketAuxdata{})))
+
+// pollInterval bounds how long a read waits for a frame before checking whether the socket has been closed
+const pollInterval = 100 * time.Millisecond
+
+var errNotBound = errors.New("raw socket must be bound to a network interface to send frames")
+
 // hostToNetworkShort converts a short (uint16) from host (usually Little Endian)
 // to network (Big Endian) byte order
 func hostToNetworkShort(i uint16) uint16 {
 	return (i<<8)&mask | i>>8
 }
 
+// NetworkPacket is a packet decoded from a frame, exposing the layers it is made of
 type NetworkPacket interface {
+	protocols.LayeredPacket
 	Source() string
 	Destination() string
 	Info() string
 }
 
-// RawSocket represents a raw socket and stores info about its file descriptor,
-// Ethernet protocol type and Link Layer info
-type RawSocket struct {
-	fd           int
-	ethType      uint16
-	layer4Filter string
-	sll          syscall.SockaddrLinklayer
+// CapturedPacket is a decoded packet together with the metadata of the frame it was captured in
+type CapturedPacket struct {
+	NetworkPacket
+	Metadata capture.Metadata
+}
+
+// Timestamp returns the time the frame carrying the packet was received
+func (p CapturedPacket) Timestamp() time.Time {
+	return p.Metadata.Timestamp
+}
+
+// PacketType returns who the frame carrying the packet was addressed to, or whether it was sent by the host
+func (p CapturedPacket) PacketType() capture.PacketType {
+	return p.Metadata.PacketType
 }
 
-// NewRawSocket opens a raw socket for the specified protocol by calling SYS_SOCKET
-// and returns the struct representing it, or eventual errors
-func NewRawSocket(protocol string, ethType uint16) (*RawSocket, error) {
-	filter := "all"
-	if strings.HasPrefix(protocol, "udp") {
-		filter = "udp"
-	} else if strings.HasPrefix(protocol, "tcp") {
-		filter = "tcp"
+// Checksums verifies the checksums carried by the packet. The transport checksums of the frames sent by the host
+// are unverified if the kernel left them for the network interface to compute, or if they are zero,
+// as the ones of frames captured on interfaces offloading them without the kernel reporting it
+func (p CapturedPacket) Checksums() []protocols.Checksum {
+	checksums := protocols.VerifyChecksums(p.NetworkPacket)
+	for i, c := range checksums {
+		// only the transport checksums are offloaded
+		if c.Layer != protocols.LayerTypeUDP && c.Layer != protocols.LayerTypeTCP {
+			continue
+		}
+		if p.Metadata.ChecksumNotReady || (p.PacketType().Outgoing() && c.Value == 0) {
+			checksums[i].Status = protocols.ChecksumUnverified
+		}
 	}
+	return checksums
+}
 
+// RawSocket represents a raw socket and stores info about its file descriptor,
+// Ethernet protocol type and Link Layer info. It implements capture.PacketSource
+type RawSocket struct {
+	fd          int
+	ethType     uint16
+	sll         syscall.SockaddrLinklayer
+	ifaceName   string
+	buf         []byte
+	oob         []byte
+	ring        *ring
+	memberships []uint16
+	joined      []unix.PacketMreq
+	fanout      *FanoutGroup
+	received    atomic.Uint64
+	kernel      kernelStats
+	closed      atomic.Bool
+	deadline    atomic.Int64
+	fdMu        sync.RWMutex
+}
+
+// NewRawSocket opens a raw socket for the specified Ethernet protocol type by calling SYS_SOCKET, configures it
+// with the passed options and returns the struct representing it, or eventual errors.
+// Its frames can be further filtered by attaching a BPF program
+func NewRawSocket(ethType uint16, opts ...Option) (*RawSocket, error) {
 	rawSocket := &RawSocket{
-		ethType:      ethType,
-		layer4Filter: filter,
+		ethType: ethType,
+		buf:     make([]byte, 4096),
+		oob:     make([]byte, oobSize),
 	}
 	// AF_PACKET specifies a packet socket, operating at the data link layer (Layer 2)
 	// SOCK_RAW specifies a raw socket
@@ -54,56 +112,417 @@ func NewRawSocket(protocol string, ethType uint16) (*RawSocket, error) {
 	}
 	rawSocket.fd = fd
 
+	// have the kernel report when each frame was received, with nanosecond resolution
+	if err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_TIMESTAMPNS, 1); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("failed to enable SO_TIMESTAMPNS: %v", err)
+	}
+	// and the VLAN tag stripped from it, which would be lost otherwise
+	if err := syscall.SetsockoptInt(fd, unix.SOL_PACKET, unix.PACKET_AUXDATA, 1); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("failed to enable PACKET_AUXDATA: %v", err)
+	}
+
+	for _, opt := range opts {
+		if err := opt(rawSocket); err != nil {
+			rawSocket.Close()
+			return nil, err
+		}
+	}
 	return rawSocket, nil
 }
 
 // BindSocket binds a raw socket  to a network interface allowing to monitor
-// and analyze packets traversing it
+// and analyze packets traversing it. The packet memberships requested by the socket's options,
+// like promiscuous mode, are then added for the interface, and the socket joins its fanout group, if any
 func (rs *RawSocket) Bind(iface net.Interface) error {
 	// network stack uses Big Endian
 	rs.sll.Protocol = hostToNetworkShort(rs.ethType)
 	rs.sll.Ifindex = iface.Index
 
-	return syscall.Bind(rs.fd, &rs.sll)
+	if err := syscall.Bind(rs.fd, &rs.sll); err != nil {
+		return err
+	}
+	rs.ifaceName = iface.Name
+	if err := rs.addMemberships(iface.Index); err != nil {
+		return err
+	}
+	if rs.fanout != nil {
+		return rs.fanout.join(rs.fd)
+	}
+	return nil
 }
 
-// ReadToChan calls SYS_RECVFROM to read data traversing the binded network interface and sends its representation to the passed channel.
-// Errors are sent to another passed channel
-func (rs *RawSocket) ReadToChan(dataChan chan<- NetworkPacket, errChan chan<- error) {
-	buf := make([]byte, 4096)
+// ReadPacket returns the next frame traversing the binded network interface, read from the RX ring
+// if the socket has one, or by calling SYS_RECVMSG otherwise. The frame is timestamped by the kernel on arrival,
+// and its metadata reports the name of the interface and the 802.1Q tag the kernel stripped from it, if any.
+// Frames longer than the read buffer are truncated, but their real length is reported in the metadata.
+// The read waits for a frame until the socket is closed, failing with capture.ErrSourceClosed,
+// or the read deadline expires, failing with os.ErrDeadlineExceeded
+func (rs *RawSocket) ReadPacket() ([]byte, capture.Metadata, error) {
+	// the descriptor is not closed, and possibly reused, while a read is in progress
+	rs.fdMu.RLock()
+	defer rs.fdMu.RUnlock()
 
 	for {
-		n, _, err := syscall.Recvfrom(rs.fd, buf, 0)
+		if rs.closed.Load() {
+			return nil, capture.Metadata{}, capture.ErrSourceClosed
+		}
+		timeout, err := rs.pollTimeout()
 		if err != nil {
-			errChan <- fmt.Errorf("error reading from raw socket: %v", err)
+			return nil, capture.Metadata{}, err
+		}
+
+		frame, md, err := rs.tryRead()
+		if err != nil || frame != nil {
+			return frame, md, err
+		}
+
+		fds := []unix.PollFd{{Fd: int32(rs.fd), Events: unix.POLLIN | unix.POLLERR}}
+		if _, err := unix.Poll(fds, int(timeout.Milliseconds())); err != nil && err != unix.EINTR {
+			return nil, capture.Metadata{}, fmt.Errorf("error polling raw socket: %v", err)
+		}
+	}
+}
+
+// tryRead returns the next frame without blocking, or a nil frame if none is available yet
+func (rs *RawSocket) tryRead() ([]byte, capture.Metadata, error) {
+	if rs.ring != nil {
+		frame, md, ok := rs.ring.next()
+		if !ok {
+			return nil, capture.Metadata{}, nil
+		}
+		rs.received.Add(1)
+		md.Interface = rs.ifaceName
+		return frame, md, nil
+	}
+
+	// with MSG_TRUNC the frame's real length is returned, even if longer than the buffer
+	n, oobn, _, from, err := syscall.Recvmsg(rs.fd, rs.buf, rs.oob, syscall.MSG_TRUNC|syscall.MSG_DONTWAIT)
+	if err == syscall.EAGAIN || err == syscall.EINTR {
+		return nil, capture.Metadata{}, nil
+	}
+	if err != nil {
+		return nil, capture.Metadata{}, fmt.Errorf("error reading from raw socket: %v", err)
+	}
+	capLen := min(n, len(rs.buf))
+
+	// decoded packets reference the frame's bytes, which must outlive the buffer
+	frame := make([]byte, capLen)
+	copy(frame, rs.buf[:capLen])
+	rs.received.Add(1)
+
+	md := capture.Metadata{
+		Timestamp:     receiveTimestamp(rs.oob[:oobn]),
+		CaptureLength: capLen,
+		Length:        n,
+		Interface:     rs.ifaceName,
+	}
+	if aux, ok := packetAuxdata(rs.oob[:oobn]); ok {
+		md.VLAN = vlanFromStatus(aux.Status, aux.Vlan_tci, aux.Vlan_tpid)
+		md.ChecksumNotReady = checksumNotReady(aux.Status)
+	}
+	if sll, ok := from.(*syscall.SockaddrLinklayer); ok {
+		md.PacketType = packetType(sll.Pkttype)
+	}
+	return frame, md, nil
+}
+
+// packetType converts the sll_pkttype of a frame's link layer address.
+// Types only seen by the kernel, like PACKET_LOOPBACK, are reported as unknown
+func packetType(pkttype uint8) capture.PacketType {
+	switch pkttype {
+	case unix.PACKET_HOST:
+		return capture.PacketTypeHost
+	case unix.PACKET_BROADCAST:
+		return capture.PacketTypeBroadcast
+	case unix.PACKET_MULTICAST:
+		return capture.PacketTypeMulticast
+	case unix.PACKET_OTHERHOST:
+		return capture.PacketTypeOtherHost
+	case unix.PACKET_OUTGOING:
+		return capture.PacketTypeOutgoing
+	default:
+		return capture.PacketTypeUnknown
+	}
+}
+
+// Write sends the frame, starting with its Ethernet header, out of the bound network interface as it is:
+// the kernel neither pads it to the minimum length nor computes any checksum. It implements io.Writer.
+// A socket opened for the 0 Ethernet type receives no frames, and can be used to send only.
+// The write waits for room in the socket's send buffer until the socket is closed, failing with capture.ErrSourceClosed
+func (rs *RawSocket) Write(frame []byte) (int, error) {
+	rs.fdMu.RLock()
+	defer rs.fdMu.RUnlock()
+
+	if rs.sll.Ifindex == 0 {
+		return 0, errNotBound
+	}
+	for {
+		if rs.closed.Load() {
+			return 0, capture.ErrSourceClosed
+		}
+
+		err := unix.Sendto(rs.fd, frame, unix.MSG_DONTWAIT, nil)
+		switch err {
+		case nil:
+			return len(frame), nil
+		// the device queue is full at top speed
+		case unix.EAGAIN, unix.ENOBUFS, unix.EINTR:
+		default:
+			return 0, fmt.Errorf("error writing to raw socket: %v", err)
+		}
+
+		fds := []unix.PollFd{{Fd: int32(rs.fd), Events: unix.POLLOUT}}
+		if _, err := unix.Poll(fds, int(pollInterval.Milliseconds())); err != nil && err != unix.EINTR {
+			return 0, fmt.Errorf("error polling raw socket: %v", err)
+		}
+	}
+}
+
+// SetReadDeadline sets the time after which pending and future reads fail with os.ErrDeadlineExceeded.
+// A zero value means reads never time out
+func (rs *RawSocket) SetReadDeadline(t time.Time) error {
+	if t.IsZero() {
+		rs.deadline.Store(0)
+	} else {
+		rs.deadline.Store(t.UnixNano())
+	}
+	return nil
+}
+
+// pollTimeout returns how long to wait for a frame before checking again whether the socket
+// has been closed, or os.ErrDeadlineExceeded if the read deadline has expired
+func (rs *RawSocket) pollTimeout() (time.Duration, error) {
+	deadline := rs.deadline.Load()
+	if deadline == 0 {
+		return pollInterval, nil
+	}
+
+	left := time.Until(time.Unix(0, deadline))
+	if left <= 0 {
+		return 0, os.ErrDeadlineExceeded
+	}
+	// poll's resolution is a millisecond
+	return min(pollInterval, left.Truncate(time.Millisecond)+time.Millisecond), nil
+}
+
+// receiveTimestamp returns the time carried by the SCM_TIMESTAMPNS control message, falling back
+// to the current time if the kernel did not send it
+func receiveTimestamp(oob []byte) time.Time {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return time.Now()
+	}
+	for _, msg := range msgs {
+		if msg.Header.Level != syscall.SOL_SOCKET || msg.Header.Type != syscall.SCM_TIMESTAMPNS {
+			continue
+		}
+		if len(msg.Data) < int(unsafe.Sizeof(syscall.Timespec{})) {
+			break
+		}
+		ts := *(*syscall.Timespec)(unsafe.Pointer(&msg.Data[0]))
+		return time.Unix(ts.Unix())
+	}
+	return time.Now()
+}
+
+// packetAuxdata returns the PACKET_AUXDATA control message, carrying the 802.1Q tag the kernel stripped
+// from the frame and the status of its checksum, or false if the kernel did not send it
+func packetAuxdata(oob []byte) (unix.TpacketAuxdata, bool) {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return unix.TpacketAuxdata{}, false
+	}
+	for _, msg := range msgs {
+		if msg.Header.Level != unix.SOL_PACKET || msg.Header.Type != unix.PACKET_AUXDATA {
 			continue
 		}
+		if len(msg.Data) < int(unsafe.Sizeof(unix.TpacketAuxdata{})) {
+			break
+		}
+		return *(*unix.TpacketAuxdata)(unsafe.Pointer(&msg.Data[0])), true
+	}
+	return unix.TpacketAuxdata{}, false
+}
+
+// vlanFromStatus returns the stripped tag described by the tp_vlan_tci and tp_vlan_tpid fields,
+// valid only if the tp_status flags say so. Older kernels report no TPID, meaning an 802.1Q tag
+func vlanFromStatus(status uint32, tci, tpid uint16) capture.VLAN {
+	if status&unix.TP_STATUS_VLAN_VALID == 0 {
+		return capture.VLAN{}
+	}
+	if status&unix.TP_STATUS_VLAN_TPID_VALID == 0 {
+		tpid = unix.ETH_P_8021Q
+	}
+	return capture.VLAN{Present: true, TPID: tpid, TCI: tci}
+}
+
+// checksumNotReady reports whether the tp_status flags say that the kernel left the transport checksum
+// of an outgoing frame for the network interface to compute
+func checksumNotReady(status uint32) bool {
+	return status&unix.TP_STATUS_CSUMNOTREADY != 0
+}
 
-		switch rs.ethType {
-		case syscall.ETH_P_ALL:
-			ethFrame, err := protocols.EthFrameFromBytes(buf[:n])
-			if err != nil {
-				errChan <- fmt.Errorf("failed to read ETH frame: %v", err)
+// Stats returns the number of frames read from the socket, together with the PACKET_STATISTICS counters:
+// the frames the kernel captured for the socket and the ones it dropped because the socket buffer, or ring, was full
+func (rs *RawSocket) Stats() (capture.Stats, error) {
+	stats := capture.Stats{Received: rs.received.Load()}
+
+	// the counters collected until the socket was closed are still returned
+	var err error
+	rs.fdMu.RLock()
+	if !rs.closed.Load() {
+		err = rs.kernel.update(rs.fd, rs.ring != nil)
+	}
+	rs.fdMu.RUnlock()
+
+	rs.kernel.mu.Lock()
+	defer rs.kernel.mu.Unlock()
+
+	stats.Captured = rs.kernel.packets
+	stats.Dropped = rs.kernel.drops
+	stats.FreezeQueue = rs.kernel.freezeQueue
+	return stats, err
+}
+
+// ReadToChan reads the frames of the passed source until it is exhausted, closed or its read deadline expires, and sends their representation
+// to the passed channel. Errors are sent to another passed channel.
+// When the context is cancelled the source is closed, interrupting a pending read, and ReadToChan returns
+// without sending anything else
+func ReadToChan(ctx context.Context, src capture.PacketSource, dataChan chan<- CapturedPacket, errChan chan<- error) {
+	readToChan(ctx, src, newReassembler(), dataChan, errChan)
+}
+
+// newReassembler returns the reassembler of the IP fragments read by readToChan,
+// which keeps them until their packet can be decoded, expiring them by capture time
+func newReassembler() *protocols.Reassembler {
+	return protocols.NewReassembler(protocols.ReassemblyOptions{})
+}
+
+// readToChan reads and parses the frames of the source, attaching to every packet the metadata of its frame.
+// IP fragments are passed to defrag, which may be shared with the other sources of a fanout group
+func readToChan(ctx context.Context, src capture.PacketSource, defrag *protocols.Reassembler, dataChan chan<- CapturedPacket, errChan chan<- error) {
+	stop := context.AfterFunc(ctx, func() { src.Close() })
+	defer stop()
+
+	// every frame yields at most one packet or one error, so decoding never blocks
+	frameData := make(chan NetworkPacket, 1)
+	frameErr := make(chan error, 1)
+
+	for {
+		frame, md, err := src.ReadPacket()
+		// once the read deadline expires every following read fails as well
+		if ctx.Err() != nil || err == io.EOF || errors.Is(err, capture.ErrSourceClosed) || errors.Is(err, os.ErrDeadlineExceeded) {
+			return
+		}
+		if err != nil {
+			if !send(ctx, errChan, err) {
+				return
+			}
+			// frames are still returned when they could not be written
+			if frame == nil {
 				continue
 			}
+		}
+		ts := md.Timestamp
+		if ts.IsZero() {
+			ts = time.Now()
+		}
+		handleFrame(frame, defrag, ts, frameData, frameErr)
 
-			switch ethFrame.Type() {
-			case "ARP":
-				handleARPPacket(buf[:n], dataChan, errChan)
-			case "IPv4", "IPv6":
-				handleIPPacket(buf[:n], rs.layer4Filter, dataChan, errChan)
-			}
-		case syscall.ETH_P_ARP:
-			handleARPPacket(buf[:n], dataChan, errChan)
-		case syscall.ETH_P_IP, syscall.ETH_P_IPV6:
-			handleIPPacket(buf[:n], rs.layer4Filter, dataChan, errChan)
+		sent := true
+		select {
+		case np := <-frameData:
+			attachVLAN(np, md.VLAN)
+			sent = send(ctx, dataChan, CapturedPacket{NetworkPacket: np, Metadata: md})
+		case err := <-frameErr:
+			sent = send(ctx, errChan, err)
+		default:
 		}
+		if !sent {
+			return
+		}
+	}
+}
+
+// attachVLAN re-attaches the tag the kernel stripped from the frame to the Ethernet frame of the packet,
+// as the outermost one
+func attachVLAN(np NetworkPacket, vlan capture.VLAN) {
+	if !vlan.Present {
+		return
+	}
+	framed, ok := np.(protocols.Framed)
+	if !ok || framed.Frame() == nil {
+		return
 	}
+	frame := framed.Frame()
+	frame.VLANs = append([]protocols.VLANTag{protocols.VLANTagFromTCI(vlan.TPID, vlan.TCI)}, frame.VLANs...)
 }
 
-// Close closes the raw socket by calling SYS_CLOSE on its file descriptor
+// send sends the value to the channel, unless the context is cancelled first. It reports whether the value was sent
+func send[T any](ctx context.Context, c chan<- T, v T) bool {
+	select {
+	case c <- v:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// ParseError is sent to the error channel when a protocol carried by a frame could not be decoded
+type ParseError struct {
+	Protocol string // lowercase name of the protocol, like "arp" or "tcp"
+	Err      error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("failed to parse %s: %v", e.Protocol, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// handleFrame parses the provided Ethernet frame and sends the representation of the packet it carries,
+// or an error, to the provided channels. Frames not carrying a supported protocol are ignored.
+// IP fragments captured at ts are passed to defrag or, if it is nil, decoded on their own
+func handleFrame(raw []byte, defrag *protocols.Reassembler, ts time.Time, dataChan chan<- NetworkPacket, errChan chan<- error) {
+	ethFrame, err := protocols.EthFrameFromBytes(raw)
+	if err != nil {
+		errChan <- &ParseError{Protocol: "ethernet", Err: err}
+		return
+	}
+
+	switch ethFrame.Type() {
+	case "ARP":
+		handleARPPacket(raw, dataChan, errChan)
+	case "IPv4", "IPv6":
+		handleIPPacket(raw, defrag, ts, dataChan, errChan)
+	}
+}
+
+// Close closes the raw socket by calling SYS_CLOSE on its file descriptor, once the pending reads
+// have noticed it and returned capture.ErrSourceClosed. Closing it again has no effect
 func (rs *RawSocket) Close() error {
-	return syscall.Close(rs.fd)
+	if rs.closed.Swap(true) {
+		return nil
+	}
+	rs.fdMu.Lock()
+	defer rs.fdMu.Unlock()
+
+	// the kernel would drop them anyway, but an error must be reported if the interface
+	// could not leave promiscuous mode
+	err := rs.dropMemberships()
+	if rs.ring != nil {
+		if ringErr := rs.ring.close(); ringErr != nil && err == nil {
+			err = ringErr
+		}
+	}
+	if closeErr := syscall.Close(rs.fd); closeErr != nil && err == nil {
+		err = closeErr
+	}
+	return err
 }
 
 // handleARPPacket parses the provided bytes to an ARP packet's data and sends its representation, or
@@ -111,29 +530,36 @@ func (rs *RawSocket) Close() error {
 func handleARPPacket(raw []byte, dataChan chan<- NetworkPacket, errChan chan<- error) {
 	packet, err := protocols.ARPPacketFromBytes(raw)
 	if err != nil {
-		errChan <- err
+		errChan <- &ParseError{Protocol: "arp", Err: err}
 		return
 	}
 	dataChan <- packet
 }
 
 // handleIPPacket parses the provided bytes to an Ipv4 or Ipv6 packet's data and sends its representation, or
-// an error, to the provided channels. It is possible to apply a layer 4 filter to the packets.
-func handleIPPacket(raw []byte, filter string, dataChan chan<- NetworkPacket, errChan chan<- error) {
+// an error, to the provided channels.
+// Fragments are sent nothing until defrag, if not nil, reassembles their packet from the last one
+func handleIPPacket(raw []byte, defrag *protocols.Reassembler, ts time.Time, dataChan chan<- NetworkPacket, errChan chan<- error) {
 	packet, err := protocols.IPPacketFromBytes(raw)
 	if err != nil {
-		errChan <- err
+		errChan <- &ParseError{Protocol: "ip", Err: err}
 		return
 	}
-	// IPv4 VS IPv6 packets filtering should be handled by the socket itself
-	l4Protocol := packet.Header().TransportLayerProtocol()
-	if filter == "all" || (l4Protocol == filter) {
-		handleLayer4Protocol(l4Protocol, packet, dataChan, errChan)
+	if defrag != nil {
+		packet, err = defrag.Reassemble(packet, ts)
+		if err != nil {
+			errChan <- &ParseError{Protocol: "ip", Err: err}
+			return
+		}
+		if packet == nil {
+			return
+		}
 	}
+	handleLayer4Protocol(packet.Header().TransportLayerProtocol(), packet, dataChan, errChan)
 }
 
-// handleLayer4Protocol obtains UDP or TCP data for the provided IPPacket, based on the given protocol filter.
-// The representation, or an error, is sent to the provided channel.
+// handleLayer4Protocol obtains UDP, TCP, ICMP or ICMPv6 data for the provided IPPacket, based on the given protocol filter.
+// The representation, or a ParseError, is sent to the provided channel.
 func handleLayer4Protocol(protocol string, packet protocols.IPPacket, dataChan chan<- NetworkPacket, errChan chan<- error) {
 	var np NetworkPacket
 	var err error
@@ -143,13 +569,17 @@ func handleLayer4Protocol(protocol string, packet protocols.IPPacket, dataChan c
 		np, err = protocols.UDPPacketFromIPPacket(packet)
 	case "tcp":
 		np, err = protocols.TCPPacketFromIPPacket(packet)
+	case "icmp":
+		np, err = protocols.ICMPPacketFromIPPacket(packet)
+	case "icmpv6":
+		np, err = protocols.ICMPv6PacketFromIPPacket(packet)
 	default:
 		// TODO: maybe support more protocols
 		return
 	}
 
 	if err != nil {
-		errChan <- err
+		errChan <- &ParseError{Protocol: protocol, Err: err}
 		return
 	}
 	dataChan <- np
@@ -1,10 +1,21 @@
 package sockets
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"net/netip"
 	"reflect"
+	"syscall"
 	"testing"
+	"time"
+	"unsafe"
 
+	"github.com/NamelessOne91/bisturi/capture"
+	"github.com/NamelessOne91/bisturi/filter"
 	"github.com/NamelessOne91/bisturi/protocols"
+	"golang.org/x/sys/unix"
 )
 
 type mockIPHeader struct {
@@ -212,7 +223,7 @@ func TestHandleLayer4Protocol(t *testing.T) {
 					WindowSize:      8192,
 					Checksum:        0xe057,
 					UrgentPointer:   0,
-					Options:         []byte{0x01, 0x01, 0x08, 0x0a, 0x00, 0x00, 0x00, 0x01},
+					Options:         []protocols.TCPOption{{Kind: protocols.TCPOptionNOP}, {Kind: protocols.TCPOptionNOP}, {Kind: protocols.TCPOptionTimestamps, Data: []byte{0x0a, 0x00, 0x00, 0x00, 0x01}, Malformed: true}},
 				},
 			},
 		},
@@ -261,7 +272,7 @@ func TestHandleLayer4Protocol(t *testing.T) {
 					WindowSize:      8192,
 					Checksum:        0xe057,
 					UrgentPointer:   0,
-					Options:         []byte{0x01, 0x01, 0x08, 0x0a, 0x00, 0x00, 0x00, 0x01},
+					Options:         []protocols.TCPOption{{Kind: protocols.TCPOptionNOP}, {Kind: protocols.TCPOptionNOP}, {Kind: protocols.TCPOptionTimestamps, Data: []byte{0x0a, 0x00, 0x00, 0x00, 0x01}, Malformed: true}},
 				},
 			},
 		},
@@ -282,6 +293,40 @@ func TestHandleLayer4Protocol(t *testing.T) {
 			},
 			expectedPacket: nil,
 		},
+		{
+			name:        "ICMP filter || error",
+			protocol:    "icmp",
+			expectedErr: protocols.ErrInvalidICMPHeader,
+			packet: &mockIPPacket{
+				info:    "invalid ICMP packet",
+				version: 4,
+				header: &mockIPHeader{
+					len:         20,
+					source:      "192.168.0.1",
+					destination: "192.168.0.2",
+					protocol:    "icmp",
+				},
+				payload: []byte{0x08, 0x00, 0xf7, 0xff},
+			},
+			expectedPacket: nil,
+		},
+		{
+			name:        "ICMPv6 filter || error",
+			protocol:    "icmpv6",
+			expectedErr: protocols.ErrInvalidICMPv6Header,
+			packet: &mockIPPacket{
+				info:    "invalid ICMPv6 packet",
+				version: 6,
+				header: &mockIPHeader{
+					len:         40,
+					source:      "2001:db8::1",
+					destination: "2001:db8::2",
+					protocol:    "icmpv6",
+				},
+				payload: []byte{0x87, 0x00},
+			},
+			expectedPacket: nil,
+		},
 		{
 			name:        "TCP filter || error",
 			protocol:    "tcp",
@@ -314,9 +359,493 @@ func TestHandleLayer4Protocol(t *testing.T) {
 					t.Errorf("Expected packet to be: %v - got %v", tt.expectedPacket, np)
 				}
 			case err := <-errChan:
-				if tt.expectedErr == nil || err != tt.expectedErr {
+				if tt.expectedErr == nil || !errors.Is(err, tt.expectedErr) {
 					t.Errorf("Expected error to be: %v - got %v", tt.expectedErr, err)
 				}
+				var pe *ParseError
+				if !errors.As(err, &pe) || pe.Protocol != tt.protocol {
+					t.Errorf("Expected a parse error for protocol %s - got %v", tt.protocol, err)
+				}
+			}
+		})
+	}
+}
+
+func TestHandleFrame(t *testing.T) {
+	tests := []struct {
+		name           string
+		raw            []byte
+		expectedSource string
+		expectedErr    bool
+	}{
+		{
+			name: "UDP over IPv4",
+			raw: []byte{
+				// Ethernet Frame
+				0x00, 0x1A, 0xA0, 0xBB, 0xCC, 0xDD, 0x00, 0x1A, 0xB0, 0xCC, 0xDD, 0xEE, 0x08, 0x00,
+				// IPv4 Header
+				0x45, 0x00, 0x00, 0x1c, 0x1c, 0x46, 0x40, 0x00,
+				0x40, 0x11, 0x00, 0x00, 0xc0, 0xa8, 0x00, 0x68,
+				0xc0, 0xa8, 0x00, 0x01,
+				// UDP Header
+				0x04, 0xd2, 0x00, 0x35, 0x00, 0x08, 0x00, 0x00,
+			},
+			expectedSource: "192.168.0.104:1234",
+		},
+		{
+			name: "UDP over IPv4 in a QinQ frame",
+			raw: []byte{
+				// Ethernet Frame with 802.1ad and 802.1Q tags
+				0x00, 0x1A, 0xA0, 0xBB, 0xCC, 0xDD, 0x00, 0x1A, 0xB0, 0xCC, 0xDD, 0xEE,
+				0x88, 0xa8, 0x00, 0xc8, 0x81, 0x00, 0x00, 0x0a, 0x08, 0x00,
+				// IPv4 Header
+				0x45, 0x00, 0x00, 0x1c, 0x1c, 0x46, 0x40, 0x00,
+				0x40, 0x11, 0x00, 0x00, 0xc0, 0xa8, 0x00, 0x68,
+				0xc0, 0xa8, 0x00, 0x01,
+				// UDP Header
+				0x04, 0xd2, 0x00, 0x35, 0x00, 0x08, 0x00, 0x00,
+			},
+			expectedSource: "192.168.0.104:1234",
+		},
+		{
+			name: "ICMP echo request",
+			raw: []byte{
+				// Ethernet Frame
+				0x00, 0x1A, 0xA0, 0xBB, 0xCC, 0xDD, 0x00, 0x1A, 0xB0, 0xCC, 0xDD, 0xEE, 0x08, 0x00,
+				// IPv4 Header
+				0x45, 0x00, 0x00, 0x1c, 0x1c, 0x46, 0x40, 0x00,
+				0x40, 0x01, 0x00, 0x00, 0xc0, 0xa8, 0x00, 0x68,
+				0xc0, 0xa8, 0x00, 0x01,
+				// ICMP Header
+				0x08, 0x00, 0xf7, 0xfd, 0x00, 0x01, 0x00, 0x01,
+			},
+			expectedSource: "192.168.0.104",
+		},
+		{
+			name: "truncated 802.1Q tag",
+			raw: []byte{
+				0x00, 0x1A, 0xA0, 0xBB, 0xCC, 0xDD, 0x00, 0x1A, 0xB0, 0xCC, 0xDD, 0xEE, 0x81, 0x00, 0x00,
+			},
+			expectedErr: true,
+		},
+		{
+			name: "ARP request",
+			raw: []byte{
+				// Ethernet Frame
+				0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x00, 0x1a, 0x2b, 0x3c, 0x4d, 0x5e, 0x08, 0x06,
+				// ARP Packet
+				0x00, 0x01, 0x08, 0x00, 0x06, 0x04, 0x00, 0x01,
+				0x00, 0x1a, 0x2b, 0x3c, 0x4d, 0x5e, 0xc0, 0xa8, 0x01, 0x01,
+				0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xc0, 0xa8, 0x01, 0x02,
+			},
+			expectedSource: "00:1a:2b:3c:4d:5e|192.168.1.1",
+		},
+		{
+			name: "unsupported EtherType is ignored",
+			raw: []byte{
+				0x00, 0x1A, 0xA0, 0xBB, 0xCC, 0xDD, 0x00, 0x1A, 0xB0, 0xCC, 0xDD, 0xEE, 0x88, 0x08,
+			},
+		},
+		{
+			name:        "truncated Ethernet frame",
+			raw:         []byte{0x00, 0x1A, 0xA0, 0xBB},
+			expectedErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dataChan := make(chan NetworkPacket, 1)
+			errChan := make(chan error, 1)
+
+			handleFrame(tt.raw, nil, time.Time{}, dataChan, errChan)
+
+			select {
+			case np := <-dataChan:
+				if np.Source() != tt.expectedSource {
+					t.Errorf("expected packet source to be %s - got %s", tt.expectedSource, np.Source())
+				}
+			case err := <-errChan:
+				if !tt.expectedErr {
+					t.Errorf("expected no error - got %v", err)
+				}
+			default:
+				if tt.expectedSource != "" || tt.expectedErr {
+					t.Errorf("expected a packet or an error to be sent")
+				}
+			}
+		})
+	}
+}
+
+func TestReadToChan(t *testing.T) {
+	frames := [][]byte{
+		{
+			// Ethernet Frame
+			0x00, 0x1A, 0xA0, 0xBB, 0xCC, 0xDD, 0x00, 0x1A, 0xB0, 0xCC, 0xDD, 0xEE, 0x08, 0x00,
+			// IPv4 Header
+			0x45, 0x00, 0x00, 0x1c, 0x1c, 0x46, 0x40, 0x00,
+			0x40, 0x11, 0x00, 0x00, 0xc0, 0xa8, 0x00, 0x68,
+			0xc0, 0xa8, 0x00, 0x01,
+			// UDP Header
+			0x04, 0xd2, 0x00, 0x35, 0x00, 0x08, 0x00, 0x00,
+		},
+		// truncated Ethernet frame
+		{0x00, 0x1A, 0xA0, 0xBB},
+		{
+			// Ethernet Frame
+			0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x00, 0x1a, 0x2b, 0x3c, 0x4d, 0x5e, 0x08, 0x06,
+			// ARP Packet
+			0x00, 0x01, 0x08, 0x00, 0x06, 0x04, 0x00, 0x01,
+			0x00, 0x1a, 0x2b, 0x3c, 0x4d, 0x5e, 0xc0, 0xa8, 0x01, 0x01,
+			0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xc0, 0xa8, 0x01, 0x02,
+		},
+	}
+	start := time.Unix(1700000000, 123456789)
+	packets := make([]capture.Packet, len(frames))
+	for i, f := range frames {
+		packets[i] = capture.Packet{
+			Data:     f,
+			Metadata: capture.Metadata{Timestamp: start.Add(time.Duration(i) * time.Nanosecond)},
+		}
+	}
+
+	dataChan := make(chan CapturedPacket, len(frames))
+	errChan := make(chan error, len(frames))
+
+	// returns once the source is exhausted
+	ReadToChan(context.Background(), capture.NewReplaySource(packets...), dataChan, errChan)
+	close(dataChan)
+	close(errChan)
+
+	sources := []string{}
+	timestamps := []time.Time{}
+	for cp := range dataChan {
+		sources = append(sources, cp.Source())
+		timestamps = append(timestamps, cp.Timestamp())
+	}
+	expected := []string{"192.168.0.104:1234", "00:1a:2b:3c:4d:5e|192.168.1.1"}
+	if !reflect.DeepEqual(sources, expected) {
+		t.Errorf("expected packet sources to be %v - got %v", expected, sources)
+	}
+	// every packet carries the timestamp of its own frame
+	expectedTimestamps := []time.Time{start, start.Add(2 * time.Nanosecond)}
+	if !reflect.DeepEqual(timestamps, expectedTimestamps) {
+		t.Errorf("expected packet timestamps to be %v - got %v", expectedTimestamps, timestamps)
+	}
+	if len(errChan) != 1 {
+		t.Errorf("expected 1 error - got %d", len(errChan))
+	}
+}
+
+// udpFragments returns the two fragments of a UDP datagram from 10.0.0.1:40000, the last one captured first
+// at the start and the first one a millisecond later, together with the datagram's UDP segment
+func udpFragments(t *testing.T, start time.Time) ([]capture.Packet, []byte) {
+	t.Helper()
+
+	ip := protocols.IPv4Header{
+		Version:        4,
+		TTL:            64,
+		Protocol:       17,
+		Identification: 42,
+		SourceIP:       netip.MustParseAddr("10.0.0.1"),
+		DestinationIP:  netip.MustParseAddr("10.0.0.2"),
+	}
+	datagram, err := protocols.Serialize(ip, &protocols.UDPHeader{SourcePort: 40000, DestinationPort: 53}, protocols.Payload(bytes.Repeat([]byte("query"), 20)))
+	if err != nil {
+		t.Fatalf("expected no error serializing the datagram - got %v", err)
+	}
+	segment := datagram[20:]
+
+	fragment := func(offset int, data []byte, more bool) []byte {
+		h := ip
+		h.FragmentOffset = uint16(offset / 8)
+		if more {
+			h.Flags = protocols.IPv4FlagMF
+		}
+		frame := &protocols.EthernetFrame{DestinationMAC: net.HardwareAddr{0, 1, 2, 3, 4, 5}, SourceMAC: net.HardwareAddr{0, 1, 2, 3, 4, 6}, EtherType: 0x0800}
+		raw, err := protocols.Serialize(frame, h, protocols.Payload(data))
+		if err != nil {
+			t.Fatalf("expected no error serializing the fragment - got %v", err)
+		}
+		return raw
+	}
+
+	return []capture.Packet{
+		{Data: fragment(64, segment[64:], false), Metadata: capture.Metadata{Timestamp: start}},
+		{Data: fragment(0, segment[:64], true), Metadata: capture.Metadata{Timestamp: start.Add(time.Millisecond)}},
+	}, segment
+}
+
+func TestReadToChanFragments(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	packets, segment := udpFragments(t, start)
+
+	dataChan := make(chan CapturedPacket, len(packets))
+	errChan := make(chan error, len(packets))
+	ReadToChan(context.Background(), capture.NewReplaySource(packets...), dataChan, errChan)
+	close(dataChan)
+	close(errChan)
+
+	if len(errChan) != 0 {
+		t.Errorf("expected no errors - got %v", <-errChan)
+	}
+	if len(dataChan) != 1 {
+		t.Fatalf("expected 1 reassembled packet - got %d", len(dataChan))
+	}
+	cp := <-dataChan
+	udp, ok := cp.NetworkPacket.(*protocols.UDPPacket)
+	if !ok {
+		t.Fatalf("expected a UDP packet - got %T", cp.NetworkPacket)
+	}
+	if cp.Source() != "10.0.0.1:40000" || !cp.Timestamp().Equal(start.Add(time.Millisecond)) {
+		t.Errorf("expected the datagram from 10.0.0.1:40000 at the last fragment's time - got %s at %v", cp.Source(), cp.Timestamp())
+	}
+	if got := udp.IPPacket.Payload(); !bytes.Equal(got, segment) {
+		t.Errorf("expected the reassembled datagram\n% x\ngot\n% x", segment, got)
+	}
+}
+
+func TestReceiveTimestamp(t *testing.T) {
+	expected := time.Unix(1700000000, 123456789)
+
+	ts := syscall.NsecToTimespec(expected.UnixNano())
+	oob := make([]byte, syscall.CmsgSpace(int(unsafe.Sizeof(ts))))
+	h := (*syscall.Cmsghdr)(unsafe.Pointer(&oob[0]))
+	h.Level = syscall.SOL_SOCKET
+	h.Type = syscall.SCM_TIMESTAMPNS
+	h.SetLen(syscall.CmsgLen(int(unsafe.Sizeof(ts))))
+	*(*syscall.Timespec)(unsafe.Pointer(&oob[syscall.CmsgLen(0)])) = ts
+
+	if got := receiveTimestamp(oob); !got.Equal(expected) {
+		t.Errorf("expected timestamp to be %v - got %v", expected, got)
+	}
+
+	// without the control message the frame is timestamped on read
+	before := time.Now()
+	if got := receiveTimestamp(nil); got.Before(before) {
+		t.Errorf("expected timestamp to be after %v - got %v", before, got)
+	}
+}
+
+// strippedVLAN returns the 802.1Q tag carried by the PACKET_AUXDATA control message, if any
+func strippedVLAN(oob []byte) capture.VLAN {
+	aux, _ := packetAuxdata(oob)
+	return vlanFromStatus(aux.Status, aux.Vlan_tci, aux.Vlan_tpid)
+}
+
+func TestStrippedVLAN(t *testing.T) {
+	aux := unix.TpacketAuxdata{Status: unix.TP_STATUS_VLAN_VALID | unix.TP_STATUS_VLAN_TPID_VALID, Vlan_tci: 0x200a, Vlan_tpid: 0x88a8}
+	oob := make([]byte, syscall.CmsgSpace(int(unsafe.Sizeof(aux))))
+	h := (*syscall.Cmsghdr)(unsafe.Pointer(&oob[0]))
+	h.Level = unix.SOL_PACKET
+	h.Type = unix.PACKET_AUXDATA
+	h.SetLen(syscall.CmsgLen(int(unsafe.Sizeof(aux))))
+	*(*unix.TpacketAuxdata)(unsafe.Pointer(&oob[syscall.CmsgLen(0)])) = aux
+
+	expected := capture.VLAN{Present: true, TPID: 0x88a8, TCI: 0x200a}
+	if got := strippedVLAN(oob); got != expected {
+		t.Errorf("expected VLAN to be %+v - got %+v", expected, got)
+	}
+
+	// kernels not reporting the TPID only strip 802.1Q tags
+	aux.Status = unix.TP_STATUS_VLAN_VALID
+	*(*unix.TpacketAuxdata)(unsafe.Pointer(&oob[syscall.CmsgLen(0)])) = aux
+	expected.TPID = 0x8100
+	if got := strippedVLAN(oob); got != expected {
+		t.Errorf("expected VLAN to be %+v - got %+v", expected, got)
+	}
+
+	// the tag fields of untagged frames are not valid
+	aux.Status = 0
+	*(*unix.TpacketAuxdata)(unsafe.Pointer(&oob[syscall.CmsgLen(0)])) = aux
+	if got := strippedVLAN(oob); got.Present {
+		t.Errorf("expected no VLAN - got %+v", got)
+	}
+	if got := strippedVLAN(nil); got.Present {
+		t.Errorf("expected no VLAN without control messages - got %+v", got)
+	}
+}
+
+func TestReadToChanVLAN(t *testing.T) {
+	frame := []byte{
+		// Ethernet Frame
+		0x00, 0x1A, 0xA0, 0xBB, 0xCC, 0xDD, 0x00, 0x1A, 0xB0, 0xCC, 0xDD, 0xEE, 0x08, 0x00,
+		// IPv4 Header
+		0x45, 0x00, 0x00, 0x1c, 0x1c, 0x46, 0x40, 0x00,
+		0x40, 0x11, 0x00, 0x00, 0xc0, 0xa8, 0x00, 0x68,
+		0xc0, 0xa8, 0x00, 0x01,
+		// UDP Header
+		0x04, 0xd2, 0x00, 0x35, 0x00, 0x08, 0x00, 0x00,
+	}
+	md := capture.Metadata{VLAN: capture.VLAN{Present: true, TPID: 0x8100, TCI: 0xa00a}}
+	src := capture.NewReplaySource(capture.Packet{Data: frame, Metadata: md}, capture.Packet{Data: frame})
+
+	dataChan := make(chan CapturedPacket, 2)
+	errChan := make(chan error, 2)
+	ReadToChan(context.Background(), src, dataChan, errChan)
+	close(dataChan)
+
+	expected := [][]protocols.VLANTag{{{TPID: 0x8100, PCP: 5, VID: 10}}, nil}
+	i := 0
+	for p := range dataChan {
+		framed, ok := p.NetworkPacket.(protocols.Framed)
+		if !ok {
+			t.Fatalf("expected %T to keep its Ethernet frame", p.NetworkPacket)
+		}
+		if got := framed.Frame().VLANs; !reflect.DeepEqual(got, expected[i]) {
+			t.Errorf("expected packet %d to have VLAN tags %v - got %v", i, expected[i], got)
+		}
+		i++
+	}
+	if i != 2 {
+		t.Errorf("expected 2 packets - got %d", i)
+	}
+}
+
+func TestPacketType(t *testing.T) {
+	ringCfg := DefaultRingConfig
+	ringCfg.BlockTimeout = 10 * time.Millisecond
+
+	for _, b := range []struct {
+		name string
+		opts []Option
+	}{
+		{name: "recvmsg"},
+		{name: "ring", opts: []Option{WithRing(ringCfg)}},
+	} {
+		t.Run(b.name, func(t *testing.T) {
+			rs := openLoopback(t, b.opts...)
+			defer rs.Close()
+
+			payload := []byte("bisturi packet type test")
+			stop := make(chan struct{})
+			defer close(stop)
+			sendUDP(t, payload, stop)
+
+			// every datagram sent on the loopback interface is seen leaving and then entering the host
+			seen := map[capture.PacketType]bool{}
+			rs.SetReadDeadline(time.Now().Add(2 * time.Second))
+			for !seen[capture.PacketTypeOutgoing] || !seen[capture.PacketTypeHost] {
+				frame, md, err := rs.ReadPacket()
+				if err != nil {
+					t.Fatalf("expected to see the datagram in both directions - got %v (seen %v)", err, seen)
+				}
+				if bytes.HasSuffix(frame, payload) {
+					seen[md.PacketType] = true
+				}
+			}
+		})
+	}
+
+	t.Run("outbound filter", func(t *testing.T) {
+		rs := openLoopback(t)
+		defer rs.Close()
+
+		prog, err := filter.Compile("outbound and udp port 9")
+		if err != nil {
+			t.Fatalf("expected no error - got %v", err)
+		}
+		if err := rs.AttachFilter(prog); err != nil {
+			t.Fatalf("expected no error - got %v", err)
+		}
+
+		payload := []byte("bisturi outbound test")
+		stop := make(chan struct{})
+		defer close(stop)
+		sendUDP(t, payload, stop)
+
+		rs.SetReadDeadline(time.Now().Add(2 * time.Second))
+		for read := 0; read < 10; {
+			frame, md, err := rs.ReadPacket()
+			if err != nil {
+				t.Fatalf("expected no error - got %v", err)
+			}
+			// frames queued before the filter was attached are not the sent datagrams
+			if !bytes.HasSuffix(frame, payload) {
+				continue
+			}
+			if md.PacketType != capture.PacketTypeOutgoing {
+				t.Errorf("expected only outgoing frames - got %s", md.PacketType)
+			}
+			read++
+		}
+	})
+}
+
+func TestWrite(t *testing.T) {
+	rs := openLoopback(t)
+	defer rs.Close()
+
+	sender, err := NewRawSocket(0)
+	if err != nil {
+		t.Fatalf("expected no error - got %v", err)
+	}
+	defer sender.Close()
+	if _, err := sender.Write(udpFrame(9)); !errors.Is(err, errNotBound) {
+		t.Errorf("expected an unbound socket to fail sending - got %v", err)
+	}
+	lo, err := net.InterfaceByName("lo")
+	if err != nil {
+		t.Fatalf("expected no error - got %v", err)
+	}
+	if err := sender.Bind(*lo); err != nil {
+		t.Fatalf("expected no error - got %v", err)
+	}
+
+	payload := []byte("bisturi write test")
+	frame := append(udpFrame(9), payload...)
+	if n, err := sender.Write(frame); err != nil || n != len(frame) {
+		t.Fatalf("expected %d bytes to be sent - got %d (error %v)", len(frame), n, err)
+	}
+
+	rs.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		got, _, err := rs.ReadPacket()
+		if err != nil {
+			t.Fatalf("expected the sent frame to be captured - got %v", err)
+		}
+		if bytes.Equal(got, frame) {
+			break
+		}
+	}
+
+	sender.Close()
+	if _, err := sender.Write(frame); !errors.Is(err, capture.ErrSourceClosed) {
+		t.Errorf("expected a closed socket to fail sending - got %v", err)
+	}
+}
+
+func TestCapturedPacketChecksums(t *testing.T) {
+	// the UDP checksum is the partial sum of the pseudo-header, left for the network interface to complete
+	frame := udpFrame(9)
+	frame[40], frame[41] = 0x12, 0x34
+	packet := func(md capture.Metadata) CapturedPacket {
+		dataChan := make(chan NetworkPacket, 1)
+		handleFrame(frame, nil, time.Time{}, dataChan, make(chan error, 1))
+		return CapturedPacket{NetworkPacket: <-dataChan, Metadata: md}
+	}
+
+	tests := []struct {
+		name     string
+		md       capture.Metadata
+		expected protocols.ChecksumStatus
+	}{
+		{name: "received", md: capture.Metadata{PacketType: capture.PacketTypeHost}, expected: protocols.ChecksumBad},
+		{name: "offloaded", md: capture.Metadata{PacketType: capture.PacketTypeOutgoing, ChecksumNotReady: true}, expected: protocols.ChecksumUnverified},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checksums := packet(tt.md).Checksums()
+			if len(checksums) != 2 || checksums[1].Layer != protocols.LayerTypeUDP {
+				t.Fatalf("expected the IPv4 and UDP checksums - got %+v", checksums)
+			}
+			if checksums[1].Status != tt.expected {
+				t.Errorf("expected UDP checksum to be %s - got %s", tt.expected, checksums[1].Status)
+			}
+			// the header checksum is computed by the kernel
+			if checksums[0].Status != protocols.ChecksumBad {
+				t.Errorf("expected IPv4 checksum to be bad - got %s", checksums[0].Status)
 			}
 		})
 	}
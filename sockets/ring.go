@@ -0,0 +1,180 @@
+package sockets
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/NamelessOne91/bisturi/capture"
+	"golang.org/x/sys/unix"
+)
+
+// sllOffset is the offset of the link layer address in a ring frame, following the aligned TPACKET_V3 header
+const sllOffset = (int(unsafe.Sizeof(unix.Tpacket3Hdr{})) + unix.TPACKET_ALIGNMENT - 1) &^ (unix.TPACKET_ALIGNMENT - 1)
+
+// tpacket3HdrLen is the minimum frame size: the TPACKET_V3 header followed by the link layer address
+const tpacket3HdrLen = sllOffset + int(unsafe.Sizeof(unix.RawSockaddrLinklayer{}))
+
+// RingConfig describes the layout of a TPACKET_V3 RX ring.
+// The kernel fills one block at a time with as many frames as fit in it, handing it to userspace
+// when it is full or when the block timeout expires
+type RingConfig struct {
+	BlockSize    int           // bytes of every block, a multiple of the page size
+	BlockCount   int           // number of blocks in the ring
+	FrameSize    int           // nominal frame size, a multiple of 16 dividing BlockSize. Frames longer than it still fit in a block
+	BlockTimeout time.Duration // time after which a partially filled block is handed to userspace
+}
+
+// DefaultRingConfig is a 16 MiB ring, whose blocks are retired at least every 64ms
+var DefaultRingConfig = RingConfig{
+	BlockSize:    1 << 20,
+	BlockCount:   16,
+	FrameSize:    1 << 11,
+	BlockTimeout: 64 * time.Millisecond,
+}
+
+var (
+	errInvalidBlockSize = errors.New("ring block size must be a positive multiple of the page size")
+	errInvalidFrameSize = fmt.Errorf("ring frame size must be a multiple of %d, not lower than %d, dividing the block size", unix.TPACKET_ALIGNMENT, tpacket3HdrLen)
+	errInvalidBlocks    = errors.New("ring block count must be positive")
+)
+
+// validate checks the configuration against the constraints imposed by the kernel
+func (c RingConfig) validate() error {
+	if c.BlockSize <= 0 || c.BlockSize%os.Getpagesize() != 0 {
+		return errInvalidBlockSize
+	}
+	if c.FrameSize < tpacket3HdrLen || c.FrameSize%unix.TPACKET_ALIGNMENT != 0 || c.BlockSize%c.FrameSize != 0 {
+		return errInvalidFrameSize
+	}
+	if c.BlockCount <= 0 {
+		return errInvalidBlocks
+	}
+	return nil
+}
+
+// ring is a TPACKET_V3 RX ring mapped in the process memory.
+// Frames are read directly from the shared blocks, without a syscall for each of them
+type ring struct {
+	mu         sync.Mutex
+	data       []byte
+	blockSize  int
+	blockCount int
+	block      int    // index of the block being read
+	pending    uint32 // frames of the current block not read yet
+	offset     uint32 // offset of the next frame in the current block
+}
+
+// newRing switches the socket to TPACKET_V3, then sets up and maps an RX ring with the given layout
+func newRing(fd int, cfg RingConfig) (*ring, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	if err := unix.SetsockoptInt(fd, unix.SOL_PACKET, unix.PACKET_VERSION, unix.TPACKET_V3); err != nil {
+		return nil, fmt.Errorf("failed to set TPACKET_V3: %v", err)
+	}
+
+	req := unix.TpacketReq3{
+		Block_size:     uint32(cfg.BlockSize),
+		Block_nr:       uint32(cfg.BlockCount),
+		Frame_size:     uint32(cfg.FrameSize),
+		Frame_nr:       uint32(cfg.BlockSize / cfg.FrameSize * cfg.BlockCount),
+		Retire_blk_tov: uint32(cfg.BlockTimeout.Milliseconds()),
+	}
+	if err := unix.SetsockoptTpacketReq3(fd, unix.SOL_PACKET, unix.PACKET_RX_RING, &req); err != nil {
+		return nil, fmt.Errorf("failed to set up the RX ring: %v", err)
+	}
+
+	data, err := unix.Mmap(fd, 0, cfg.BlockSize*cfg.BlockCount, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED)
+	if err != nil {
+		return nil, fmt.Errorf("failed to map the RX ring: %v", err)
+	}
+
+	return &ring{
+		data:       data,
+		blockSize:  cfg.BlockSize,
+		blockCount: cfg.BlockCount,
+	}, nil
+}
+
+// blockHeader returns the descriptor of the current block
+func (r *ring) blockHeader() *unix.TpacketHdrV1 {
+	// the descriptor follows the version and private data offset words of tpacket_block_desc
+	return (*unix.TpacketHdrV1)(unsafe.Pointer(&r.data[r.block*r.blockSize+8]))
+}
+
+// next copies the next frame out of the ring, reporting false if the kernel has not handed over
+// any block with frames yet
+func (r *ring) next() ([]byte, capture.Metadata, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for r.pending == 0 {
+		bh := r.blockHeader()
+		if atomic.LoadUint32(&bh.Block_status)&unix.TP_STATUS_USER == 0 {
+			return nil, capture.Metadata{}, false
+		}
+		if bh.Num_pkts == 0 {
+			r.releaseBlock()
+			continue
+		}
+		r.pending = bh.Num_pkts
+		r.offset = bh.Offset_to_first_pkt
+	}
+
+	start := r.block*r.blockSize + int(r.offset)
+	hdr := (*unix.Tpacket3Hdr)(unsafe.Pointer(&r.data[start]))
+	sll := (*unix.RawSockaddrLinklayer)(unsafe.Pointer(&r.data[start+sllOffset]))
+
+	// decoded packets reference the frame's bytes, which must outlive the block
+	frame := make([]byte, hdr.Snaplen)
+	copy(frame, r.data[start+int(hdr.Mac):])
+	md := capture.Metadata{
+		Timestamp:        time.Unix(int64(hdr.Sec), int64(hdr.Nsec)),
+		CaptureLength:    int(hdr.Snaplen),
+		Length:           int(hdr.Len),
+		PacketType:       packetType(sll.Pkttype),
+		VLAN:             vlanFromStatus(hdr.Status, uint16(hdr.Hv1.Vlan_tci), hdr.Hv1.Vlan_tpid),
+		ChecksumNotReady: checksumNotReady(hdr.Status),
+	}
+
+	r.offset += hdr.Next_offset
+	r.pending--
+	if r.pending == 0 {
+		r.releaseBlock()
+	}
+	return frame, md, true
+}
+
+// releaseBlock hands the current block back to the kernel and moves to the next one
+func (r *ring) releaseBlock() {
+	atomic.StoreUint32(&r.blockHeader().Block_status, unix.TP_STATUS_KERNEL)
+	r.block = (r.block + 1) % r.blockCount
+}
+
+// close unmaps the ring
+func (r *ring) close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	err := unix.Munmap(r.data)
+	r.data = nil
+	return err
+}
+
+// WithRing makes the socket read the frames from a memory-mapped TPACKET_V3 RX ring with the given layout,
+// instead of calling SYS_RECVFROM for every frame. Frames are only truncated if longer than a block
+func WithRing(cfg RingConfig) Option {
+	return func(rs *RawSocket) error {
+		r, err := newRing(rs.fd, cfg)
+		if err != nil {
+			return err
+		}
+		rs.ring = r
+		return nil
+	}
+}
@@ -0,0 +1,221 @@
+package sockets
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/NamelessOne91/bisturi/capture"
+)
+
+func TestRingConfigValidate(t *testing.T) {
+	tests := []struct {
+		name        string
+		cfg         RingConfig
+		expectedErr error
+	}{
+		{
+			name: "default configuration",
+			cfg:  DefaultRingConfig,
+		},
+		{
+			name:        "block size not a multiple of the page size",
+			cfg:         RingConfig{BlockSize: 1000, BlockCount: 4, FrameSize: 1 << 11},
+			expectedErr: errInvalidBlockSize,
+		},
+		{
+			name:        "frame size not aligned",
+			cfg:         RingConfig{BlockSize: 1 << 16, BlockCount: 4, FrameSize: 1000},
+			expectedErr: errInvalidFrameSize,
+		},
+		{
+			name:        "frame size smaller than the frame header",
+			cfg:         RingConfig{BlockSize: 1 << 16, BlockCount: 4, FrameSize: 16},
+			expectedErr: errInvalidFrameSize,
+		},
+		{
+			name:        "frame size larger than the block",
+			cfg:         RingConfig{BlockSize: 1 << 16, BlockCount: 4, FrameSize: 1 << 17},
+			expectedErr: errInvalidFrameSize,
+		},
+		{
+			name:        "no blocks",
+			cfg:         RingConfig{BlockSize: 1 << 16, FrameSize: 1 << 11},
+			expectedErr: errInvalidBlocks,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.cfg.validate(); err != tt.expectedErr {
+				t.Errorf("expected error: %v - got %v", tt.expectedErr, err)
+			}
+		})
+	}
+}
+
+// openLoopback opens a raw socket bound to the loopback interface, skipping the test
+// if the process lacks the privileges to do so
+func openLoopback(tb testing.TB, opts ...Option) *RawSocket {
+	tb.Helper()
+
+	rs, err := NewRawSocket(syscall.ETH_P_ALL, opts...)
+	if errors.Is(err, syscall.EPERM) || errors.Is(err, syscall.EACCES) {
+		tb.Skip("raw sockets require CAP_NET_RAW")
+	}
+	if err != nil {
+		tb.Fatalf("expected no error - got %v", err)
+	}
+	lo, err := net.InterfaceByName("lo")
+	if err != nil {
+		rs.Close()
+		tb.Skipf("no loopback interface: %v", err)
+	}
+	if err := rs.Bind(*lo); err != nil {
+		rs.Close()
+		tb.Fatalf("expected no error - got %v", err)
+	}
+	return rs
+}
+
+// sendUDP sends datagrams carrying the payload to a local port until stop is closed
+func sendUDP(tb testing.TB, payload []byte, stop <-chan struct{}) {
+	tb.Helper()
+
+	conn, err := net.Dial("udp", "127.0.0.1:9")
+	if err != nil {
+		tb.Fatalf("expected no error - got %v", err)
+	}
+	go func() {
+		defer conn.Close()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				conn.Write(payload)
+			}
+		}
+	}()
+}
+
+func TestRingReadPacket(t *testing.T) {
+	cfg := DefaultRingConfig
+	cfg.BlockTimeout = 10 * time.Millisecond
+	rs := openLoopback(t, WithRing(cfg))
+
+	payload := []byte("bisturi ring test")
+	stop := make(chan struct{})
+	defer close(stop)
+	sendUDP(t, payload, stop)
+
+	// the read loop is unblocked by closing the socket if the datagram never shows up
+	timer := time.AfterFunc(2*time.Second, func() { rs.Close() })
+	defer timer.Stop()
+	defer rs.Close()
+
+	for {
+		frame, md, err := rs.ReadPacket()
+		if err != nil {
+			t.Fatalf("expected to read the sent datagram - got %v", err)
+		}
+		if !bytes.HasSuffix(frame, payload) {
+			continue
+		}
+		if md.CaptureLength != len(frame) || md.Length != len(frame) {
+			t.Errorf("expected lengths to be %d - got %d and %d", len(frame), md.CaptureLength, md.Length)
+		}
+		if time.Since(md.Timestamp) > time.Minute {
+			t.Errorf("expected the kernel timestamp to be recent - got %v", md.Timestamp)
+		}
+		if md.Interface != "lo" {
+			t.Errorf("expected the frame to be seen on lo - got %q", md.Interface)
+		}
+		break
+	}
+
+	stats, _ := rs.Stats()
+	if stats.Received == 0 {
+		t.Errorf("expected received frames to be counted")
+	}
+}
+
+func TestReadPacketTimestamp(t *testing.T) {
+	rs := openLoopback(t)
+	defer rs.Close()
+
+	payload := []byte("bisturi timestamp test")
+	stop := make(chan struct{})
+	defer close(stop)
+	sendUDP(t, payload, stop)
+
+	var previous time.Time
+	for i := 0; i < 10; i++ {
+		_, md, err := rs.ReadPacket()
+		if err != nil {
+			t.Fatalf("expected no error - got %v", err)
+		}
+		// the kernel timestamp precedes the read, and follows the one of the previous frame
+		if md.Timestamp.After(time.Now()) || md.Timestamp.Before(previous) {
+			t.Errorf("expected timestamp %v to be between %v and now", md.Timestamp, previous)
+		}
+		previous = md.Timestamp
+		if md.Interface != "lo" {
+			t.Errorf("expected the frame to be seen on lo - got %q", md.Interface)
+		}
+	}
+}
+
+func TestRingClose(t *testing.T) {
+	rs := openLoopback(t, WithRing(DefaultRingConfig))
+
+	done := make(chan error)
+	go func() {
+		for {
+			if _, _, err := rs.ReadPacket(); err != nil {
+				done <- err
+				return
+			}
+		}
+	}()
+
+	rs.Close()
+	select {
+	case err := <-done:
+		if !errors.Is(err, capture.ErrSourceClosed) {
+			t.Errorf("expected error: %v - got %v", capture.ErrSourceClosed, err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the read to return after closing the socket")
+	}
+}
+
+// benchmarkReadPacket reads b.N frames of loopback UDP traffic from the socket
+func benchmarkReadPacket(b *testing.B, opts ...Option) {
+	rs := openLoopback(b, opts...)
+	defer rs.Close()
+
+	stop := make(chan struct{})
+	defer close(stop)
+	sendUDP(b, make([]byte, 512), stop)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		frame, _, err := rs.ReadPacket()
+		if err != nil {
+			b.Fatalf("expected no error - got %v", err)
+		}
+		b.SetBytes(int64(len(frame)))
+	}
+}
+
+func BenchmarkReadPacketRecvfrom(b *testing.B) {
+	benchmarkReadPacket(b)
+}
+
+func BenchmarkReadPacketRing(b *testing.B) {
+	benchmarkReadPacket(b, WithRing(DefaultRingConfig))
+}
@@ -0,0 +1,42 @@
+package sockets
+
+import (
+	"fmt"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// kernelStats accumulates the counters returned by PACKET_STATISTICS, which the kernel resets on every read
+type kernelStats struct {
+	mu          sync.Mutex
+	packets     uint64
+	drops       uint64
+	freezeQueue uint64
+}
+
+// update reads the counters of the socket since the last call and adds them to the totals.
+// Sockets using a TPACKET_V3 ring also report how many times the ring was frozen because full
+func (ks *kernelStats) update(fd int, v3 bool) error {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	if v3 {
+		st, err := unix.GetsockoptTpacketStatsV3(fd, unix.SOL_PACKET, unix.PACKET_STATISTICS)
+		if err != nil {
+			return fmt.Errorf("failed to read the socket statistics: %v", err)
+		}
+		ks.packets += uint64(st.Packets)
+		ks.drops += uint64(st.Drops)
+		ks.freezeQueue += uint64(st.Freeze_q_cnt)
+		return nil
+	}
+
+	st, err := unix.GetsockoptTpacketStats(fd, unix.SOL_PACKET, unix.PACKET_STATISTICS)
+	if err != nil {
+		return fmt.Errorf("failed to read the socket statistics: %v", err)
+	}
+	ks.packets += uint64(st.Packets)
+	ks.drops += uint64(st.Drops)
+	return nil
+}
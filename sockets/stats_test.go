@@ -0,0 +1,49 @@
+package sockets
+
+import "testing"
+
+func TestRawSocketStats(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []Option
+	}{
+		{name: "recvfrom"},
+		{name: "ring", opts: []Option{WithRing(DefaultRingConfig)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rs := openLoopback(t, tt.opts...)
+			defer rs.Close()
+
+			stop := make(chan struct{})
+			sendUDP(t, []byte("bisturi stats test"), stop)
+			for i := 0; i < 10; i++ {
+				if _, _, err := rs.ReadPacket(); err != nil {
+					t.Fatalf("expected no error - got %v", err)
+				}
+			}
+			close(stop)
+
+			first, err := rs.Stats()
+			if err != nil {
+				t.Fatalf("expected no error - got %v", err)
+			}
+			if first.Received != 10 {
+				t.Errorf("expected 10 frames to be received - got %d", first.Received)
+			}
+			if first.Captured < first.Received {
+				t.Errorf("expected the kernel to capture at least %d frames - got %d", first.Received, first.Captured)
+			}
+
+			// the kernel resets its counters when they are read, the socket must not
+			second, err := rs.Stats()
+			if err != nil {
+				t.Fatalf("expected no error - got %v", err)
+			}
+			if second.Captured < first.Captured || second.Dropped < first.Dropped {
+				t.Errorf("expected the counters to be cumulative - got %+v after %+v", second, first)
+			}
+		})
+	}
+}
@@ -1,12 +1,18 @@
 package tui
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
+	"github.com/NamelessOne91/bisturi/capture"
+	"github.com/NamelessOne91/bisturi/filter"
+	"github.com/NamelessOne91/bisturi/pcap"
 	"github.com/NamelessOne91/bisturi/sockets"
 	"github.com/NamelessOne91/bisturi/tui/styles"
 	"github.com/charmbracelet/bubbles/spinner"
@@ -21,30 +27,78 @@ const (
 	retrieveIfaces step = iota
 	selectIface
 	selectProtocol
+	insertFilter
 	selectRows
 	receivePackets
 )
 
 type errMsg error
 
-type readPacketsMsg []sockets.NetworkPacket
+type readPacketsMsg []sockets.CapturedPacket
+
+// defaultBatchInterval is how often the packets read are sent to the table
+const defaultBatchInterval = 5 * time.Second
+
+// mergeWindow is how long the frames captured on an interface wait for the ones of the other
+// interfaces, to be displayed in order. It exceeds the ring's block timeout
+const mergeWindow = 200 * time.Millisecond
+
+// Config contains the options bisturi has been started with
+type Config struct {
+	// Context stops the capture when cancelled. It defaults to context.Background()
+	Context context.Context
+	// ReadFile is the path of a pcap file to read the packets from, instead of capturing them live
+	ReadFile string
+	// WriteFile is the path of a pcap or pcapng file to write the captured frames to
+	WriteFile string
+	// Source, if set, is read instead of a live interface or a pcap file
+	Source capture.PacketSource
+	// Ring makes the live capture read the frames from a memory-mapped TPACKET_V3 ring
+	Ring bool
+	// Fanout, if greater than 1, is the number of sockets opened for every interface, sharing its frames
+	// through PACKET_FANOUT according to FanoutMode. Each of them is read and decoded by its own goroutine
+	Fanout     int
+	FanoutMode sockets.FanoutMode
+}
 
 type bisturiModel struct {
-	terminalHeight    int
-	terminalWidth     int
-	step              step
-	spinner           spinner.Model
-	startMenu         startMenuModel
-	rowsInput         textinput.Model
-	packetsTable      packetsTableModel
-	selectedInterface net.Interface
-	selectedProtocol  string
-	selectedEthType   uint16
-	rawSocket         *sockets.RawSocket
-	packetsChan       chan sockets.NetworkPacket
-	msgChan           chan tea.Msg
-	errChan           chan error
-	err               error
+	terminalHeight   int
+	terminalWidth    int
+	step             step
+	spinner          spinner.Model
+	startMenu        startMenuModel
+	interfaces       []net.Interface
+	filterInput      textinput.Model
+	filterErr        error
+	rowsInput        textinput.Model
+	packetsTable     packetsTableModel
+	selectedIfaces   []net.Interface
+	selectedProtocol string
+	selectedEthType  uint16
+	selectedProto    protoItem
+	promisc          bool
+	allMulti         bool
+	sources          capture.Group
+	offline          bool
+	readFile         string
+	writeFile        string
+	ring             bool
+	fanout           int
+	fanoutMode       sockets.FanoutMode
+	ctx              context.Context
+	captureCtx       context.Context
+	cancelCapture    context.CancelFunc
+	captureWg        *sync.WaitGroup
+	captureStopped   bool
+	dump             *pcapDump
+	dumpErr          error
+	counters         *pipelineCounters
+	statusBar        statusBarModel
+	packetsChan      chan sockets.CapturedPacket
+	msgChan          chan tea.Msg
+	errChan          chan error
+	batchInterval    time.Duration
+	err              error
 }
 
 func newRowsInput(terminalWidth int) textinput.Model {
@@ -57,20 +111,56 @@ func newRowsInput(terminalWidth int) textinput.Model {
 	return ti
 }
 
-func NewBisturiModel() *bisturiModel {
+func newFilterInput(terminalWidth int) textinput.Model {
+	ti := textinput.New()
+	ti.Placeholder = "Enter a tcpdump-style filter expression (e.g. host 10.0.0.1 and port 443) or leave it empty"
+	ti.Focus()
+	ti.Width = terminalWidth / 2
+
+	return ti
+}
+
+func NewBisturiModel(cfg Config) *bisturiModel {
 	s := spinner.New(spinner.WithSpinner(spinner.Meter))
 	s.Style = lipgloss.NewStyle().Foreground(lipgloss.Color("#00cc99"))
 
-	return &bisturiModel{
-		step:        retrieveIfaces,
-		spinner:     s,
-		packetsChan: make(chan sockets.NetworkPacket),
-		msgChan:     make(chan tea.Msg),
-		errChan:     make(chan error),
+	ctx := cfg.Context
+	if ctx == nil {
+		ctx = context.Background()
 	}
+
+	m := &bisturiModel{
+		step:          retrieveIfaces,
+		spinner:       s,
+		ctx:           ctx,
+		captureWg:     &sync.WaitGroup{},
+		ring:          cfg.Ring,
+		fanout:        cfg.Fanout,
+		fanoutMode:    cfg.FanoutMode,
+		offline:       cfg.Source != nil || cfg.ReadFile != "",
+		readFile:      cfg.ReadFile,
+		writeFile:     cfg.WriteFile,
+		packetsChan:   make(chan sockets.CapturedPacket),
+		msgChan:       make(chan tea.Msg),
+		errChan:       make(chan error),
+		batchInterval: defaultBatchInterval,
+		counters:      newPipelineCounters(),
+	}
+	if cfg.Source != nil {
+		m.sources = capture.Group{cfg.Source}
+	}
+	// packets already captured need no interface nor protocol selection
+	if m.offline {
+		m.step = insertFilter
+		m.filterInput = newFilterInput(0)
+	}
+	return m
 }
 
 func (m bisturiModel) Init() tea.Cmd {
+	if m.offline {
+		return textinput.Blink
+	}
 	return tea.Batch(m.spinner.Tick, fetchInterfaces())
 }
 
@@ -80,6 +170,8 @@ func (m bisturiModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		return m.updateLoading(msg)
 	case selectIface, selectProtocol:
 		return m.updateStartMenuSelection(msg)
+	case insertFilter:
+		return m.updateFilterInput(msg)
 	case selectRows:
 		return m.updateRowsInput(msg)
 	case receivePackets:
@@ -94,11 +186,6 @@ func (m bisturiModel) View() string {
 
 	if m.err != nil {
 		sb.WriteString(fmt.Sprintf("Error: %s\n", m.err))
-		if m.rawSocket != nil {
-			if err := m.rawSocket.Close(); err != nil {
-				sb.WriteString(err.Error())
-			}
-		}
 	}
 
 	switch m.step {
@@ -106,10 +193,17 @@ func (m bisturiModel) View() string {
 		sb.WriteString(fmt.Sprintf("\nWelcome!\nRetrieving network interfaces \n\n%s", m.spinner.View()))
 	case selectIface, selectProtocol:
 		sb.WriteString(m.startMenu.View())
+	case insertFilter:
+		sb.WriteString(m.filterInput.View())
+		if m.filterErr != nil {
+			sb.WriteString(fmt.Sprintf("\n\nInvalid filter: %s", m.filterErr))
+		}
 	case selectRows:
 		sb.WriteString(m.rowsInput.View())
 	case receivePackets:
 		sb.WriteString(m.packetsTable.View())
+		sb.WriteString(m.statusBar.View())
+		sb.WriteString(m.dumpView())
 	default:
 		sb.WriteString("The program is in an unknown state\nQuit with 'q'")
 	}
@@ -129,6 +223,7 @@ func (m *bisturiModel) updateLoading(msg tea.Msg) (tea.Model, tea.Cmd) {
 		return m, nil
 
 	case networkInterfacesMsg:
+		m.interfaces = msg
 		m.startMenu = newStartMenuModel(msg, m.terminalHeight, m.terminalWidth)
 		m.step = selectIface
 
@@ -163,38 +258,170 @@ func (m *bisturiModel) updateStartMenuSelection(msg tea.Msg) (tea.Model, tea.Cmd
 		return m, nil
 
 	case selectedIfaceItemMsg:
-		iface, err := net.InterfaceByName(msg.name)
-		if err != nil {
-			m.err = err
-			return m, tea.Quit
+		m.selectedIfaces = make([]net.Interface, 0, len(msg.names))
+		for _, name := range msg.names {
+			iface, err := net.InterfaceByName(name)
+			if err != nil {
+				m.err = err
+				return m, tea.Quit
+			}
+			m.selectedIfaces = append(m.selectedIfaces, *iface)
 		}
-		m.selectedInterface = *iface
+		m.promisc = msg.promisc
+		m.allMulti = msg.allMulti
 		m.step = selectProtocol
 
 		return m, nil
 
 	case selectedProtocolItemMsg:
-		// SYS_SOCKET syscall
-		rs, err := sockets.NewRawSocket(msg.name, msg.ethType)
-		if err != nil {
-			m.err = err
+		m.selectedProto = protoItem(msg)
+		m.step = insertFilter
+
+		m.filterInput = newFilterInput(m.terminalWidth)
+		return m, nil
+	}
+	return m, cmd
+}
+
+func (m *bisturiModel) updateFilterInput(msg tea.Msg) (tea.Model, tea.Cmd) {
+	var cmd tea.Cmd
+	m.filterInput, cmd = m.filterInput.Update(msg)
+
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.terminalHeight = msg.Height
+		m.terminalWidth = msg.Width
+
+		return m, nil
+
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "esc", "ctrl+c":
 			return m, tea.Quit
+
+		case "enter":
+			prog, err := m.compileFilter(strings.TrimSpace(m.filterInput.Value()))
+			if err != nil {
+				m.filterErr = err
+				return m, nil
+			}
+			if m.offline {
+				err = m.openOfflineSource(prog)
+			} else {
+				err = m.openSocket(prog)
+			}
+			if err != nil {
+				m.err = err
+				return m, tea.Quit
+			}
+			m.step = selectRows
+
+			m.rowsInput = newRowsInput(m.terminalWidth)
+			return m, nil
 		}
+	}
 
-		err = rs.Bind(m.selectedInterface)
+	return m, cmd
+}
+
+// compileFilter returns the BPF program for the selected protocol, restricted by the
+// filter expression inserted by the user, if any
+func (m *bisturiModel) compileFilter(expression string) ([]syscall.SockFilter, error) {
+	if expression == "" {
+		return m.selectedProto.filter, nil
+	}
+	if m.selectedProto.expression != "" {
+		expression = fmt.Sprintf("%s and (%s)", m.selectedProto.expression, expression)
+	}
+	return filter.Compile(expression)
+}
+
+// openSocket opens a raw socket for every selected network interface, merging their frames
+// in a single stream ordered by timestamp. With fanout, every interface gets a group of sockets,
+// which are read and decoded in parallel
+func (m *bisturiModel) openSocket(prog []syscall.SockFilter) error {
+	perIface := max(m.fanout, 1)
+	sources := make(capture.Group, 0, len(m.selectedIfaces)*perIface)
+	for _, iface := range m.selectedIfaces {
+		var opts []sockets.Option
+		if perIface > 1 {
+			opts = append(opts, sockets.WithFanout(sockets.NewFanoutGroup(m.fanoutMode)))
+		}
+		for i := 0; i < perIface; i++ {
+			rs, err := m.openInterfaceSocket(iface, prog, opts...)
+			if err != nil {
+				sources.Close()
+				return fmt.Errorf("failed to capture on %s: %v", iface.Name, err)
+			}
+			sources = append(sources, rs)
+		}
+	}
+	m.selectedProtocol = m.selectedProto.name
+	m.selectedEthType = m.selectedProto.ethType
+
+	if perIface == 1 && len(sources) > 1 {
+		m.sources = capture.Group{capture.NewMergedSource(mergeWindow, sources...)}
+	} else {
+		m.sources = sources
+	}
+	return nil
+}
+
+// openInterfaceSocket opens a raw socket for the selected protocol, configured with the passed options,
+// attaches the BPF program to it and binds it to the network interface
+func (m *bisturiModel) openInterfaceSocket(iface net.Interface, prog []syscall.SockFilter, opts ...sockets.Option) (*sockets.RawSocket, error) {
+	if m.ring {
+		opts = append(opts, sockets.WithRing(sockets.DefaultRingConfig))
+	}
+	if m.promisc {
+		opts = append(opts, sockets.WithPromiscuous())
+	}
+	if m.allMulti {
+		opts = append(opts, sockets.WithAllMulticast())
+	}
+
+	// SYS_SOCKET syscall
+	rs, err := sockets.NewRawSocket(m.selectedProto.ethType, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	// let the kernel discard unwanted frames before they reach us
+	err = rs.AttachFilter(prog)
+	if err != nil {
+		rs.Close()
+		return nil, err
+	}
+
+	err = rs.Bind(iface)
+	if err != nil {
+		rs.Close()
+		return nil, err
+	}
+	return rs, nil
+}
+
+// openOfflineSource opens the pcap file to read the packets from, unless a source has been configured.
+// Being the frames already captured, the BPF program is executed in userspace
+func (m *bisturiModel) openOfflineSource(prog []syscall.SockFilter) error {
+	if len(m.sources) == 0 {
+		r, err := pcap.OpenFile(m.readFile)
 		if err != nil {
-			m.err = err
-			return m, tea.Quit
+			return fmt.Errorf("failed to read %s: %v", m.readFile, err)
+		}
+		if r.LinkType() != pcap.LinkTypeEthernet {
+			r.Close()
+			return fmt.Errorf("unsupported link type %d in %s: only Ethernet captures can be read", r.LinkType(), m.readFile)
 		}
-		m.selectedProtocol = msg.name
-		m.selectedEthType = msg.ethType
-		m.rawSocket = rs
-		m.step = selectRows
+		m.sources = capture.Group{r}
+	}
 
-		m.rowsInput = newRowsInput(m.terminalWidth)
-		return m, nil
+	if len(prog) > 0 {
+		m.sources[0] = capture.NewFilteredSource(m.sources[0], func(data []byte, md capture.Metadata) bool {
+			return filter.MatchWithMetadata(prog, data, md)
+		})
 	}
-	return m, cmd
+	return nil
 }
 
 func (m *bisturiModel) updateRowsInput(msg tea.Msg) (tea.Model, tea.Cmd) {
@@ -219,10 +446,18 @@ func (m *bisturiModel) updateRowsInput(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.packetsTable = newPacketsTable(maxRows, m.terminalHeight, m.terminalWidth)
 				m.step = receivePackets
 
-				go m.rawSocket.ReadToChan(m.packetsChan, m.errChan)
-				go m.readPackets()
-
-				return m, m.pollPacketsMessages()
+				// the same file records the frames of every capture of the session
+				if m.dump == nil {
+					m.dump = newPcapDump(m.writeFile)
+					if m.writeFile != "" {
+						if _, err := m.dump.toggle(); err != nil {
+							m.err = err
+							return m, tea.Quit
+						}
+					}
+				}
+
+				return m, m.startCapture()
 			}
 		}
 	}
@@ -244,32 +479,179 @@ func (m *bisturiModel) updateReceivingPacket(msg tea.Msg) (tea.Model, tea.Cmd) {
 		return m, nil
 	case readPacketsMsg:
 		return m, m.pollPacketsMessages()
+	case errMsg:
+		m.statusBar.lastErr = msg
+		return m, m.pollPacketsMessages()
+	case statsTickMsg:
+		if m.captureStopped {
+			return m, nil
+		}
+		m.statusBar.refresh(m.sources, m.counters, m.packetsTable.counter)
+		return m, tickStats()
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "w":
+			_, m.dumpErr = m.dump.toggle()
+			return m, nil
+		case "s":
+			m.stopCapture()
+			return m, nil
+		case "i":
+			// frames read from a file come from a single source
+			if m.offline {
+				return m, nil
+			}
+			m.stopCapture()
+			m.startMenu = newStartMenuModel(m.interfaces, m.terminalHeight, m.terminalWidth)
+			m.step = selectIface
+			return m, nil
+		}
 	}
 	return m, cmd
 }
 
-func (m bisturiModel) readPackets() {
-	readPackets := []sockets.NetworkPacket{}
-	timer := time.NewTicker(5 * time.Second)
+// startCapture starts the goroutines reading and decoding the frames of the source, returning the commands
+// polling the decoded packets and refreshing the status bar
+func (m *bisturiModel) startCapture() tea.Cmd {
+	ctx, cancel := context.WithCancel(m.ctx)
+	m.captureCtx = ctx
+	m.cancelCapture = cancel
+	m.captureStopped = false
+	m.counters = newPipelineCounters()
+	for i, src := range m.sources {
+		m.sources[i] = capture.NewTeeSource(src, m.dump)
+	}
+
+	// the goroutines must not access the model, which keeps being updated
+	sources, packetsChan, errChan, wg := m.sources, m.packetsChan, m.errChan, m.captureWg
+	readPackets := m.readPackets
+	window := m.parallelWindow()
+
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		if len(sources) == 1 {
+			sockets.ReadToChan(ctx, sources[0], packetsChan, errChan)
+		} else {
+			sockets.ReadToChanParallel(ctx, sources, window, packetsChan, errChan)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		readPackets(ctx)
+	}()
+
+	m.statusBar = statusBarModel{live: !m.offline}
+	m.statusBar.refresh(m.sources, m.counters, m.packetsTable.counter)
+	return tea.Batch(m.pollPacketsMessages(), tickStats())
+}
+
+// parallelWindow returns how long the packets decoded by a fanout worker wait for the ones of the others,
+// to be displayed in order. The hash mode keeps the frames of every flow on a single socket,
+// so the packets of a single interface are displayed as soon as they are decoded
+func (m *bisturiModel) parallelWindow() time.Duration {
+	if m.fanoutMode == sockets.FanoutHash && len(m.selectedIfaces) <= 1 {
+		return 0
+	}
+	return mergeWindow
+}
+
+// stopCapture cancels the capture and waits for its goroutines to return. The source is closed,
+// releasing the socket's file descriptor, while the packets already read are still displayed
+func (m *bisturiModel) stopCapture() {
+	if m.cancelCapture == nil {
+		return
+	}
+	m.cancelCapture()
+	m.captureWg.Wait()
+	m.cancelCapture = nil
+
+	if err := m.sources.Close(); err != nil {
+		m.statusBar.lastErr = err
+	}
+	m.statusBar.refresh(m.sources, m.counters, m.packetsTable.counter)
+	m.captureStopped = true
+}
+
+// dumpView returns a status line describing the recording of the captured frames
+func (m bisturiModel) dumpView() string {
+	if m.captureStopped {
+		if m.offline {
+			return "Capture stopped"
+		}
+		return "Capture stopped - press 'i' to select another interface"
+	}
+	if m.dumpErr != nil {
+		return fmt.Sprintf("Failed to record the frames: %s", m.dumpErr)
+	}
+	if m.dump.recording() {
+		return fmt.Sprintf("Recording frames to %s - press 'w' to pause", m.dump.path)
+	}
+	return fmt.Sprintf("Press 'w' to record the frames to %s", m.dump.path)
+}
+
+// Close stops the capture and releases the resources held by the model, flushing the recorded frames
+func (m *bisturiModel) Close() error {
+	m.stopCapture()
+
+	err := m.sources.Close()
+	if m.dump != nil {
+		if dumpErr := m.dump.Close(); dumpErr != nil {
+			err = dumpErr
+		}
+	}
+	return err
+}
+
+// readPackets batches the decoded packets, sending them to the program at every batch interval,
+// until the context is cancelled
+func (m bisturiModel) readPackets(ctx context.Context) {
+	readPackets := []sockets.CapturedPacket{}
+	timer := time.NewTicker(m.batchInterval)
 	defer timer.Stop()
 
 	for {
+		var msg tea.Msg
+
 		select {
+		case <-ctx.Done():
+			return
 		case packet := <-m.packetsChan:
+			m.counters.countPacket()
 			readPackets = append(readPackets, packet)
 		case <-timer.C:
 			if len(readPackets) > 0 {
-				m.msgChan <- readPacketsMsg(readPackets)
-				readPackets = []sockets.NetworkPacket{}
+				msg = readPacketsMsg(readPackets)
+				readPackets = []sockets.CapturedPacket{}
 			}
 		case err := <-m.errChan:
-			m.msgChan <- errMsg(err)
+			// frames which could not be decoded are only counted, not to flood the view
+			if !m.counters.countError(err) {
+				msg = errMsg(err)
+			}
+		}
+
+		if msg == nil {
+			continue
+		}
+		select {
+		case m.msgChan <- msg:
+		case <-ctx.Done():
+			return
 		}
 	}
 }
 
+// pollPacketsMessages waits for the next message of the current capture. Once the capture is stopped
+// it returns nil, which the program ignores
 func (m bisturiModel) pollPacketsMessages() tea.Cmd {
+	ctx, msgChan := m.captureCtx, m.msgChan
 	return func() tea.Msg {
-		return <-m.msgChan
+		select {
+		case msg := <-msgChan:
+			return msg
+		case <-ctx.Done():
+			return nil
+		}
 	}
 }
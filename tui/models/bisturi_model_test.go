@@ -0,0 +1,484 @@
+package tui
+
+import (
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/NamelessOne91/bisturi/capture"
+	"github.com/NamelessOne91/bisturi/filter"
+	"github.com/NamelessOne91/bisturi/protocols"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// mldFrame returns an Ethernet frame carrying an MLDv2 report behind a Hop-by-Hop router alert
+func mldFrame(t *testing.T) []byte {
+	t.Helper()
+	raw, err := protocols.Serialize(
+		&protocols.EthernetFrame{
+			DestinationMAC: net.HardwareAddr{0x33, 0x33, 0x00, 0x00, 0x00, 0x16},
+			SourceMAC:      net.HardwareAddr{0x00, 0x1A, 0xC0, 0x00, 0x00, 0x01},
+			EtherType:      0x86DD,
+		},
+		protocols.IPv6Header{
+			Version:       6,
+			NextHeader:    protocols.IPv6ExtHopByHop,
+			HopLimit:      1,
+			SourceIP:      netip.MustParseAddr("fe80::1"),
+			DestinationIP: netip.MustParseAddr("ff02::16"),
+			Extensions: []protocols.IPv6ExtensionHeader{
+				&protocols.IPv6HopByHopHeader{NextHeader: 58, Options: []protocols.IPv6Option{{Type: protocols.IPv6OptionRouterAlert, Data: []byte{0, 0}}}},
+			},
+		},
+		&protocols.ICMPv6Header{Type: protocols.ICMPv6TypeMLDv2Report},
+		&protocols.MLDMessage{
+			Type:    protocols.ICMPv6TypeMLDv2Report,
+			Records: []protocols.MLDAddressRecord{{Type: 4, MulticastAddress: netip.MustParseAddr("ff02::fb")}},
+		},
+	)
+	if err != nil {
+		t.Fatalf("expected no error serializing the MLD report - got %v", err)
+	}
+	return raw
+}
+
+// waitForPackets executes the command, and the ones it batches, failing the test if none of them
+// returns the read packets in time
+func waitForPackets(t *testing.T, cmd tea.Cmd) readPacketsMsg {
+	t.Helper()
+
+	msgChan := make(chan tea.Msg, 1)
+	var run func(cmd tea.Cmd)
+	run = func(cmd tea.Cmd) {
+		msg := cmd()
+		if batch, ok := msg.(tea.BatchMsg); ok {
+			for _, c := range batch {
+				go run(c)
+			}
+			return
+		}
+		if packets, ok := msg.(readPacketsMsg); ok {
+			msgChan <- packets
+		}
+	}
+	go run(cmd)
+
+	select {
+	case msg := <-msgChan:
+		return msg.(readPacketsMsg)
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the read packets to be returned")
+		return nil
+	}
+}
+
+// startCapture drives the model through the filter and rows steps, returning it together with
+// the command polling the read packets
+func startCapture(t *testing.T, src capture.PacketSource, expression string) (*bisturiModel, tea.Cmd) {
+	t.Helper()
+
+	m := NewBisturiModel(Config{Source: src})
+	m.batchInterval = 10 * time.Millisecond
+
+	var model tea.Model = m
+	model, _ = model.Update(tea.WindowSizeMsg{Width: 200, Height: 50})
+	model, _ = model.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(expression)})
+	model, _ = model.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	if bm := model.(*bisturiModel); bm.step != selectRows {
+		t.Fatalf("expected the model to ask for the rows - got step %d (filter error: %v)", bm.step, bm.filterErr)
+	}
+
+	model, _ = model.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("10")})
+	model, cmd := model.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	bm := model.(*bisturiModel)
+	if bm.step != receivePackets {
+		t.Fatalf("expected the model to receive packets - got step %d", bm.step)
+	}
+	return bm, cmd
+}
+
+func TestUpdateReceivesPackets(t *testing.T) {
+	tests := []struct {
+		name             string
+		expression       string
+		expectedSources  []string
+		expectedFiltered uint64
+	}{
+		{
+			name:            "no filter",
+			expectedSources: []string{"192.168.0.104:1234", "00:1a:2b:3c:4d:5e|192.168.1.1"},
+		},
+		{
+			name:             "filter expression",
+			expression:       "arp",
+			expectedSources:  []string{"00:1a:2b:3c:4d:5e|192.168.1.1"},
+			expectedFiltered: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := capture.NewReplaySource(
+				capture.Packet{Data: udpFrame},
+				capture.Packet{Data: arpFrame},
+			)
+			m, cmd := startCapture(t, src, tt.expression)
+			defer m.Close()
+
+			model, _ := m.Update(waitForPackets(t, cmd))
+			model, _ = model.Update(statsTickMsg(time.Now()))
+			m = model.(*bisturiModel)
+
+			rows := m.packetsTable.cachedRows
+			if len(rows) != len(tt.expectedSources) {
+				t.Fatalf("expected %d rows - got %d", len(tt.expectedSources), len(rows))
+			}
+			for i, expected := range tt.expectedSources {
+				if source := rows[i].Data[columnKeySource]; source != expected {
+					t.Errorf("expected row %d source to be %s - got %v", i, expected, source)
+				}
+			}
+			expected := uint64(len(tt.expectedSources))
+			if m.statusBar.parsed != expected || m.statusBar.displayed != expected {
+				t.Errorf("expected %d packets to be parsed and displayed - got %d and %d", expected, m.statusBar.parsed, m.statusBar.displayed)
+			}
+			if stats := m.statusBar.source; stats.Received != 2 || stats.Filtered != tt.expectedFiltered {
+				t.Errorf("expected 2 frames to be received and %d filtered out - got %+v", tt.expectedFiltered, stats)
+			}
+			if m.View() == "" {
+				t.Errorf("expected the packets to be rendered")
+			}
+		})
+	}
+}
+
+func TestCompileFilterHopByHop(t *testing.T) {
+	m := &bisturiModel{selectedProto: newProtoItem("icmp6", syscall.ETH_P_IPV6, "icmp6")}
+
+	frame := mldFrame(t)
+	// the program of the protocol alone and the ones restricted by an expression must agree
+	for _, expression := range []string{"", "icmp6", "ip6", "not udp"} {
+		prog, err := m.compileFilter(expression)
+		if err != nil {
+			t.Fatalf("expected no error compiling %q - got %v", expression, err)
+		}
+		if !filter.Match(prog, frame) {
+			t.Errorf("expected the MLD frame to match %q", expression)
+		}
+	}
+}
+
+func TestUpdateInvalidFilter(t *testing.T) {
+	m := NewBisturiModel(Config{Source: capture.NewReplaySource()})
+
+	var model tea.Model = m
+	model, _ = model.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("port nope")})
+	model, _ = model.Update(tea.KeyMsg{Type: tea.KeyEnter})
+
+	bm := model.(*bisturiModel)
+	if bm.step != insertFilter {
+		t.Errorf("expected the model to stay at the filter step - got step %d", bm.step)
+	}
+	if bm.filterErr == nil {
+		t.Errorf("expected a filter error")
+	}
+}
+
+func TestUpdatePacketTimestamps(t *testing.T) {
+	start := time.Date(2024, 1, 1, 10, 30, 0, 123456789, time.Local)
+	src := capture.NewReplaySource(
+		capture.Packet{Data: udpFrame, Metadata: capture.Metadata{Timestamp: start}},
+		capture.Packet{Data: arpFrame, Metadata: capture.Metadata{Timestamp: start.Add(1500 * time.Nanosecond)}},
+	)
+	m, cmd := startCapture(t, src, "")
+	defer m.Close()
+
+	model, _ := m.Update(waitForPackets(t, cmd))
+	rows := model.(*bisturiModel).packetsTable.cachedRows
+	if len(rows) != 2 {
+		t.Fatalf("expected 2 rows - got %d", len(rows))
+	}
+
+	// rows are stamped with the capture time of their frame, not the time they are displayed
+	expectedTimes := []string{"10:30:00.123456789", "10:30:00.123458289"}
+	expectedDeltas := []string{"0.000000000", "0.000001500"}
+	for i, row := range rows {
+		if row.Data[columnKeyTime] != expectedTimes[i] {
+			t.Errorf("expected row %d time to be %s - got %v", i, expectedTimes[i], row.Data[columnKeyTime])
+		}
+		if row.Data[columnKeyDelta] != expectedDeltas[i] {
+			t.Errorf("expected row %d delta to be %s - got %v", i, expectedDeltas[i], row.Data[columnKeyDelta])
+		}
+	}
+}
+
+func TestUpdatePacketProtocol(t *testing.T) {
+	src := capture.NewReplaySource(
+		capture.Packet{Data: udpFrame, Metadata: capture.Metadata{Timestamp: time.Now()}},
+		capture.Packet{Data: arpFrame, Metadata: capture.Metadata{Timestamp: time.Now()}},
+	)
+	m, cmd := startCapture(t, src, "")
+	defer m.Close()
+
+	model, _ := m.Update(waitForPackets(t, cmd))
+	rows := model.(*bisturiModel).packetsTable.cachedRows
+	if len(rows) != 2 {
+		t.Fatalf("expected 2 rows - got %d", len(rows))
+	}
+
+	// the highest layer decoded from every packet
+	expected := []string{"UDP", "ARP"}
+	for i, row := range rows {
+		if row.Data[columnKeyProtocol] != expected[i] {
+			t.Errorf("expected row %d protocol to be %s - got %v", i, expected[i], row.Data[columnKeyProtocol])
+		}
+	}
+}
+
+func TestUpdateBadChecksums(t *testing.T) {
+	good, err := protocols.Serialize(
+		&protocols.EthernetFrame{DestinationMAC: net.HardwareAddr(udpFrame[0:6]), SourceMAC: net.HardwareAddr(udpFrame[6:12]), EtherType: 0x0800},
+		protocols.IPv4Header{Version: 4, TTL: 64, Protocol: 17, SourceIP: netip.MustParseAddr("192.168.0.104"), DestinationIP: netip.MustParseAddr("192.168.0.1")},
+		&protocols.UDPHeader{SourcePort: 1234, DestinationPort: 53},
+	)
+	if err != nil {
+		t.Fatalf("expected no error - got %v", err)
+	}
+	// the header checksum of udpFrame is zero
+	src := capture.NewReplaySource(
+		capture.Packet{Data: good, Metadata: capture.Metadata{Timestamp: time.Now()}},
+		capture.Packet{Data: udpFrame, Metadata: capture.Metadata{Timestamp: time.Now()}},
+	)
+	m, cmd := startCapture(t, src, "")
+	defer m.Close()
+
+	model, _ := m.Update(waitForPackets(t, cmd))
+	rows := model.(*bisturiModel).packetsTable.cachedRows
+	if len(rows) != 2 {
+		t.Fatalf("expected 2 rows - got %d", len(rows))
+	}
+	for i, expected := range []bool{false, true} {
+		if rows[i].Data[columnKeyBadChecksum] != expected {
+			t.Errorf("expected row %d to have a bad checksum: %t", i, expected)
+		}
+	}
+	if info := rows[1].Data[columnKeyInfo].(string); !strings.Contains(info, "IPv4: 0x0000 (bad, should be 0x") {
+		t.Errorf("expected the details to show the bad IPv4 checksum - got %s", info)
+	}
+
+	model, _ = model.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("b")})
+	visible := model.(*bisturiModel).packetsTable.table.GetVisibleRows()
+	if len(visible) != 1 || visible[0].Data[columnKeyID] != uint64(2) {
+		t.Errorf("expected only the second packet to be displayed - got %d rows", len(visible))
+	}
+
+	model, _ = model.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("b")})
+	if visible := model.(*bisturiModel).packetsTable.table.GetVisibleRows(); len(visible) != 2 {
+		t.Errorf("expected all the packets to be displayed again - got %d rows", len(visible))
+	}
+}
+
+func TestUpdateMergedInterfaces(t *testing.T) {
+	start := time.Date(2024, 1, 1, 10, 30, 0, 0, time.Local)
+	packet := func(frame []byte, offset time.Duration, iface string) capture.Packet {
+		return capture.Packet{Data: frame, Metadata: capture.Metadata{Timestamp: start.Add(offset), Interface: iface}}
+	}
+	src := capture.NewMergedSource(time.Second,
+		capture.NewReplaySource(packet(udpFrame, 0, "eth0"), packet(udpFrame, 2*time.Millisecond, "eth0")),
+		capture.NewReplaySource(packet(arpFrame, time.Millisecond, "eth1")),
+	)
+	m, cmd := startCapture(t, src, "")
+	defer m.Close()
+
+	model, _ := m.Update(waitForPackets(t, cmd))
+	rows := model.(*bisturiModel).packetsTable.cachedRows
+	if len(rows) != 3 {
+		t.Fatalf("expected 3 rows - got %d", len(rows))
+	}
+
+	// frames of different interfaces are displayed in the order they have been captured
+	expected := []string{"eth0", "eth1", "eth0"}
+	for i, row := range rows {
+		if row.Data[columnKeyInterface] != expected[i] {
+			t.Errorf("expected row %d interface to be %s - got %v", i, expected[i], row.Data[columnKeyInterface])
+		}
+	}
+}
+
+func TestUpdatePacketDirection(t *testing.T) {
+	packet := func(frame []byte, pt capture.PacketType) capture.Packet {
+		return capture.Packet{Data: frame, Metadata: capture.Metadata{Timestamp: time.Now(), PacketType: pt}}
+	}
+	packets := []capture.Packet{
+		packet(udpFrame, capture.PacketTypeOutgoing),
+		packet(udpFrame, capture.PacketTypeHost),
+		packet(arpFrame, capture.PacketTypeBroadcast),
+		packet(arpFrame, capture.PacketTypeUnknown),
+	}
+
+	tests := []struct {
+		expression string
+		expected   []string
+	}{
+		{expression: "", expected: []string{"→", "←", "←", ""}},
+		{expression: "outbound", expected: []string{"→"}},
+		{expression: "inbound and arp", expected: []string{"←"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			m, cmd := startCapture(t, capture.NewReplaySource(packets...), tt.expression)
+			defer m.Close()
+
+			model, _ := m.Update(waitForPackets(t, cmd))
+			rows := model.(*bisturiModel).packetsTable.cachedRows
+			if len(rows) != len(tt.expected) {
+				t.Fatalf("expected %d rows - got %d", len(tt.expected), len(rows))
+			}
+			for i, row := range rows {
+				if row.Data[columnKeyDirection] != tt.expected[i] {
+					t.Errorf("expected row %d direction to be %q - got %v", i, tt.expected[i], row.Data[columnKeyDirection])
+				}
+			}
+		})
+	}
+}
+
+// blockingSource is a PacketSource whose reads block until it is closed
+type blockingSource struct {
+	once   sync.Once
+	closed chan struct{}
+}
+
+func newBlockingSource() *blockingSource {
+	return &blockingSource{closed: make(chan struct{})}
+}
+
+func (s *blockingSource) ReadPacket() ([]byte, capture.Metadata, error) {
+	<-s.closed
+	return nil, capture.Metadata{}, capture.ErrSourceClosed
+}
+
+func (s *blockingSource) Close() error {
+	s.once.Do(func() { close(s.closed) })
+	return nil
+}
+
+func (s *blockingSource) Stats() (capture.Stats, error) {
+	return capture.Stats{}, nil
+}
+
+// isClosed reports whether the source has been closed
+func (s *blockingSource) isClosed() bool {
+	select {
+	case <-s.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// pollCmd returns a command polling the messages of the running capture
+func pollCmd(t *testing.T, m *bisturiModel) tea.Cmd {
+	t.Helper()
+
+	if m.captureCtx == nil {
+		t.Fatal("expected a capture to be started")
+	}
+	return m.pollPacketsMessages()
+}
+
+func TestStopCapture(t *testing.T) {
+	tests := []struct {
+		name string
+		stop func(m *bisturiModel) *bisturiModel
+	}{
+		{
+			name: "stop key",
+			stop: func(m *bisturiModel) *bisturiModel {
+				model, _ := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("s")})
+				return model.(*bisturiModel)
+			},
+		},
+		{
+			name: "close",
+			stop: func(m *bisturiModel) *bisturiModel {
+				m.Close()
+				return m
+			},
+		},
+		{
+			name: "context cancelled",
+			stop: func(m *bisturiModel) *bisturiModel {
+				m.cancelCapture()
+				m.captureWg.Wait()
+				return m
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := newBlockingSource()
+			m, _ := startCapture(t, src, "")
+			poll := pollCmd(t, m)
+
+			done := make(chan *bisturiModel)
+			go func() { done <- tt.stop(m) }()
+
+			select {
+			case m = <-done:
+			case <-time.After(2 * time.Second):
+				t.Fatal("expected the capture to stop")
+			}
+			if !src.isClosed() {
+				t.Errorf("expected the source to be closed")
+			}
+			// the pending poll returns instead of leaking its goroutine
+			if msg := runPoll(t, poll); msg != nil {
+				t.Errorf("expected no message after stopping the capture - got %v", msg)
+			}
+		})
+	}
+}
+
+// runPoll executes the poll command, failing the test if it does not return in time
+func runPoll(t *testing.T, cmd tea.Cmd) tea.Msg {
+	t.Helper()
+
+	msgChan := make(chan tea.Msg, 1)
+	go func() { msgChan <- cmd() }()
+
+	select {
+	case msg := <-msgChan:
+		return msg
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the command to return")
+		return nil
+	}
+}
+
+func TestSwitchInterface(t *testing.T) {
+	src := newBlockingSource()
+	m, _ := startCapture(t, src, "")
+	// pretend the frames come from a live interface
+	m.offline = false
+	m.interfaces = []net.Interface{{Index: 1, Name: "lo"}}
+
+	model, _ := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("i")})
+	m = model.(*bisturiModel)
+
+	if m.step != selectIface {
+		t.Errorf("expected the model to ask for an interface - got step %d", m.step)
+	}
+	if !src.isClosed() {
+		t.Errorf("expected the source of the previous capture to be closed")
+	}
+	if !strings.Contains(m.View(), "lo") {
+		t.Errorf("expected the interfaces to be listed")
+	}
+}
@@ -2,31 +2,54 @@ package tui
 
 import (
 	"net"
+	"strings"
 	"time"
 
+	"github.com/charmbracelet/bubbles/key"
 	"github.com/charmbracelet/bubbles/list"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 )
 
-// ifaceItem represents a network interface in a list
+// ifaceItem represents a network interface in a list, and whether it has been selected for the capture
 type ifaceItem struct {
-	name  string
-	flags string
+	name     string
+	flags    string
+	selected bool
 }
 
-func (i ifaceItem) Title() string { return i.name }
+func (i ifaceItem) Title() string {
+	if i.selected {
+		return "[x] " + i.name
+	}
+	return "[ ] " + i.name
+}
 
 func (i ifaceItem) Description() string { return i.flags }
 
 func (i ifaceItem) FilterValue() string { return i.name }
 
-type selectedIfaceItemMsg ifaceItem
+// selectedIfaceItemMsg carries the names of the interfaces to capture on, and the capture modes selected for them
+type selectedIfaceItemMsg struct {
+	names    []string
+	promisc  bool
+	allMulti bool
+}
 
 type networkInterfacesMsg []net.Interface
 
+const ifaceListTitle = "Select one or more Network Interfaces"
+
+var (
+	toggleIfaceKey = key.NewBinding(key.WithKeys(" "), key.WithHelp("space", "select"))
+	promiscKey     = key.NewBinding(key.WithKeys("p"), key.WithHelp("p", "promiscuous"))
+	allMultiKey    = key.NewBinding(key.WithKeys("m"), key.WithHelp("m", "all multicast"))
+)
+
 type interfacesListModel struct {
-	l list.Model
+	l        list.Model
+	promisc  bool
+	allMulti bool
 }
 
 func newInterfacesListModel(interfaces []net.Interface, terminalHeight, terminalWidth int) interfacesListModel {
@@ -46,15 +69,34 @@ func newInterfacesListModel(interfaces []net.Interface, terminalHeight, terminal
 	ifaceDelegate.Styles.SelectedTitle = lipgloss.NewStyle().Foreground(lipgloss.Color("#00cc99"))
 
 	ifaceList := list.New(items, ifaceDelegate, listWidth, listHeight)
-	ifaceList.Title = "Select a Network Interface"
+	ifaceList.Title = ifaceListTitle
 	ifaceList.Styles.Title = titlesStyle
 	ifaceList.SetShowStatusBar(true)
 	ifaceList.SetFilteringEnabled(false)
 	ifaceList.SetShowHelp(true)
+	ifaceList.AdditionalShortHelpKeys = func() []key.Binding {
+		return []key.Binding{toggleIfaceKey, promiscKey, allMultiKey}
+	}
 
 	return interfacesListModel{l: ifaceList}
 }
 
+// updateTitle lists the capture modes enabled in the title
+func (m *interfacesListModel) updateTitle() {
+	modes := []string{}
+	if m.promisc {
+		modes = append(modes, "promiscuous")
+	}
+	if m.allMulti {
+		modes = append(modes, "all multicast")
+	}
+
+	m.l.Title = ifaceListTitle
+	if len(modes) > 0 {
+		m.l.Title += " (" + strings.Join(modes, ", ") + ")"
+	}
+}
+
 func (m *interfacesListModel) resize(terminalHeight, terminalWidth int) {
 	listHeight := (75 * terminalHeight) / 100
 	listWidth := (75 * terminalWidth) / 100
@@ -72,12 +114,27 @@ func (m interfacesListModel) Update(msg tea.Msg) (interfacesListModel, tea.Cmd)
 	case tea.KeyMsg:
 		switch msg.String() {
 		case "enter":
-			i, ok := m.l.SelectedItem().(ifaceItem)
-			if ok {
+			names := m.selectedNames()
+			if len(names) > 0 {
+				msg := selectedIfaceItemMsg{names: names, promisc: m.promisc, allMulti: m.allMulti}
 				return m, func() tea.Msg {
-					return selectedIfaceItemMsg(i)
+					return msg
 				}
 			}
+		case " ":
+			if i, ok := m.l.SelectedItem().(ifaceItem); ok {
+				i.selected = !i.selected
+				cmd := m.l.SetItem(m.l.Index(), i)
+				return m, cmd
+			}
+		case "p":
+			m.promisc = !m.promisc
+			m.updateTitle()
+			return m, nil
+		case "m":
+			m.allMulti = !m.allMulti
+			m.updateTitle()
+			return m, nil
 		case "q", "ctrl+c":
 			return m, tea.Quit
 		}
@@ -87,6 +144,23 @@ func (m interfacesListModel) Update(msg tea.Msg) (interfacesListModel, tea.Cmd)
 	return m, cmd
 }
 
+// selectedNames returns the names of the interfaces selected with the space key,
+// or the one of the highlighted interface if none has been selected
+func (m interfacesListModel) selectedNames() []string {
+	names := []string{}
+	for _, item := range m.l.Items() {
+		if i, ok := item.(ifaceItem); ok && i.selected {
+			names = append(names, i.name)
+		}
+	}
+	if len(names) == 0 {
+		if i, ok := m.l.SelectedItem().(ifaceItem); ok {
+			names = append(names, i.name)
+		}
+	}
+	return names
+}
+
 func (m interfacesListModel) View() string {
 	return m.l.View()
 }
@@ -0,0 +1,69 @@
+package tui
+
+import (
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+func TestInterfacesListModes(t *testing.T) {
+	m := newInterfacesListModel([]net.Interface{{Index: 1, Name: "lo"}}, 50, 200)
+
+	m, _ = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("p")})
+	m, _ = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("m")})
+	m, _ = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("m")})
+	if !strings.Contains(m.l.Title, "promiscuous") || strings.Contains(m.l.Title, "multicast") {
+		t.Errorf("expected the title to list only the promiscuous mode - got %s", m.l.Title)
+	}
+
+	_, cmd := m.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	if cmd == nil {
+		t.Fatal("expected the selected interface to be sent")
+	}
+	msg, ok := cmd().(selectedIfaceItemMsg)
+	if !ok {
+		t.Fatalf("expected a selected interface message - got %T", msg)
+	}
+	if !reflect.DeepEqual(msg.names, []string{"lo"}) || !msg.promisc || msg.allMulti {
+		t.Errorf("expected lo in promiscuous mode only - got %+v", msg)
+	}
+}
+
+func TestInterfacesListMultiSelect(t *testing.T) {
+	m := newInterfacesListModel([]net.Interface{
+		{Index: 1, Name: "lo"},
+		{Index: 2, Name: "eth0"},
+		{Index: 3, Name: "eth1"},
+	}, 50, 200)
+
+	space := tea.KeyMsg{Type: tea.KeySpace, Runes: []rune{' '}}
+	down := tea.KeyMsg{Type: tea.KeyDown}
+	m, _ = m.Update(space)
+	m, _ = m.Update(down)
+	m, _ = m.Update(down)
+	m, _ = m.Update(space)
+
+	_, cmd := m.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	if cmd == nil {
+		t.Fatal("expected the selected interfaces to be sent")
+	}
+	msg, ok := cmd().(selectedIfaceItemMsg)
+	if !ok {
+		t.Fatalf("expected a selected interface message - got %T", msg)
+	}
+	if expected := []string{"lo", "eth1"}; !reflect.DeepEqual(msg.names, expected) {
+		t.Errorf("expected interfaces %v - got %v", expected, msg.names)
+	}
+
+	// deselecting every interface falls back to the highlighted one
+	m, _ = m.Update(space)
+	m, _ = m.Update(tea.KeyMsg{Type: tea.KeyUp})
+	m, _ = m.Update(tea.KeyMsg{Type: tea.KeyUp})
+	m, _ = m.Update(space)
+	if names := m.selectedNames(); !reflect.DeepEqual(names, []string{"lo"}) {
+		t.Errorf("expected only the highlighted interface - got %v", names)
+	}
+}
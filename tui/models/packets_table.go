@@ -1,8 +1,12 @@
 package tui
 
 import (
+	"fmt"
+	"strings"
 	"time"
 
+	"github.com/NamelessOne91/bisturi/capture"
+	"github.com/NamelessOne91/bisturi/protocols"
 	"github.com/NamelessOne91/bisturi/sockets"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
@@ -12,11 +16,20 @@ import (
 const (
 	columnKeyID          = "id"
 	columnKeyTime        = "time"
+	columnKeyDelta       = "delta"
+	columnKeyInterface   = "interface"
+	columnKeyDirection   = "direction"
+	columnKeyProtocol    = "protocol"
 	columnKeySource      = "source"
 	columnKeyDestination = "destination"
 	columnKeyInfo        = "info"
+	// not displayed, set for the rows of the packets carrying a bad checksum
+	columnKeyBadChecksum = "badChecksum"
 )
 
+// badChecksumStyle highlights the rows of the packets carrying a bad checksum
+var badChecksumStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("#ff5f5f"))
+
 type packetsTableModel struct {
 	table      table.Model
 	height     int
@@ -24,16 +37,24 @@ type packetsTableModel struct {
 	maxRows    int
 	cachedRows []table.Row
 	counter    uint64
+	// timestamp of the last packet added, to compute the delta of the next one
+	lastTimestamp time.Time
+	// onlyBadChecksums hides the packets whose checksums are all good or unverified
+	onlyBadChecksums bool
 }
 
 func (m *packetsTableModel) buildTable() {
 	m.table = table.New([]table.Column{
 		table.NewColumn(columnKeyID, "#", (3*m.width)/100),
-		table.NewColumn(columnKeyTime, "Time", (7*m.width)/100),
-		table.NewColumn(columnKeySource, "Source", (20*m.width)/100),
-		table.NewColumn(columnKeyDestination, "Destination", (20*m.width)/100),
+		table.NewColumn(columnKeyTime, "Time", (10*m.width)/100),
+		table.NewColumn(columnKeyDelta, "Delta", (7*m.width)/100),
+		table.NewColumn(columnKeyInterface, "Interface", (6*m.width)/100),
+		table.NewColumn(columnKeyDirection, "Dir", (3*m.width)/100),
+		table.NewColumn(columnKeySource, "Source", (12*m.width)/100),
+		table.NewColumn(columnKeyDestination, "Destination", (12*m.width)/100),
+		table.NewColumn(columnKeyProtocol, "Protocol", (6*m.width)/100),
 	}).
-		WithRows(m.cachedRows).
+		WithRows(m.visibleRows()).
 		Focused(true).
 		WithBaseStyle(lipgloss.NewStyle().
 			BorderForeground(lipgloss.Color("#00cc99")).
@@ -76,6 +97,10 @@ func (m packetsTableModel) Update(msg tea.Msg) (packetsTableModel, tea.Cmd) {
 		switch msg.String() {
 		case "ctrl+c", "esc", "q":
 			return m, tea.Quit
+		case "b":
+			m.onlyBadChecksums = !m.onlyBadChecksums
+			m.table = m.table.WithRows(m.visibleRows())
+			return m, nil
 		}
 	}
 
@@ -109,11 +134,90 @@ func (m packetsTableModel) View() string {
 		lipgloss.Left,
 		mainView,
 	) + "\n"
+	if m.onlyBadChecksums {
+		view += "Showing only the packets with bad checksums - press 'b' to show all\n"
+	}
 
 	return view
 }
 
-func (m *packetsTableModel) addRows(packets []sockets.NetworkPacket) {
+// deltaSeconds formats the time elapsed since the previous packet, in seconds with nanosecond resolution
+func deltaSeconds(ts, previous time.Time) string {
+	if previous.IsZero() {
+		return "0.000000000"
+	}
+	return fmt.Sprintf("%.9f", ts.Sub(previous).Seconds())
+}
+
+// direction returns an arrow pointing out of the host for the frames it sent, and into it for the ones
+// it received. Frames read from a file have no direction
+func direction(t capture.PacketType) string {
+	switch {
+	case t.Outgoing():
+		return "→"
+	case t.Incoming():
+		return "←"
+	default:
+		return ""
+	}
+}
+
+// protocol returns the type of the highest layer decoded from the packet, not counting its payload
+func protocol(np sockets.NetworkPacket) string {
+	layers := np.Layers()
+	for i := len(layers) - 1; i >= 0; i-- {
+		if t := layers[i].LayerType(); t != protocols.LayerTypePayload {
+			return t.String()
+		}
+	}
+	return ""
+}
+
+// checksumsInfo returns the status of the checksums carried by the packet, shown before its details
+func checksumsInfo(checksums []protocols.Checksum) string {
+	if len(checksums) == 0 {
+		return ""
+	}
+
+	var sb strings.Builder
+	sb.WriteString("\nChecksums\n\n")
+	for _, c := range checksums {
+		fmt.Fprintf(&sb, "%s: 0x%04X (%s", c.Layer, c.Value, c.Status)
+		if c.Status == protocols.ChecksumBad {
+			fmt.Fprintf(&sb, ", should be 0x%04X", c.Expected)
+		}
+		sb.WriteString(")\n")
+	}
+	sb.WriteString("\n===============================\n")
+	return sb.String()
+}
+
+// hasBadChecksum reports whether any of the checksums is bad
+func hasBadChecksum(checksums []protocols.Checksum) bool {
+	for _, c := range checksums {
+		if c.Status == protocols.ChecksumBad {
+			return true
+		}
+	}
+	return false
+}
+
+// visibleRows returns the cached rows to display: only the ones of packets with bad checksums, if so chosen
+func (m *packetsTableModel) visibleRows() []table.Row {
+	if !m.onlyBadChecksums {
+		return m.cachedRows
+	}
+
+	rows := make([]table.Row, 0, len(m.cachedRows))
+	for _, row := range m.cachedRows {
+		if row.Data[columnKeyBadChecksum] == true {
+			rows = append(rows, row)
+		}
+	}
+	return rows
+}
+
+func (m *packetsTableModel) addRows(packets []sockets.CapturedPacket) {
 	lp := len(packets)
 	lc := len(m.cachedRows)
 
@@ -131,15 +235,27 @@ func (m *packetsTableModel) addRows(packets []sockets.NetworkPacket) {
 
 	for _, np := range packets {
 		m.counter += 1
+		ts := np.Timestamp()
+		checksums := np.Checksums()
+		bad := hasBadChecksum(checksums)
 
 		newRow := table.NewRow(table.RowData{
 			columnKeyID:          m.counter,
-			columnKeyTime:        time.Now().Local().Format(time.TimeOnly),
+			columnKeyTime:        ts.Local().Format("15:04:05.000000000"),
+			columnKeyDelta:       deltaSeconds(ts, m.lastTimestamp),
+			columnKeyInterface:   np.Metadata.Interface,
+			columnKeyDirection:   direction(np.PacketType()),
 			columnKeySource:      np.Source(),
 			columnKeyDestination: np.Destination(),
-			columnKeyInfo:        np.Info(),
+			columnKeyProtocol:    protocol(np),
+			columnKeyInfo:        checksumsInfo(checksums) + np.Info(),
+			columnKeyBadChecksum: bad,
 		})
+		if bad {
+			newRow = newRow.WithStyle(badChecksumStyle)
+		}
 		m.cachedRows = append(m.cachedRows, newRow)
+		m.lastTimestamp = ts
 	}
-	m.table = m.table.WithRows(m.cachedRows)
+	m.table = m.table.WithRows(m.visibleRows())
 }
@@ -0,0 +1,157 @@
+package tui
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/NamelessOne91/bisturi/capture"
+	"github.com/NamelessOne91/bisturi/pcap"
+)
+
+// dumpSnapLen is the maximum number of bytes written for every frame
+const dumpSnapLen = 0x40000
+
+// pcapDump writes the captured frames to a file which can be opened in Wireshark later.
+// Files with the .pcap extension use the classic format, any other the pcapng one, describing
+// every interface the frames have been captured on.
+// The file is created when the recording is enabled for the first time, and can then be paused and resumed
+type pcapDump struct {
+	mu       sync.Mutex
+	path     string
+	file     *os.File
+	buf      *bufio.Writer
+	writer   capture.Writer
+	ng       *pcap.NgWriter
+	ifaceIDs map[string]int
+	enabled  bool
+}
+
+// newPcapDump returns a dump for the captured frames. A default file name, based on the current time,
+// is used if the path is empty
+func newPcapDump(path string) *pcapDump {
+	if path == "" {
+		path = fmt.Sprintf("bisturi_%s.pcapng", time.Now().Format("20060102_150405"))
+	}
+	return &pcapDump{path: path}
+}
+
+// open creates the file and writes its headers
+func (d *pcapDump) open() error {
+	f, err := os.Create(d.path)
+	if err != nil {
+		return err
+	}
+	buf := bufio.NewWriter(f)
+
+	var w capture.Writer
+	var ng *pcap.NgWriter
+	if filepath.Ext(d.path) == ".pcap" {
+		w, err = pcap.NewWriter(buf, dumpSnapLen, pcap.LinkTypeEthernet)
+	} else {
+		ng, err = pcap.NewNgWriter(buf)
+		w = ng
+	}
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	d.file = f
+	d.buf = buf
+	d.writer = w
+	d.ng = ng
+	d.ifaceIDs = map[string]int{}
+	return nil
+}
+
+// ngInterface describes the named interface in a pcapng file
+func ngInterface(name string) pcap.NgInterface {
+	return pcap.NgInterface{
+		Name:     name,
+		LinkType: pcap.LinkTypeEthernet,
+		SnapLen:  dumpSnapLen,
+	}
+}
+
+// interfaceID returns the ID of the named interface in the pcapng file, describing it
+// in a new block the first time a frame captured on it is written
+func (d *pcapDump) interfaceID(name string) (int, error) {
+	if id, ok := d.ifaceIDs[name]; ok {
+		return id, nil
+	}
+	id, err := d.ng.AddInterface(ngInterface(name))
+	if err != nil {
+		return 0, err
+	}
+	d.ifaceIDs[name] = id
+	return id, nil
+}
+
+// WritePacket writes the frame to the file, if the recording is enabled
+func (d *pcapDump) WritePacket(md capture.Metadata, data []byte) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !d.enabled {
+		return nil
+	}
+	if d.ng != nil {
+		id, err := d.interfaceID(md.Interface)
+		if err != nil {
+			return err
+		}
+		return d.ng.WriteInterfacePacket(id, md, data)
+	}
+	return d.writer.WritePacket(md, data)
+}
+
+// toggle enables or pauses the recording, returning whether it is now enabled.
+// Written frames are flushed to the file when pausing
+func (d *pcapDump) toggle() (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.enabled {
+		d.enabled = false
+		return false, d.buf.Flush()
+	}
+
+	if d.file == nil {
+		if err := d.open(); err != nil {
+			return false, err
+		}
+	}
+	d.enabled = true
+	return true, nil
+}
+
+// recording reports whether the frames are currently written to the file
+func (d *pcapDump) recording() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.enabled
+}
+
+// Close flushes the written frames and closes the file
+func (d *pcapDump) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.enabled = false
+	if d.file == nil {
+		return nil
+	}
+	f := d.file
+	d.file = nil
+
+	if err := d.buf.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
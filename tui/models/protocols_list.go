@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"syscall"
 
+	"github.com/NamelessOne91/bisturi/filter"
 	"github.com/charmbracelet/bubbles/list"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
@@ -13,6 +14,23 @@ import (
 type protoItem struct {
 	name    string
 	ethType uint16
+	filter  []syscall.SockFilter
+	// the protocol as a filter expression, combined with the one inserted by the user
+	expression string
+}
+
+// newProtoItem returns the list item for the given protocol, compiling its expression to the BPF program
+// which lets the kernel filter the protocol's packets
+func newProtoItem(name string, ethType uint16, expression string) protoItem {
+	// the expressions of the protocols in the list are always valid
+	prog, _ := filter.Compile(expression)
+
+	return protoItem{
+		name:       name,
+		ethType:    ethType,
+		filter:     prog,
+		expression: expression,
+	}
 }
 
 func (p protoItem) Title() string { return p.name }
@@ -35,15 +53,17 @@ func newProtocolsListModel(terminalHeight, terminalWidth int) protocolsListModel
 	protoDelegate.Styles.SelectedTitle = lipgloss.NewStyle().Foreground(lipgloss.Color("#00cc99"))
 
 	items := []list.Item{
-		protoItem{name: "all", ethType: syscall.ETH_P_ALL},
-		protoItem{name: "arp", ethType: syscall.ETH_P_ARP},
-		protoItem{name: "ip", ethType: syscall.ETH_P_IP},
-		protoItem{name: "ipv6", ethType: syscall.ETH_P_IPV6},
-		// UDP and TCP are part of IP, need special handling if filtered specifically
-		protoItem{name: "udp", ethType: syscall.ETH_P_IP},
-		protoItem{name: "udp6", ethType: syscall.ETH_P_IPV6},
-		protoItem{name: "tcp", ethType: syscall.ETH_P_IP},
-		protoItem{name: "tcp6", ethType: syscall.ETH_P_IPV6},
+		newProtoItem("all", syscall.ETH_P_ALL, ""),
+		newProtoItem("arp", syscall.ETH_P_ARP, "arp"),
+		newProtoItem("ip", syscall.ETH_P_IP, "ip"),
+		newProtoItem("ipv6", syscall.ETH_P_IPV6, "ip6"),
+		// UDP, TCP, ICMP and ICMPv6 are part of IP, their BPF programs also inspect the IP header
+		newProtoItem("udp", syscall.ETH_P_IP, "ip and udp"),
+		newProtoItem("udp6", syscall.ETH_P_IPV6, "ip6 and udp"),
+		newProtoItem("tcp", syscall.ETH_P_IP, "ip and tcp"),
+		newProtoItem("tcp6", syscall.ETH_P_IPV6, "ip6 and tcp"),
+		newProtoItem("icmp", syscall.ETH_P_IP, "icmp"),
+		newProtoItem("icmp6", syscall.ETH_P_IPV6, "icmp6"),
 	}
 	protoList := list.New(items, protoDelegate, listWidth, listHeight)
 	protoList.Title = "Select a Network Protocol"
@@ -0,0 +1,71 @@
+package tui
+
+import (
+	"testing"
+
+	"github.com/NamelessOne91/bisturi/filter"
+)
+
+var (
+	udpFrame = []byte{
+		// Ethernet Frame
+		0x00, 0x1A, 0xA0, 0xBB, 0xCC, 0xDD, 0x00, 0x1A, 0xB0, 0xCC, 0xDD, 0xEE, 0x08, 0x00,
+		// IPv4 Header
+		0x45, 0x00, 0x00, 0x1c, 0x1c, 0x46, 0x40, 0x00,
+		0x40, 0x11, 0x00, 0x00, 0xc0, 0xa8, 0x00, 0x68,
+		0xc0, 0xa8, 0x00, 0x01,
+		// UDP Header
+		0x04, 0xd2, 0x00, 0x35, 0x00, 0x08, 0x00, 0x00,
+	}
+	arpFrame = []byte{
+		// Ethernet Frame
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x00, 0x1a, 0x2b, 0x3c, 0x4d, 0x5e, 0x08, 0x06,
+		// ARP Packet
+		0x00, 0x01, 0x08, 0x00, 0x06, 0x04, 0x00, 0x01,
+		0x00, 0x1a, 0x2b, 0x3c, 0x4d, 0x5e, 0xc0, 0xa8, 0x01, 0x01,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xc0, 0xa8, 0x01, 0x02,
+	}
+)
+
+func TestProtocolsListFilters(t *testing.T) {
+	frames := map[string][]byte{
+		"udp": udpFrame,
+		"arp": arpFrame,
+		"mld": mldFrame(t),
+	}
+	tests := map[string][]string{
+		"all":   {"udp", "arp", "mld"},
+		"arp":   {"arp"},
+		"ip":    {"udp"},
+		"ipv6":  {"mld"},
+		"udp":   {"udp"},
+		"udp6":  nil,
+		"tcp":   nil,
+		"tcp6":  nil,
+		"icmp":  nil,
+		"icmp6": {"mld"},
+	}
+
+	m := newProtocolsListModel(50, 200)
+	for _, item := range m.l.Items() {
+		p := item.(protoItem)
+		matching, ok := tests[p.name]
+		if !ok {
+			t.Errorf("unexpected protocol %s in the list", p.name)
+			continue
+		}
+		if (p.expression == "") != (p.filter == nil) {
+			t.Errorf("expected %s to have a program only with an expression - got %d instructions", p.name, len(p.filter))
+		}
+
+		expected := map[string]bool{}
+		for _, name := range matching {
+			expected[name] = true
+		}
+		for name, frame := range frames {
+			if got := filter.Match(p.filter, frame); got != expected[name] {
+				t.Errorf("expected %s to match the %s frame: %v - got %v", p.name, name, expected[name], got)
+			}
+		}
+	}
+}
@@ -13,7 +13,7 @@ type startMenuModel struct {
 }
 
 type selectedInterfaceMsg struct {
-	name string
+	names []string
 }
 
 type selectedProtocolMsg struct {
@@ -44,7 +44,7 @@ func (m startMenuModel) Update(msg tea.Msg) (startMenuModel, tea.Cmd) {
 			m.step = selectProtocol
 			return m, func() tea.Msg {
 				return selectedInterfaceMsg{
-					name: i.name,
+					names: i.names,
 				}
 			}
 		}
@@ -0,0 +1,122 @@
+package tui
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/NamelessOne91/bisturi/capture"
+	"github.com/NamelessOne91/bisturi/sockets"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// statsInterval is how often the status bar is refreshed
+const statsInterval = time.Second
+
+type statsTickMsg time.Time
+
+// pipelineCounters counts the outcome of decoding the frames read from the source.
+// It is shared by the goroutine reading the packets and the model
+type pipelineCounters struct {
+	mu          sync.Mutex
+	parsed      uint64
+	parseErrors map[string]uint64
+}
+
+func newPipelineCounters() *pipelineCounters {
+	return &pipelineCounters{parseErrors: map[string]uint64{}}
+}
+
+// countPacket counts a successfully decoded packet
+func (c *pipelineCounters) countPacket() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.parsed++
+}
+
+// countError counts the error if it was caused by a frame which could not be decoded,
+// reporting whether it was
+func (c *pipelineCounters) countError(err error) bool {
+	var pe *sockets.ParseError
+	if !errors.As(err, &pe) {
+		return false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.parseErrors[pe.Protocol]++
+	return true
+}
+
+// statusBarModel shows the counters of the capture, from the kernel to the packets table
+type statusBarModel struct {
+	live        bool
+	source      capture.Stats
+	sourceErr   error
+	parsed      uint64
+	parseErrors map[string]uint64
+	displayed   uint64
+	lastErr     error
+}
+
+// refresh copies the current values of the counters
+func (m *statusBarModel) refresh(src capture.Group, counters *pipelineCounters, displayed uint64) {
+	m.source, m.sourceErr = src.Stats()
+	m.displayed = displayed
+
+	counters.mu.Lock()
+	defer counters.mu.Unlock()
+
+	m.parsed = counters.parsed
+	m.parseErrors = make(map[string]uint64, len(counters.parseErrors))
+	for proto, n := range counters.parseErrors {
+		m.parseErrors[proto] = n
+	}
+}
+
+func tickStats() tea.Cmd {
+	return tea.Tick(statsInterval, func(t time.Time) tea.Msg {
+		return statsTickMsg(t)
+	})
+}
+
+func (m statusBarModel) View() string {
+	parts := []string{fmt.Sprintf("Received %d", m.source.Received)}
+	if m.live {
+		parts = append(parts, fmt.Sprintf("Kernel: captured %d, dropped %d, ring freezes %d",
+			m.source.Captured, m.source.Dropped, m.source.FreezeQueue))
+	}
+	parts = append(parts, fmt.Sprintf("Filtered out %d", m.source.Filtered))
+	parts = append(parts, fmt.Sprintf("Parsed %d", m.parsed))
+
+	if len(m.parseErrors) > 0 {
+		protos := make([]string, 0, len(m.parseErrors))
+		for proto := range m.parseErrors {
+			protos = append(protos, proto)
+		}
+		sort.Strings(protos)
+
+		errs := make([]string, len(protos))
+		for i, proto := range protos {
+			errs[i] = fmt.Sprintf("%s %d", proto, m.parseErrors[proto])
+		}
+		parts = append(parts, "Parse errors: "+strings.Join(errs, ", "))
+	}
+	parts = append(parts, fmt.Sprintf("Displayed %d", m.displayed))
+
+	view := strings.Join(parts, " | ")
+	if m.sourceErr != nil {
+		view += fmt.Sprintf("\nFailed to read the statistics: %s", m.sourceErr)
+	}
+	if m.lastErr != nil {
+		view += fmt.Sprintf("\nLast error: %s", m.lastErr)
+	}
+
+	return lipgloss.NewStyle().Foreground(lipgloss.Color("#00cc99")).Render(view) + "\n"
+}